@@ -3,9 +3,11 @@
 package config
 
 import (
+	"admin-backend/models"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,14 +15,26 @@ import (
 type Config struct {
 	// Server configuration
 	Server ServerConfig
+	// Storage backend configuration
+	Storage StorageConfig
 	// Redis configuration
 	Redis RedisConfig
 	// JWT configuration
 	JWT JWTConfig
+	// User authentication configuration
+	Auth AuthConfig
+	// OpenID Connect single sign-on configuration
+	OIDC OIDCConfig
+	// Login brute-force protection configuration
+	LoginProtection LoginProtectionConfig
 	// CORS configuration
 	CORS CORSConfig
 	// Rate limiting configuration
 	RateLimit RateLimitConfig
+	// Cluster capacity configuration
+	Capacity CapacityConfig
+	// Emergency mode configuration
+	Emergency EmergencyConfig
 	// Logging configuration
 	Log LogConfig
 }
@@ -35,6 +49,19 @@ type ServerConfig struct {
 	WriteTimeout time.Duration
 	// ShutdownTimeout is the maximum time to wait for graceful shutdown
 	ShutdownTimeout time.Duration
+	// TrustedProxies are the proxy addresses or CIDRs whose X-Forwarded-For is trusted
+	// (empty trusts every proxy)
+	TrustedProxies []string
+}
+
+// StorageConfig selects the storage backend.
+type StorageConfig struct {
+	// Backend is the storage implementation to use (redis or memory)
+	Backend string
+	// GatewayClusterID is the cluster whose config drives the gateway's L1 bucket
+	GatewayClusterID string
+	// MaxHistoryEntries is the number of snapshots kept in each config's history
+	MaxHistoryEntries int
 }
 
 // RedisConfig contains Redis connection configuration.
@@ -63,8 +90,12 @@ type RedisConfig struct {
 
 // JWTConfig contains JWT token configuration.
 type JWTConfig struct {
-	// Secret is the signing secret for JWT tokens (must be set in production)
+	// Secret is the HS256 signing secret; with KeysDir set it only verifies older tokens
 	Secret string
+	// KeysDir is a directory of PEM encoded RSA or Ed25519 keys named <kid>.pem
+	KeysDir string
+	// ActiveKeyID is the kid of the key in KeysDir that signs new tokens
+	ActiveKeyID string
 	// AccessExpiration is the duration until access tokens expire
 	AccessExpiration time.Duration
 	// RefreshExpiration is the duration until refresh tokens expire
@@ -73,6 +104,73 @@ type JWTConfig struct {
 	Issuer string
 }
 
+// AuthConfig contains user authentication configuration.
+type AuthConfig struct {
+	// BootstrapAdminUsername is the username of the admin created when no users exist
+	BootstrapAdminUsername string
+	// BootstrapAdminPassword is the password of the bootstrap admin
+	BootstrapAdminPassword string
+	// BootstrapAdminPasswordFile is a file containing the bootstrap admin password
+	BootstrapAdminPasswordFile string
+	// PasswordHashCost is the bcrypt cost used to hash passwords
+	PasswordHashCost int
+}
+
+// OIDCConfig contains OpenID Connect single sign-on configuration.
+type OIDCConfig struct {
+	// Enabled turns on the SSO login endpoints
+	Enabled bool
+	// IssuerURL is the identity provider's issuer; its discovery document is read at startup
+	IssuerURL string
+	// ClientID is the client registered with the identity provider
+	ClientID string
+	// ClientSecret is the client's secret
+	ClientSecret string
+	// RedirectURL is this service's callback URL registered with the identity provider
+	RedirectURL string
+	// Scopes are the scopes requested at login
+	Scopes []string
+	// UsernameClaim is the ID token claim used as the console username
+	UsernameClaim string
+	// GroupsClaim is the ID token claim listing the user's groups
+	GroupsClaim string
+	// RoleMapping maps groups to console roles as "group=role,group=role"
+	RoleMapping string
+	// DefaultRole is the role of users in no mapped group (empty denies them)
+	DefaultRole string
+	// PostLoginRedirectURL is where the browser is sent with the tokens after login
+	PostLoginRedirectURL string
+	// DisablePasswordLogin rejects username/password logins so SSO is the only way in
+	DisablePasswordLogin bool
+}
+
+// RoleMap parses RoleMapping into a group to role map.
+func (c *OIDCConfig) RoleMap() (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, entry := range strings.Split(c.RoleMapping, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid OIDC role mapping %q (expected group=role)", entry)
+		}
+		group, role := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		if !isRole(role) {
+			return nil, fmt.Errorf("invalid role %q in OIDC role mapping", role)
+		}
+		roles[group] = role
+	}
+	return roles, nil
+}
+
+// isRole reports whether role is a console role.
+func isRole(role string) bool {
+	return role == models.RoleAdmin || role == models.RoleOperator || role == models.RoleViewer
+}
+
 // CORSConfig contains CORS middleware configuration.
 type CORSConfig struct {
 	// AllowedOrigins is a list of allowed origins (wildcards supported)
@@ -89,7 +187,29 @@ type CORSConfig struct {
 	MaxAge time.Duration
 }
 
+// LoginProtectionConfig contains login brute-force protection configuration.
+// Failures are counted per username and per client IP. Past half the maximum,
+// each failure delays the next attempt exponentially, and reaching the maximum
+// locks the username or IP out.
+type LoginProtectionConfig struct {
+	// Enabled indicates whether failed logins are throttled
+	Enabled bool
+	// MaxUserFailures is the number of failures that locks a username out
+	MaxUserFailures int
+	// MaxIPFailures is the number of failures that locks a client IP out
+	MaxIPFailures int
+	// FailureWindow is how long failures are remembered after the last one
+	FailureWindow time.Duration
+	// LockoutDuration is how long a lockout lasts
+	LockoutDuration time.Duration
+	// BackoffBase is the first backoff delay; it doubles with each further failure
+	BackoffBase time.Duration
+	// BackoffMax caps the delay between attempts before a lockout
+	BackoffMax time.Duration
+}
+
 // RateLimitConfig contains rate limiting configuration.
+// Limits are counted per caller in a sliding window shared by all replicas.
 type RateLimitConfig struct {
 	// Enabled indicates whether rate limiting is active
 	Enabled bool
@@ -97,8 +217,89 @@ type RateLimitConfig struct {
 	RequestsPerWindow int
 	// Window is the time window for rate limiting
 	Window time.Duration
-	// CleanupInterval is how often to clean up stale rate limit data
-	CleanupInterval time.Duration
+	// RoleLimits overrides RequestsPerWindow per role as "role=limit,role=limit"
+	RoleLimits string
+	// RouteLimits adds a separate limit per route as "METHOD /path=limit,/path=limit";
+	// paths use route parameters (e.g. /api/v1/apps/:id) and the method is optional
+	RouteLimits string
+}
+
+// RoleLimitMap parses RoleLimits into a map from role to limit.
+func (c *RateLimitConfig) RoleLimitMap() (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(c.RoleLimits, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		role, limit, err := parseLimitEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit role limit %q (expected role=limit)", entry)
+		}
+		if !isRole(role) {
+			return nil, fmt.Errorf("invalid role %q in rate limit role limits", role)
+		}
+		limits[role] = limit
+	}
+	return limits, nil
+}
+
+// RouteLimitMap parses RouteLimits into a map from route to limit. Routes are
+// keyed "METHOD /path", or just "/path" for limits that apply to every method.
+func (c *RateLimitConfig) RouteLimitMap() (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(c.RouteLimits, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, limit, err := parseLimitEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit route limit %q (expected METHOD /path=limit)", entry)
+		}
+		fields := strings.Fields(route)
+		switch {
+		case len(fields) == 1 && strings.HasPrefix(fields[0], "/"):
+			route = fields[0]
+		case len(fields) == 2 && strings.HasPrefix(fields[1], "/"):
+			route = strings.ToUpper(fields[0]) + " " + fields[1]
+		default:
+			return nil, fmt.Errorf("invalid route %q in rate limit route limits", route)
+		}
+		limits[route] = limit
+	}
+	return limits, nil
+}
+
+// parseLimitEntry splits a "name=limit" entry; the limit must be positive.
+func parseLimitEntry(entry string) (string, int, error) {
+	i := strings.LastIndex(entry, "=")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("missing =")
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+	if err != nil || limit <= 0 {
+		return "", 0, fmt.Errorf("limit must be a positive integer")
+	}
+	return strings.TrimSpace(entry[:i]), limit, nil
+}
+
+// CapacityConfig contains the cluster capacity policy of config writes. It
+// applies when the guaranteed quotas of all apps would exceed a cluster's
+// max_capacity * (1 - reserved_ratio).
+type CapacityConfig struct {
+	// OversubscriptionPolicy is reject, warn or allow
+	OversubscriptionPolicy string
+	// OversubscriptionFactor is how far allow may exceed the capacity, e.g. 1.5
+	OversubscriptionFactor float64
+}
+
+// EmergencyConfig contains emergency mode configuration.
+type EmergencyConfig struct {
+	// ReaperInterval is how often expired emergencies are deactivated
+	ReaperInterval time.Duration
 }
 
 // LogConfig contains logging configuration.
@@ -122,6 +323,14 @@ func Load() (*Config, error) {
 		ReadTimeout:     getDurationEnv("SERVER_READ_TIMEOUT", 15*time.Second),
 		WriteTimeout:    getDurationEnv("SERVER_WRITE_TIMEOUT", 15*time.Second),
 		ShutdownTimeout: getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 30*time.Second),
+		TrustedProxies:  getStringSliceEnv("SERVER_TRUSTED_PROXIES", nil),
+	}
+
+	// Load storage configuration
+	cfg.Storage = StorageConfig{
+		Backend:           getEnv("STORAGE_BACKEND", "redis"),
+		GatewayClusterID:  getEnv("GATEWAY_CLUSTER_ID", "default"),
+		MaxHistoryEntries: getIntEnv("HISTORY_MAX_ENTRIES", 1000),
 	}
 
 	// Load Redis configuration
@@ -141,34 +350,83 @@ func Load() (*Config, error) {
 
 	// Load JWT configuration
 	cfg.JWT = JWTConfig{
-		Secret:           getEnv("JWT_SECRET", ""),
+		Secret:            getEnv("JWT_SECRET", ""),
+		KeysDir:           getEnv("JWT_KEYS_DIR", ""),
+		ActiveKeyID:       getEnv("JWT_ACTIVE_KEY_ID", ""),
 		AccessExpiration:  getDurationEnv("JWT_ACCESS_EXPIRATION", 1*time.Hour),
 		RefreshExpiration: getDurationEnv("JWT_REFRESH_EXPIRATION", 7*24*time.Hour),
-		Issuer:           getEnv("JWT_ISSUER", "qos-gateway-admin"),
+		Issuer:            getEnv("JWT_ISSUER", "qos-gateway-admin"),
+	}
+
+	// Load user authentication configuration
+	cfg.Auth = AuthConfig{
+		BootstrapAdminUsername:     getEnv("BOOTSTRAP_ADMIN_USERNAME", "admin"),
+		BootstrapAdminPassword:     getEnv("BOOTSTRAP_ADMIN_PASSWORD", ""),
+		BootstrapAdminPasswordFile: getEnv("BOOTSTRAP_ADMIN_PASSWORD_FILE", ""),
+		PasswordHashCost:           getIntEnv("PASSWORD_HASH_COST", 12),
+	}
+
+	// Load OIDC configuration
+	cfg.OIDC = OIDCConfig{
+		Enabled:              getBoolEnv("OIDC_ENABLED", false),
+		IssuerURL:            getEnv("OIDC_ISSUER_URL", ""),
+		ClientID:             getEnv("OIDC_CLIENT_ID", ""),
+		ClientSecret:         getEnv("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:          getEnv("OIDC_REDIRECT_URL", ""),
+		Scopes:               getStringSliceEnv("OIDC_SCOPES", []string{"openid", "profile", "email"}),
+		UsernameClaim:        getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+		GroupsClaim:          getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		RoleMapping:          getEnv("OIDC_ROLE_MAPPING", ""),
+		DefaultRole:          getEnv("OIDC_DEFAULT_ROLE", ""),
+		PostLoginRedirectURL: getEnv("OIDC_POST_LOGIN_REDIRECT_URL", ""),
+		DisablePasswordLogin: getBoolEnv("OIDC_DISABLE_PASSWORD_LOGIN", false),
+	}
+
+	// Load login protection configuration
+	cfg.LoginProtection = LoginProtectionConfig{
+		Enabled:         getBoolEnv("LOGIN_PROTECTION_ENABLED", true),
+		MaxUserFailures: getIntEnv("LOGIN_MAX_USER_FAILURES", 5),
+		MaxIPFailures:   getIntEnv("LOGIN_MAX_IP_FAILURES", 50),
+		FailureWindow:   getDurationEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LockoutDuration: getDurationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		BackoffBase:     getDurationEnv("LOGIN_BACKOFF_BASE", 1*time.Second),
+		BackoffMax:      getDurationEnv("LOGIN_BACKOFF_MAX", 1*time.Minute),
 	}
 
 	// Load CORS configuration
 	cfg.CORS = CORSConfig{
 		AllowedOrigins:   getStringSliceEnv("CORS_ALLOWED_ORIGINS", []string{"*"}),
 		AllowedMethods:   getStringSliceEnv("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		AllowedHeaders:   getStringSliceEnv("CORS_ALLOWED_HEADERS", []string{"Origin", "Content-Type", "Authorization"}),
-		ExposedHeaders:   getStringSliceEnv("CORS_EXPOSED_HEADERS", []string{}),
+		AllowedHeaders:   getStringSliceEnv("CORS_ALLOWED_HEADERS", []string{"Origin", "Content-Type", "Authorization", "If-Match", "X-Change-Reason", "X-API-Key"}),
+		ExposedHeaders:   getStringSliceEnv("CORS_EXPOSED_HEADERS", []string{"ETag"}),
 		AllowCredentials: getBoolEnv("CORS_ALLOW_CREDENTIALS", false),
 		MaxAge:           getDurationEnv("CORS_MAX_AGE", 86400*time.Second),
 	}
 
 	// Load rate limiting configuration
 	cfg.RateLimit = RateLimitConfig{
-		Enabled:          getBoolEnv("RATE_LIMIT_ENABLED", true),
+		Enabled:           getBoolEnv("RATE_LIMIT_ENABLED", true),
 		RequestsPerWindow: getIntEnv("RATE_LIMIT_REQUESTS", 100),
-		Window:           getDurationEnv("RATE_LIMIT_WINDOW", 1*time.Minute),
-		CleanupInterval:  getDurationEnv("RATE_LIMIT_CLEANUP_INTERVAL", 5*time.Minute),
+		Window:            getDurationEnv("RATE_LIMIT_WINDOW", 1*time.Minute),
+		RoleLimits:        getEnv("RATE_LIMIT_ROLE_LIMITS", ""),
+		RouteLimits:       getEnv("RATE_LIMIT_ROUTE_LIMITS", ""),
+	}
+
+	// Load cluster capacity configuration
+	cfg.Capacity = CapacityConfig{
+		OversubscriptionPolicy: getEnv("OVERSUBSCRIPTION_POLICY", "reject"),
+		OversubscriptionFactor: getFloatEnv("OVERSUBSCRIPTION_FACTOR", 1.0),
+	}
+
+	// Load emergency mode configuration
+	cfg.Emergency = EmergencyConfig{
+		ReaperInterval: getDurationEnv("EMERGENCY_REAPER_INTERVAL", 5*time.Second),
 	}
 
 	// Load logging configuration
 	cfg.Log = LogConfig{
-		Level:     getEnv("LOG_LEVEL", "info"),
-		Format:    getEnv("LOG_FORMAT", "console"),
+		Level:      getEnv("LOG_LEVEL", "info"),
+		Format:     getEnv("LOG_FORMAT", "console"),
 		OutputPath: getEnv("LOG_OUTPUT_PATH", ""),
 	}
 
@@ -188,6 +446,14 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("server port cannot be empty")
 	}
 
+	// Validate storage backend
+	if c.Storage.Backend != "redis" && c.Storage.Backend != "memory" {
+		return fmt.Errorf("invalid storage backend: %s (must be redis or memory)", c.Storage.Backend)
+	}
+	if c.Storage.MaxHistoryEntries <= 0 {
+		return fmt.Errorf("history max entries must be positive")
+	}
+
 	// Validate Redis configuration
 	if c.Redis.Addr == "" {
 		return fmt.Errorf("redis address cannot be empty")
@@ -200,12 +466,15 @@ func (c *Config) Validate() error {
 	}
 
 	// Validate JWT configuration
-	if c.JWT.Secret == "" {
-		return fmt.Errorf("JWT secret must be set (use JWT_SECRET environment variable)")
+	if c.JWT.Secret == "" && c.JWT.KeysDir == "" {
+		return fmt.Errorf("JWT secret must be set (use JWT_SECRET or JWT_KEYS_DIR environment variable)")
 	}
-	if len(c.JWT.Secret) < 32 {
+	if c.JWT.Secret != "" && len(c.JWT.Secret) < 32 {
 		return fmt.Errorf("JWT secret must be at least 32 characters for security")
 	}
+	if c.JWT.ActiveKeyID != "" && c.JWT.KeysDir == "" {
+		return fmt.Errorf("JWT_ACTIVE_KEY_ID requires JWT_KEYS_DIR")
+	}
 	if c.JWT.AccessExpiration <= 0 {
 		return fmt.Errorf("JWT access expiration must be positive")
 	}
@@ -213,6 +482,73 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("JWT refresh expiration must be positive")
 	}
 
+	// Validate user authentication configuration
+	if c.Auth.BootstrapAdminPassword != "" && c.Auth.BootstrapAdminPasswordFile != "" {
+		return fmt.Errorf("set only one of BOOTSTRAP_ADMIN_PASSWORD and BOOTSTRAP_ADMIN_PASSWORD_FILE")
+	}
+	if c.Auth.PasswordHashCost < 4 || c.Auth.PasswordHashCost > 31 {
+		return fmt.Errorf("password hash cost must be between 4 and 31")
+	}
+
+	// Validate OIDC configuration
+	if c.OIDC.Enabled {
+		if c.OIDC.IssuerURL == "" || c.OIDC.ClientID == "" || c.OIDC.RedirectURL == "" {
+			return fmt.Errorf("OIDC_ISSUER_URL, OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set when OIDC is enabled")
+		}
+		if !hasString(c.OIDC.Scopes, "openid") {
+			return fmt.Errorf("OIDC scopes must include openid")
+		}
+		if _, err := c.OIDC.RoleMap(); err != nil {
+			return err
+		}
+		if c.OIDC.DefaultRole != "" && !isRole(c.OIDC.DefaultRole) {
+			return fmt.Errorf("invalid OIDC default role: %s", c.OIDC.DefaultRole)
+		}
+	} else if c.OIDC.DisablePasswordLogin {
+		return fmt.Errorf("OIDC_DISABLE_PASSWORD_LOGIN requires OIDC_ENABLED, or no one could log in")
+	}
+
+	// Validate login protection configuration
+	if c.LoginProtection.Enabled {
+		if c.LoginProtection.MaxUserFailures <= 0 || c.LoginProtection.MaxIPFailures <= 0 {
+			return fmt.Errorf("login failure limits must be positive")
+		}
+		if c.LoginProtection.FailureWindow <= 0 || c.LoginProtection.LockoutDuration <= 0 {
+			return fmt.Errorf("login failure window and lockout duration must be positive")
+		}
+		if c.LoginProtection.BackoffBase < 0 || c.LoginProtection.BackoffMax < c.LoginProtection.BackoffBase {
+			return fmt.Errorf("login backoff must be non-negative and not exceed LOGIN_BACKOFF_MAX")
+		}
+	}
+
+	// Validate rate limiting configuration
+	if c.RateLimit.Enabled {
+		if c.RateLimit.RequestsPerWindow <= 0 || c.RateLimit.Window <= 0 {
+			return fmt.Errorf("rate limit requests and window must be positive")
+		}
+		if _, err := c.RateLimit.RoleLimitMap(); err != nil {
+			return err
+		}
+		if _, err := c.RateLimit.RouteLimitMap(); err != nil {
+			return err
+		}
+	}
+
+	// Validate cluster capacity configuration
+	switch c.Capacity.OversubscriptionPolicy {
+	case "reject", "warn", "allow":
+	default:
+		return fmt.Errorf("invalid oversubscription policy: %s (must be reject, warn, or allow)", c.Capacity.OversubscriptionPolicy)
+	}
+	if c.Capacity.OversubscriptionFactor < 1 {
+		return fmt.Errorf("oversubscription factor must be at least 1")
+	}
+
+	// Validate emergency mode configuration
+	if c.Emergency.ReaperInterval <= 0 {
+		return fmt.Errorf("emergency reaper interval must be positive")
+	}
+
 	// Validate log level
 	validLogLevels := map[string]bool{
 		"debug": true,
@@ -250,6 +586,16 @@ func getIntEnv(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getFloatEnv retrieves an environment variable as a float or returns a default value.
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+	}
+	return defaultValue
+}
+
 // getBoolEnv retrieves an environment variable as a boolean or returns a default value.
 func getBoolEnv(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
@@ -274,9 +620,25 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 // The environment variable should be a comma-separated list.
 func getStringSliceEnv(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
-		if value != "" {
-			return []string{value}
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		if len(values) > 0 {
+			return values
 		}
 	}
 	return defaultValue
 }
+
+// hasString reports whether values contains s.
+func hasString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -17,6 +17,10 @@ type AppError struct {
 	Err error
 	// Context contains additional error context
 	Context map[string]interface{}
+	// ErrorCode is the stable machine-readable error code (defaults from Code)
+	ErrorCode string
+	// Fields lists the invalid request fields, if any
+	Fields []FieldError
 }
 
 // Error returns the error message.
@@ -32,6 +36,27 @@ func (e *AppError) Unwrap() error {
 	return e.Err
 }
 
+// WithErrorCode sets the machine-readable error code and returns the error.
+func (e *AppError) WithErrorCode(code string) *AppError {
+	e.ErrorCode = code
+	return e
+}
+
+// WithFields attaches field-level details and returns the error.
+func (e *AppError) WithFields(fields ...FieldError) *AppError {
+	e.Fields = append(e.Fields, fields...)
+	return e
+}
+
+// WithContext adds a context value and returns the error.
+func (e *AppError) WithContext(key string, value interface{}) *AppError {
+	if e.Context == nil {
+		e.Context = make(map[string]interface{})
+	}
+	e.Context[key] = value
+	return e
+}
+
 // NewAppError creates a new application error.
 func NewAppError(code int, message string, err error) *AppError {
 	return &AppError{
@@ -83,6 +108,11 @@ func InternalServerError(message string, err error) *AppError {
 	return NewAppError(http.StatusInternalServerError, message, err)
 }
 
+// TooManyRequests creates a 429 Too Many Requests error.
+func TooManyRequests(message string, err error) *AppError {
+	return NewAppError(http.StatusTooManyRequests, message, err)
+}
+
 // ServiceUnavailable creates a 503 Service Unavailable error.
 func ServiceUnavailable(message string, err error) *AppError {
 	return NewAppError(http.StatusServiceUnavailable, message, err)
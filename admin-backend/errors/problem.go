@@ -0,0 +1,164 @@
+// Package errors provides the RFC 7807 problem details returned for failed requests.
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ProblemContentType is the media type of problem detail responses.
+const ProblemContentType = "application/problem+json"
+
+// Machine-readable error codes. They are part of the API and must not change.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodePermissionDenied   = "permission_denied"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeVersionConflict    = "version_conflict"
+	CodeCapacityExceeded   = "capacity_exceeded"
+	CodePreconditionFailed = "precondition_failed"
+	CodeRateLimited        = "rate_limited"
+	CodeLoginThrottled     = "login_throttled"
+	CodeInternal           = "internal_error"
+	CodeServiceUnavailable = "service_unavailable"
+	CodeTimeout            = "timeout"
+)
+
+// statusCodes maps HTTP statuses to the error code used when none is set.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          CodeBadRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusPreconditionFailed:  CodePreconditionFailed,
+	http.StatusUnprocessableEntity: CodeValidationFailed,
+	http.StatusTooManyRequests:     CodeRateLimited,
+	http.StatusInternalServerError: CodeInternal,
+	http.StatusServiceUnavailable:  CodeServiceUnavailable,
+	http.StatusGatewayTimeout:      CodeTimeout,
+}
+
+// DefaultErrorCode returns the error code for an HTTP status.
+func DefaultErrorCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// FieldError describes why one request field was rejected.
+type FieldError struct {
+	// Field is the JSON path of the field, e.g. "guaranteed_quota"
+	Field string `json:"field"`
+	// Rule is the validation rule that failed, e.g. "required" or "min"
+	Rule string `json:"rule,omitempty"`
+	// Message is a user-friendly explanation
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details document.
+// Context values of the error are rendered as extension members.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	// Error repeats Detail for clients written against the older {"error": "..."} body
+	Error string `json:"error"`
+	// Extensions holds additional members taken from AppError.Context
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON renders the problem with its extension members inlined.
+// Extensions never override the standard members.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	base, err := json.Marshal((*problem)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return base, err
+	}
+
+	members := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(base, &members); err != nil {
+		return nil, err
+	}
+	for key, value := range p.Extensions {
+		if _, ok := members[key]; ok {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		members[key] = raw
+	}
+	return json.Marshal(members)
+}
+
+// Resolve returns the AppError a client should see for err.
+//
+// The outermost AppError wins, except that a server error wrapping a client
+// error (4xx) yields the client error: a handler reporting "failed to update
+// application" must not turn storage's NotFound or BadRequest into a 500.
+// Errors without an AppError become a generic 500.
+func Resolve(err error) *AppError {
+	var outer *AppError
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		appErr, ok := e.(*AppError)
+		if !ok {
+			continue
+		}
+		if outer == nil {
+			outer = appErr
+			if appErr.Code < http.StatusInternalServerError {
+				return appErr
+			}
+			continue
+		}
+		if appErr.Code >= http.StatusBadRequest && appErr.Code < http.StatusInternalServerError {
+			return appErr
+		}
+	}
+
+	if outer == nil {
+		return InternalServerError("internal server error", err)
+	}
+	return outer
+}
+
+// NewProblem builds the problem details for err. Only the message of the
+// resolved AppError is exposed; wrapped errors stay in the logs.
+func NewProblem(err error, requestID, instance string) *Problem {
+	appErr := Resolve(err)
+
+	code := appErr.ErrorCode
+	if code == "" {
+		code = DefaultErrorCode(appErr.Code)
+	}
+
+	return &Problem{
+		Type:       "about:blank",
+		Title:      http.StatusText(appErr.Code),
+		Status:     appErr.Code,
+		Detail:     appErr.Message,
+		Instance:   instance,
+		Code:       code,
+		RequestID:  requestID,
+		Errors:     appErr.Fields,
+		Error:      appErr.Message,
+		Extensions: appErr.Context,
+	}
+}
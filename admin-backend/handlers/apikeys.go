@@ -0,0 +1,249 @@
+// Package handlers provides HTTP handlers for service account API keys.
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/validation"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListAPIKeys returns all API keys. Secrets are never included.
+// @Summary List API keys
+// @Description Get all service account API keys with their scope, expiry and last use
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/api-keys [get]
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	keys, err := h.storage.ListAPIKeys(ctx)
+	if err != nil {
+		logger.Errorw("failed to list API keys",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list API keys", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// CreateAPIKey issues a new API key scoped to a role or an explicit permission list.
+// @Summary Create API key
+// @Description Issue a service account API key. The key is only returned in this response.
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param request body models.CreateAPIKeyRequest true "Key name, scope and lifetime in seconds (0 = no expiry)"
+// @Success 201 {object} models.CreateAPIKeyResponse
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden or scope exceeds the caller's permissions"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/api-keys [post]
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.BadRequest("invalid request format", err))
+		return
+	}
+
+	if err := validation.ValidateAPIKeyName(req.Name); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := validation.ValidateAPIKeyExpiry(req.ExpiresIn); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	key := &models.APIKey{
+		ID:        uuid.NewString(),
+		Name:      strings.TrimSpace(req.Name),
+		Role:      req.Role,
+		CreatedBy: c.GetString(middleware.UserIDKey),
+	}
+
+	switch {
+	case req.Role != "" && len(req.Permissions) > 0:
+		_ = c.Error(errors.BadRequest("specify either role or permissions, not both", nil))
+		return
+	case len(req.Permissions) > 0:
+		perms, err := normalizePermissions(req.Permissions)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		key.Permissions = perms
+	case req.Role == "":
+		_ = c.Error(errors.BadRequest("role or permissions is required", nil))
+		return
+	default:
+		if err := validation.ValidateRole(req.Role); err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+
+	// A key may not grant more than its creator holds
+	for _, p := range middleware.APIKeyPermissions(key) {
+		if !middleware.Permitted(c, p) {
+			appErr := errors.Forbidden(fmt.Sprintf("cannot grant permission %s you do not hold", p), nil).
+				WithErrorCode(errors.CodePermissionDenied).
+				WithContext("permission", p)
+
+			logger.Warnw("API key permission escalation denied",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"user_id", c.GetString(middleware.UserIDKey),
+				"permission", p,
+			)
+			middleware.RecordAudit(c, h.storage, string(middleware.PermAPIKeysManage), models.AuditDenied, appErr.Message)
+
+			_ = c.Error(appErr)
+			return
+		}
+	}
+
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Unix(time.Now().Unix()+req.ExpiresIn, 0)
+		key.ExpiresAt = &expiresAt
+	}
+
+	secret, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		logger.Errorw("failed to generate API key", "request_id", c.GetString(middleware.RequestIDKey), "error", err)
+		_ = c.Error(errors.InternalServerError("failed to create API key", err))
+		return
+	}
+	key.Prefix = prefix
+	key.KeyHash = hash
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	if err := h.storage.CreateAPIKey(ctx, key); err != nil {
+		logger.Errorw("failed to create API key",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"name", key.Name,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to create API key", err))
+		return
+	}
+
+	logger.Infow("API key created",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", key.CreatedBy,
+		"api_key_id", key.ID,
+		"name", key.Name,
+		"role", key.Role,
+		"permissions", key.Permissions,
+	)
+
+	c.JSON(http.StatusCreated, models.CreateAPIKeyResponse{APIKey: *key, Key: secret})
+}
+
+// GetAPIKey retrieves an API key by ID.
+// @Summary Get API key
+// @Description Get a service account API key by ID
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} models.APIKey
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/api-keys/{id} [get]
+func (h *Handler) GetAPIKey(c *gin.Context) {
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	key, err := h.storage.GetAPIKey(ctx, c.Param("id"))
+	if err != nil {
+		logger.Errorw("failed to get API key",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"api_key_id", c.Param("id"),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to get API key", err))
+		return
+	}
+
+	if key == nil {
+		_ = c.Error(errors.NotFound("API key not found", nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// DeleteAPIKey revokes an API key.
+// @Summary Delete API key
+// @Description Revoke a service account API key; requests using it are rejected immediately
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 204
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/api-keys/{id} [delete]
+func (h *Handler) DeleteAPIKey(c *gin.Context) {
+	keyID := c.Param("id")
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	if err := h.storage.DeleteAPIKey(ctx, keyID); err != nil {
+		reportError(c, err, "failed to delete API key", "api_key_id", keyID)
+		return
+	}
+
+	logger.Infow("API key deleted",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"api_key_id", keyID,
+	)
+
+	c.Status(http.StatusNoContent)
+}
+
+// normalizePermissions checks that every entry names a known permission and
+// returns the list sorted with duplicates removed.
+func normalizePermissions(perms []string) ([]string, error) {
+	seen := make(map[string]bool, len(perms))
+	out := make([]string, 0, len(perms))
+	for _, p := range perms {
+		if !middleware.IsPermission(p) {
+			return nil, errors.BadRequest(fmt.Sprintf("unknown permission %q", p), nil)
+		}
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+
+	sort.Strings(out)
+	return out, nil
+}
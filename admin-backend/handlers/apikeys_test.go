@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIKeyScope(t *testing.T) {
+	// A key scoped to manage keys and work on apps, but nothing else
+	scoped := []string{
+		string(middleware.PermAPIKeysManage),
+		string(middleware.PermAppsRead),
+		string(middleware.PermAppsWrite),
+	}
+
+	tests := []struct {
+		name       string
+		callerRole string
+		callerKey  []string
+		req        models.CreateAPIKeyRequest
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "admin grants a role",
+			callerRole: models.RoleAdmin,
+			req:        models.CreateAPIKeyRequest{Name: "ci", Role: models.RoleOperator},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "admin grants permissions",
+			callerRole: models.RoleAdmin,
+			req:        models.CreateAPIKeyRequest{Name: "ci", Permissions: []string{"apps:read"}},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "role and permissions",
+			callerRole: models.RoleAdmin,
+			req:        models.CreateAPIKeyRequest{Name: "ci", Role: models.RoleViewer, Permissions: []string{"apps:read"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no scope",
+			callerRole: models.RoleAdmin,
+			req:        models.CreateAPIKeyRequest{Name: "ci"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown permission",
+			callerRole: models.RoleAdmin,
+			req:        models.CreateAPIKeyRequest{Name: "ci", Permissions: []string{"apps:launch"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "operator may not manage keys",
+			callerRole: models.RoleOperator,
+			req:        models.CreateAPIKeyRequest{Name: "ci", Role: models.RoleViewer},
+			wantStatus: http.StatusForbidden,
+			wantCode:   errors.CodePermissionDenied,
+		},
+		{
+			name:       "scoped key grants a permission it holds",
+			callerKey:  scoped,
+			req:        models.CreateAPIKeyRequest{Name: "ci", Permissions: []string{"apps:read", "apps:write"}},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "scoped key grants a permission it lacks",
+			callerKey:  scoped,
+			req:        models.CreateAPIKeyRequest{Name: "ci", Permissions: []string{"apps:read", "apps:delete"}},
+			wantStatus: http.StatusForbidden,
+			wantCode:   errors.CodePermissionDenied,
+		},
+		{
+			name:       "scoped key grants a role beyond its scope",
+			callerKey:  scoped,
+			req:        models.CreateAPIKeyRequest{Name: "ci", Role: models.RoleViewer},
+			wantStatus: http.StatusForbidden,
+			wantCode:   errors.CodePermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+
+			var header http.Header
+			if tt.callerKey != nil {
+				header = s.apiKey("", tt.callerKey, nil)
+			} else {
+				header = s.bearer(s.createUser("caller", tt.callerRole))
+			}
+
+			w := s.do(http.MethodPost, "/api/v1/api-keys", tt.req, header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			switch w.Code {
+			case http.StatusCreated:
+				var created models.CreateAPIKeyResponse
+				decodeResponse(t, w, &created)
+				if created.Key == "" {
+					t.Fatal("response lacks the key")
+				}
+				// The new key authenticates with exactly the granted scope
+				if w := s.do(http.MethodGet, "/api/v1/apps", nil, apiKeyHeader(created.Key)); w.Code != http.StatusOK {
+					t.Errorf("new key listing apps: status %d", w.Code)
+				}
+
+			case http.StatusForbidden:
+				if code := problemCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+				entries, err := s.store.ListAuditEntries(context.Background(), 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) == 0 || entries[0].Outcome != models.AuditDenied ||
+					entries[0].Action != string(middleware.PermAPIKeysManage) {
+					t.Errorf("denial not audited: %+v", entries)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		role        string
+		permissions []string
+		expiresAt   *time.Time
+		authScheme  bool
+		method      string
+		wantStatus  int
+	}{
+		{name: "scoped key reads apps", permissions: []string{"apps:read"}, method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "authorization header", permissions: []string{"apps:read"}, authScheme: true, method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "scoped key may not write", permissions: []string{"apps:read"}, method: http.MethodPost, wantStatus: http.StatusForbidden},
+		{name: "role key reads apps", role: models.RoleViewer, method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "role key may not write", role: models.RoleViewer, method: http.MethodPost, wantStatus: http.StatusForbidden},
+		{name: "unexpired key", role: models.RoleViewer, expiresAt: &future, method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "expired key", role: models.RoleViewer, expiresAt: &past, method: http.MethodGet, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			header := s.apiKey(tt.role, tt.permissions, tt.expiresAt)
+			if tt.authScheme {
+				header = http.Header{"Authorization": {"ApiKey " + header.Get(middleware.APIKeyHeader)}}
+			}
+
+			var body interface{}
+			if tt.method == http.MethodPost {
+				body = models.AppConfig{AppID: "app1", GuaranteedQuota: 100, BurstQuota: 200, Priority: 1}
+			}
+			w := s.do(tt.method, "/api/v1/apps", body, header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("unknown key", func(t *testing.T) {
+		s := newTestServer(t)
+		if w := s.do(http.MethodGet, "/api/v1/apps", nil, apiKeyHeader("rk_unknown")); w.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want 401", w.Code)
+		}
+	})
+}
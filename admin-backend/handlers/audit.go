@@ -0,0 +1,48 @@
+// Package handlers provides HTTP handlers for the audit log.
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListAuditEntries returns the most recent audit log entries.
+// @Summary List audit entries
+// @Description Get recent audit log entries, newest first
+// @Tags audit
+// @Accept json
+// @Produce json
+// @Param limit query int false "Maximum number of entries (default 100, max 1000)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/audit [get]
+func (h *Handler) ListAuditEntries(c *gin.Context) {
+	limit, err := parseLimit(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	entries, err := h.storage.ListAuditEntries(ctx, limit)
+	if err != nil {
+		logger.Errorw("failed to list audit entries",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list audit entries", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
@@ -0,0 +1,532 @@
+// Package handlers provides HTTP handlers for emergency extension, scheduled
+// windows and history, and the background work that starts scheduled windows
+// and deactivates expired emergencies.
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"admin-backend/validation"
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// StartEmergencyReaper deactivates expired emergencies and starts due
+// scheduled windows every interval until Close is called. Storage expires
+// emergencies and claims windows atomically, so every backend instance can
+// run a reaper.
+func (h *Handler) StartEmergencyReaper(interval time.Duration) {
+	h.stopReaper = make(chan struct{})
+	go h.reapEmergencies(interval, h.stopReaper)
+}
+
+// reapEmergencies runs the reaper until stop is closed.
+func (h *Handler) reapEmergencies(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.reap()
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reap runs one tick of the reaper. Both jobs run on every tick, so a failing
+// one does not hold up the other.
+func (h *Handler) reap() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	h.expireEmergency(ctx)
+	h.startScheduledEmergencies(ctx)
+}
+
+// expireEmergency deactivates emergency mode if it has expired.
+func (h *Handler) expireEmergency(ctx context.Context) {
+	expired, err := h.storage.ExpireEmergency(ctx)
+	if err != nil {
+		logger.Warnw("failed to expire emergency mode", "error", err)
+		return
+	}
+	if expired != nil {
+		logger.Warnw("emergency mode expired",
+			"reason", expired.Reason,
+			"activated_at", expired.ActivatedAt,
+			"expires_at", expired.ExpiresAt,
+		)
+	}
+}
+
+// startScheduledEmergencies starts the scheduled windows that are due. A
+// window ends at its end time however late it starts; an emergency already
+// active is extended to the window's end if that is later. A window that
+// fails to start is put back to be retried on the next tick.
+func (h *Handler) startScheduledEmergencies(ctx context.Context) {
+	now := time.Now()
+	schedules, err := h.storage.ClaimEmergencySchedules(ctx, now)
+	if err != nil {
+		logger.Warnw("failed to claim emergency schedules", "error", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		remaining := schedule.EndAt.Unix() - now.Unix()
+		if remaining <= 0 {
+			logger.Warnw("scheduled emergency window missed",
+				"schedule_id", schedule.ID,
+				"start_at", schedule.StartAt,
+				"end_at", schedule.EndAt,
+				"attempts", schedule.Attempts,
+				"last_error", schedule.LastError,
+			)
+			continue
+		}
+
+		// The window is recorded in the history as its creator's change
+		scheduleCtx := storage.WithChangeInfo(ctx, storage.ChangeInfo{
+			Author: schedule.CreatedBy,
+			Reason: "scheduled window " + schedule.ID,
+		})
+
+		status, err := h.storage.GetEmergencyStatus(ctx)
+		if err == nil {
+			switch {
+			case !status.Active:
+				err = h.storage.ActivateEmergency(scheduleCtx, schedule.Reason, remaining)
+			case status.ExpiresAt.Before(schedule.EndAt):
+				_, err = h.storage.ExtendEmergency(scheduleCtx, remaining)
+			}
+		}
+		if err != nil {
+			h.releaseSchedule(schedule, err)
+			continue
+		}
+
+		logger.Warnw("scheduled emergency window started",
+			"schedule_id", schedule.ID,
+			"reason", schedule.Reason,
+			"end_at", schedule.EndAt,
+		)
+	}
+}
+
+// releaseSchedule puts back a claimed window that failed to start with cause,
+// so it is listed with the failure and retried on the next tick. A refusal
+// such as the 24 hour cap on extending the active emergency clears once that
+// emergency ends.
+func (h *Handler) releaseSchedule(schedule *models.EmergencySchedule, cause error) {
+	schedule.Attempts++
+	schedule.LastError = errors.Resolve(cause).Message
+
+	if isClientError(cause) {
+		logger.Warnw("scheduled emergency window refused",
+			"schedule_id", schedule.ID,
+			"attempts", schedule.Attempts,
+			"error", cause,
+		)
+	} else {
+		logger.Errorw("failed to start scheduled emergency window",
+			"schedule_id", schedule.ID,
+			"attempts", schedule.Attempts,
+			"error", cause,
+		)
+	}
+
+	// The tick's context may have run out while the start failed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.storage.CreateEmergencySchedule(ctx, schedule); err != nil {
+		logger.Errorw("failed to put back scheduled emergency window",
+			"schedule_id", schedule.ID,
+			"error", err,
+		)
+	}
+}
+
+// ExtendEmergency moves the expiry of the active emergency.
+// @Summary Extend emergency mode
+// @Description Make the active emergency expire the given number of seconds from now, extending or shortening it
+// @Tags emergency
+// @Accept json
+// @Produce json
+// @Param request body models.EmergencyExtendRequest true "Extend request"
+// @Param X-Change-Reason header string false "Comment recorded in the emergency history"
+// @Success 200 {object} models.EmergencyStatus
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 409 {object} errors.Problem "Emergency mode is not active, or would run longer than 24 hours from activation"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/emergency/extend [post]
+func (h *Handler) ExtendEmergency(c *gin.Context) {
+	var req models.EmergencyExtendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(validation.BindError(err))
+		return
+	}
+
+	if err := validation.ValidateEmergencyDuration(req.Duration); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := withChangeInfo(c, h.getRequestContext(c, 5*time.Second))
+	defer h.cancelRequestContext(c)
+
+	status, err := h.storage.ExtendEmergency(ctx, req.Duration)
+	if err != nil {
+		reportError(c, err, "failed to extend emergency mode", "user_id", c.GetString(middleware.UserIDKey))
+		return
+	}
+
+	logger.Warnw("emergency mode extended",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"duration", req.Duration,
+		"expires_at", status.ExpiresAt,
+	)
+
+	c.JSON(http.StatusOK, status)
+}
+
+// ListEmergencySchedules returns the pending scheduled emergency windows.
+// @Summary List emergency schedules
+// @Description Get the scheduled emergency windows that have not started, ordered by start time. Windows that failed to start carry attempts and last_error until retried.
+// @Tags emergency
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/emergency/schedules [get]
+func (h *Handler) ListEmergencySchedules(c *gin.Context) {
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	schedules, err := h.storage.ListEmergencySchedules(ctx)
+	if err != nil {
+		logger.Errorw("failed to list emergency schedules",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list emergency schedules", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+// CreateEmergencySchedule schedules an emergency window.
+// @Summary Schedule emergency window
+// @Description Schedule emergency mode to activate at start_at and expire duration seconds later. Windows must not overlap.
+// @Tags emergency
+// @Accept json
+// @Produce json
+// @Param request body models.EmergencyScheduleRequest true "Schedule request"
+// @Success 201 {object} models.EmergencySchedule
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 409 {object} errors.Problem "Window overlaps a scheduled window, or would extend the active emergency past 24 hours from activation"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/emergency/schedules [post]
+func (h *Handler) CreateEmergencySchedule(c *gin.Context) {
+	var req models.EmergencyScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(validation.BindError(err))
+		return
+	}
+
+	req.Reason = validation.SanitizeReason(req.Reason)
+
+	now := time.Now()
+	if err := validation.ValidateEmergencySchedule(req.Reason, req.StartAt, req.Duration, now); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	startAt := time.Unix(req.StartAt.Unix(), 0)
+	schedule := &models.EmergencySchedule{
+		ID:        uuid.NewString(),
+		Reason:    req.Reason,
+		StartAt:   startAt,
+		EndAt:     startAt.Add(time.Duration(req.Duration) * time.Second),
+		Duration:  req.Duration,
+		CreatedBy: c.GetString(middleware.UserIDKey),
+		CreatedAt: time.Unix(now.Unix(), 0),
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	pending, err := h.storage.ListEmergencySchedules(ctx)
+	if err != nil {
+		reportError(c, err, "failed to create emergency schedule", "user_id", c.GetString(middleware.UserIDKey))
+		return
+	}
+	status, err := h.storage.GetEmergencyStatus(ctx)
+	if err != nil {
+		reportError(c, err, "failed to create emergency schedule", "user_id", c.GetString(middleware.UserIDKey))
+		return
+	}
+	if err := validation.ValidateScheduleConflicts(schedule.StartAt, schedule.EndAt, pending, status); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if err := h.storage.CreateEmergencySchedule(ctx, schedule); err != nil {
+		logger.Errorw("failed to create emergency schedule",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"user_id", c.GetString(middleware.UserIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to create emergency schedule", err))
+		return
+	}
+
+	logger.Infow("emergency window scheduled",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"schedule_id", schedule.ID,
+		"start_at", schedule.StartAt,
+		"end_at", schedule.EndAt,
+	)
+
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// CancelEmergencySchedule cancels a pending scheduled emergency window.
+// @Summary Cancel emergency schedule
+// @Description Cancel a scheduled emergency window that has not started
+// @Tags emergency
+// @Accept json
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 204
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/emergency/schedules/{id} [delete]
+func (h *Handler) CancelEmergencySchedule(c *gin.Context) {
+	scheduleID := c.Param("id")
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	if _, err := h.storage.CancelEmergencySchedule(ctx, scheduleID); err != nil {
+		reportError(c, err, "failed to cancel emergency schedule", "schedule_id", scheduleID)
+		return
+	}
+
+	logger.Infow("emergency window cancelled",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"schedule_id", scheduleID,
+	)
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetEmergencyHistory returns the emergency history of a time range with totals.
+// @Summary Get emergency history
+// @Description Get emergency activations, extensions, deactivations and expiries, newest first, with time spent in emergency per week
+// @Tags emergency
+// @Accept json
+// @Produce json
+// @Param from query string false "Start of the range (RFC 3339)"
+// @Param to query string false "End of the range (RFC 3339)"
+// @Param limit query int false "Maximum number of events (default 100, max 1000)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/emergency/history [get]
+func (h *Handler) GetEmergencyHistory(c *gin.Context) {
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		_ = c.Error(errors.BadRequest("to must not be before from", nil))
+		return
+	}
+	limit, err := parseLimit(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	events, err := h.storage.ListEmergencyEvents(ctx, from, to)
+	if err != nil {
+		logger.Errorw("failed to list emergency events",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list emergency events", err))
+		return
+	}
+
+	status, err := h.storage.GetEmergencyStatus(ctx)
+	if err != nil {
+		logger.Errorw("failed to get emergency status",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to get emergency status", err))
+		return
+	}
+
+	// Totals cover the whole range; only the listed events are limited
+	totals := emergencyTotals(events, status, from, to, time.Now())
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"totals": totals,
+	})
+}
+
+// parseTimeQuery parses an optional RFC 3339 query parameter, returning the
+// zero time if it is absent.
+func parseTimeQuery(c *gin.Context, name string) (time.Time, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, errors.BadRequest(name+" must be an RFC 3339 timestamp", nil)
+	}
+	return t, nil
+}
+
+// emergencyTotals counts the events, newest first, and sums the time spent in
+// emergency between from and to, per week. The periods are rebuilt from the
+// events: each ends with its deactivation or expiry (as last extended), or
+// with a later activation replacing it. current covers an emergency activated before from.
+func emergencyTotals(events []*models.EmergencyEvent, current *models.EmergencyStatus, from, to, now time.Time) *models.EmergencyTotals {
+	if to.IsZero() || to.After(now) {
+		to = now
+	}
+
+	totals := &models.EmergencyTotals{}
+	weeks := make(map[time.Time]*models.EmergencyWeek)
+	week := func(t time.Time) *models.EmergencyWeek {
+		start := weekStart(t)
+		w, ok := weeks[start]
+		if !ok {
+			w = &models.EmergencyWeek{WeekStart: start}
+			weeks[start] = w
+		}
+		return w
+	}
+
+	type period struct{ start, end time.Time }
+	var periods []period
+	var open *models.EmergencyEvent
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		switch event.Action {
+		case models.EmergencyActivated:
+			totals.Activations++
+			week(event.Timestamp).Activations++
+			if open != nil {
+				periods = append(periods, period{open.ActivatedAt, earliest(event.Timestamp, open.ExpiresAt)})
+			}
+			open = event
+
+		case models.EmergencyExtended:
+			// Carries the emergency's activation and its new expiry
+			totals.Extensions++
+			open = event
+
+		case models.EmergencyDeactivated, models.EmergencyExpired:
+			if event.Action == models.EmergencyDeactivated {
+				totals.Deactivations++
+			} else {
+				totals.Expirations++
+			}
+			if !event.ActivatedAt.IsZero() {
+				end := event.ActivatedAt.Add(time.Duration(event.Duration) * time.Second)
+				periods = append(periods, period{event.ActivatedAt, end})
+			}
+			open = nil
+		}
+	}
+	if open != nil {
+		periods = append(periods, period{open.ActivatedAt, earliest(now, open.ExpiresAt)})
+	} else if current != nil && current.Active {
+		periods = append(periods, period{current.ActivatedAt, now})
+	}
+
+	for _, p := range periods {
+		start, end := p.start, p.end
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		// Split the period at week boundaries
+		for start.Before(end) {
+			next := weekStart(start).AddDate(0, 0, 7)
+			if next.After(end) {
+				next = end
+			}
+			seconds := int64(next.Sub(start) / time.Second)
+			week(start).Seconds += seconds
+			totals.Seconds += seconds
+			start = next
+		}
+	}
+
+	totals.Weeks = make([]*models.EmergencyWeek, 0, len(weeks))
+	for _, w := range weeks {
+		totals.Weeks = append(totals.Weeks, w)
+	}
+	sort.Slice(totals.Weeks, func(i, j int) bool {
+		return totals.Weeks[i].WeekStart.Before(totals.Weeks[j].WeekStart)
+	})
+	return totals
+}
+
+// weekStart returns the start of the week containing t: Monday 00:00 UTC.
+func weekStart(t time.Time) time.Time {
+	day := t.UTC().Truncate(24 * time.Hour)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// earliest returns the earlier of t and the optional deadline.
+func earliest(t, deadline time.Time) time.Time {
+	if !deadline.IsZero() && deadline.Before(t) {
+		return deadline
+	}
+	return t
+}
@@ -0,0 +1,576 @@
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReap(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup brings the emergency into the state the ticks find
+		setup func(t *testing.T, store storage.Storage)
+		ticks int
+		// wantActive and wantActions are the state after the ticks and the
+		// history actions recorded, newest first
+		wantActive  bool
+		wantActions []string
+	}{
+		{
+			name:  "no active emergency",
+			setup: func(t *testing.T, store storage.Storage) {},
+			ticks: 1,
+		},
+		{
+			name: "active emergency kept",
+			setup: func(t *testing.T, store storage.Storage) {
+				if err := store.ActivateEmergency(context.Background(), "load", 3600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			ticks:       1,
+			wantActive:  true,
+			wantActions: []string{models.EmergencyActivated},
+		},
+		{
+			name: "expired emergency deactivated",
+			setup: func(t *testing.T, store storage.Storage) {
+				expireNow(t, store)
+			},
+			ticks:       1,
+			wantActions: []string{models.EmergencyExpired, models.EmergencyExtended, models.EmergencyActivated},
+		},
+		{
+			name: "expired once over several ticks",
+			setup: func(t *testing.T, store storage.Storage) {
+				expireNow(t, store)
+			},
+			ticks:       3,
+			wantActions: []string{models.EmergencyExpired, models.EmergencyExtended, models.EmergencyActivated},
+		},
+		{
+			name: "deactivated emergency not expired again",
+			setup: func(t *testing.T, store storage.Storage) {
+				ctx := context.Background()
+				if err := store.ActivateEmergency(ctx, "load", 3600); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.DeactivateEmergency(ctx); err != nil {
+					t.Fatal(err)
+				}
+			},
+			ticks:       1,
+			wantActions: []string{models.EmergencyDeactivated, models.EmergencyActivated},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			ctx := context.Background()
+			tt.setup(t, s.store)
+
+			for i := 0; i < tt.ticks; i++ {
+				s.handler.reap()
+			}
+
+			status, err := s.store.GetEmergencyStatus(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Active != tt.wantActive {
+				t.Errorf("active = %v, want %v", status.Active, tt.wantActive)
+			}
+
+			events, err := s.store.ListEmergencyEvents(ctx, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actions []string
+			for _, event := range events {
+				actions = append(actions, event.Action)
+			}
+			if !reflect.DeepEqual(actions, tt.wantActions) {
+				t.Fatalf("actions = %v, want %v", actions, tt.wantActions)
+			}
+			if len(events) > 0 && events[0].Action == models.EmergencyExpired && events[0].Actor != storage.EmergencyActorSystem {
+				t.Errorf("expiry actor = %q, want %q", events[0].Actor, storage.EmergencyActorSystem)
+			}
+		})
+	}
+}
+
+// expireNow activates an emergency and shortens it to expire now, leaving it
+// for the reaper to deactivate.
+func expireNow(t *testing.T, store storage.Storage) {
+	t.Helper()
+
+	ctx := context.Background()
+	if err := store.ActivateEmergency(ctx, "load", 3600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ExtendEmergency(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmergencyTotals(t *testing.T) {
+	monday := time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)
+	at := func(days, hours int) time.Time {
+		return monday.Add(time.Duration(days*24+hours) * time.Hour)
+	}
+
+	activated := func(ts, expiresAt time.Time) *models.EmergencyEvent {
+		return &models.EmergencyEvent{Timestamp: ts, Action: models.EmergencyActivated, ActivatedAt: ts, ExpiresAt: expiresAt}
+	}
+	extended := func(ts, activatedAt, expiresAt time.Time) *models.EmergencyEvent {
+		return &models.EmergencyEvent{Timestamp: ts, Action: models.EmergencyExtended, ActivatedAt: activatedAt, ExpiresAt: expiresAt}
+	}
+	ended := func(action string, ts, activatedAt time.Time, seconds int64) *models.EmergencyEvent {
+		return &models.EmergencyEvent{Timestamp: ts, Action: action, ActivatedAt: activatedAt, Duration: seconds}
+	}
+	week := func(start time.Time, activations int, seconds int64) *models.EmergencyWeek {
+		return &models.EmergencyWeek{WeekStart: start, Activations: activations, Seconds: seconds}
+	}
+
+	tests := []struct {
+		name string
+		// events are listed oldest first
+		events   []*models.EmergencyEvent
+		current  *models.EmergencyStatus
+		from, to time.Time
+		now      time.Time
+		want     models.EmergencyTotals
+	}{
+		{
+			name: "no emergency",
+			now:  at(3, 0),
+			want: models.EmergencyTotals{Weeks: []*models.EmergencyWeek{}},
+		},
+		{
+			name: "deactivated within a week",
+			events: []*models.EmergencyEvent{
+				activated(at(1, 0), at(1, 4)),
+				ended(models.EmergencyDeactivated, at(1, 2), at(1, 0), 7200),
+			},
+			now:  at(3, 0),
+			want: models.EmergencyTotals{Activations: 1, Deactivations: 1, Seconds: 7200, Weeks: []*models.EmergencyWeek{week(monday, 1, 7200)}},
+		},
+		{
+			name: "split at the week boundary",
+			events: []*models.EmergencyEvent{
+				activated(at(6, 22), at(7, 4)),
+				ended(models.EmergencyExpired, at(7, 4), at(6, 22), 6*3600),
+			},
+			now: at(8, 0),
+			want: models.EmergencyTotals{Activations: 1, Expirations: 1, Seconds: 6 * 3600, Weeks: []*models.EmergencyWeek{
+				week(monday, 1, 2*3600),
+				week(at(7, 0), 0, 4*3600),
+			}},
+		},
+		{
+			name: "extension moves the expiry",
+			events: []*models.EmergencyEvent{
+				activated(at(1, 0), at(1, 1)),
+				extended(at(1, 0).Add(30*time.Minute), at(1, 0), at(1, 3)),
+				ended(models.EmergencyExpired, at(1, 3), at(1, 0), 3*3600),
+			},
+			now:  at(3, 0),
+			want: models.EmergencyTotals{Activations: 1, Extensions: 1, Expirations: 1, Seconds: 3 * 3600, Weeks: []*models.EmergencyWeek{week(monday, 1, 3*3600)}},
+		},
+		{
+			name: "activation replaces the open emergency",
+			events: []*models.EmergencyEvent{
+				activated(at(1, 0), at(1, 8)),
+				activated(at(1, 2), at(1, 3)),
+				ended(models.EmergencyExpired, at(1, 3), at(1, 2), 3600),
+			},
+			now:  at(3, 0),
+			want: models.EmergencyTotals{Activations: 2, Expirations: 1, Seconds: 3 * 3600, Weeks: []*models.EmergencyWeek{week(monday, 2, 3*3600)}},
+		},
+		{
+			name:   "open at the end of the range",
+			events: []*models.EmergencyEvent{activated(at(2, 0), at(2, 10))},
+			to:     at(2, 4),
+			now:    at(2, 6),
+			want:   models.EmergencyTotals{Activations: 1, Seconds: 4 * 3600, Weeks: []*models.EmergencyWeek{week(monday, 1, 4*3600)}},
+		},
+		{
+			name:   "open until now",
+			events: []*models.EmergencyEvent{activated(at(2, 0), at(2, 10))},
+			now:    at(2, 6),
+			want:   models.EmergencyTotals{Activations: 1, Seconds: 6 * 3600, Weeks: []*models.EmergencyWeek{week(monday, 1, 6*3600)}},
+		},
+		{
+			name:   "open past its expiry before the reaper ran",
+			events: []*models.EmergencyEvent{activated(at(2, 0), at(2, 2))},
+			now:    at(2, 6),
+			want:   models.EmergencyTotals{Activations: 1, Seconds: 2 * 3600, Weeks: []*models.EmergencyWeek{week(monday, 1, 2*3600)}},
+		},
+		{
+			name:    "active since before the range",
+			current: &models.EmergencyStatus{Active: true, ActivatedAt: at(0, 0), ExpiresAt: at(1, 12)},
+			from:    at(1, 0),
+			now:     at(1, 5),
+			want:    models.EmergencyTotals{Seconds: 5 * 3600, Weeks: []*models.EmergencyWeek{week(monday, 0, 5*3600)}},
+		},
+		{
+			name:   "ended in the range after starting before it",
+			events: []*models.EmergencyEvent{ended(models.EmergencyDeactivated, at(1, 2), at(0, 20), 6*3600)},
+			from:   at(1, 0),
+			now:    at(3, 0),
+			want:   models.EmergencyTotals{Deactivations: 1, Seconds: 2 * 3600, Weeks: []*models.EmergencyWeek{week(monday, 0, 2*3600)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Storage lists events newest first
+			events := make([]*models.EmergencyEvent, len(tt.events))
+			for i, event := range tt.events {
+				events[len(events)-1-i] = event
+			}
+			current := tt.current
+			if current == nil {
+				current = &models.EmergencyStatus{}
+			}
+
+			got := emergencyTotals(events, current, tt.from, tt.to, tt.now)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("totals = %+v, want %+v", *got, tt.want)
+				for _, w := range got.Weeks {
+					t.Logf("week %s: %+v", w.WeekStart.Format(time.RFC3339), *w)
+				}
+			}
+		})
+	}
+}
+
+func TestWeekStart(t *testing.T) {
+	monday := time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t    time.Time
+		want time.Time
+	}{
+		{t: monday, want: monday},
+		{t: monday.Add(-time.Second), want: monday.AddDate(0, 0, -7)},
+		{t: time.Date(2026, 10, 18, 23, 59, 59, 0, time.UTC), want: monday},
+		// Monday 01:00 in UTC+2 is still Sunday in UTC
+		{t: time.Date(2026, 10, 19, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*3600)), want: monday},
+	}
+
+	for _, tt := range tests {
+		if got := weekStart(tt.t); !got.Equal(tt.want) {
+			t.Errorf("weekStart(%s) = %s, want %s", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestEmergencyHistory(t *testing.T) {
+	const cycles = 60
+
+	s := newTestServer(t)
+	viewer := s.bearer(s.createUser("viewer", models.RoleViewer))
+	ctx := context.Background()
+	for i := 0; i < cycles; i++ {
+		if err := s.store.ActivateEmergency(ctx, "load", 3600); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.store.DeactivateEmergency(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now().UTC()
+	hourAgo := now.Add(-time.Hour).Format(time.RFC3339)
+	later := now.Add(time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		// wantEvents and wantActivations are the events listed and the
+		// activations counted in the totals
+		wantEvents      int
+		wantActivations int
+		wantDetail      string
+	}{
+		{name: "default limit", wantStatus: http.StatusOK, wantEvents: DefaultPageSize, wantActivations: cycles},
+		{name: "limit below the events", query: "?limit=5", wantStatus: http.StatusOK, wantEvents: 5, wantActivations: cycles},
+		{name: "limit above the events", query: "?limit=1000", wantStatus: http.StatusOK, wantEvents: 2 * cycles, wantActivations: cycles},
+		{name: "limit zero", query: "?limit=0", wantStatus: http.StatusBadRequest, wantDetail: "limit must be between 1 and 1000"},
+		{name: "limit above the maximum", query: "?limit=1001", wantStatus: http.StatusBadRequest, wantDetail: "limit must be between 1 and 1000"},
+		{name: "limit not a number", query: "?limit=all", wantStatus: http.StatusBadRequest, wantDetail: "limit must be an integer"},
+		{name: "from not RFC 3339", query: "?from=yesterday", wantStatus: http.StatusBadRequest, wantDetail: "from must be an RFC 3339 timestamp"},
+		{name: "to without a time", query: "?to=2026-10-16", wantStatus: http.StatusBadRequest, wantDetail: "to must be an RFC 3339 timestamp"},
+		{name: "inverted range", query: "?from=" + later + "&to=" + hourAgo, wantStatus: http.StatusBadRequest, wantDetail: "to must not be before from"},
+		{name: "range of one instant", query: "?from=" + hourAgo + "&to=" + hourAgo, wantStatus: http.StatusOK},
+		{name: "range before the events", query: "?to=" + hourAgo, wantStatus: http.StatusOK},
+		{name: "range covering the events", query: "?from=" + hourAgo + "&to=" + later + "&limit=1000", wantStatus: http.StatusOK, wantEvents: 2 * cycles, wantActivations: cycles},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(http.MethodGet, "/api/v1/emergency/history"+tt.query, nil, viewer)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				var problem errors.Problem
+				decodeResponse(t, w, &problem)
+				if problem.Code != errors.CodeBadRequest || problem.Detail != tt.wantDetail {
+					t.Errorf("problem = %s %q, want %s %q", problem.Code, problem.Detail, errors.CodeBadRequest, tt.wantDetail)
+				}
+				return
+			}
+
+			var body struct {
+				Events []*models.EmergencyEvent `json:"events"`
+				Totals models.EmergencyTotals   `json:"totals"`
+			}
+			decodeResponse(t, w, &body)
+			if len(body.Events) != tt.wantEvents {
+				t.Errorf("events = %d, want %d", len(body.Events), tt.wantEvents)
+			}
+			// Newest first: the last deactivation leads
+			if len(body.Events) > 0 && body.Events[0].Action != models.EmergencyDeactivated {
+				t.Errorf("first event = %s, want the last deactivation", body.Events[0].Action)
+			}
+			// Limiting the events leaves the totals of the range
+			if body.Totals.Activations != tt.wantActivations || body.Totals.Deactivations != tt.wantActivations {
+				t.Errorf("totals = %d activations, %d deactivations, want %d each",
+					body.Totals.Activations, body.Totals.Deactivations, tt.wantActivations)
+			}
+		})
+	}
+}
+
+func TestCreateEmergencyScheduleConflicts(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	pending := &models.EmergencySchedule{
+		ID:       "pending",
+		Reason:   "sale",
+		StartAt:  now.Add(48 * time.Hour),
+		EndAt:    now.Add(50 * time.Hour),
+		Duration: 2 * 3600,
+	}
+
+	tests := []struct {
+		name     string
+		startIn  time.Duration
+		duration int64
+		// active is the duration of an emergency activated now, 0 for none
+		active     int64
+		wantStatus int
+		// wantContext is the problem member naming the conflict
+		wantContext string
+	}{
+		{name: "before the pending window", startIn: 24 * time.Hour, duration: 3600, wantStatus: http.StatusCreated},
+		{name: "ends at the pending window's start", startIn: 46 * time.Hour, duration: 2 * 3600, wantStatus: http.StatusCreated},
+		{name: "starts at the pending window's end", startIn: 50 * time.Hour, duration: 3600, wantStatus: http.StatusCreated},
+		{name: "overlaps the pending window's start", startIn: 47 * time.Hour, duration: 2 * 3600, wantStatus: http.StatusConflict, wantContext: "schedule_id"},
+		{name: "inside the pending window", startIn: 49 * time.Hour, duration: 600, wantStatus: http.StatusConflict, wantContext: "schedule_id"},
+		{name: "around the pending window", startIn: 40 * time.Hour, duration: 20 * 3600, wantStatus: http.StatusConflict, wantContext: "schedule_id"},
+		{name: "extends the active emergency within the cap", startIn: 30 * time.Minute, duration: 23 * 3600, active: 3600, wantStatus: http.StatusCreated},
+		{name: "extends the active emergency past the cap", startIn: 30 * time.Minute, duration: 24 * 3600, active: 3600, wantStatus: http.StatusConflict, wantContext: "max_end_at"},
+		{name: "starts after the active emergency", startIn: 2 * time.Hour, duration: 24 * 3600, active: 3600, wantStatus: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			operator := s.bearer(s.createUser("operator", models.RoleOperator))
+			ctx := context.Background()
+
+			if err := s.store.CreateEmergencySchedule(ctx, pending); err != nil {
+				t.Fatal(err)
+			}
+			if tt.active > 0 {
+				if err := s.store.ActivateEmergency(ctx, "load", tt.active); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			body := models.EmergencyScheduleRequest{Reason: "migration", StartAt: now.Add(tt.startIn), Duration: tt.duration}
+			w := s.do(http.MethodPost, "/api/v1/emergency/schedules", body, operator)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			schedules, err := s.store.ListEmergencySchedules(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus == http.StatusCreated {
+				if len(schedules) != 2 {
+					t.Errorf("schedules = %d, want the new one stored", len(schedules))
+				}
+				return
+			}
+
+			var problem map[string]interface{}
+			decodeResponse(t, w, &problem)
+			if problem["code"] != errors.CodeConflict || problem[tt.wantContext] == nil {
+				t.Errorf("problem = %v, want %s with %s", problem, errors.CodeConflict, tt.wantContext)
+			}
+			if tt.wantContext == "schedule_id" && problem["schedule_id"] != pending.ID {
+				t.Errorf("schedule_id = %v, want %s", problem["schedule_id"], pending.ID)
+			}
+			if len(schedules) != 1 {
+				t.Errorf("schedules = %d, want the rejected one not stored", len(schedules))
+			}
+		})
+	}
+}
+
+// flakyEmergencyStorage fails the first failures emergency activations and
+// extensions with err.
+type flakyEmergencyStorage struct {
+	storage.Storage
+	failures int
+	err      error
+}
+
+func (s *flakyEmergencyStorage) ActivateEmergency(ctx context.Context, reason string, duration int64) error {
+	if s.failures > 0 {
+		s.failures--
+		return s.err
+	}
+	return s.Storage.ActivateEmergency(ctx, reason, duration)
+}
+
+func (s *flakyEmergencyStorage) ExtendEmergency(ctx context.Context, duration int64) (*models.EmergencyStatus, error) {
+	if s.failures > 0 {
+		s.failures--
+		return nil, s.err
+	}
+	return s.Storage.ExtendEmergency(ctx, duration)
+}
+
+func TestStartScheduledEmergencies(t *testing.T) {
+	tests := []struct {
+		name           string
+		startIn, endIn time.Duration
+		active         int64
+		failures       int
+		err            error
+		// wantActive and wantExpiresIn are the emergency after the ticks and
+		// when it expires, from now
+		wantActive    bool
+		wantExpiresIn time.Duration
+		// wantPending is the window still pending after the ticks, with its failures
+		wantPending   bool
+		wantAttempts  int
+		wantLastError string
+	}{
+		{name: "due window activates", startIn: -time.Minute, endIn: time.Hour, wantActive: true, wantExpiresIn: time.Hour},
+		{name: "due window extends the active emergency", startIn: -time.Minute, endIn: time.Hour, active: 600, wantActive: true, wantExpiresIn: time.Hour},
+		{name: "active emergency outlasting the window kept", startIn: -time.Minute, endIn: time.Hour, active: 3 * 3600, wantActive: true, wantExpiresIn: 3 * time.Hour},
+		{name: "window not due", startIn: time.Minute, endIn: time.Hour, wantPending: true},
+		{name: "missed window dropped", startIn: -2 * time.Hour, endIn: -time.Hour},
+		{
+			name:          "failed activation put back",
+			startIn:       -time.Minute,
+			endIn:         time.Hour,
+			failures:      2,
+			err:           errors.InternalServerError("failed to activate emergency mode", fmt.Errorf("connection refused")),
+			wantPending:   true,
+			wantAttempts:  2,
+			wantLastError: "failed to activate emergency mode",
+		},
+		{
+			name:          "refused extension put back",
+			startIn:       -time.Minute,
+			endIn:         time.Hour,
+			active:        600,
+			failures:      2,
+			err:           errors.Conflict("emergency mode cannot run longer than 86400 seconds from activation", nil),
+			wantActive:    true,
+			wantExpiresIn: 10 * time.Minute,
+			wantPending:   true,
+			wantAttempts:  2,
+			wantLastError: "emergency mode cannot run longer than 86400 seconds from activation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			flaky := &flakyEmergencyStorage{Storage: store, failures: tt.failures, err: tt.err}
+			h := NewHandler(flaky)
+			t.Cleanup(func() {
+				h.Close()
+				store.Close()
+			})
+			ctx := context.Background()
+
+			now := time.Now().Truncate(time.Second)
+			window := &models.EmergencySchedule{ID: "w1", Reason: "sale", StartAt: now.Add(tt.startIn), EndAt: now.Add(tt.endIn), CreatedBy: "u1"}
+			if err := store.CreateEmergencySchedule(ctx, window); err != nil {
+				t.Fatal(err)
+			}
+			if tt.active > 0 {
+				if err := store.ActivateEmergency(ctx, "load", tt.active); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// A tick per failure, each retrying the window put back by the last
+			ticks := tt.failures
+			if ticks == 0 {
+				ticks = 1
+			}
+			for i := 0; i < ticks; i++ {
+				h.startScheduledEmergencies(ctx)
+			}
+
+			schedules, err := store.ListEmergencySchedules(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantPending {
+				if len(schedules) != 0 {
+					t.Errorf("pending windows = %+v, want none", schedules)
+				}
+			} else if len(schedules) != 1 || schedules[0].Attempts != tt.wantAttempts || schedules[0].LastError != tt.wantLastError {
+				t.Fatalf("pending windows = %+v, want w1 after %d attempts failing with %q", schedules, tt.wantAttempts, tt.wantLastError)
+			}
+			checkEmergency(t, store, tt.wantActive, now.Add(tt.wantExpiresIn))
+
+			if tt.failures == 0 {
+				return
+			}
+			// Once storage recovers, the next tick starts the window
+			h.startScheduledEmergencies(ctx)
+			if schedules, _ := store.ListEmergencySchedules(ctx); len(schedules) != 0 {
+				t.Errorf("pending windows after recovery = %+v, want none", schedules)
+			}
+			checkEmergency(t, store, true, window.EndAt)
+		})
+	}
+}
+
+// checkEmergency checks that the emergency is active if wantActive and then
+// expires at expiresAt, give or take the second a tick may straddle.
+func checkEmergency(t *testing.T, store storage.Storage, wantActive bool, expiresAt time.Time) {
+	t.Helper()
+
+	status, err := store.GetEmergencyStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Active != wantActive {
+		t.Fatalf("active = %v, want %v", status.Active, wantActive)
+	}
+	if offset := status.ExpiresAt.Sub(expiresAt); wantActive && (offset < 0 || offset > time.Second) {
+		t.Errorf("expires_at = %s, want %s", status.ExpiresAt, expiresAt)
+	}
+}
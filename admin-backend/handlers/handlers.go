@@ -2,13 +2,19 @@
 package handlers
 
 import (
+	"admin-backend/config"
+	"admin-backend/errors"
 	"admin-backend/logger"
 	"admin-backend/middleware"
 	"admin-backend/models"
+	"admin-backend/oidc"
 	"admin-backend/storage"
 	"admin-backend/validation"
 	"context"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,6 +27,10 @@ const (
 	DefaultWebSocketReadTimeout = 60 * time.Second
 	// MetricsPushInterval is the interval between metrics pushes to WebSocket clients
 	MetricsPushInterval = 5 * time.Second
+	// DefaultPageSize is the number of items returned by list endpoints when no limit is given
+	DefaultPageSize = 100
+	// MaxPageSize is the largest limit accepted by list endpoints
+	MaxPageSize = 1000
 )
 
 // Handler holds dependencies for HTTP handlers.
@@ -31,6 +41,20 @@ type Handler struct {
 	wsMutex     sync.RWMutex
 	requestOpts map[context.Context]context.CancelFunc
 	reqOptsMu   sync.RWMutex
+
+	// Single sign-on, set by EnableOIDC
+	oidcProvider oidc.Provider
+	oidcConfig   *config.OIDCConfig
+	oidcRoles    map[string]string
+
+	// Failed login throttling, set by EnableLoginProtection
+	loginProtection *config.LoginProtectionConfig
+
+	// Cluster oversubscription policy of config writes, set by SetCapacityPolicy
+	capacityPolicy validation.CapacityPolicy
+
+	// Closed by Close to stop the reaper started by StartEmergencyReaper
+	stopReaper chan struct{}
 }
 
 // NewHandler creates a new handler instance with the given storage backend.
@@ -44,13 +68,19 @@ func NewHandler(store storage.Storage) *Handler {
 			},
 			HandshakeTimeout: DefaultWebSocketReadTimeout,
 		},
-		wsClients:   make(map[*websocket.Conn]bool),
-		requestOpts: make(map[context.Context]context.CancelFunc),
+		wsClients:      make(map[*websocket.Conn]bool),
+		requestOpts:    make(map[context.Context]context.CancelFunc),
+		capacityPolicy: validation.DefaultCapacityPolicy,
 	}
 }
 
 // Close gracefully closes the handler and cleans up resources.
 func (h *Handler) Close() error {
+	if h.stopReaper != nil {
+		close(h.stopReaper)
+		h.stopReaper = nil
+	}
+
 	h.wsMutex.Lock()
 	defer h.wsMutex.Unlock()
 
@@ -92,61 +122,106 @@ func (h *Handler) Health(c *gin.Context) {
 	})
 }
 
+// JWKS publishes the public keys that verify access and refresh tokens.
+// @Summary JSON Web Key Set
+// @Description Public signing keys (RS256/EdDSA) for verifying console tokens; empty when tokens are signed with a shared secret
+// @Tags auth
+// @Produce json
+// @Success 200 {object} middleware.JWKS
+// @Router /.well-known/jwks.json [get]
+func (h *Handler) JWKS(c *gin.Context) {
+	// Verifiers may cache the set; rotated-in keys are published before they sign
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, middleware.PublicJWKS())
+}
+
 // Login handles user authentication requests.
 // @Summary User login
-// @Description Authenticate a user and return JWT tokens
+// @Description Authenticate a user and return JWT tokens. Repeated failures delay and then lock out the username and client IP.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body models.LoginRequest true "Login credentials"
 // @Success 200 {object} models.TokenResponse
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Invalid credentials"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Invalid credentials"
+// @Failure 429 {object} errors.Problem "Too many failed logins for the username or client IP"
 // @Router /api/v1/auth/login [post]
 func (h *Handler) Login(c *gin.Context) {
+	if h.passwordLoginDisabled() {
+		_ = c.Error(errors.Forbidden("password login is disabled, sign in with SSO", nil))
+		return
+	}
+
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		_ = c.Error(errors.BadRequest("invalid request format", err))
 		return
 	}
 
 	// Validate username
 	if err := validation.ValidateUsername(req.Username); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
 	// Validate password
 	if err := validation.ValidatePassword(req.Password); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
-	// Authenticate user (simplified for demo - use database in production)
-	// TODO: Replace with proper authentication against user database
-	if req.Username == "admin" && req.Password == "admin123" {
-		accessToken, refreshToken, err := middleware.GenerateToken("1", req.Username, "admin")
-		if err != nil {
-			logger.Errorw("failed to generate token", "error", err, "username", req.Username)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
-			return
-		}
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	if h.rejectBlockedLogin(c, ctx, req.Username) {
+		return
+	}
 
-		logger.Infow("user logged in",
+	user, err := h.storage.GetUserByUsername(ctx, req.Username)
+	if err != nil {
+		logger.Errorw("failed to look up user",
 			"request_id", c.GetString(middleware.RequestIDKey),
 			"username", req.Username,
+			"error", err,
 		)
+		_ = c.Error(errors.InternalServerError("failed to authenticate", err))
+		return
+	}
 
-		c.JSON(http.StatusOK, models.TokenResponse{
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-			ExpiresIn:    3600,
-		})
+	// Always compare a hash so unknown usernames take as long as wrong passwords
+	var passwordHash string
+	if user != nil {
+		passwordHash = user.PasswordHash
+	}
+	if !middleware.CheckPassword(passwordHash, req.Password) {
+		if wait := h.recordLoginFailure(c, ctx, req.Username); wait > 0 {
+			c.Header("Retry-After", strconv.FormatInt(retryAfterSeconds(wait), 10))
+		}
+		_ = c.Error(errors.Unauthorized("invalid credentials", nil))
+		return
+	}
+
+	h.clearUserLoginFailures(c, ctx, user.Username)
+
+	accessToken, refreshToken, err := middleware.GenerateToken(user.ID, user.Username, user.Role)
+	if err != nil {
+		logger.Errorw("failed to generate token", "error", err, "username", req.Username)
+		_ = c.Error(errors.InternalServerError("failed to generate token", err))
 		return
 	}
 
-	// Invalid credentials
-	c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	logger.Infow("user logged in",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", user.ID,
+		"username", user.Username,
+	)
+
+	c.JSON(http.StatusOK, models.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(middleware.AccessTokenTTL().Seconds()),
+	})
 }
 
 // RefreshToken handles token refresh requests.
@@ -157,112 +232,272 @@ func (h *Handler) Login(c *gin.Context) {
 // @Produce json
 // @Param request body object{refresh_token=string} true "Refresh token"
 // @Success 200 {object} models.TokenResponse
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Invalid token"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Invalid token"
 // @Router /api/v1/auth/refresh [post]
 func (h *Handler) RefreshToken(c *gin.Context) {
 	var req struct {
 		RefreshToken string `json:"refresh_token" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		_ = c.Error(errors.BadRequest("invalid request format", err))
 		return
 	}
 
 	// Validate refresh token
-	claims, err := middleware.ValidateToken(req.RefreshToken)
+	claims, err := middleware.ValidateToken(req.RefreshToken, middleware.TokenTypeRefresh)
 	if err != nil {
 		logger.Warnw("invalid refresh token",
 			"request_id", c.GetString(middleware.RequestIDKey),
 			"error", err,
 		)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		_ = c.Error(errors.Unauthorized("invalid refresh token", nil))
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	revoked, err := h.storage.IsTokenRevoked(ctx, claims.ID, claims.UserID, claims.IssuedAt.Time)
+	if err != nil {
+		logger.Errorw("failed to check token revocation",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"user_id", claims.UserID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to refresh token", err))
+		return
+	}
+
+	// Rotate: each refresh token is single use. Consuming it is atomic, so of two
+	// concurrent refreshes with the same token only one succeeds.
+	if !revoked {
+		consumed, err := h.storage.RevokeToken(ctx, claims.ID, middleware.TokenRemainingTTL(claims))
+		if err != nil {
+			logger.Errorw("failed to rotate refresh token",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"user_id", claims.UserID,
+				"error", err,
+			)
+			_ = c.Error(errors.InternalServerError("failed to refresh token", err))
+			return
+		}
+		revoked = !consumed
+	}
+
+	if revoked {
+		// A reused refresh token may have been stolen; end all of the user's sessions
+		logger.Warnw("revoked refresh token reused, revoking all user sessions",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"user_id", claims.UserID,
+			"token_id", claims.ID,
+			"client_ip", c.ClientIP(),
+		)
+		if err := h.storage.RevokeUserTokens(ctx, claims.UserID, middleware.RefreshTokenTTL()); err != nil {
+			logger.Errorw("failed to revoke user sessions",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"user_id", claims.UserID,
+				"error", err,
+			)
+		}
+		_ = c.Error(errors.Unauthorized("invalid refresh token", nil))
+		return
+	}
+
+	// Re-read the user so deleted users cannot refresh and role changes take effect
+	user, err := h.storage.GetUser(ctx, claims.UserID)
+	if err != nil {
+		logger.Errorw("failed to get user",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"user_id", claims.UserID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to refresh token", err))
+		return
+	}
+	if user == nil {
+		_ = c.Error(errors.Unauthorized("invalid refresh token", nil))
 		return
 	}
 
 	// Generate new tokens
-	accessToken, refreshToken, err := middleware.GenerateToken(claims.UserID, claims.Username, claims.Role)
+	accessToken, refreshToken, err := middleware.GenerateToken(user.ID, user.Username, user.Role)
 	if err != nil {
 		logger.Errorw("failed to generate token", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		_ = c.Error(errors.InternalServerError("failed to generate token", err))
 		return
 	}
 
 	c.JSON(http.StatusOK, models.TokenResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
-		ExpiresIn:    3600,
+		ExpiresIn:    int64(middleware.AccessTokenTTL().Seconds()),
 	})
 }
 
-// ListApps returns all application configurations.
-// @Summary List all applications
-// @Description Get a list of all application configurations
+// Logout revokes the caller's access token and, if given, its refresh token.
+// @Summary Log out
+// @Description Revoke the current access token and optionally the matching refresh token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body object{refresh_token=string} false "Refresh token to revoke"
+// @Success 204
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/auth/logout [post]
+func (h *Handler) Logout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			_ = c.Error(errors.BadRequest("invalid request format", err))
+			return
+		}
+	}
+
+	// API key callers have no session to end
+	claims, ok := c.Value(middleware.ClaimsKey).(*middleware.Claims)
+	if !ok {
+		_ = c.Error(errors.BadRequest("logout requires a bearer token", nil))
+		return
+	}
+
+	revoke := []*middleware.Claims{claims}
+	if req.RefreshToken != "" {
+		refreshClaims, err := middleware.ValidateToken(req.RefreshToken, middleware.TokenTypeRefresh)
+		if err != nil || refreshClaims.UserID != claims.UserID {
+			_ = c.Error(errors.BadRequest("invalid refresh token", nil))
+			return
+		}
+		revoke = append(revoke, refreshClaims)
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	for _, tc := range revoke {
+		if _, err := h.storage.RevokeToken(ctx, tc.ID, middleware.TokenRemainingTTL(tc)); err != nil {
+			logger.Errorw("failed to revoke token",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"user_id", claims.UserID,
+				"error", err,
+			)
+			_ = c.Error(errors.InternalServerError("failed to log out", err))
+			return
+		}
+	}
+
+	logger.Infow("user logged out",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", claims.UserID,
+		"refresh_token_revoked", len(revoke) > 1,
+	)
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListApps returns one page of application configurations.
+// @Summary List applications
+// @Description Get a page of application configurations
 // @Tags apps
 // @Accept json
 // @Produce json
+// @Param limit query int false "Page size (default 100, max 1000)"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
 // @Success 200 {object} map[string]interface{}
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/apps [get]
 func (h *Handler) ListApps(c *gin.Context) {
+	cursor, limit, err := parsePagination(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
 	ctx := h.getRequestContext(c, 5*time.Second)
 	defer h.cancelRequestContext(c)
 
-	apps, err := h.storage.ListAppConfigs(ctx)
+	apps, next, err := h.storage.ScanAppConfigs(ctx, cursor, limit)
 	if err != nil {
 		logger.Errorw("failed to list apps",
 			"request_id", c.GetString(middleware.RequestIDKey),
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list applications"})
+		_ = c.Error(errors.InternalServerError("failed to list applications", err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"apps": apps})
+	c.JSON(http.StatusOK, gin.H{"apps": apps, "next_cursor": next})
 }
 
 // CreateApp creates a new application configuration.
 // @Summary Create application
-// @Description Create a new application configuration
+// @Description Create an application configuration; the app ID must not exist yet
 // @Tags apps
 // @Accept json
 // @Produce json
 // @Param request body models.AppConfig true "Application configuration"
+// @Param X-Change-Reason header string false "Reason recorded in the configuration history"
 // @Success 201 {object} map[string]interface{}
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 409 {object} errors.Problem "Application already exists or capacity exceeded"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/apps [post]
 func (h *Handler) CreateApp(c *gin.Context) {
 	var config models.AppConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		_ = c.Error(validation.BindError(err))
 		return
 	}
 
-	// Validate app ID
-	if err := validation.ValidateAppID(config.AppID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Validate config
+	if err := validation.ValidateAppConfig(&config); err != nil {
+		_ = c.Error(err)
 		return
 	}
 
-	// Validate config
-	if err := validation.ValidateAppConfig(config.GuaranteedQuota, config.BurstQuota, config.Priority); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	ctx := withChangeInfo(c, h.getRequestContext(c, 5*time.Second))
+	defer h.cancelRequestContext(c)
+
+	existing, err := h.storage.GetAppConfig(ctx, config.AppID)
+	if err != nil {
+		logger.Errorw("failed to get app",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"app_id", config.AppID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to create application", err))
+		return
+	}
+	if existing != nil {
+		_ = c.Error(appExists(config.AppID, nil))
 		return
 	}
 
-	ctx := h.getRequestContext(c, 5*time.Second)
-	defer h.cancelRequestContext(c)
+	warnings, err := h.checkAppClusters(ctx, "guaranteed_quota", &config)
+	if err != nil {
+		reportError(c, err, "failed to check cluster capacity", "app_id", config.AppID)
+		return
+	}
 
-	if err := h.storage.SetAppConfig(ctx, &config); err != nil {
+	// Version 0 makes the write fail if the app was created meanwhile
+	if err := h.storage.CompareAndSetAppConfig(ctx, &config, 0); err != nil {
+		if errors.Resolve(err).ErrorCode == errors.CodeVersionConflict {
+			_ = c.Error(appExists(config.AppID, err))
+			return
+		}
 		logger.Errorw("failed to create app",
 			"request_id", c.GetString(middleware.RequestIDKey),
 			"app_id", config.AppID,
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create application"})
+		_ = c.Error(errors.InternalServerError("failed to create application", err))
 		return
 	}
 
@@ -272,7 +507,13 @@ func (h *Handler) CreateApp(c *gin.Context) {
 		"app_id", config.AppID,
 	)
 
-	c.JSON(http.StatusCreated, gin.H{"success": true, "app_id": config.AppID})
+	c.Header("ETag", formatETag(config.Version))
+	c.JSON(http.StatusCreated, withWarnings(gin.H{"success": true, "app_id": config.AppID, "version": config.Version}, warnings))
+}
+
+// appExists returns the error refusing to create an existing application.
+func appExists(appID string, err error) *errors.AppError {
+	return errors.Conflict(fmt.Sprintf("application %s already exists", appID), err)
 }
 
 // GetApp retrieves an application configuration by ID.
@@ -283,17 +524,17 @@ func (h *Handler) CreateApp(c *gin.Context) {
 // @Produce json
 // @Param id path string true "Application ID"
 // @Success 200 {object} models.AppConfig
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 404 {object} map[string]string "Not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/apps/{id} [get]
 func (h *Handler) GetApp(c *gin.Context) {
 	appID := c.Param("id")
 
 	// Validate app ID
 	if err := validation.ValidateAppID(appID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
@@ -307,15 +548,16 @@ func (h *Handler) GetApp(c *gin.Context) {
 			"app_id", appID,
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get application"})
+		_ = c.Error(errors.InternalServerError("failed to get application", err))
 		return
 	}
 
 	if config == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "application not found"})
+		_ = c.Error(errors.NotFound("application not found", nil))
 		return
 	}
 
+	c.Header("ETag", formatETag(config.Version))
 	c.JSON(http.StatusOK, config)
 }
 
@@ -326,44 +568,55 @@ func (h *Handler) GetApp(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path string true "Application ID"
+// @Param If-Match header string false "ETag returned by GetApp; the update is rejected if the app changed since"
 // @Param request body models.AppConfig true "Application configuration"
+// @Param X-Change-Reason header string false "Reason recorded in the configuration history"
 // @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 409 {object} errors.Problem "Version conflict or cluster capacity exceeded"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/apps/{id} [put]
 func (h *Handler) UpdateApp(c *gin.Context) {
 	appID := c.Param("id")
 	var config models.AppConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		_ = c.Error(validation.BindError(err))
 		return
 	}
 
 	// Validate app ID
 	if err := validation.ValidateAppID(appID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
+	// The path names the app; the body's app_id is optional
+	config.AppID = appID
+
 	// Validate config
-	if err := validation.ValidateAppConfig(config.GuaranteedQuota, config.BurstQuota, config.Priority); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := validation.ValidateAppConfig(&config); err != nil {
+		_ = c.Error(err)
 		return
 	}
 
-	config.AppID = appID
+	expectedVersion, err := parseIfMatch(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
 
-	ctx := h.getRequestContext(c, 5*time.Second)
+	ctx := withChangeInfo(c, h.getRequestContext(c, 5*time.Second))
 	defer h.cancelRequestContext(c)
 
-	if err := h.storage.SetAppConfig(ctx, &config); err != nil {
-		logger.Errorw("failed to update app",
-			"request_id", c.GetString(middleware.RequestIDKey),
-			"app_id", appID,
-			"error", err,
-		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update application"})
+	warnings, err := h.checkAppClusters(ctx, "guaranteed_quota", &config)
+	if err != nil {
+		reportError(c, err, "failed to check cluster capacity", "app_id", appID)
+		return
+	}
+
+	if err := h.storage.CompareAndSetAppConfig(ctx, &config, expectedVersion); err != nil {
+		reportError(c, err, "failed to update application", "app_id", appID)
 		return
 	}
 
@@ -371,9 +624,11 @@ func (h *Handler) UpdateApp(c *gin.Context) {
 		"request_id", c.GetString(middleware.RequestIDKey),
 		"user_id", c.GetString(middleware.UserIDKey),
 		"app_id", appID,
+		"version", config.Version,
 	)
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	c.Header("ETag", formatETag(config.Version))
+	c.JSON(http.StatusOK, withWarnings(gin.H{"success": true, "version": config.Version}, warnings))
 }
 
 // DeleteApp deletes an application configuration.
@@ -383,30 +638,27 @@ func (h *Handler) UpdateApp(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path string true "Application ID"
+// @Param X-Change-Reason header string false "Reason recorded in the configuration history"
 // @Success 204
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Application not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/apps/{id} [delete]
 func (h *Handler) DeleteApp(c *gin.Context) {
 	appID := c.Param("id")
 
 	// Validate app ID
 	if err := validation.ValidateAppID(appID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
-	ctx := h.getRequestContext(c, 5*time.Second)
+	ctx := withChangeInfo(c, h.getRequestContext(c, 5*time.Second))
 	defer h.cancelRequestContext(c)
 
 	if err := h.storage.DeleteAppConfig(ctx, appID); err != nil {
-		logger.Errorw("failed to delete app",
-			"request_id", c.GetString(middleware.RequestIDKey),
-			"app_id", appID,
-			"error", err,
-		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete application"})
+		reportError(c, err, "failed to delete application", "app_id", appID)
 		return
 	}
 
@@ -419,31 +671,121 @@ func (h *Handler) DeleteApp(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// ListClusters returns all cluster configurations.
-// @Summary List all clusters
-// @Description Get a list of all cluster configurations
+// ListClusters returns one page of cluster configurations.
+// @Summary List clusters
+// @Description Get a page of cluster configurations
 // @Tags clusters
 // @Accept json
 // @Produce json
+// @Param limit query int false "Page size (default 100, max 1000)"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
 // @Success 200 {object} map[string]interface{}
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/clusters [get]
 func (h *Handler) ListClusters(c *gin.Context) {
+	cursor, limit, err := parsePagination(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
 	ctx := h.getRequestContext(c, 5*time.Second)
 	defer h.cancelRequestContext(c)
 
-	clusters, err := h.storage.ListClusterConfigs(ctx)
+	clusters, next, err := h.storage.ScanClusterConfigs(ctx, cursor, limit)
 	if err != nil {
 		logger.Errorw("failed to list clusters",
 			"request_id", c.GetString(middleware.RequestIDKey),
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list clusters"})
+		_ = c.Error(errors.InternalServerError("failed to list clusters", err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"clusters": clusters})
+	c.JSON(http.StatusOK, gin.H{"clusters": clusters, "next_cursor": next})
+}
+
+// CreateCluster creates a new cluster configuration.
+// @Summary Create cluster
+// @Description Create a cluster configuration; the cluster ID must not exist yet
+// @Tags clusters
+// @Accept json
+// @Produce json
+// @Param request body models.ClusterConfig true "Cluster configuration"
+// @Param X-Change-Reason header string false "Reason recorded in the configuration history"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 409 {object} errors.Problem "Cluster already exists or capacity exceeded"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/clusters [post]
+func (h *Handler) CreateCluster(c *gin.Context) {
+	var config models.ClusterConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		_ = c.Error(validation.BindError(err))
+		return
+	}
+
+	// Validate config
+	if err := validation.ValidateClusterConfig(&config); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := withChangeInfo(c, h.getRequestContext(c, 5*time.Second))
+	defer h.cancelRequestContext(c)
+
+	existing, err := h.storage.GetClusterConfig(ctx, config.ClusterID)
+	if err != nil {
+		logger.Errorw("failed to get cluster",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"cluster_id", config.ClusterID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to create cluster", err))
+		return
+	}
+	if existing != nil {
+		_ = c.Error(clusterExists(config.ClusterID, nil))
+		return
+	}
+
+	warnings, err := h.checkClusterCapacity(ctx, &config)
+	if err != nil {
+		reportError(c, err, "failed to check cluster capacity", "cluster_id", config.ClusterID)
+		return
+	}
+
+	// Version 0 makes the write fail if the cluster was created meanwhile
+	if err := h.storage.CompareAndSetClusterConfig(ctx, &config, 0); err != nil {
+		if errors.Resolve(err).ErrorCode == errors.CodeVersionConflict {
+			_ = c.Error(clusterExists(config.ClusterID, err))
+			return
+		}
+		logger.Errorw("failed to create cluster",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"cluster_id", config.ClusterID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to create cluster", err))
+		return
+	}
+
+	logger.Infow("cluster created",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"cluster_id", config.ClusterID,
+	)
+
+	c.Header("ETag", formatETag(config.Version))
+	c.JSON(http.StatusCreated, withWarnings(gin.H{"success": true, "cluster_id": config.ClusterID, "version": config.Version}, warnings))
+}
+
+// clusterExists returns the error refusing to create an existing cluster.
+func clusterExists(clusterID string, err error) *errors.AppError {
+	return errors.Conflict(fmt.Sprintf("cluster %s already exists", clusterID), err)
 }
 
 // GetCluster retrieves a cluster configuration by ID.
@@ -454,17 +796,17 @@ func (h *Handler) ListClusters(c *gin.Context) {
 // @Produce json
 // @Param id path string true "Cluster ID"
 // @Success 200 {object} models.ClusterConfig
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 404 {object} map[string]string "Not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/clusters/{id} [get]
 func (h *Handler) GetCluster(c *gin.Context) {
 	clusterID := c.Param("id")
 
 	// Validate cluster ID
 	if err := validation.ValidateClusterID(clusterID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
@@ -478,18 +820,84 @@ func (h *Handler) GetCluster(c *gin.Context) {
 			"cluster_id", clusterID,
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get cluster"})
+		_ = c.Error(errors.InternalServerError("failed to get cluster", err))
 		return
 	}
 
 	if config == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "cluster not found"})
+		_ = c.Error(errors.NotFound("cluster not found", nil))
 		return
 	}
 
+	c.Header("ETag", formatETag(config.Version))
 	c.JSON(http.StatusOK, config)
 }
 
+// GetClusterStatus returns a cluster's config alongside the gateway's live L1 bucket.
+// @Summary Get cluster status
+// @Description Get the live allocation and remaining capacity of the gateway's L1 bucket. l1 is null unless the cluster is the gateway cluster and the bucket exists.
+// @Tags clusters
+// @Accept json
+// @Produce json
+// @Param id path string true "Cluster ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/clusters/{id}/status [get]
+func (h *Handler) GetClusterStatus(c *gin.Context) {
+	clusterID := c.Param("id")
+
+	// Validate cluster ID
+	if err := validation.ValidateClusterID(clusterID); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	config, err := h.storage.GetClusterConfig(ctx, clusterID)
+	if err != nil {
+		logger.Errorw("failed to get cluster",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"cluster_id", clusterID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to get cluster status", err))
+		return
+	}
+	if config == nil {
+		_ = c.Error(errors.NotFound("cluster not found", nil))
+		return
+	}
+
+	l1, err := h.storage.GetGatewayClusterStatus(ctx, clusterID)
+	if err != nil {
+		logger.Errorw("failed to get gateway cluster status",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"cluster_id", clusterID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to get cluster status", err))
+		return
+	}
+
+	// The gateway can also change its bucket itself, e.g. through set_capacity
+	inSync := l1 != nil &&
+		l1.Capacity == config.MaxCapacity &&
+		l1.ReservedRatio == config.ReservedRatio &&
+		l1.EmergencyThreshold == config.EmergencyThreshold
+
+	c.JSON(http.StatusOK, gin.H{
+		"cluster_id": clusterID,
+		"config":     config,
+		"l1":         l1,
+		"in_sync":    inSync,
+	})
+}
+
 // UpdateCluster updates a cluster configuration.
 // @Summary Update cluster
 // @Description Update a cluster configuration
@@ -497,111 +905,282 @@ func (h *Handler) GetCluster(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path string true "Cluster ID"
+// @Param If-Match header string false "ETag returned by GetCluster; the update is rejected if the cluster changed since"
 // @Param request body models.ClusterConfig true "Cluster configuration"
+// @Param X-Change-Reason header string false "Reason recorded in the configuration history"
 // @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 409 {object} errors.Problem "Version conflict or cluster capacity exceeded"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/clusters/{id} [put]
 func (h *Handler) UpdateCluster(c *gin.Context) {
 	clusterID := c.Param("id")
 	var config models.ClusterConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		_ = c.Error(validation.BindError(err))
 		return
 	}
 
 	// Validate cluster ID
 	if err := validation.ValidateClusterID(clusterID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
+	// The path names the cluster; the body's cluster_id is optional
+	config.ClusterID = clusterID
+
 	// Validate config
-	if err := validation.ValidateClusterConfig(config.MaxCapacity, config.ReservedRatio, config.EmergencyThreshold); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := validation.ValidateClusterConfig(&config); err != nil {
+		_ = c.Error(err)
 		return
 	}
 
-	config.ClusterID = clusterID
+	expectedVersion, err := parseIfMatch(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
 
-	ctx := h.getRequestContext(c, 5*time.Second)
+	ctx := withChangeInfo(c, h.getRequestContext(c, 5*time.Second))
+	defer h.cancelRequestContext(c)
+
+	warnings, err := h.checkClusterCapacity(ctx, &config)
+	if err != nil {
+		reportError(c, err, "failed to check cluster capacity", "cluster_id", clusterID)
+		return
+	}
+
+	if err := h.storage.CompareAndSetClusterConfig(ctx, &config, expectedVersion); err != nil {
+		reportError(c, err, "failed to update cluster", "cluster_id", clusterID)
+		return
+	}
+
+	logger.Infow("cluster updated",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"cluster_id", clusterID,
+		"version", config.Version,
+	)
+
+	c.Header("ETag", formatETag(config.Version))
+	c.JSON(http.StatusOK, withWarnings(gin.H{"success": true, "version": config.Version}, warnings))
+}
+
+// DeleteCluster deletes a cluster configuration.
+// @Summary Delete cluster
+// @Description Delete a cluster configuration. Refused while apps are assigned to the cluster unless cascade is set, which first removes the cluster from those apps.
+// @Tags clusters
+// @Accept json
+// @Produce json
+// @Param id path string true "Cluster ID"
+// @Param cascade query bool false "Remove the cluster from its apps instead of refusing"
+// @Param X-Change-Reason header string false "Reason recorded in the configuration history"
+// @Success 204
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 409 {object} errors.Problem "Apps are still assigned to the cluster, or detaching them would exceed cluster capacity"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/clusters/{id} [delete]
+func (h *Handler) DeleteCluster(c *gin.Context) {
+	clusterID := c.Param("id")
+
+	// Validate cluster ID
+	if err := validation.ValidateClusterID(clusterID); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	cascade, err := strconv.ParseBool(c.DefaultQuery("cascade", "false"))
+	if err != nil {
+		_ = c.Error(errors.BadRequest("cascade must be true or false", nil))
+		return
+	}
+
+	ctx := withChangeInfo(c, h.getRequestContext(c, 30*time.Second))
 	defer h.cancelRequestContext(c)
 
-	if err := h.storage.SetClusterConfig(ctx, &config); err != nil {
-		logger.Errorw("failed to update cluster",
+	cluster, err := h.storage.GetClusterConfig(ctx, clusterID)
+	if err != nil {
+		logger.Errorw("failed to get cluster",
 			"request_id", c.GetString(middleware.RequestIDKey),
 			"cluster_id", clusterID,
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update cluster"})
+		_ = c.Error(errors.InternalServerError("failed to delete cluster", err))
+		return
+	}
+	if cluster == nil {
+		_ = c.Error(errors.NotFound("cluster not found", nil))
 		return
 	}
 
-	logger.Infow("cluster updated",
+	apps, err := h.storage.ListClusterApps(ctx, clusterID)
+	if err != nil {
+		logger.Errorw("failed to list cluster apps",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"cluster_id", clusterID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to delete cluster", err))
+		return
+	}
+	if len(apps) > 0 && !cascade {
+		appIDs := make([]string, len(apps))
+		for i, app := range apps {
+			appIDs[i] = app.AppID
+		}
+		_ = c.Error(errors.Conflict(fmt.Sprintf("cluster %s still has %d apps assigned; detach them or pass cascade=true", clusterID, len(apps)), nil).
+			WithContext("apps", appIDs))
+		return
+	}
+
+	warnings, err := h.detachApps(ctx, clusterID, apps)
+	if err != nil {
+		reportError(c, err, "failed to delete cluster", "cluster_id", clusterID)
+		return
+	}
+
+	if err := h.storage.DeleteClusterConfig(ctx, clusterID); err != nil {
+		reportError(c, err, "failed to delete cluster", "cluster_id", clusterID)
+		return
+	}
+
+	logger.Infow("cluster deleted",
 		"request_id", c.GetString(middleware.RequestIDKey),
 		"user_id", c.GetString(middleware.UserIDKey),
 		"cluster_id", clusterID,
+		"detached_apps", len(apps),
 	)
+	for _, warning := range warnings {
+		logger.Warnw("cluster deletion oversubscribes a cluster",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"cluster_id", clusterID,
+			"warning", warning.Message,
+		)
+	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	c.Status(http.StatusNoContent)
 }
 
 // GetConnectionStats returns connection statistics.
 // @Summary Get connection statistics
-// @Description Get connection statistics
+// @Description Get the connection statistics reported by the gateway nodes per app and per cluster, and the configured limits
 // @Tags connections
 // @Accept json
 // @Produce json
+// @Param by_node query bool false "Include the per-node breakdown and node totals"
 // @Success 200 {object} map[string]interface{}
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/connections [get]
 func (h *Handler) GetConnectionStats(c *gin.Context) {
+	h.writeConnectionStats(c, "failed to get connection statistics")
+}
+
+// writeConnectionStats writes the aggregated connection statistics, with the
+// per-node breakdown if the by_node query parameter is set.
+func (h *Handler) writeConnectionStats(c *gin.Context, failure string) {
+	byNode, err := strconv.ParseBool(c.DefaultQuery("by_node", "false"))
+	if err != nil {
+		_ = c.Error(errors.BadRequest("by_node must be true or false", nil))
+		return
+	}
+
 	ctx := h.getRequestContext(c, 5*time.Second)
 	defer h.cancelRequestContext(c)
 
-	stats, err := h.storage.GetConnectionMetrics(ctx)
+	stats, err := h.storage.GetConnectionMetrics(ctx, byNode)
 	if err != nil {
 		logger.Errorw("failed to get connection stats",
 			"request_id", c.GetString(middleware.RequestIDKey),
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get connection statistics"})
+		_ = c.Error(errors.InternalServerError(failure, err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"connections": stats})
+	limits, err := h.storage.ListConnectionLimits(ctx)
+	if err != nil {
+		logger.Errorw("failed to list connection limits",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError(failure, err))
+		return
+	}
+
+	resp := gin.H{"connections": stats, "limits": limits}
+	if byNode {
+		nodes, err := h.storage.ListConnectionNodes(ctx)
+		if err != nil {
+			logger.Errorw("failed to list connection nodes",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"error", err,
+			)
+			_ = c.Error(errors.InternalServerError(failure, err))
+			return
+		}
+		resp["nodes"] = nodes
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 // UpdateConnectionLimit updates connection limits.
 // @Summary Update connection limit
-// @Description Update connection limits for a target
+// @Description Set the max_connections of an app or cluster and publish it to the gateways
 // @Tags connections
 // @Accept json
 // @Produce json
 // @Param request body models.ConnectionLimit true "Connection limit"
+// @Param X-Change-Reason header string false "Reason recorded in the configuration history"
 // @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Target not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/connections [put]
 func (h *Handler) UpdateConnectionLimit(c *gin.Context) {
 	var req models.ConnectionLimit
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		_ = c.Error(validation.BindError(err))
 		return
 	}
 
-	// TODO: Implement connection limit update logic
-	logger.Infow("connection limit update requested",
+	if err := validation.ValidateConnectionLimit(&req); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := withChangeInfo(c, h.getRequestContext(c, 5*time.Second))
+	defer h.cancelRequestContext(c)
+
+	version, err := h.storage.SetConnectionLimit(ctx, &req)
+	if err != nil {
+		reportError(c, err, "failed to update connection limit", "target_type", req.TargetType, "target_id", req.TargetID)
+		return
+	}
+
+	logger.Infow("connection limit updated",
 		"request_id", c.GetString(middleware.RequestIDKey),
 		"user_id", c.GetString(middleware.UserIDKey),
 		"target_type", req.TargetType,
 		"target_id", req.TargetID,
 		"limit", req.Limit,
+		"version", version,
 	)
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"target_type": req.TargetType,
+		"target_id":   req.TargetID,
+		"limit":       req.Limit,
+		"version":     version,
+	})
 }
 
 // GetEmergencyStatus returns the current emergency mode status.
@@ -611,8 +1190,8 @@ func (h *Handler) UpdateConnectionLimit(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Success 200 {object} models.EmergencyStatus
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/emergency [get]
 func (h *Handler) GetEmergencyStatus(c *gin.Context) {
 	ctx := h.getRequestContext(c, 5*time.Second)
@@ -624,7 +1203,7 @@ func (h *Handler) GetEmergencyStatus(c *gin.Context) {
 			"request_id", c.GetString(middleware.RequestIDKey),
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get emergency status"})
+		_ = c.Error(errors.InternalServerError("failed to get emergency status", err))
 		return
 	}
 
@@ -638,10 +1217,11 @@ func (h *Handler) GetEmergencyStatus(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param request body models.EmergencyRequest true "Emergency request"
+// @Param X-Change-Reason header string false "Comment recorded in the emergency history"
 // @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/emergency/activate [post]
 func (h *Handler) ActivateEmergency(c *gin.Context) {
 	var req models.EmergencyRequest
@@ -656,20 +1236,15 @@ func (h *Handler) ActivateEmergency(c *gin.Context) {
 
 	// Validate request
 	if err := validation.ValidateEmergencyRequest(req.Reason, req.Duration); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
-	ctx := h.getRequestContext(c, 5*time.Second)
+	ctx := withChangeInfo(c, h.getRequestContext(c, 5*time.Second))
 	defer h.cancelRequestContext(c)
 
 	if err := h.storage.ActivateEmergency(ctx, req.Reason, req.Duration); err != nil {
-		logger.Errorw("failed to activate emergency",
-			"request_id", c.GetString(middleware.RequestIDKey),
-			"user_id", c.GetString(middleware.UserIDKey),
-			"error", err,
-		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to activate emergency mode"})
+		reportError(c, err, "failed to activate emergency mode", "user_id", c.GetString(middleware.UserIDKey))
 		return
 	}
 
@@ -689,21 +1264,17 @@ func (h *Handler) ActivateEmergency(c *gin.Context) {
 // @Tags emergency
 // @Accept json
 // @Produce json
+// @Param X-Change-Reason header string false "Comment recorded in the emergency history"
 // @Success 200 {object} map[string]interface{}
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/emergency/deactivate [post]
 func (h *Handler) DeactivateEmergency(c *gin.Context) {
-	ctx := h.getRequestContext(c, 5*time.Second)
+	ctx := withChangeInfo(c, h.getRequestContext(c, 5*time.Second))
 	defer h.cancelRequestContext(c)
 
 	if err := h.storage.DeactivateEmergency(ctx); err != nil {
-		logger.Errorw("failed to deactivate emergency",
-			"request_id", c.GetString(middleware.RequestIDKey),
-			"user_id", c.GetString(middleware.UserIDKey),
-			"error", err,
-		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to deactivate emergency mode"})
+		reportError(c, err, "failed to deactivate emergency mode", "user_id", c.GetString(middleware.UserIDKey))
 		return
 	}
 
@@ -722,8 +1293,8 @@ func (h *Handler) DeactivateEmergency(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Success 200 {object} models.Metrics
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/metrics [get]
 func (h *Handler) GetMetrics(c *gin.Context) {
 	ctx := h.getRequestContext(c, 10*time.Second)
@@ -735,7 +1306,7 @@ func (h *Handler) GetMetrics(c *gin.Context) {
 			"request_id", c.GetString(middleware.RequestIDKey),
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get metrics"})
+		_ = c.Error(errors.InternalServerError("failed to get metrics", err))
 		return
 	}
 
@@ -750,16 +1321,16 @@ func (h *Handler) GetMetrics(c *gin.Context) {
 // @Produce json
 // @Param id path string true "Application ID"
 // @Success 200 {object} models.AppMetrics
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/metrics/apps/{id} [get]
 func (h *Handler) GetAppMetrics(c *gin.Context) {
 	appID := c.Param("id")
 
 	// Validate app ID
 	if err := validation.ValidateAppID(appID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
@@ -773,7 +1344,7 @@ func (h *Handler) GetAppMetrics(c *gin.Context) {
 			"app_id", appID,
 			"error", err,
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get application metrics"})
+		_ = c.Error(errors.InternalServerError("failed to get application metrics", err))
 		return
 	}
 
@@ -782,29 +1353,18 @@ func (h *Handler) GetAppMetrics(c *gin.Context) {
 
 // GetConnectionMetrics returns connection metrics.
 // @Summary Get connection metrics
-// @Description Get connection metrics
+// @Description Get connection metrics; same as GET /api/v1/connections
 // @Tags metrics
 // @Accept json
 // @Produce json
+// @Param by_node query bool false "Include the per-node breakdown and node totals"
 // @Success 200 {object} map[string]interface{}
-// @Failure 401 {object} map[string]string "Unauthorized"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
 // @Router /api/v1/metrics/connections [get]
 func (h *Handler) GetConnectionMetrics(c *gin.Context) {
-	ctx := h.getRequestContext(c, 5*time.Second)
-	defer h.cancelRequestContext(c)
-
-	stats, err := h.storage.GetConnectionMetrics(ctx)
-	if err != nil {
-		logger.Errorw("failed to get connection metrics",
-			"request_id", c.GetString(middleware.RequestIDKey),
-			"error", err,
-		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get connection metrics"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"connections": stats})
+	h.writeConnectionStats(c, "failed to get connection metrics")
 }
 
 // WebSocketHandler handles WebSocket connections for real-time updates.
@@ -815,7 +1375,7 @@ func (h *Handler) GetConnectionMetrics(c *gin.Context) {
 // @Produce json
 // @Param Authorization header string true "Bearer token"
 // @Success 101 {string} string "Switching to WebSocket protocol"
-// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 401 {object} errors.Problem "Unauthorized"
 // @Router /ws [get]
 func (h *Handler) WebSocketHandler(c *gin.Context) {
 	// Upgrade HTTP connection to WebSocket
@@ -940,6 +1500,82 @@ func (h *Handler) pushMetrics(conn *websocket.Conn, stop chan struct{}) {
 	}
 }
 
+// parsePagination reads the cursor and limit query parameters for list endpoints.
+func parsePagination(c *gin.Context) (string, int, error) {
+	cursor := c.Query("cursor")
+	if err := validation.ValidateCursor(cursor); err != nil {
+		return "", 0, err
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return cursor, limit, nil
+}
+
+// parseLimit reads the limit query parameter, defaulting to DefaultPageSize.
+func parseLimit(c *gin.Context) (int, error) {
+	raw := c.Query("limit")
+	if raw == "" {
+		return DefaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, errors.BadRequest("limit must be an integer", nil)
+	}
+	if err := validation.ValidatePageLimit(limit, MaxPageSize); err != nil {
+		return 0, err
+	}
+
+	return limit, nil
+}
+
+// formatETag formats a config version as a strong ETag value.
+func formatETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseIfMatch returns the version required by the If-Match header.
+// A missing header or "*" yields storage.AnyVersion (unconditional write).
+func parseIfMatch(c *gin.Context) (int64, error) {
+	value := strings.TrimSpace(c.GetHeader("If-Match"))
+	if value == "" || value == "*" {
+		return storage.AnyVersion, nil
+	}
+
+	value = strings.TrimPrefix(value, "W/")
+	version, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+	if err != nil || version < 0 {
+		return 0, errors.BadRequest("invalid If-Match header, expected an ETag returned by GET", err)
+	}
+
+	return version, nil
+}
+
+// isClientError reports whether err resolves to a 4xx error, such as a version
+// conflict or a missing record. These are returned to the caller as they are
+// rather than logged as storage failures.
+func isClientError(err error) bool {
+	return errors.Resolve(err).Code < http.StatusInternalServerError
+}
+
+// reportError fails the request with err from a storage call or check.
+// Client errors reach the caller as they are; anything else is logged with
+// keysAndValues and reported as an internal error with message.
+func reportError(c *gin.Context, err error, message string, keysAndValues ...interface{}) {
+	if isClientError(err) {
+		_ = c.Error(err)
+		return
+	}
+
+	fields := append([]interface{}{"request_id", c.GetString(middleware.RequestIDKey)}, keysAndValues...)
+	logger.Errorw(message, append(fields, "error", err)...)
+	_ = c.Error(errors.InternalServerError(message, err))
+}
+
 // getRequestContext creates a context with timeout for the request.
 // It tracks the cancel function for cleanup.
 func (h *Handler) getRequestContext(c *gin.Context, timeout time.Duration) context.Context {
@@ -0,0 +1,616 @@
+package handlers
+
+import (
+	"admin-backend/config"
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword is the password of every user created by testServer.createUser.
+const testPassword = "correct-horse-1"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	if err := logger.Init("fatal", "console", ""); err != nil {
+		panic(err)
+	}
+	if err := middleware.InitJWT(&config.JWTConfig{
+		Secret:            "0123456789abcdef0123456789abcdef",
+		AccessExpiration:  time.Hour,
+		RefreshExpiration: 24 * time.Hour,
+	}); err != nil {
+		panic(err)
+	}
+	if err := middleware.InitPasswordHashing(&config.AuthConfig{PasswordHashCost: bcrypt.MinCost}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// testServer serves the handlers on the memory backend, wired the way main.go
+// wires them for the routes the tests use.
+type testServer struct {
+	t       *testing.T
+	store   storage.Storage
+	handler *Handler
+	router  *gin.Engine
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	store := storage.NewMemoryStorage()
+	h := NewHandler(store)
+	t.Cleanup(func() {
+		h.Close()
+		store.Close()
+	})
+
+	require := func(permission middleware.Permission) gin.HandlerFunc {
+		return middleware.RequirePermission(store, permission)
+	}
+
+	r := gin.New()
+	r.Use(middleware.RequestIDMiddleware(), middleware.ErrorHandler())
+
+	auth := r.Group("/api/v1/auth")
+	auth.POST("/login", h.Login)
+	auth.POST("/refresh", h.RefreshToken)
+	r.POST("/api/v1/auth/logout", middleware.AuthMiddleware(store), h.Logout)
+
+	api := r.Group("/api/v1", middleware.AuthMiddleware(store))
+
+	apps := api.Group("/apps", require(middleware.PermAppsRead))
+	apps.GET("", h.ListApps)
+	apps.POST("", require(middleware.PermAppsWrite), h.CreateApp)
+	apps.GET("/:id", h.GetApp)
+	apps.PUT("/:id", require(middleware.PermAppsWrite), h.UpdateApp)
+	apps.DELETE("/:id", require(middleware.PermAppsDelete), h.DeleteApp)
+	apps.GET("/:id/versions", h.ListAppVersions)
+	apps.GET("/:id/versions/:revision", h.GetAppVersion)
+	apps.GET("/:id/diff", h.DiffAppVersions)
+	apps.POST("/:id/rollback", require(middleware.PermAppsWrite), h.RollbackApp)
+
+	clusters := api.Group("/clusters", require(middleware.PermClustersRead))
+	clusters.GET("", h.ListClusters)
+	clusters.POST("", require(middleware.PermClustersWrite), h.CreateCluster)
+	clusters.GET("/:id", h.GetCluster)
+	clusters.PUT("/:id", require(middleware.PermClustersWrite), h.UpdateCluster)
+	clusters.DELETE("/:id", require(middleware.PermClustersDelete), h.DeleteCluster)
+
+	emergency := api.Group("/emergency", require(middleware.PermEmergencyRead))
+	emergency.GET("", h.GetEmergencyStatus)
+	emergency.GET("/history", h.GetEmergencyHistory)
+	emergency.POST("/activate", require(middleware.PermEmergencyActivate), h.ActivateEmergency)
+	emergency.POST("/deactivate", require(middleware.PermEmergencyDeactivate), h.DeactivateEmergency)
+	emergency.POST("/extend", require(middleware.PermEmergencyActivate), h.ExtendEmergency)
+	emergency.GET("/schedules", h.ListEmergencySchedules)
+	emergency.POST("/schedules", require(middleware.PermEmergencyActivate), h.CreateEmergencySchedule)
+	emergency.DELETE("/schedules/:id", require(middleware.PermEmergencyActivate), h.CancelEmergencySchedule)
+
+	users := api.Group("/users")
+	users.GET("/me", h.GetCurrentUser)
+	manage := users.Group("", require(middleware.PermUsersManage))
+	manage.PUT("/:id", h.UpdateUser)
+	manage.DELETE("/:id", h.DeleteUser)
+
+	apiKeys := api.Group("/api-keys", require(middleware.PermAPIKeysManage))
+	apiKeys.POST("", h.CreateAPIKey)
+
+	return &testServer{t: t, store: store, handler: h, router: r}
+}
+
+// createUser stores a user with testPassword and the given role.
+func (s *testServer) createUser(username, role string) *models.User {
+	s.t.Helper()
+
+	hash, err := middleware.HashPassword(testPassword)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.NewString(), Username: username, Role: role, PasswordHash: hash}
+	if err := s.store.CreateUser(context.Background(), user); err != nil {
+		s.t.Fatalf("create user %s: %v", username, err)
+	}
+	return user
+}
+
+// bearer returns the Authorization header of a fresh access token for user.
+func (s *testServer) bearer(user *models.User) http.Header {
+	s.t.Helper()
+
+	access, _, err := middleware.GenerateToken(user.ID, user.Username, user.Role)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return http.Header{"Authorization": {"Bearer " + access}}
+}
+
+// do sends a request with body encoded as JSON, if not nil.
+func (s *testServer) do(method, path string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// decodeResponse decodes the JSON body of w into v.
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+}
+
+// problemCode returns the error code of a problem details response.
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var problem errors.Problem
+	decodeResponse(t, w, &problem)
+	return problem.Code
+}
+
+// login logs user in with testPassword and returns the issued tokens.
+func (s *testServer) login(username string) models.TokenResponse {
+	s.t.Helper()
+
+	w := s.do(http.MethodPost, "/api/v1/auth/login", models.LoginRequest{Username: username, Password: testPassword}, nil)
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("login %s: status %d: %s", username, w.Code, w.Body.String())
+	}
+	var tokens models.TokenResponse
+	decodeResponse(s.t, w, &tokens)
+	return tokens
+}
+
+// refresh exchanges a refresh token and returns the response.
+func (s *testServer) refresh(refreshToken string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.do(http.MethodPost, "/api/v1/auth/refresh", map[string]string{"refresh_token": refreshToken}, nil)
+}
+
+// apiKey stores an API key with the given role or permissions and returns
+// the header authenticating with it.
+func (s *testServer) apiKey(role string, permissions []string, expiresAt *time.Time) http.Header {
+	s.t.Helper()
+
+	secret, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	key := &models.APIKey{
+		ID:          uuid.NewString(),
+		Name:        "test key",
+		Prefix:      prefix,
+		KeyHash:     hash,
+		Role:        role,
+		Permissions: permissions,
+		ExpiresAt:   expiresAt,
+	}
+	if err := s.store.CreateAPIKey(context.Background(), key); err != nil {
+		s.t.Fatal(err)
+	}
+	return apiKeyHeader(secret)
+}
+
+// apiKeyHeader returns the header authenticating with an API key.
+func apiKeyHeader(secret string) http.Header {
+	header := http.Header{}
+	header.Set(middleware.APIKeyHeader, secret)
+	return header
+}
+
+func TestTokenTypes(t *testing.T) {
+	s := newTestServer(t)
+	s.createUser("alice", models.RoleViewer)
+	tokens := s.login("alice")
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       interface{}
+		header     http.Header
+		wantStatus int
+	}{
+		{
+			name:       "access token authenticates",
+			method:     http.MethodGet,
+			path:       "/api/v1/users/me",
+			header:     http.Header{"Authorization": {"Bearer " + tokens.AccessToken}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "refresh token is not a bearer token",
+			method:     http.MethodGet,
+			path:       "/api/v1/users/me",
+			header:     http.Header{"Authorization": {"Bearer " + tokens.RefreshToken}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "access token cannot refresh",
+			method:     http.MethodPost,
+			path:       "/api/v1/auth/refresh",
+			body:       map[string]string{"refresh_token": tokens.AccessToken},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "malformed token",
+			method:     http.MethodGet,
+			path:       "/api/v1/users/me",
+			header:     http.Header{"Authorization": {"Bearer not-a-token"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(tt.method, tt.path, tt.body, tt.header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestServer(t)
+	s.createUser("alice", models.RoleViewer)
+	first := s.login("alice")
+
+	w := s.refresh(first.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: status %d: %s", w.Code, w.Body.String())
+	}
+	var second models.TokenResponse
+	decodeResponse(t, w, &second)
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("refresh did not issue new tokens")
+	}
+
+	// The rotated refresh token keeps working until it is used
+	secondAccess := http.Header{"Authorization": {"Bearer " + second.AccessToken}}
+	if w := s.do(http.MethodGet, "/api/v1/users/me", nil, secondAccess); w.Code != http.StatusOK {
+		t.Fatalf("new access token: status %d: %s", w.Code, w.Body.String())
+	}
+
+	// Reusing the consumed token ends every session of the user
+	if w := s.refresh(first.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: status %d, want 401", w.Code)
+	}
+	if w := s.refresh(second.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh token issued before reuse: status %d, want 401", w.Code)
+	}
+	if w := s.do(http.MethodGet, "/api/v1/users/me", nil, secondAccess); w.Code != http.StatusUnauthorized {
+		t.Fatalf("access token issued before reuse: status %d, want 401", w.Code)
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	tests := []struct {
+		name             string
+		sendRefresh      bool
+		wantRefreshAfter int
+	}{
+		{name: "access token only", sendRefresh: false, wantRefreshAfter: http.StatusOK},
+		{name: "with refresh token", sendRefresh: true, wantRefreshAfter: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.createUser("alice", models.RoleViewer)
+			tokens := s.login("alice")
+			access := http.Header{"Authorization": {"Bearer " + tokens.AccessToken}}
+
+			var body interface{}
+			if tt.sendRefresh {
+				body = map[string]string{"refresh_token": tokens.RefreshToken}
+			}
+			if w := s.do(http.MethodPost, "/api/v1/auth/logout", body, access); w.Code != http.StatusNoContent {
+				t.Fatalf("logout: status %d: %s", w.Code, w.Body.String())
+			}
+
+			if w := s.do(http.MethodGet, "/api/v1/users/me", nil, access); w.Code != http.StatusUnauthorized {
+				t.Fatalf("access token after logout: status %d, want 401", w.Code)
+			}
+			if w := s.refresh(tokens.RefreshToken); w.Code != tt.wantRefreshAfter {
+				t.Fatalf("refresh after logout: status %d, want %d", w.Code, tt.wantRefreshAfter)
+			}
+		})
+	}
+}
+
+func TestCreateAppExisting(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.bearer(s.createUser("root", models.RoleAdmin))
+	app := models.AppConfig{AppID: "app1", GuaranteedQuota: 100, BurstQuota: 200, Priority: 1}
+
+	if w := s.do(http.MethodPost, "/api/v1/apps", app, admin); w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+	}
+
+	app.GuaranteedQuota = 50
+	w := s.do(http.MethodPost, "/api/v1/apps", app, admin)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("create existing: status %d, want 409", w.Code)
+	}
+	if code := problemCode(t, w); code != errors.CodeConflict {
+		t.Errorf("code = %q, want %q", code, errors.CodeConflict)
+	}
+
+	stored, err := s.store.GetAppConfig(context.Background(), "app1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.GuaranteedQuota != 100 || stored.Version != 1 {
+		t.Errorf("existing app overwritten: %+v", stored)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// ifMatch is sent as If-Match unless empty; "current" and "stale" stand
+		// for the ETag of the stored version and of the one before it
+		ifMatch     string
+		wantStatus  int
+		wantCode    string
+		wantVersion int64
+	}{
+		{name: "no If-Match", wantStatus: http.StatusOK, wantVersion: 3},
+		{name: "any version", ifMatch: "*", wantStatus: http.StatusOK, wantVersion: 3},
+		{name: "current version", ifMatch: "current", wantStatus: http.StatusOK, wantVersion: 3},
+		{name: "weak current version", ifMatch: `W/"2"`, wantStatus: http.StatusOK, wantVersion: 3},
+		{name: "stale version", ifMatch: "stale", wantStatus: http.StatusConflict, wantCode: errors.CodeVersionConflict, wantVersion: 2},
+		{name: "malformed", ifMatch: "abc", wantStatus: http.StatusBadRequest, wantCode: errors.CodeBadRequest, wantVersion: 2},
+	}
+
+	resources := []struct {
+		name   string
+		create interface{}
+		path   string
+		update interface{}
+	}{
+		{
+			name:   "app",
+			create: models.AppConfig{AppID: "app1", GuaranteedQuota: 100, BurstQuota: 200, Priority: 1},
+			path:   "/api/v1/apps/app1",
+			update: models.AppConfig{GuaranteedQuota: 150, BurstQuota: 200, Priority: 1},
+		},
+		{
+			name:   "cluster",
+			create: models.ClusterConfig{ClusterID: "c1", MaxCapacity: 1000, ReservedRatio: 0.1, EmergencyThreshold: 0.9},
+			path:   "/api/v1/clusters/c1",
+			update: models.ClusterConfig{MaxCapacity: 2000, ReservedRatio: 0.1, EmergencyThreshold: 0.9},
+		},
+	}
+
+	for _, resource := range resources {
+		for _, tt := range tests {
+			t.Run(resource.name+"/"+tt.name, func(t *testing.T) {
+				s := newTestServer(t)
+				admin := s.bearer(s.createUser("root", models.RoleAdmin))
+
+				collection := resource.path[:strings.LastIndex(resource.path, "/")]
+				if w := s.do(http.MethodPost, collection, resource.create, admin); w.Code != http.StatusCreated {
+					t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+				}
+				stale := s.do(http.MethodGet, resource.path, nil, admin).Header().Get("ETag")
+				if w := s.do(http.MethodPut, resource.path, resource.update, admin); w.Code != http.StatusOK {
+					t.Fatalf("first update: status %d: %s", w.Code, w.Body.String())
+				}
+				current := s.do(http.MethodGet, resource.path, nil, admin).Header().Get("ETag")
+				if stale != `"1"` || current != `"2"` {
+					t.Fatalf("ETags %s and %s, want \"1\" and \"2\"", stale, current)
+				}
+
+				header := s.bearer(s.createUser("editor", models.RoleAdmin))
+				switch tt.ifMatch {
+				case "":
+				case "current":
+					header.Set("If-Match", current)
+				case "stale":
+					header.Set("If-Match", stale)
+				default:
+					header.Set("If-Match", tt.ifMatch)
+				}
+
+				w := s.do(http.MethodPut, resource.path, resource.update, header)
+				if w.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+				}
+				if tt.wantCode != "" {
+					var problem map[string]interface{}
+					decodeResponse(t, w, &problem)
+					if problem["code"] != tt.wantCode {
+						t.Errorf("code = %v, want %q", problem["code"], tt.wantCode)
+					}
+					if tt.wantCode == errors.CodeVersionConflict &&
+						(problem["current_version"] != float64(2) || problem["expected_version"] != float64(1)) {
+						t.Errorf("conflict reports versions %v and %v, want 2 and 1", problem["current_version"], problem["expected_version"])
+					}
+				} else if etag := w.Header().Get("ETag"); etag != formatETag(tt.wantVersion) {
+					t.Errorf("ETag = %s, want %s", etag, formatETag(tt.wantVersion))
+				}
+
+				if etag := s.do(http.MethodGet, resource.path, nil, admin).Header().Get("ETag"); etag != formatETag(tt.wantVersion) {
+					t.Errorf("stored ETag = %s, want %s", etag, formatETag(tt.wantVersion))
+				}
+			})
+		}
+	}
+}
+
+// failingEmergencyStorage fails every emergency activation with err.
+type failingEmergencyStorage struct {
+	storage.Storage
+	err error
+}
+
+func (s *failingEmergencyStorage) ActivateEmergency(ctx context.Context, reason string, duration int64) error {
+	return s.err
+}
+
+func TestReportError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantDetail string
+	}{
+		{
+			name:       "client error",
+			err:        errors.Conflict("app1 already exists", nil),
+			wantStatus: http.StatusConflict,
+			wantCode:   errors.CodeConflict,
+			wantDetail: "app1 already exists",
+		},
+		{
+			name:       "wrapped client error",
+			err:        fmt.Errorf("lookup: %w", errors.NotFound("app1 not found", nil)),
+			wantStatus: http.StatusNotFound,
+			wantCode:   errors.CodeNotFound,
+			wantDetail: "app1 not found",
+		},
+		{
+			name:       "storage failure",
+			err:        fmt.Errorf("dial tcp: connection refused"),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   errors.CodeInternal,
+			wantDetail: "failed to do the thing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(middleware.RequestIDMiddleware(), middleware.ErrorHandler())
+			r.GET("/", func(c *gin.Context) {
+				reportError(c, tt.err, "failed to do the thing", "app_id", "app1")
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			var problem errors.Problem
+			decodeResponse(t, w, &problem)
+			if problem.Code != tt.wantCode || problem.Detail != tt.wantDetail {
+				t.Errorf("problem = %s %q, want %s %q", problem.Code, problem.Detail, tt.wantCode, tt.wantDetail)
+			}
+		})
+	}
+
+	// Emergency activation goes through the same path
+	store := &failingEmergencyStorage{
+		Storage: storage.NewMemoryStorage(),
+		err:     errors.Conflict("emergency mode is locked", nil),
+	}
+	defer store.Close()
+	h := NewHandler(store)
+	defer h.Close()
+	r := gin.New()
+	r.Use(middleware.RequestIDMiddleware(), middleware.ErrorHandler())
+	r.POST("/activate", h.ActivateEmergency)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/activate", strings.NewReader(`{"reason":"load test","duration":60}`)))
+	if w.Code != http.StatusConflict || problemCode(t, w) != errors.CodeConflict {
+		t.Errorf("activate: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestValidationErrorsInOneResponse(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.bearer(s.createUser("root", models.RoleAdmin))
+
+	tests := []struct {
+		name string
+		path string
+		body interface{}
+		want []string
+	}{
+		{
+			name: "app",
+			path: "/api/v1/apps",
+			body: map[string]interface{}{"app_id": "bad id", "guaranteed_quota": 10, "burst_quota": 5, "priority": 7},
+			want: []string{"app_id", "priority", "burst_quota"},
+		},
+		{
+			name: "cluster",
+			path: "/api/v1/clusters",
+			body: map[string]interface{}{"cluster_id": "", "max_capacity": -1, "emergency_threshold": 3},
+			want: []string{"cluster_id", "max_capacity", "emergency_threshold"},
+		},
+		{
+			name: "mistyped field",
+			path: "/api/v1/apps",
+			body: map[string]interface{}{"app_id": "app1", "guaranteed_quota": "lots"},
+			want: []string{"guaranteed_quota"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(http.MethodPost, tt.path, tt.body, admin)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", w.Code, w.Body.String())
+			}
+
+			var problem errors.Problem
+			decodeResponse(t, w, &problem)
+			if problem.Code != errors.CodeValidationFailed {
+				t.Errorf("code = %s, want %s", problem.Code, errors.CodeValidationFailed)
+			}
+			var fields []string
+			for _, fe := range problem.Errors {
+				fields = append(fields, fe.Field)
+				if fe.Rule == "" || !strings.HasPrefix(fe.Message, fe.Field) {
+					t.Errorf("field error %+v", fe)
+				}
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("fields = %v, want %v", fields, tt.want)
+			}
+		})
+	}
+}
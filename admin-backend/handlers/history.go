@@ -0,0 +1,466 @@
+// Package handlers provides HTTP handlers for configuration history and rollback.
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"admin-backend/validation"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChangeReasonHeader carries an optional free-form reason for a configuration write.
+const ChangeReasonHeader = "X-Change-Reason"
+
+// diffIgnoredFields are bookkeeping fields left out of version diffs.
+var diffIgnoredFields = map[string]bool{
+	"version":    true,
+	"updated_at": true,
+}
+
+// withChangeInfo attaches the authenticated user and the X-Change-Reason header
+// to ctx so storage records them in the configuration history.
+func withChangeInfo(c *gin.Context, ctx context.Context) context.Context {
+	return storage.WithChangeInfo(ctx, storage.ChangeInfo{
+		Author: c.GetString(middleware.UserIDKey),
+		Reason: validation.SanitizeReason(c.GetHeader(ChangeReasonHeader)),
+	})
+}
+
+// ListAppVersions returns the version history of an application.
+// @Summary List application versions
+// @Description Get the configuration history of an application, newest first
+// @Tags apps
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Param limit query int false "Maximum number of versions (default 100, max 1000)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/apps/{id}/versions [get]
+func (h *Handler) ListAppVersions(c *gin.Context) {
+	h.listVersions(c, models.ResourceApp, validation.ValidateAppID)
+}
+
+// GetAppVersion returns a single revision of an application.
+// @Summary Get application version
+// @Description Get one snapshot from an application's configuration history
+// @Tags apps
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Param revision path int true "Revision number"
+// @Success 200 {object} models.ConfigSnapshot
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/apps/{id}/versions/{revision} [get]
+func (h *Handler) GetAppVersion(c *gin.Context) {
+	h.getVersion(c, models.ResourceApp, validation.ValidateAppID)
+}
+
+// DiffAppVersions compares two revisions of an application.
+// @Summary Diff application versions
+// @Description List the fields that differ between two revisions of an application
+// @Tags apps
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Param from query int true "Base revision"
+// @Param to query int true "Target revision"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/apps/{id}/diff [get]
+func (h *Handler) DiffAppVersions(c *gin.Context) {
+	h.diffVersions(c, models.ResourceApp, validation.ValidateAppID)
+}
+
+// RollbackApp restores an application to a previous revision.
+// The restore is recorded as a new version and published like any other update.
+// @Summary Roll back application
+// @Description Restore an application configuration from its history
+// @Tags apps
+// @Accept json
+// @Produce json
+// @Param id path string true "Application ID"
+// @Param If-Match header string false "ETag returned by GetApp; the rollback is rejected if the app changed since"
+// @Param request body models.RollbackRequest true "Rollback request"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 409 {object} errors.Problem "Version conflict"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/apps/{id}/rollback [post]
+func (h *Handler) RollbackApp(c *gin.Context) {
+	h.rollback(c, models.ResourceApp, validation.ValidateAppID, func(ctx context.Context, snap *models.ConfigSnapshot, expectedVersion int64) (int64, error) {
+		config := *snap.App
+
+		// The snapshot may name clusters deleted since it was taken
+		clusters, err := h.storage.ListClusterConfigs(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if violations := validation.ValidateClusterRefs(&config, clusters); len(violations) > 0 {
+			return 0, validation.ValidationFailed(violations)
+		}
+
+		if err := h.storage.CompareAndSetAppConfig(ctx, &config, expectedVersion); err != nil {
+			return 0, err
+		}
+		return config.Version, nil
+	})
+}
+
+// ListClusterVersions returns the version history of a cluster.
+// @Summary List cluster versions
+// @Description Get the configuration history of a cluster, newest first
+// @Tags clusters
+// @Accept json
+// @Produce json
+// @Param id path string true "Cluster ID"
+// @Param limit query int false "Maximum number of versions (default 100, max 1000)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/clusters/{id}/versions [get]
+func (h *Handler) ListClusterVersions(c *gin.Context) {
+	h.listVersions(c, models.ResourceCluster, validation.ValidateClusterID)
+}
+
+// GetClusterVersion returns a single revision of a cluster.
+// @Summary Get cluster version
+// @Description Get one snapshot from a cluster's configuration history
+// @Tags clusters
+// @Accept json
+// @Produce json
+// @Param id path string true "Cluster ID"
+// @Param revision path int true "Revision number"
+// @Success 200 {object} models.ConfigSnapshot
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/clusters/{id}/versions/{revision} [get]
+func (h *Handler) GetClusterVersion(c *gin.Context) {
+	h.getVersion(c, models.ResourceCluster, validation.ValidateClusterID)
+}
+
+// DiffClusterVersions compares two revisions of a cluster.
+// @Summary Diff cluster versions
+// @Description List the fields that differ between two revisions of a cluster
+// @Tags clusters
+// @Accept json
+// @Produce json
+// @Param id path string true "Cluster ID"
+// @Param from query int true "Base revision"
+// @Param to query int true "Target revision"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/clusters/{id}/diff [get]
+func (h *Handler) DiffClusterVersions(c *gin.Context) {
+	h.diffVersions(c, models.ResourceCluster, validation.ValidateClusterID)
+}
+
+// RollbackCluster restores a cluster to a previous revision.
+// @Summary Roll back cluster
+// @Description Restore a cluster configuration from its history
+// @Tags clusters
+// @Accept json
+// @Produce json
+// @Param id path string true "Cluster ID"
+// @Param If-Match header string false "ETag returned by GetCluster; the rollback is rejected if the cluster changed since"
+// @Param request body models.RollbackRequest true "Rollback request"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 409 {object} errors.Problem "Version conflict"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/clusters/{id}/rollback [post]
+func (h *Handler) RollbackCluster(c *gin.Context) {
+	h.rollback(c, models.ResourceCluster, validation.ValidateClusterID, func(ctx context.Context, snap *models.ConfigSnapshot, expectedVersion int64) (int64, error) {
+		config := *snap.Cluster
+		if err := h.storage.CompareAndSetClusterConfig(ctx, &config, expectedVersion); err != nil {
+			return 0, err
+		}
+		return config.Version, nil
+	})
+}
+
+// listVersions writes the history of the resource named by the id path parameter.
+func (h *Handler) listVersions(c *gin.Context, resourceType string, validateID func(string) error) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	versions, err := h.storage.ListConfigHistory(ctx, resourceType, id, limit)
+	if err != nil {
+		logger.Errorw("failed to list versions",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"resource_type", resourceType,
+			"resource_id", id,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list versions", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// getVersion writes the snapshot named by the id and revision path parameters.
+func (h *Handler) getVersion(c *gin.Context, resourceType string, validateID func(string) error) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	revision, err := parseRevision(c.Param("revision"), "revision")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	snap, ok := h.loadSnapshot(c, ctx, resourceType, id, revision)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, snap)
+}
+
+// diffVersions writes the field changes between the from and to revisions.
+func (h *Handler) diffVersions(c *gin.Context, resourceType string, validateID func(string) error) {
+	id := c.Param("id")
+	if err := validateID(id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	from, err := parseRevision(c.Query("from"), "from")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	to, err := parseRevision(c.Query("to"), "to")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	fromSnap, ok := h.loadSnapshot(c, ctx, resourceType, id, from)
+	if !ok {
+		return
+	}
+	toSnap, ok := h.loadSnapshot(c, ctx, resourceType, id, to)
+	if !ok {
+		return
+	}
+
+	changes, err := diffSnapshots(fromSnap, toSnap)
+	if err != nil {
+		logger.Errorw("failed to diff versions",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"resource_type", resourceType,
+			"resource_id", id,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to diff versions", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    fromSnap.Revision,
+		"to":      toSnap.Revision,
+		"changes": changes,
+	})
+}
+
+// rollback restores the resource named by the id path parameter using restore,
+// which writes the snapshot's config and returns the new version.
+func (h *Handler) rollback(c *gin.Context, resourceType string, validateID func(string) error, restore func(context.Context, *models.ConfigSnapshot, int64) (int64, error)) {
+	id := c.Param("id")
+	var req models.RollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.BadRequest("invalid request format", err))
+		return
+	}
+
+	if err := validateID(id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	expectedVersion, err := parseIfMatch(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	snap, ok := h.loadSnapshot(c, ctx, resourceType, id, req.Revision)
+	if !ok {
+		return
+	}
+	if snap.Action == models.ChangeDelete {
+		_ = c.Error(errors.BadRequest("cannot roll back to a deletion", nil))
+		return
+	}
+
+	reason := fmt.Sprintf("rollback to revision %d", req.Revision)
+	if r := validation.SanitizeReason(req.Reason); r != "" {
+		reason += ": " + r
+	}
+	ctx = storage.WithChangeInfo(ctx, storage.ChangeInfo{
+		Author: c.GetString(middleware.UserIDKey),
+		Reason: reason,
+		Action: models.ChangeRollback,
+	})
+
+	version, err := restore(ctx, snap, expectedVersion)
+	if err != nil {
+		reportError(c, err, "failed to roll back", "resource_type", resourceType, "resource_id", id, "revision", req.Revision)
+		return
+	}
+
+	logger.Infow("config rolled back",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"resource_type", resourceType,
+		"resource_id", id,
+		"revision", req.Revision,
+		"version", version,
+	)
+
+	c.Header("ETag", formatETag(version))
+	c.JSON(http.StatusOK, gin.H{"success": true, "version": version})
+}
+
+// loadSnapshot fetches a snapshot, reporting an error if it cannot be returned.
+func (h *Handler) loadSnapshot(c *gin.Context, ctx context.Context, resourceType, id string, revision int64) (*models.ConfigSnapshot, bool) {
+	snap, err := h.storage.GetConfigSnapshot(ctx, resourceType, id, revision)
+	if err != nil {
+		logger.Errorw("failed to get version",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"resource_type", resourceType,
+			"resource_id", id,
+			"revision", revision,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to get version", err))
+		return nil, false
+	}
+
+	if snap == nil {
+		_ = c.Error(errors.NotFound(fmt.Sprintf("revision %d not found", revision), nil))
+		return nil, false
+	}
+
+	return snap, true
+}
+
+// parseRevision parses a positive revision number.
+func parseRevision(raw, name string) (int64, error) {
+	revision, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || revision < 1 {
+		return 0, errors.BadRequest(name+" must be a positive revision number", nil)
+	}
+	return revision, nil
+}
+
+// diffSnapshots lists the config fields that differ between two snapshots,
+// sorted by field name.
+func diffSnapshots(from, to *models.ConfigSnapshot) ([]models.FieldChange, error) {
+	fromFields, err := snapshotFields(from)
+	if err != nil {
+		return nil, err
+	}
+	toFields, err := snapshotFields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(fromFields)+len(toFields))
+	for name := range fromFields {
+		names[name] = true
+	}
+	for name := range toFields {
+		names[name] = true
+	}
+
+	changes := make([]models.FieldChange, 0)
+	for name := range names {
+		if diffIgnoredFields[name] || reflect.DeepEqual(fromFields[name], toFields[name]) {
+			continue
+		}
+		changes = append(changes, models.FieldChange{
+			Field: name,
+			From:  fromFields[name],
+			To:    toFields[name],
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// snapshotFields returns the snapshot's config as a JSON field map.
+func snapshotFields(snap *models.ConfigSnapshot) (map[string]interface{}, error) {
+	var config interface{} = snap.App
+	if snap.ResourceType == models.ResourceCluster {
+		config = snap.Cluster
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
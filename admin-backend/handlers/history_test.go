@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"admin-backend/models"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// newHistoryServer returns a server where app1 has three revisions: created
+// with a guaranteed quota of 100, raised to 150, then given priority 2.
+func newHistoryServer(t *testing.T) (*testServer, http.Header) {
+	t.Helper()
+
+	s := newTestServer(t)
+	admin := s.bearer(s.createUser("root", models.RoleAdmin))
+
+	writes := []struct {
+		method string
+		path   string
+		config models.AppConfig
+	}{
+		{http.MethodPost, "/api/v1/apps", models.AppConfig{AppID: "app1", GuaranteedQuota: 100, BurstQuota: 200, Priority: 1, MaxBorrow: 100}},
+		{http.MethodPut, "/api/v1/apps/app1", models.AppConfig{GuaranteedQuota: 150, BurstQuota: 200, Priority: 1, MaxBorrow: 100}},
+		{http.MethodPut, "/api/v1/apps/app1", models.AppConfig{GuaranteedQuota: 150, BurstQuota: 200, Priority: 2, MaxBorrow: 100}},
+	}
+	for i, write := range writes {
+		header := s.bearer(s.createUser(fmt.Sprintf("writer%d", i+1), models.RoleOperator))
+		header.Set(ChangeReasonHeader, fmt.Sprintf("change %d", i+1))
+		if w := s.do(write.method, write.path, write.config, header); w.Code/100 != 2 {
+			t.Fatalf("write %d: status %d: %s", i+1, w.Code, w.Body.String())
+		}
+	}
+
+	return s, admin
+}
+
+func TestListAppVersions(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		wantStatus    int
+		wantRevisions []int64
+	}{
+		{name: "full history", wantStatus: http.StatusOK, wantRevisions: []int64{3, 2, 1}},
+		{name: "limited", query: "?limit=2", wantStatus: http.StatusOK, wantRevisions: []int64{3, 2}},
+		{name: "invalid limit", query: "?limit=0", wantStatus: http.StatusBadRequest},
+		{name: "limit not a number", query: "?limit=x", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, admin := newHistoryServer(t)
+
+			w := s.do(http.MethodGet, "/api/v1/apps/app1/versions"+tt.query, nil, admin)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Versions []models.ConfigSnapshot `json:"versions"`
+			}
+			decodeResponse(t, w, &resp)
+			if len(resp.Versions) != len(tt.wantRevisions) {
+				t.Fatalf("%d versions, want %d", len(resp.Versions), len(tt.wantRevisions))
+			}
+			for i, snap := range resp.Versions {
+				if snap.Revision != tt.wantRevisions[i] || snap.Version != tt.wantRevisions[i] {
+					t.Errorf("version %d: revision %d, version %d, want %d", i, snap.Revision, snap.Version, tt.wantRevisions[i])
+				}
+				if want := fmt.Sprintf("change %d", snap.Revision); snap.Reason != want || snap.Author == "" {
+					t.Errorf("revision %d: author %q, reason %q, want a user and %q", snap.Revision, snap.Author, snap.Reason, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetAppVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		revision   string
+		wantStatus int
+		wantQuota  int64
+	}{
+		{name: "first revision", revision: "1", wantStatus: http.StatusOK, wantQuota: 100},
+		{name: "second revision", revision: "2", wantStatus: http.StatusOK, wantQuota: 150},
+		{name: "unknown revision", revision: "9", wantStatus: http.StatusNotFound},
+		{name: "zero", revision: "0", wantStatus: http.StatusBadRequest},
+		{name: "not a number", revision: "x", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, admin := newHistoryServer(t)
+
+			w := s.do(http.MethodGet, "/api/v1/apps/app1/versions/"+tt.revision, nil, admin)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code == http.StatusOK {
+				var snap models.ConfigSnapshot
+				decodeResponse(t, w, &snap)
+				if snap.App == nil || snap.App.GuaranteedQuota != tt.wantQuota {
+					t.Errorf("snapshot %+v, want guaranteed quota %d", snap, tt.wantQuota)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffAppVersions(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantChanges map[string][2]float64
+	}{
+		{
+			name:        "one field",
+			query:       "?from=1&to=2",
+			wantStatus:  http.StatusOK,
+			wantChanges: map[string][2]float64{"guaranteed_quota": {100, 150}},
+		},
+		{
+			name:        "several fields",
+			query:       "?from=1&to=3",
+			wantStatus:  http.StatusOK,
+			wantChanges: map[string][2]float64{"guaranteed_quota": {100, 150}, "priority": {1, 2}},
+		},
+		{
+			name:        "backwards",
+			query:       "?from=3&to=2",
+			wantStatus:  http.StatusOK,
+			wantChanges: map[string][2]float64{"priority": {2, 1}},
+		},
+		{name: "same revision", query: "?from=2&to=2", wantStatus: http.StatusOK, wantChanges: map[string][2]float64{}},
+		{name: "missing from", query: "?to=2", wantStatus: http.StatusBadRequest},
+		{name: "unknown revision", query: "?from=1&to=9", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, admin := newHistoryServer(t)
+
+			w := s.do(http.MethodGet, "/api/v1/apps/app1/diff"+tt.query, nil, admin)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Changes []models.FieldChange `json:"changes"`
+			}
+			decodeResponse(t, w, &resp)
+			if len(resp.Changes) != len(tt.wantChanges) {
+				t.Fatalf("changes %+v, want %v", resp.Changes, tt.wantChanges)
+			}
+			for _, change := range resp.Changes {
+				want, ok := tt.wantChanges[change.Field]
+				if !ok || change.From != want[0] || change.To != want[1] {
+					t.Errorf("change %+v, want %v", change, tt.wantChanges)
+				}
+			}
+		})
+	}
+}
+
+func TestRollbackApp(t *testing.T) {
+	tests := []struct {
+		name string
+		role string
+		// deleted deletes app1 first, making revision 4 its deletion
+		deleted     bool
+		ifMatch     string
+		revision    int64
+		wantStatus  int
+		wantVersion int64
+		wantQuota   int64
+	}{
+		{name: "restore revision 1", role: models.RoleOperator, revision: 1, wantStatus: http.StatusOK, wantVersion: 4, wantQuota: 100},
+		{name: "matching If-Match", role: models.RoleOperator, ifMatch: `"3"`, revision: 1, wantStatus: http.StatusOK, wantVersion: 4, wantQuota: 100},
+		{name: "stale If-Match", role: models.RoleOperator, ifMatch: `"2"`, revision: 1, wantStatus: http.StatusConflict, wantVersion: 3, wantQuota: 150},
+		{name: "restore a deleted app", role: models.RoleOperator, deleted: true, revision: 2, wantStatus: http.StatusOK, wantVersion: 1, wantQuota: 150},
+		{name: "roll back to a deletion", role: models.RoleOperator, deleted: true, revision: 4, wantStatus: http.StatusBadRequest},
+		{name: "unknown revision", role: models.RoleOperator, revision: 9, wantStatus: http.StatusNotFound, wantVersion: 3, wantQuota: 150},
+		{name: "viewer", role: models.RoleViewer, revision: 1, wantStatus: http.StatusForbidden, wantVersion: 3, wantQuota: 150},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, admin := newHistoryServer(t)
+			if tt.deleted {
+				if w := s.do(http.MethodDelete, "/api/v1/apps/app1", nil, admin); w.Code != http.StatusNoContent {
+					t.Fatalf("delete: status %d: %s", w.Code, w.Body.String())
+				}
+			}
+
+			header := s.bearer(s.createUser("caller", tt.role))
+			if tt.ifMatch != "" {
+				header.Set("If-Match", tt.ifMatch)
+			}
+			w := s.do(http.MethodPost, "/api/v1/apps/app1/rollback", models.RollbackRequest{Revision: tt.revision, Reason: "bad deploy"}, header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			ctx := context.Background()
+			stored, err := s.store.GetAppConfig(ctx, "app1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantVersion == 0 {
+				if stored != nil {
+					t.Errorf("deleted app restored: %+v", stored)
+				}
+				return
+			}
+			if stored == nil || stored.Version != tt.wantVersion || stored.GuaranteedQuota != tt.wantQuota {
+				t.Fatalf("stored %+v, want version %d with guaranteed quota %d", stored, tt.wantVersion, tt.wantQuota)
+			}
+
+			if w.Code == http.StatusOK {
+				latest, err := s.store.ListConfigHistory(ctx, models.ResourceApp, "app1", 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := fmt.Sprintf("rollback to revision %d: bad deploy", tt.revision)
+				if len(latest) != 1 || latest[0].Action != models.ChangeRollback || latest[0].Reason != want {
+					t.Errorf("rollback recorded as %+v, want action %q with reason %q", latest, models.ChangeRollback, want)
+				}
+			}
+		})
+	}
+}
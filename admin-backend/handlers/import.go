@@ -0,0 +1,92 @@
+// Package handlers provides HTTP handlers for bulk configuration import.
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"admin-backend/validation"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// importChangeReason is recorded in the history when an import gives no reason.
+const importChangeReason = "bulk import"
+
+// ImportApps creates or replaces many application configurations at once.
+// @Summary Import applications
+// @Description Create or replace up to 1000 application configurations. Every config is validated first and nothing is written unless all are valid; violations are reported per field as apps[i].field.
+// @Tags apps
+// @Accept json
+// @Produce json
+// @Param request body models.AppImportRequest true "Application configurations"
+// @Param X-Change-Reason header string false "Reason recorded in the configuration history"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 409 {object} errors.Problem "Cluster capacity exceeded"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/apps/import [post]
+func (h *Handler) ImportApps(c *gin.Context) {
+	var req models.AppImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(validation.BindError(err))
+		return
+	}
+
+	if err := validation.ValidateAppImport(req.Apps); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	info := storage.ChangeInfo{
+		Author: c.GetString(middleware.UserIDKey),
+		Reason: validation.SanitizeReason(c.GetHeader(ChangeReasonHeader)),
+	}
+	if info.Reason == "" {
+		info.Reason = importChangeReason
+	}
+	ctx := storage.WithChangeInfo(h.getRequestContext(c, 30*time.Second), info)
+	defer h.cancelRequestContext(c)
+
+	configs := make([]*models.AppConfig, len(req.Apps))
+	for i := range req.Apps {
+		configs[i] = &req.Apps[i]
+	}
+	warnings, err := h.checkAppClusters(ctx, "apps", configs...)
+	if err != nil {
+		reportError(c, err, "failed to check cluster capacity")
+		return
+	}
+
+	imported := make([]gin.H, 0, len(req.Apps))
+	for i := range req.Apps {
+		config := &req.Apps[i]
+		if err := h.storage.SetAppConfig(ctx, config); err != nil {
+			logger.Errorw("failed to import app",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"app_id", config.AppID,
+				"imported", len(imported),
+				"error", err,
+			)
+			// Earlier configs stay written; report how far the import got
+			_ = c.Error(errors.InternalServerError("failed to import applications", err).
+				WithContext("imported", imported))
+			return
+		}
+		imported = append(imported, gin.H{"app_id": config.AppID, "version": config.Version})
+	}
+
+	logger.Infow("apps imported",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"count", len(imported),
+	)
+
+	c.JSON(http.StatusOK, withWarnings(gin.H{"success": true, "imported": imported}, warnings))
+}
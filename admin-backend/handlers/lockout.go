@@ -0,0 +1,346 @@
+// Package handlers provides HTTP handlers for login brute-force protection.
+package handlers
+
+import (
+	"admin-backend/config"
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginAuditAction is the audit log action of login security events.
+const loginAuditAction = "auth:login"
+
+// EnableLoginProtection turns on failed login throttling.
+func (h *Handler) EnableLoginProtection(cfg *config.LoginProtectionConfig) {
+	h.loginProtection = cfg
+}
+
+// loginSubject is a username or client IP whose failed logins are counted.
+type loginSubject struct {
+	scope       string
+	key         string
+	maxFailures int
+}
+
+// loginSubjects returns the subjects a login attempt counts against.
+func (h *Handler) loginSubjects(c *gin.Context, username string) []loginSubject {
+	return []loginSubject{
+		{scope: models.LoginScopeUser, key: username, maxFailures: h.loginProtection.MaxUserFailures},
+		{scope: models.LoginScopeIP, key: c.ClientIP(), maxFailures: h.loginProtection.MaxIPFailures},
+	}
+}
+
+// loginBlock returns how long logins are refused after the given number of
+// failures, and whether the block is a lockout. The first half of maxFailures
+// are free, so a few typos cost nothing; each failure after that doubles the
+// delay from BackoffBase up to BackoffMax, and reaching maxFailures locks the
+// subject out for LockoutDuration.
+func (h *Handler) loginBlock(failures int64, maxFailures int) (time.Duration, bool) {
+	cfg := h.loginProtection
+	if failures >= int64(maxFailures) {
+		return cfg.LockoutDuration, true
+	}
+
+	free := int64(maxFailures / 2)
+	if free < 1 {
+		free = 1
+	}
+	if failures <= free || cfg.BackoffBase <= 0 {
+		return 0, false
+	}
+
+	delay := cfg.BackoffBase
+	for i := free + 1; i < failures && delay < cfg.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > cfg.BackoffMax {
+		delay = cfg.BackoffMax
+	}
+	return delay, false
+}
+
+// rejectBlockedLogin refuses a login with 429 while its username or client IP
+// is blocked. Returns true if the login was refused.
+func (h *Handler) rejectBlockedLogin(c *gin.Context, ctx context.Context, username string) bool {
+	if h.loginProtection == nil {
+		return false
+	}
+
+	now := time.Now()
+	for _, subject := range h.loginSubjects(c, username) {
+		attempts, err := h.storage.GetLoginAttempts(ctx, subject.scope, subject.key)
+		if err != nil {
+			logger.Errorw("failed to check login attempts",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"scope", subject.scope,
+				"error", err,
+			)
+			_ = c.Error(errors.InternalServerError("failed to authenticate", err))
+			return true
+		}
+		if attempts == nil || attempts.BlockedUntil == nil {
+			continue
+		}
+
+		retryAfter := retryAfterSeconds(attempts.BlockedUntil.Sub(now))
+		kind := "backoff"
+		if attempts.Locked {
+			kind = "lockout"
+		}
+		h.recordLoginEvent(c, username, models.AuditBlocked,
+			fmt.Sprintf("%s %s active for %s, retry after %ds", subject.scope, kind, subject.key, retryAfter))
+
+		c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+		_ = c.Error(errors.TooManyRequests("too many failed login attempts, try again later", nil).
+			WithErrorCode(errors.CodeLoginThrottled).
+			WithContext("retry_after", retryAfter))
+		return true
+	}
+
+	return false
+}
+
+// recordLoginFailure counts a failed login against its username and client IP
+// and blocks them as the policy requires. Returns how long the caller must wait
+// before the next attempt, 0 if it may retry at once.
+// Storage errors are logged; the login has failed either way.
+func (h *Handler) recordLoginFailure(c *gin.Context, ctx context.Context, username string) time.Duration {
+	h.recordLoginEvent(c, username, models.AuditFailed, "invalid credentials")
+	if h.loginProtection == nil {
+		return 0
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, subject := range h.loginSubjects(c, username) {
+		attempts, err := h.storage.RecordLoginFailure(ctx, subject.scope, subject.key, now, h.loginProtection.FailureWindow)
+		if err != nil {
+			logger.Errorw("failed to record login failure",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"scope", subject.scope,
+				"error", err,
+			)
+			continue
+		}
+
+		block, locked := h.loginBlock(attempts.Failures, subject.maxFailures)
+		if block <= 0 {
+			continue
+		}
+		if err := h.storage.BlockLogin(ctx, subject.scope, subject.key, now.Add(block), locked); err != nil {
+			logger.Errorw("failed to block login",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"scope", subject.scope,
+				"error", err,
+			)
+			continue
+		}
+
+		if block > wait {
+			wait = block
+		}
+		// Only the failure that reaches the limit reports the lockout
+		if locked && attempts.Failures == int64(subject.maxFailures) {
+			h.recordLoginEvent(c, username, models.AuditLocked,
+				fmt.Sprintf("%s %s locked out for %s after %d failures", subject.scope, subject.key, block, attempts.Failures))
+		}
+	}
+
+	return wait
+}
+
+// clearUserLoginFailures forgets a username's failures after a successful login.
+// The client IP's failures are kept, so logging into one account does not
+// reset the count of guesses against others.
+func (h *Handler) clearUserLoginFailures(c *gin.Context, ctx context.Context, username string) {
+	if h.loginProtection == nil {
+		return
+	}
+
+	if _, err := h.storage.ClearLoginAttempts(ctx, models.LoginScopeUser, username); err != nil {
+		logger.Errorw("failed to clear login failures",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"username", username,
+			"error", err,
+		)
+	}
+}
+
+// recordLoginEvent logs a login security event and writes it to the audit log.
+// The request is unauthenticated, so the attempted username is recorded instead of a user.
+func (h *Handler) recordLoginEvent(c *gin.Context, username, outcome, detail string) {
+	logger.Warnw("login security event",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"event", "login_"+outcome,
+		"username", username,
+		"client_ip", c.ClientIP(),
+		"detail", detail,
+	)
+
+	entry := &models.AuditEntry{
+		Timestamp: time.Now(),
+		RequestID: c.GetString(middleware.RequestIDKey),
+		Username:  username,
+		Action:    loginAuditAction,
+		Outcome:   outcome,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		ClientIP:  c.ClientIP(),
+		Detail:    detail,
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := h.storage.RecordAuditEntry(ctx, entry); err != nil {
+		logger.Errorw("failed to record audit entry",
+			"request_id", entry.RequestID,
+			"action", loginAuditAction,
+			"error", err,
+		)
+	}
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds for the Retry-After header.
+func retryAfterSeconds(wait time.Duration) int64 {
+	if wait <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(wait.Seconds()))
+}
+
+// ListLoginLockouts returns the usernames and client IPs with recent failed logins.
+// @Summary List login lockouts
+// @Description List usernames and client IPs with recent failed logins, including active backoff delays and lockouts
+// @Tags auth
+// @Produce json
+// @Param blocked query bool false "Only return usernames and IPs that are currently blocked"
+// @Success 200 {object} map[string]interface{} "Failed login records"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/lockouts [get]
+func (h *Handler) ListLoginLockouts(c *gin.Context) {
+	blockedOnly, _ := strconv.ParseBool(c.Query("blocked"))
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	attempts, err := h.storage.ListLoginAttempts(ctx)
+	if err != nil {
+		logger.Errorw("failed to list login attempts",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list lockouts", err))
+		return
+	}
+
+	if blockedOnly {
+		blocked := attempts[:0]
+		for _, a := range attempts {
+			if a.BlockedUntil != nil {
+				blocked = append(blocked, a)
+			}
+		}
+		attempts = blocked
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lockouts": attempts})
+}
+
+// UnlockLogin clears the failed logins and any lockout of a username or client IP.
+// @Summary Unlock login
+// @Description Clear the failed logins, backoff delay and lockout of a username or client IP
+// @Tags auth
+// @Param scope path string true "user or ip"
+// @Param key path string true "Username or client IP"
+// @Success 204
+// @Failure 400 {object} errors.Problem "Invalid scope"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "No failed logins recorded"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/lockouts/{scope}/{key} [delete]
+func (h *Handler) UnlockLogin(c *gin.Context) {
+	scope, key := c.Param("scope"), c.Param("key")
+	if scope != models.LoginScopeUser && scope != models.LoginScopeIP {
+		_ = c.Error(errors.BadRequest("scope must be user or ip", nil))
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	if !h.unlockLogin(c, ctx, scope, key) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UnlockUser clears the failed logins and any lockout of a user's username.
+// @Summary Unlock user
+// @Description Clear the failed logins, backoff delay and lockout of a console user
+// @Tags users
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "User not found or not locked"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users/{id}/unlock [post]
+func (h *Handler) UnlockUser(c *gin.Context) {
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	user, ok := h.loadUser(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if !h.unlockLogin(c, ctx, models.LoginScopeUser, user.Username) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// unlockLogin clears a failed login record and audits the unlock.
+// Reports an error and returns false on failure.
+func (h *Handler) unlockLogin(c *gin.Context, ctx context.Context, scope, key string) bool {
+	cleared, err := h.storage.ClearLoginAttempts(ctx, scope, key)
+	if err != nil {
+		logger.Errorw("failed to clear login attempts",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"scope", scope,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to unlock", err))
+		return false
+	}
+	if !cleared {
+		_ = c.Error(errors.NotFound(fmt.Sprintf("no failed logins recorded for %s %s", scope, key), nil))
+		return false
+	}
+
+	logger.Infow("login unlocked",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"scope", scope,
+		"key", key,
+	)
+	middleware.RecordAudit(c, h.storage, "auth:unlock", models.AuditAllowed, scope+" "+key)
+
+	return true
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"admin-backend/config"
+	"admin-backend/errors"
+	"admin-backend/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLoginBlock(t *testing.T) {
+	tests := []struct {
+		name        string
+		backoffBase time.Duration
+		backoffMax  time.Duration
+		maxFailures int
+		failures    int64
+		wantBlock   time.Duration
+		wantLocked  bool
+	}{
+		{name: "no failures", maxFailures: 10, failures: 0},
+		{name: "last free failure", maxFailures: 10, failures: 5},
+		{name: "first backoff", maxFailures: 10, failures: 6, wantBlock: time.Second},
+		{name: "backoff doubles", maxFailures: 10, failures: 7, wantBlock: 2 * time.Second},
+		{name: "backoff doubles again", maxFailures: 10, failures: 8, wantBlock: 4 * time.Second},
+		{name: "backoff reaches max", maxFailures: 10, failures: 9, wantBlock: 8 * time.Second},
+		{name: "lockout at max failures", maxFailures: 10, failures: 10, wantBlock: 15 * time.Minute, wantLocked: true},
+		{name: "lockout beyond max failures", maxFailures: 10, failures: 12, wantBlock: 15 * time.Minute, wantLocked: true},
+		{name: "backoff capped", backoffMax: 3 * time.Second, maxFailures: 20, failures: 13, wantBlock: 3 * time.Second},
+		{name: "backoff below cap", backoffMax: 3 * time.Second, maxFailures: 20, failures: 12, wantBlock: 2 * time.Second},
+		{name: "one failure is always free", maxFailures: 3, failures: 1},
+		{name: "backoff after the free failure", maxFailures: 3, failures: 2, wantBlock: time.Second},
+		{name: "single failure allowed", maxFailures: 1, failures: 1, wantBlock: 15 * time.Minute, wantLocked: true},
+		{name: "backoff disabled", backoffBase: -1, maxFailures: 10, failures: 9},
+		{name: "lockout without backoff", backoffBase: -1, maxFailures: 10, failures: 10, wantBlock: 15 * time.Minute, wantLocked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.LoginProtectionConfig{
+				LockoutDuration: 15 * time.Minute,
+				BackoffBase:     time.Second,
+				BackoffMax:      8 * time.Second,
+			}
+			if tt.backoffBase != 0 {
+				cfg.BackoffBase = tt.backoffBase
+			}
+			if tt.backoffMax != 0 {
+				cfg.BackoffMax = tt.backoffMax
+			}
+			h := &Handler{loginProtection: cfg}
+
+			block, locked := h.loginBlock(tt.failures, tt.maxFailures)
+			if block != tt.wantBlock || locked != tt.wantLocked {
+				t.Errorf("loginBlock(%d, %d) = (%s, %t), want (%s, %t)",
+					tt.failures, tt.maxFailures, block, locked, tt.wantBlock, tt.wantLocked)
+			}
+		})
+	}
+}
+
+func TestLoginThrottling(t *testing.T) {
+	// Valid as a password, so the login is checked against the user
+	const wrongPassword = "wrong-horse-1"
+
+	type attempt struct {
+		password       string
+		wantStatus     int
+		wantRetryAfter bool
+	}
+
+	tests := []struct {
+		name        string
+		maxFailures int
+		backoffBase time.Duration
+		attempts    []attempt
+	}{
+		{
+			name:        "backoff after the free failures",
+			maxFailures: 4,
+			backoffBase: time.Minute,
+			attempts: []attempt{
+				{password: wrongPassword, wantStatus: http.StatusUnauthorized},
+				{password: wrongPassword, wantStatus: http.StatusUnauthorized},
+				{password: wrongPassword, wantStatus: http.StatusUnauthorized, wantRetryAfter: true},
+				{password: testPassword, wantStatus: http.StatusTooManyRequests, wantRetryAfter: true},
+			},
+		},
+		{
+			name:        "lockout at max failures",
+			maxFailures: 2,
+			attempts: []attempt{
+				{password: wrongPassword, wantStatus: http.StatusUnauthorized},
+				{password: wrongPassword, wantStatus: http.StatusUnauthorized, wantRetryAfter: true},
+				{password: testPassword, wantStatus: http.StatusTooManyRequests, wantRetryAfter: true},
+			},
+		},
+		{
+			name:        "success before the limit",
+			maxFailures: 4,
+			backoffBase: time.Minute,
+			attempts: []attempt{
+				{password: wrongPassword, wantStatus: http.StatusUnauthorized},
+				{password: testPassword, wantStatus: http.StatusOK},
+				{password: wrongPassword, wantStatus: http.StatusUnauthorized},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.handler.EnableLoginProtection(&config.LoginProtectionConfig{
+				Enabled:         true,
+				MaxUserFailures: tt.maxFailures,
+				MaxIPFailures:   100,
+				FailureWindow:   time.Hour,
+				LockoutDuration: 15 * time.Minute,
+				BackoffBase:     tt.backoffBase,
+				BackoffMax:      time.Hour,
+			})
+			s.createUser("alice", models.RoleViewer)
+
+			for i, a := range tt.attempts {
+				w := s.do(http.MethodPost, "/api/v1/auth/login", models.LoginRequest{Username: "alice", Password: a.password}, nil)
+				if w.Code != a.wantStatus {
+					t.Fatalf("attempt %d: status = %d, want %d: %s", i+1, w.Code, a.wantStatus, w.Body.String())
+				}
+				if got := w.Header().Get("Retry-After") != ""; got != a.wantRetryAfter {
+					t.Errorf("attempt %d: Retry-After set = %t, want %t", i+1, got, a.wantRetryAfter)
+				}
+				if w.Code == http.StatusTooManyRequests {
+					if code := problemCode(t, w); code != errors.CodeLoginThrottled {
+						t.Errorf("attempt %d: code = %q, want %q", i+1, code, errors.CodeLoginThrottled)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Package handlers provides HTTP handlers for app-to-cluster membership.
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"admin-backend/validation"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListClusterApps returns the applications assigned to a cluster.
+// @Summary List cluster applications
+// @Description List the applications assigned to a cluster with the guaranteed quota each draws on it. Applications assigned to no cluster are not listed, although they count against every cluster's capacity.
+// @Tags clusters
+// @Produce json
+// @Param id path string true "Cluster ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/clusters/{id}/apps [get]
+func (h *Handler) ListClusterApps(c *gin.Context) {
+	clusterID := c.Param("id")
+
+	// Validate cluster ID
+	if err := validation.ValidateClusterID(clusterID); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 10*time.Second)
+	defer h.cancelRequestContext(c)
+
+	cluster, err := h.storage.GetClusterConfig(ctx, clusterID)
+	if err != nil {
+		logger.Errorw("failed to get cluster",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"cluster_id", clusterID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list cluster applications", err))
+		return
+	}
+	if cluster == nil {
+		_ = c.Error(errors.NotFound("cluster not found", nil))
+		return
+	}
+
+	apps, err := h.storage.ListClusterApps(ctx, clusterID)
+	if err != nil {
+		logger.Errorw("failed to list cluster apps",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"cluster_id", clusterID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list cluster applications", err))
+		return
+	}
+
+	members := make([]models.ClusterApp, 0, len(apps))
+	var guaranteed int64
+	for _, app := range apps {
+		quota, _ := validation.ClusterQuota(app, clusterID)
+		members = append(members, models.ClusterApp{AppID: app.AppID, GuaranteedQuota: quota, App: app})
+		guaranteed += quota
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cluster_id": clusterID,
+		"apps":       members,
+		"guaranteed": guaranteed,
+	})
+}
+
+// detachApps removes the cluster from the assignments of apps, recording each
+// write in the history. The reason defaults to the cluster's deletion. Apps
+// left without clusters count against every cluster, like unassigned apps, so
+// the oversubscription policy is applied to the remaining clusters first and
+// nothing is written if it refuses. It returns the policy's warnings.
+func (h *Handler) detachApps(ctx context.Context, clusterID string, apps []*models.AppConfig) ([]errors.FieldError, error) {
+	if info := storage.ChangeInfoFrom(ctx); info.Reason == "" {
+		info.Reason = fmt.Sprintf("cluster %s deleted", clusterID)
+		ctx = storage.WithChangeInfo(ctx, info)
+	}
+
+	for _, app := range apps {
+		assignments := make([]models.ClusterAssignment, 0, len(app.Clusters))
+		for _, assignment := range app.Clusters {
+			if assignment.ClusterID != clusterID {
+				assignments = append(assignments, assignment)
+			}
+		}
+		app.Clusters = assignments
+	}
+
+	warnings, err := h.checkDetachCapacity(ctx, clusterID, apps)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, app := range apps {
+		// Fails if the app changed since it was listed rather than overwrite the change
+		if err := h.storage.CompareAndSetAppConfig(ctx, app, app.Version); err != nil {
+			return nil, err
+		}
+	}
+	return warnings, nil
+}
+
+// checkDetachCapacity applies the oversubscription policy to the clusters
+// other than clusterID as if detached replaced the stored apps, reporting
+// violations against the cascade.
+func (h *Handler) checkDetachCapacity(ctx context.Context, clusterID string, detached []*models.AppConfig) ([]errors.FieldError, error) {
+	clusters, err := h.storage.ListClusterConfigs(ctx)
+	if err != nil {
+		return nil, errors.InternalServerError("failed to check cluster capacity", err)
+	}
+	remaining := make([]*models.ClusterConfig, 0, len(clusters))
+	for _, cluster := range clusters {
+		if cluster.ClusterID != clusterID {
+			remaining = append(remaining, cluster)
+		}
+	}
+	if len(remaining) == 0 {
+		return nil, nil
+	}
+
+	apps, err := h.storage.ListAppConfigs(ctx)
+	if err != nil {
+		return nil, errors.InternalServerError("failed to check cluster capacity", err)
+	}
+
+	reports := h.capacityPolicy.AppCapacity(remaining, apps, detached...)
+	violations, warnings := h.capacityPolicy.Check("cascade", reports)
+	if len(violations) > 0 {
+		return nil, validation.CapacityExceeded(violations, reports)
+	}
+	return warnings, nil
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// newMembershipServer returns a testServer holding the clusters c1 and c2,
+// 900 allocatable each, and the apps shared on both, solo on c1 only and
+// other on c2 only.
+func newMembershipServer(t *testing.T) *testServer {
+	t.Helper()
+
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"c1", "c2"} {
+		cluster := &models.ClusterConfig{ClusterID: id, MaxCapacity: 1000, ReservedRatio: 0.1, EmergencyThreshold: 0.9}
+		if err := s.store.SetClusterConfig(ctx, cluster); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, app := range []*models.AppConfig{
+		{AppID: "shared", GuaranteedQuota: 100, Clusters: []models.ClusterAssignment{{ClusterID: "c1", GuaranteedQuota: 40}, {ClusterID: "c2"}}},
+		{AppID: "solo", GuaranteedQuota: 200, Clusters: []models.ClusterAssignment{{ClusterID: "c1"}}},
+		{AppID: "other", GuaranteedQuota: 50, Clusters: []models.ClusterAssignment{{ClusterID: "c2"}}},
+	} {
+		if err := s.store.SetAppConfig(ctx, app); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// appVersions returns the stored version of every app by app ID.
+func (s *testServer) appVersions() map[string]int64 {
+	s.t.Helper()
+
+	apps, err := s.store.ListAppConfigs(context.Background())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	versions := make(map[string]int64, len(apps))
+	for _, app := range apps {
+		versions[app.AppID] = app.Version
+	}
+	return versions
+}
+
+func TestDeleteClusterCascade(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		wantCode  int
+		wantError string
+		// wantDeleted is set when the cluster and its assignments are gone
+		wantDeleted bool
+	}{
+		{name: "refused while apps are attached", path: "/api/v1/clusters/c1", wantCode: http.StatusConflict, wantError: errors.CodeConflict},
+		{name: "refused with cascade false", path: "/api/v1/clusters/c1?cascade=false", wantCode: http.StatusConflict, wantError: errors.CodeConflict},
+		{name: "invalid cascade", path: "/api/v1/clusters/c1?cascade=maybe", wantCode: http.StatusBadRequest, wantError: errors.CodeBadRequest},
+		{name: "cascade detaches apps", path: "/api/v1/clusters/c1?cascade=true", wantCode: http.StatusNoContent, wantDeleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMembershipServer(t)
+			root := s.createUser("root", models.RoleAdmin)
+			ctx := context.Background()
+			before := s.appVersions()
+
+			w := s.do(http.MethodDelete, tt.path, nil, s.bearer(root))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantError != "" {
+				if code := problemCode(t, w); code != tt.wantError {
+					t.Errorf("code = %s, want %s", code, tt.wantError)
+				}
+			}
+
+			cluster, err := s.store.GetClusterConfig(ctx, "c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (cluster == nil) != tt.wantDeleted {
+				t.Errorf("cluster stored = %v, want deleted %v", cluster != nil, tt.wantDeleted)
+			}
+			members, err := s.store.ListClusterApps(ctx, "c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.wantDeleted {
+				if len(members) != 2 {
+					t.Errorf("cluster apps = %d, want the 2 attached", len(members))
+				}
+				if after := s.appVersions(); !reflect.DeepEqual(after, before) {
+					t.Errorf("app versions = %v, want unchanged %v", after, before)
+				}
+				return
+			}
+
+			if len(members) != 0 {
+				t.Errorf("cluster apps after cascade = %d, want none", len(members))
+			}
+			after := s.appVersions()
+			wantVersions := map[string]int64{"shared": before["shared"] + 1, "solo": before["solo"] + 1, "other": before["other"]}
+			if !reflect.DeepEqual(after, wantVersions) {
+				t.Errorf("app versions = %v, want %v", after, wantVersions)
+			}
+
+			shared, _ := s.store.GetAppConfig(ctx, "shared")
+			if want := []models.ClusterAssignment{{ClusterID: "c2"}}; !reflect.DeepEqual(shared.Clusters, want) {
+				t.Errorf("shared clusters = %+v, want %+v", shared.Clusters, want)
+			}
+			solo, _ := s.store.GetAppConfig(ctx, "solo")
+			if len(solo.Clusters) != 0 {
+				t.Errorf("solo clusters = %+v, want none", solo.Clusters)
+			}
+
+			for _, appID := range []string{"shared", "solo"} {
+				history, err := s.store.ListConfigHistory(ctx, models.ResourceApp, appID, 1)
+				if err != nil || len(history) != 1 {
+					t.Fatalf("history of %s = %v, %v", appID, history, err)
+				}
+				if snap := history[0]; snap.Version != after[appID] || snap.Reason != "cluster c1 deleted" || snap.Author != root.ID {
+					t.Errorf("latest snapshot of %s = version %d by %q %q, want version %d by %s for the deletion",
+						appID, snap.Version, snap.Author, snap.Reason, after[appID], root.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteClusterConflictListsApps(t *testing.T) {
+	s := newMembershipServer(t)
+	admin := s.bearer(s.createUser("root", models.RoleAdmin))
+
+	w := s.do(http.MethodDelete, "/api/v1/clusters/c1", nil, admin)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body.String())
+	}
+
+	var problem struct {
+		Apps []string `json:"apps"`
+	}
+	decodeResponse(t, w, &problem)
+	if want := []string{"shared", "solo"}; !reflect.DeepEqual(problem.Apps, want) {
+		t.Errorf("apps = %v, want %v", problem.Apps, want)
+	}
+}
+
+func TestDeleteClusterCascadeOverCapacity(t *testing.T) {
+	// a1 drawing 500 on c1 only would be left unassigned, drawing on c2 too,
+	// where 300 of 400 are committed
+	s := newCapacityServer(t, nil)
+	admin := s.bearer(s.createUser("root", models.RoleAdmin))
+	ctx := context.Background()
+	before := s.appVersions()
+
+	w := s.do(http.MethodDelete, "/api/v1/clusters/c1?cascade=true", nil, admin)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body.String())
+	}
+
+	var problem capacityProblem
+	decodeResponse(t, w, &problem)
+	if problem.Code != errors.CodeCapacityExceeded {
+		t.Errorf("code = %s, want %s", problem.Code, errors.CodeCapacityExceeded)
+	}
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "cascade" {
+		t.Errorf("errors = %+v, want one against cascade", problem.Errors)
+	}
+
+	// Nothing was written
+	if cluster, err := s.store.GetClusterConfig(ctx, "c1"); err != nil || cluster == nil {
+		t.Errorf("cluster c1 = %+v, %v, want kept", cluster, err)
+	}
+	if after := s.appVersions(); !reflect.DeepEqual(after, before) {
+		t.Errorf("app versions = %v, want unchanged %v", after, before)
+	}
+	a1, _ := s.store.GetAppConfig(ctx, "a1")
+	if len(a1.Clusters) != 1 || a1.Clusters[0].ClusterID != "c1" {
+		t.Errorf("a1 clusters = %+v, want still c1", a1.Clusters)
+	}
+}
@@ -0,0 +1,307 @@
+// Package handlers provides HTTP handlers for OIDC single sign-on.
+package handlers
+
+import (
+	"admin-backend/config"
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/oidc"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// oidcLoginTimeout is how long a user has to complete a login at the identity provider
+	oidcLoginTimeout = 10 * time.Minute
+	// oidcStateCookie binds a login attempt to the browser that started it
+	oidcStateCookie = "oidc_state"
+	// oidcCookiePath limits the state cookie to the SSO endpoints
+	oidcCookiePath = "/api/v1/auth/oidc"
+)
+
+// EnableOIDC turns on single sign-on through provider.
+func (h *Handler) EnableOIDC(provider oidc.Provider, cfg *config.OIDCConfig) error {
+	roles, err := cfg.RoleMap()
+	if err != nil {
+		return errors.BadRequest("invalid OIDC role mapping", err)
+	}
+
+	h.oidcProvider = provider
+	h.oidcConfig = cfg
+	h.oidcRoles = roles
+	return nil
+}
+
+// passwordLoginDisabled reports whether SSO is the only way to log in.
+func (h *Handler) passwordLoginDisabled() bool {
+	return h.oidcConfig != nil && h.oidcConfig.DisablePasswordLogin
+}
+
+// OIDCLogin starts a single sign-on login.
+// @Summary Start SSO login
+// @Description Redirect the browser to the identity provider to log in
+// @Tags auth
+// @Success 302 "Redirect to the identity provider"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/auth/oidc/login [get]
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	state, err1 := randomToken()
+	nonce, err2 := randomToken()
+	verifier, err3 := randomToken()
+	if err1 != nil || err2 != nil || err3 != nil {
+		logger.Errorw("failed to generate SSO login state", "request_id", c.GetString(middleware.RequestIDKey))
+		_ = c.Error(errors.InternalServerError("failed to start SSO login", errors.Join(err1, err2, err3)))
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	loginState := &models.OIDCLoginState{
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		CreatedAt:    time.Now(),
+	}
+	if err := h.storage.SaveLoginState(ctx, state, loginState, oidcLoginTimeout); err != nil {
+		logger.Errorw("failed to save SSO login state",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to start SSO login", err))
+		return
+	}
+
+	h.setStateCookie(c, state, int(oidcLoginTimeout.Seconds()))
+	c.Redirect(http.StatusFound, h.oidcProvider.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier)))
+}
+
+// OIDCCallback completes a single sign-on login and issues console tokens.
+// @Summary Complete SSO login
+// @Description Exchange the identity provider's authorization code, map the user's groups to a role and issue tokens. Redirects to the console with the tokens in the URL fragment if a post-login URL is configured.
+// @Tags auth
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "Login state"
+// @Success 200 {object} models.TokenResponse
+// @Success 302 "Redirect to the console with tokens"
+// @Failure 400 {object} errors.Problem "Invalid or expired login state"
+// @Failure 401 {object} errors.Problem "Login rejected by the identity provider"
+// @Failure 403 {object} errors.Problem "No console role mapped"
+// @Failure 409 {object} errors.Problem "Username belongs to another account"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/auth/oidc/callback [get]
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	if idpErr := c.Query("error"); idpErr != "" {
+		logger.Warnw("SSO login rejected by identity provider",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", idpErr,
+			"description", c.Query("error_description"),
+		)
+		_ = c.Error(errors.Unauthorized("SSO login failed: "+idpErr, nil))
+		return
+	}
+
+	// The state must match the cookie set by OIDCLogin, so a callback URL
+	// crafted by someone else cannot log this browser into their account
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	h.setStateCookie(c, "", -1)
+	if state == "" || cookie != state {
+		_ = c.Error(errors.BadRequest("invalid login state", nil))
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		_ = c.Error(errors.BadRequest("authorization code is required", nil))
+		return
+	}
+
+	ctx := h.getRequestContext(c, 15*time.Second)
+	defer h.cancelRequestContext(c)
+
+	loginState, err := h.storage.ConsumeLoginState(ctx, state)
+	if err != nil {
+		logger.Errorw("failed to load SSO login state",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to complete SSO login", err))
+		return
+	}
+	if loginState == nil {
+		_ = c.Error(errors.BadRequest("login state expired or already used", nil))
+		return
+	}
+
+	identity, err := h.oidcProvider.Exchange(ctx, code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		logger.Warnw("SSO code exchange failed",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"client_ip", c.ClientIP(),
+			"error", err,
+		)
+		_ = c.Error(errors.Unauthorized("SSO login failed", nil))
+		return
+	}
+
+	role := oidc.MapRole(identity.Groups, h.oidcRoles, h.oidcConfig.DefaultRole)
+	if role == "" {
+		logger.Warnw("SSO login denied, no role mapped",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"subject", identity.Subject,
+			"username", identity.Username,
+			"groups", identity.Groups,
+		)
+		_ = c.Error(errors.Forbidden("no console role is mapped to your groups", nil))
+		return
+	}
+
+	user, ok := h.provisionSSOUser(c, ctx, identity, role)
+	if !ok {
+		return
+	}
+
+	accessToken, refreshToken, err := middleware.GenerateToken(user.ID, user.Username, user.Role)
+	if err != nil {
+		logger.Errorw("failed to generate tokens",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"user_id", user.ID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to generate tokens", err))
+		return
+	}
+
+	logger.Infow("user logged in with SSO",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", user.ID,
+		"username", user.Username,
+		"role", user.Role,
+		"subject", identity.Subject,
+	)
+
+	expiresIn := int64(middleware.AccessTokenTTL().Seconds())
+
+	// Browsers go back to the console; the fragment never reaches any server
+	if target := h.oidcConfig.PostLoginRedirectURL; target != "" {
+		fragment := url.Values{
+			"access_token":  {accessToken},
+			"refresh_token": {refreshToken},
+			"expires_in":    {strconv.FormatInt(expiresIn, 10)},
+			"token_type":    {"Bearer"},
+		}
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, strings.SplitN(target, "#", 2)[0]+"#"+fragment.Encode())
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    expiresIn,
+	})
+}
+
+// provisionSSOUser returns the console user for an SSO identity, creating it on
+// first login and updating its role to the mapped one. A username that belongs
+// to a local account or another identity is never taken over.
+// Reports an error and returns false on failure.
+func (h *Handler) provisionSSOUser(c *gin.Context, ctx context.Context, identity *oidc.Identity, role string) (*models.User, bool) {
+	username := identity.Username
+	if username == "" {
+		username = identity.Email
+	}
+	if username == "" {
+		_ = c.Error(errors.Forbidden("identity provider did not return a username", nil))
+		return nil, false
+	}
+
+	user, err := h.storage.GetUserByUsername(ctx, username)
+	if err != nil {
+		logger.Errorw("failed to get user",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"username", username,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to complete SSO login", err))
+		return nil, false
+	}
+
+	if user == nil {
+		user = &models.User{
+			ID:               uuid.NewString(),
+			Username:         username,
+			Role:             role,
+			IdentityProvider: models.IdentityProviderOIDC,
+			ExternalID:       identity.Subject,
+		}
+		if err := h.storage.CreateUser(ctx, user); err != nil {
+			reportError(c, err, "failed to complete SSO login", "username", username)
+			return nil, false
+		}
+
+		logger.Infow("SSO user created",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"created_user_id", user.ID,
+			"username", user.Username,
+			"role", user.Role,
+		)
+		return user, true
+	}
+
+	if user.IdentityProvider != models.IdentityProviderOIDC || user.ExternalID != identity.Subject {
+		logger.Warnw("SSO login for a username owned by another account",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"username", username,
+			"subject", identity.Subject,
+		)
+		_ = c.Error(errors.Conflict(fmt.Sprintf("username %q belongs to another account", username), nil))
+		return nil, false
+	}
+
+	// The identity provider owns group membership, so the role follows it on every login
+	if user.Role != role {
+		user.Role = role
+		if err := h.storage.UpdateUser(ctx, user); err != nil {
+			logger.Errorw("failed to update SSO user role",
+				"request_id", c.GetString(middleware.RequestIDKey),
+				"target_user_id", user.ID,
+				"error", err,
+			)
+			_ = c.Error(errors.InternalServerError("failed to complete SSO login", err))
+			return nil, false
+		}
+	}
+
+	return user, true
+}
+
+// setStateCookie sets or, with a negative maxAge, clears the SSO state cookie.
+func (h *Handler) setStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := strings.HasPrefix(h.oidcConfig.RedirectURL, "https://")
+	// Lax lets the cookie ride along on the identity provider's top-level redirect back
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, oidcCookiePath, "", secure, true)
+}
+
+// randomToken returns 32 random bytes encoded for use in URLs.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
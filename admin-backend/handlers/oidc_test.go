@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"admin-backend/config"
+	"admin-backend/errors"
+	"admin-backend/models"
+	"admin-backend/oidc"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// fakeProvider is an identity provider that logs in whoever the test issued
+// a code for. Like a real provider it binds each code to the nonce and PKCE
+// challenge of the login it was issued in.
+type fakeProvider struct {
+	// challenges maps the nonce of each login to its code challenge
+	challenges map[string]string
+	codes      map[string]fakeCode
+}
+
+// fakeCode is an authorization code and the ID token it redeems for.
+type fakeCode struct {
+	nonce    string
+	identity oidc.Identity
+}
+
+func (p *fakeProvider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	p.challenges[nonce] = codeChallenge
+	return "https://idp.example.com/authorize?" + url.Values{"state": {state}, "nonce": {nonce}}.Encode()
+}
+
+func (p *fakeProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*oidc.Identity, error) {
+	issued, ok := p.codes[code]
+	if !ok {
+		return nil, errors.Unauthorized("unknown code", nil)
+	}
+	delete(p.codes, code)
+
+	if oidc.CodeChallenge(codeVerifier) != p.challenges[issued.nonce] {
+		return nil, errors.Unauthorized("code verifier does not match", nil)
+	}
+	if issued.nonce != nonce {
+		return nil, errors.Unauthorized("ID token nonce does not match", nil)
+	}
+	identity := issued.identity
+	return &identity, nil
+}
+
+// oidcLogin is a login started with GET /oidc/login.
+type oidcLogin struct {
+	state string
+	nonce string
+}
+
+// newOIDCServer returns a testServer with SSO enabled through a fakeProvider.
+func newOIDCServer(t *testing.T, cfg *config.OIDCConfig) (*testServer, *fakeProvider) {
+	t.Helper()
+
+	s := newTestServer(t)
+	provider := &fakeProvider{challenges: make(map[string]string), codes: make(map[string]fakeCode)}
+	if cfg.RedirectURL == "" {
+		cfg.RedirectURL = "http://console.example.com/api/v1/auth/oidc/callback"
+	}
+	if err := s.handler.EnableOIDC(provider, cfg); err != nil {
+		t.Fatal(err)
+	}
+	s.router.GET("/api/v1/auth/oidc/login", s.handler.OIDCLogin)
+	s.router.GET("/api/v1/auth/oidc/callback", s.handler.OIDCCallback)
+	return s, provider
+}
+
+// startOIDCLogin starts a login and returns the state and nonce it was given.
+func (s *testServer) startOIDCLogin() oidcLogin {
+	s.t.Helper()
+
+	w := s.do(http.MethodGet, "/api/v1/auth/oidc/login", nil, nil)
+	if w.Code != http.StatusFound {
+		s.t.Fatalf("login: status %d: %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	login := oidcLogin{state: location.Query().Get("state"), nonce: location.Query().Get("nonce")}
+	var cookie string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c.Value
+		}
+	}
+	if login.state == "" || cookie != login.state {
+		s.t.Fatalf("login state %q, cookie %q", login.state, cookie)
+	}
+	return login
+}
+
+// oidcCallback calls back with state and code, sending cookie as the state cookie.
+func (s *testServer) oidcCallback(state, cookie, code string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	header := http.Header{}
+	if cookie != "" {
+		header.Set("Cookie", (&http.Cookie{Name: oidcStateCookie, Value: cookie}).String())
+	}
+	query := url.Values{"state": {state}, "code": {code}}
+	return s.do(http.MethodGet, "/api/v1/auth/oidc/callback?"+query.Encode(), nil, header)
+}
+
+// ssoIdentity returns an identity with the given username and groups.
+func ssoIdentity(username string, groups ...string) oidc.Identity {
+	return oidc.Identity{Subject: "sub-" + username, Username: username, Groups: groups}
+}
+
+func TestOIDCLoginStateSingleUse(t *testing.T) {
+	s, provider := newOIDCServer(t, &config.OIDCConfig{RoleMapping: "sre=operator"})
+
+	login := s.startOIDCLogin()
+	provider.codes["code1"] = fakeCode{nonce: login.nonce, identity: ssoIdentity("alice", "sre")}
+
+	w := s.oidcCallback(login.state, login.state, "code1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", w.Code, w.Body.String())
+	}
+	var tokens models.TokenResponse
+	decodeResponse(t, w, &tokens)
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("tokens = %+v", tokens)
+	}
+
+	// Replaying the callback, even with a fresh code for the same login, fails
+	provider.codes["code2"] = fakeCode{nonce: login.nonce, identity: ssoIdentity("alice", "sre")}
+	w = s.oidcCallback(login.state, login.state, "code2")
+	if w.Code != http.StatusBadRequest || problemCode(t, w) != errors.CodeBadRequest {
+		t.Errorf("replayed callback: status %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := provider.codes["code2"]; !ok {
+		t.Error("replayed callback redeemed its code")
+	}
+}
+
+func TestOIDCCallbackRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		// callback returns the state, cookie and code of the callback for
+		// login a, with b another login of the same provider
+		callback   func(p *fakeProvider, a, b oidcLogin) (state, cookie, code string)
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name: "no state cookie",
+			callback: func(p *fakeProvider, a, b oidcLogin) (string, string, string) {
+				p.codes["c"] = fakeCode{nonce: a.nonce, identity: ssoIdentity("alice", "sre")}
+				return a.state, "", "c"
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errors.CodeBadRequest,
+		},
+		{
+			name: "state of another browser",
+			callback: func(p *fakeProvider, a, b oidcLogin) (string, string, string) {
+				p.codes["c"] = fakeCode{nonce: a.nonce, identity: ssoIdentity("alice", "sre")}
+				return a.state, b.state, "c"
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errors.CodeBadRequest,
+		},
+		{
+			name: "state never issued",
+			callback: func(p *fakeProvider, a, b oidcLogin) (string, string, string) {
+				p.codes["c"] = fakeCode{nonce: a.nonce, identity: ssoIdentity("alice", "sre")}
+				return "forged", "forged", "c"
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errors.CodeBadRequest,
+		},
+		{
+			name: "no code",
+			callback: func(p *fakeProvider, a, b oidcLogin) (string, string, string) {
+				return a.state, a.state, ""
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errors.CodeBadRequest,
+		},
+		{
+			name: "code issued for the nonce of another login",
+			callback: func(p *fakeProvider, a, b oidcLogin) (string, string, string) {
+				p.codes["c"] = fakeCode{nonce: b.nonce, identity: ssoIdentity("alice", "sre")}
+				return a.state, a.state, "c"
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errors.CodeUnauthorized,
+		},
+		{
+			name: "no role mapped",
+			callback: func(p *fakeProvider, a, b oidcLogin) (string, string, string) {
+				p.codes["c"] = fakeCode{nonce: a.nonce, identity: ssoIdentity("alice", "finance")}
+				return a.state, a.state, "c"
+			},
+			wantStatus: http.StatusForbidden,
+			wantCode:   errors.CodeForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, provider := newOIDCServer(t, &config.OIDCConfig{RoleMapping: "sre=operator"})
+			a, b := s.startOIDCLogin(), s.startOIDCLogin()
+
+			state, cookie, code := tt.callback(provider, a, b)
+			w := s.oidcCallback(state, cookie, code)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if got := problemCode(t, w); got != tt.wantCode {
+				t.Errorf("code = %s, want %s", got, tt.wantCode)
+			}
+			if user, _ := s.store.GetUserByUsername(context.Background(), "alice"); user != nil {
+				t.Errorf("rejected login created user %+v", user)
+			}
+		})
+	}
+}
+
+func TestOIDCRoleMapping(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultRole string
+		// existing is the role of alice's account from an earlier SSO login, if any
+		existing string
+		groups   []string
+		wantRole string
+	}{
+		{name: "mapped group", groups: []string{"sre"}, wantRole: models.RoleOperator},
+		{name: "most privileged group wins", groups: []string{"eng", "admins", "sre"}, wantRole: models.RoleAdmin},
+		{name: "default role", defaultRole: models.RoleViewer, groups: []string{"finance"}, wantRole: models.RoleViewer},
+		{name: "role follows the groups on the next login", existing: models.RoleAdmin, groups: []string{"eng"}, wantRole: models.RoleViewer},
+		{name: "denied without a mapped group or default", groups: []string{"finance"}},
+		{name: "existing user denied once unmapped", existing: models.RoleOperator, groups: []string{"finance"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, provider := newOIDCServer(t, &config.OIDCConfig{
+				RoleMapping: "admins=admin, sre=operator, eng=viewer",
+				DefaultRole: tt.defaultRole,
+			})
+			ctx := context.Background()
+
+			if tt.existing != "" {
+				if err := s.store.CreateUser(ctx, &models.User{
+					ID:               "alice-id",
+					Username:         "alice",
+					Role:             tt.existing,
+					IdentityProvider: models.IdentityProviderOIDC,
+					ExternalID:       "sub-alice",
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			login := s.startOIDCLogin()
+			provider.codes["c"] = fakeCode{nonce: login.nonce, identity: ssoIdentity("alice", tt.groups...)}
+			w := s.oidcCallback(login.state, login.state, "c")
+
+			user, err := s.store.GetUserByUsername(ctx, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantRole == "" {
+				if w.Code != http.StatusForbidden {
+					t.Fatalf("status = %d, want 403: %s", w.Code, w.Body.String())
+				}
+				if tt.existing == "" && user != nil {
+					t.Errorf("denied login created user %+v", user)
+				}
+				if tt.existing != "" && user.Role != tt.existing {
+					t.Errorf("denied login changed role to %s", user.Role)
+				}
+				return
+			}
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+			if user == nil || user.Role != tt.wantRole {
+				t.Fatalf("user = %+v, want role %s", user, tt.wantRole)
+			}
+			if user.IdentityProvider != models.IdentityProviderOIDC || user.ExternalID != "sub-alice" {
+				t.Errorf("user identity = %s/%s", user.IdentityProvider, user.ExternalID)
+			}
+
+			var tokens models.TokenResponse
+			decodeResponse(t, w, &tokens)
+			me := s.do(http.MethodGet, "/api/v1/users/me", nil, http.Header{"Authorization": {"Bearer " + tokens.AccessToken}})
+			var profile models.User
+			decodeResponse(t, me, &profile)
+			if profile.Role != tt.wantRole {
+				t.Errorf("token role = %s, want %s", profile.Role, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestOIDCUsernameTakenByLocalAccount(t *testing.T) {
+	s, provider := newOIDCServer(t, &config.OIDCConfig{RoleMapping: "admins=admin"})
+	local := s.createUser("alice", models.RoleViewer)
+
+	login := s.startOIDCLogin()
+	provider.codes["c"] = fakeCode{nonce: login.nonce, identity: ssoIdentity("alice", "admins")}
+	w := s.oidcCallback(login.state, login.state, "c")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body.String())
+	}
+
+	user, err := s.store.GetUserByUsername(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != local.ID || user.Role != models.RoleViewer {
+		t.Errorf("local account taken over: %+v", user)
+	}
+}
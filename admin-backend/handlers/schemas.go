@@ -0,0 +1,45 @@
+// Package handlers provides HTTP handlers serving the validation schemas of models.
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/validation"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSchemas returns the names of the published model schemas.
+// @Summary List model schemas
+// @Description List the models whose JSON Schema is published
+// @Tags schemas
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Router /api/v1/schemas [get]
+func (h *Handler) ListSchemas(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"schemas": validation.SchemaNames()})
+}
+
+// GetSchema returns the JSON Schema of a model, so clients can validate with
+// the same rules as the API.
+// @Summary Get model schema
+// @Description Get the JSON Schema of a model. Rules comparing two fields are listed under x-checks.
+// @Tags schemas
+// @Produce json
+// @Param model path string true "Model name (app or cluster)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Unknown model"
+// @Router /api/v1/schemas/{model} [get]
+func (h *Handler) GetSchema(c *gin.Context) {
+	schema := validation.LookupSchema(c.Param("model"))
+	if schema == nil {
+		_ = c.Error(errors.NotFound(fmt.Sprintf("no schema for model %q", c.Param("model")), nil))
+		return
+	}
+
+	c.Header("Content-Type", "application/schema+json")
+	c.JSON(http.StatusOK, schema.JSONSchema())
+}
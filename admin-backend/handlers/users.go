@@ -0,0 +1,493 @@
+// Package handlers provides HTTP handlers for console user management.
+package handlers
+
+import (
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/validation"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListUsers returns all console users.
+// @Summary List users
+// @Description Get all console users
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users [get]
+func (h *Handler) ListUsers(c *gin.Context) {
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	users, err := h.storage.ListUsers(ctx)
+	if err != nil {
+		logger.Errorw("failed to list users",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list users", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// CreateUser creates a console user.
+// @Summary Create user
+// @Description Create a console user with a password and role
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.CreateUserRequest true "User to create"
+// @Success 201 {object} models.User
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 409 {object} errors.Problem "Username already exists"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users [post]
+func (h *Handler) CreateUser(c *gin.Context) {
+	var req models.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.BadRequest("invalid request format", err))
+		return
+	}
+
+	if err := validation.ValidateUsername(req.Username); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := validation.ValidatePassword(req.Password); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := validation.ValidateRole(req.Role); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	hash, err := middleware.HashPassword(req.Password)
+	if err != nil {
+		logger.Errorw("failed to hash password", "request_id", c.GetString(middleware.RequestIDKey), "error", err)
+		_ = c.Error(errors.InternalServerError("failed to create user", err))
+		return
+	}
+
+	user := &models.User{
+		ID:           uuid.NewString(),
+		Username:     req.Username,
+		Role:         req.Role,
+		PasswordHash: hash,
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	if err := h.storage.CreateUser(ctx, user); err != nil {
+		reportError(c, err, "failed to create user", "username", req.Username)
+		return
+	}
+
+	logger.Infow("user created",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"created_user_id", user.ID,
+		"username", user.Username,
+		"role", user.Role,
+	)
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// GetUser retrieves a console user by ID.
+// @Summary Get user
+// @Description Get a console user by ID
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.User
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users/{id} [get]
+func (h *Handler) GetUser(c *gin.Context) {
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	user, ok := h.loadUser(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// GetCurrentUser returns the authenticated user.
+// @Summary Get current user
+// @Description Get the user the request is authenticated as
+// @Tags users
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.User
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users/me [get]
+func (h *Handler) GetCurrentUser(c *gin.Context) {
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	user, ok := h.loadUser(c, ctx, c.GetString(middleware.UserIDKey))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateUser changes the role of a console user.
+// @Summary Update user
+// @Description Change a console user's role
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body models.UpdateUserRequest true "New role"
+// @Success 200 {object} models.User
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 409 {object} errors.Problem "Would remove the last admin"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users/{id} [put]
+func (h *Handler) UpdateUser(c *gin.Context) {
+	var req models.UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.BadRequest("invalid request format", err))
+		return
+	}
+
+	if err := validation.ValidateRole(req.Role); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	user, ok := h.loadUser(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if user.Role == models.RoleAdmin && req.Role != models.RoleAdmin && !h.ensureOtherAdmin(c, ctx, user.ID) {
+		return
+	}
+
+	roleChanged := user.Role != req.Role
+	user.Role = req.Role
+	if err := h.storage.UpdateUser(ctx, user); err != nil {
+		reportError(c, err, "failed to update user", "target_user_id", user.ID)
+		return
+	}
+
+	logger.Infow("user updated",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"target_user_id", user.ID,
+		"role", user.Role,
+	)
+
+	// Tokens carry the role, so sessions issued under the old one must end
+	if roleChanged {
+		h.revokeSessions(c, ctx, user.ID)
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser deletes a console user.
+// @Summary Delete user
+// @Description Delete a console user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 409 {object} errors.Problem "Would remove the last admin"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users/{id} [delete]
+func (h *Handler) DeleteUser(c *gin.Context) {
+	userID := c.Param("id")
+	if userID == c.GetString(middleware.UserIDKey) {
+		_ = c.Error(errors.BadRequest("cannot delete your own account", nil))
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	user, ok := h.loadUser(c, ctx, userID)
+	if !ok {
+		return
+	}
+
+	if user.Role == models.RoleAdmin && !h.ensureOtherAdmin(c, ctx, user.ID) {
+		return
+	}
+
+	if err := h.storage.DeleteUser(ctx, userID); err != nil {
+		reportError(c, err, "failed to delete user", "target_user_id", userID)
+		return
+	}
+
+	logger.Infow("user deleted",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"target_user_id", userID,
+		"username", user.Username,
+	)
+
+	h.revokeSessions(c, ctx, userID)
+
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeUserSessions invalidates every token issued to a user.
+// @Summary Revoke user sessions
+// @Description Invalidate all access and refresh tokens issued to a console user so far
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users/{id}/revoke-sessions [post]
+func (h *Handler) RevokeUserSessions(c *gin.Context) {
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	user, ok := h.loadUser(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if err := h.storage.RevokeUserTokens(ctx, user.ID, middleware.RefreshTokenTTL()); err != nil {
+		logger.Errorw("failed to revoke user sessions",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"target_user_id", user.ID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to revoke sessions", err))
+		return
+	}
+
+	logger.Infow("user sessions revoked",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"target_user_id", user.ID,
+	)
+
+	c.Status(http.StatusNoContent)
+}
+
+// ChangePassword changes the authenticated user's password.
+// @Summary Change own password
+// @Description Change the password of the authenticated user; the current password is required and all existing sessions are ended
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.ChangePasswordRequest true "Current and new password"
+// @Success 204
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized or wrong current password"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users/me/password [put]
+func (h *Handler) ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.BadRequest("invalid request format", err))
+		return
+	}
+
+	if err := validation.ValidatePassword(req.NewPassword); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		_ = c.Error(errors.BadRequest("new password must differ from the current password", nil))
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	user, ok := h.loadUser(c, ctx, c.GetString(middleware.UserIDKey))
+	if !ok {
+		return
+	}
+
+	if !middleware.CheckPassword(user.PasswordHash, req.CurrentPassword) {
+		logger.Warnw("password change with wrong current password",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"user_id", user.ID,
+		)
+		_ = c.Error(errors.Unauthorized("current password is incorrect", nil))
+		return
+	}
+
+	h.setPassword(c, ctx, user, req.NewPassword)
+}
+
+// ResetPassword sets another user's password.
+// @Summary Reset user password
+// @Description Set a console user's password without knowing the current one and end the user's sessions
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body models.ChangePasswordRequest true "New password (current_password is ignored)"
+// @Success 204
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 403 {object} errors.Problem "Forbidden"
+// @Failure 404 {object} errors.Problem "Not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/users/{id}/password [put]
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(errors.BadRequest("invalid request format", err))
+		return
+	}
+
+	if err := validation.ValidatePassword(req.NewPassword); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	ctx := h.getRequestContext(c, 5*time.Second)
+	defer h.cancelRequestContext(c)
+
+	user, ok := h.loadUser(c, ctx, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	h.setPassword(c, ctx, user, req.NewPassword)
+}
+
+// setPassword hashes and stores a new password for user and writes the response.
+func (h *Handler) setPassword(c *gin.Context, ctx context.Context, user *models.User, password string) {
+	if user.IdentityProvider != "" {
+		_ = c.Error(errors.BadRequest("user signs in with SSO and has no password", nil))
+		return
+	}
+
+	hash, err := middleware.HashPassword(password)
+	if err != nil {
+		logger.Errorw("failed to hash password", "request_id", c.GetString(middleware.RequestIDKey), "error", err)
+		_ = c.Error(errors.InternalServerError("failed to change password", err))
+		return
+	}
+
+	user.PasswordHash = hash
+	if err := h.storage.UpdateUser(ctx, user); err != nil {
+		reportError(c, err, "failed to change password", "target_user_id", user.ID)
+		return
+	}
+
+	logger.Infow("password changed",
+		"request_id", c.GetString(middleware.RequestIDKey),
+		"user_id", c.GetString(middleware.UserIDKey),
+		"target_user_id", user.ID,
+	)
+
+	h.revokeSessions(c, ctx, user.ID)
+
+	c.Status(http.StatusNoContent)
+}
+
+// revokeSessions invalidates the tokens issued to userID after a change that
+// has already been stored. Failure is logged rather than reported, since the
+// change itself succeeded.
+func (h *Handler) revokeSessions(c *gin.Context, ctx context.Context, userID string) {
+	if err := h.storage.RevokeUserTokens(ctx, userID, middleware.RefreshTokenTTL()); err != nil {
+		logger.Errorw("failed to revoke user sessions",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"target_user_id", userID,
+			"error", err,
+		)
+	}
+}
+
+// loadUser fetches a user, reporting an error if it cannot be returned.
+func (h *Handler) loadUser(c *gin.Context, ctx context.Context, userID string) (*models.User, bool) {
+	if userID == "" {
+		_ = c.Error(errors.BadRequest("user ID is required", nil))
+		return nil, false
+	}
+
+	user, err := h.storage.GetUser(ctx, userID)
+	if err != nil {
+		logger.Errorw("failed to get user",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"target_user_id", userID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to get user", err))
+		return nil, false
+	}
+
+	if user == nil {
+		_ = c.Error(errors.NotFound("user not found", nil))
+		return nil, false
+	}
+
+	return user, true
+}
+
+// ensureOtherAdmin checks that an admin other than userID exists, so the
+// console cannot be locked out. Reports an error and returns false otherwise.
+func (h *Handler) ensureOtherAdmin(c *gin.Context, ctx context.Context, userID string) bool {
+	users, err := h.storage.ListUsers(ctx)
+	if err != nil {
+		logger.Errorw("failed to list users",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to list users", err))
+		return false
+	}
+
+	for _, u := range users {
+		if u.ID != userID && u.Role == models.RoleAdmin {
+			return true
+		}
+	}
+
+	_ = c.Error(errors.Conflict("cannot remove the last admin", nil))
+	return false
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"admin-backend/models"
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestLastAdminGuard(t *testing.T) {
+	// Each case starts with admin "root" and, if other is set, a user "other"
+	// with that role. The request is sent by root, or by an admin API key if
+	// asAPIKey is set.
+	tests := []struct {
+		name       string
+		other      string
+		asAPIKey   bool
+		method     string
+		target     string
+		role       string
+		wantStatus int
+		wantAdmins int
+	}{
+		{
+			name:       "demote the only admin",
+			method:     http.MethodPut,
+			target:     "root",
+			role:       models.RoleOperator,
+			wantStatus: http.StatusConflict,
+			wantAdmins: 1,
+		},
+		{
+			name:       "demote an admin while another remains",
+			other:      models.RoleAdmin,
+			method:     http.MethodPut,
+			target:     "other",
+			role:       models.RoleViewer,
+			wantStatus: http.StatusOK,
+			wantAdmins: 1,
+		},
+		{
+			name:       "keep the only admin an admin",
+			method:     http.MethodPut,
+			target:     "root",
+			role:       models.RoleAdmin,
+			wantStatus: http.StatusOK,
+			wantAdmins: 1,
+		},
+		{
+			name:       "delete an admin while another remains",
+			other:      models.RoleAdmin,
+			method:     http.MethodDelete,
+			target:     "other",
+			wantStatus: http.StatusNoContent,
+			wantAdmins: 1,
+		},
+		{
+			name:       "delete yourself",
+			other:      models.RoleAdmin,
+			method:     http.MethodDelete,
+			target:     "root",
+			wantStatus: http.StatusBadRequest,
+			wantAdmins: 2,
+		},
+		{
+			name:       "API key deletes the only admin",
+			other:      models.RoleOperator,
+			asAPIKey:   true,
+			method:     http.MethodDelete,
+			target:     "root",
+			wantStatus: http.StatusConflict,
+			wantAdmins: 1,
+		},
+		{
+			name:       "API key demotes the only admin",
+			asAPIKey:   true,
+			method:     http.MethodPut,
+			target:     "root",
+			role:       models.RoleViewer,
+			wantStatus: http.StatusConflict,
+			wantAdmins: 1,
+		},
+		{
+			name:       "API key deletes a non-admin",
+			other:      models.RoleOperator,
+			asAPIKey:   true,
+			method:     http.MethodDelete,
+			target:     "other",
+			wantStatus: http.StatusNoContent,
+			wantAdmins: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			root := s.createUser("root", models.RoleAdmin)
+			targets := map[string]*models.User{"root": root}
+			if tt.other != "" {
+				targets["other"] = s.createUser("other", tt.other)
+			}
+
+			header := s.bearer(root)
+			if tt.asAPIKey {
+				header = s.apiKey(models.RoleAdmin, nil, nil)
+			}
+
+			var body interface{}
+			if tt.method == http.MethodPut {
+				body = models.UpdateUserRequest{Role: tt.role}
+			}
+			w := s.do(tt.method, "/api/v1/users/"+targets[tt.target].ID, body, header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			users, err := s.store.ListUsers(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			admins := 0
+			for _, u := range users {
+				if u.Role == models.RoleAdmin {
+					admins++
+				}
+			}
+			if admins != tt.wantAdmins {
+				t.Errorf("admins = %d, want %d", admins, tt.wantAdmins)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Package handlers provides HTTP handlers that dry-run config changes and the
+// cluster capacity checks of config writes.
+package handlers
+
+import (
+	"admin-backend/config"
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"admin-backend/validation"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetCapacityPolicy sets the oversubscription policy applied when app and
+// cluster writes push guaranteed quotas past a cluster's capacity.
+func (h *Handler) SetCapacityPolicy(cfg *config.CapacityConfig) {
+	h.capacityPolicy = validation.CapacityPolicy{
+		Mode:   cfg.OversubscriptionPolicy,
+		Factor: cfg.OversubscriptionFactor,
+	}
+}
+
+// checkAppClusters checks that the clusters writing configs are assigned to
+// exist, then applies the oversubscription policy, reporting capacity
+// violations against field. Unknown clusters of a bulk import, whose field is
+// "apps", are reported as apps[i].clusters[j].cluster_id. It returns the
+// warnings to include in the response of the write.
+func (h *Handler) checkAppClusters(ctx context.Context, field string, configs ...*models.AppConfig) ([]errors.FieldError, error) {
+	clusters, err := h.storage.ListClusterConfigs(ctx)
+	if err != nil {
+		return nil, errors.InternalServerError("failed to check cluster capacity", err)
+	}
+
+	var unknown []errors.FieldError
+	for i, config := range configs {
+		violations := validation.ValidateClusterRefs(config, clusters)
+		if field == "apps" {
+			violations = validation.PrefixFields(fmt.Sprintf("apps[%d]", i), violations)
+		}
+		unknown = append(unknown, violations...)
+	}
+	if len(unknown) > 0 {
+		return nil, validation.ValidationFailed(unknown)
+	}
+
+	if len(clusters) == 0 {
+		return nil, nil
+	}
+	apps, err := h.storage.ListAppConfigs(ctx)
+	if err != nil {
+		return nil, errors.InternalServerError("failed to check cluster capacity", err)
+	}
+
+	reports := h.capacityPolicy.AppCapacity(clusters, apps, configs...)
+	violations, warnings := h.capacityPolicy.Check(field, reports)
+	if len(violations) > 0 {
+		return nil, validation.CapacityExceeded(violations, reports)
+	}
+	return warnings, nil
+}
+
+// checkClusterCapacity applies the oversubscription policy to writing config,
+// which only matters when the write lowers the cluster's limit. It returns
+// the warnings to include in the response of the write.
+func (h *Handler) checkClusterCapacity(ctx context.Context, config *models.ClusterConfig) ([]errors.FieldError, error) {
+	current, err := h.storage.GetClusterConfig(ctx, config.ClusterID)
+	if err != nil {
+		return nil, errors.InternalServerError("failed to check cluster capacity", err)
+	}
+	apps, err := h.storage.ListAppConfigs(ctx)
+	if err != nil {
+		return nil, errors.InternalServerError("failed to check cluster capacity", err)
+	}
+
+	reports := []validation.CapacityReport{
+		h.capacityPolicy.ClusterCapacity(config, effectiveReservedRatio(config), current, apps),
+	}
+	violations, warnings := h.capacityPolicy.Check("max_capacity", reports)
+	if len(violations) > 0 {
+		return nil, validation.CapacityExceeded(violations, reports)
+	}
+	return warnings, nil
+}
+
+// effectiveReservedRatio returns the reserved ratio config is stored with,
+// since an unset ratio is stored as the default.
+func effectiveReservedRatio(config *models.ClusterConfig) float64 {
+	if config.ReservedRatio == 0 {
+		return storage.DefaultReservedRatio
+	}
+	return config.ReservedRatio
+}
+
+// hasCluster reports whether clusters contains the cluster clusterID.
+func hasCluster(clusters []*models.ClusterConfig, clusterID string) bool {
+	for _, cluster := range clusters {
+		if cluster.ClusterID == clusterID {
+			return true
+		}
+	}
+	return false
+}
+
+// withWarnings adds capacity warnings to a write response.
+func withWarnings(resp gin.H, warnings []errors.FieldError) gin.H {
+	if len(warnings) > 0 {
+		resp["warnings"] = warnings
+	}
+	return resp
+}
+
+// ValidateApp checks an application config without writing it.
+// @Summary Validate application
+// @Description Dry-run an application config: field rules, that its clusters exist, warnings (burst over 10x guaranteed, P0 priority) and whether the guaranteed quotas still fit in each cluster under the oversubscription policy. Nothing is written.
+// @Tags apps
+// @Accept json
+// @Produce json
+// @Param cluster_id query string false "Only check capacity of this cluster (default: all clusters)"
+// @Param request body models.AppConfig true "Application configuration"
+// @Success 200 {object} validation.DryRunResult
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 404 {object} errors.Problem "Cluster not found"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/apps/validate [post]
+func (h *Handler) ValidateApp(c *gin.Context) {
+	var config models.AppConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		_ = c.Error(validation.BindError(err))
+		return
+	}
+
+	ctx := h.getRequestContext(c, 10*time.Second)
+	defer h.cancelRequestContext(c)
+
+	clusterID := c.Query("cluster_id")
+	if clusterID != "" {
+		if err := validation.ValidateClusterID(clusterID); err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+
+	clusters, err := h.storage.ListClusterConfigs(ctx)
+	if err != nil {
+		logger.Errorw("failed to list clusters",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to validate application", err))
+		return
+	}
+	if clusterID != "" && !hasCluster(clusters, clusterID) {
+		_ = c.Error(errors.NotFound(fmt.Sprintf("cluster %s not found", clusterID), nil))
+		return
+	}
+
+	apps, err := h.storage.ListAppConfigs(ctx)
+	if err != nil {
+		logger.Errorw("failed to list apps",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to validate application", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, validation.DryRunApp(&config, clusters, apps, h.capacityPolicy, clusterID))
+}
+
+// ValidateCluster checks a cluster config without writing it.
+// @Summary Validate cluster
+// @Description Dry-run a cluster config: field rules, warnings (reserved_ratio below 5%, emergency_threshold above 98%) and whether the guaranteed quotas of all apps still fit under the oversubscription policy. Nothing is written.
+// @Tags clusters
+// @Accept json
+// @Produce json
+// @Param id path string true "Cluster ID"
+// @Param request body models.ClusterConfig true "Cluster configuration"
+// @Success 200 {object} validation.DryRunResult
+// @Failure 400 {object} errors.Problem "Invalid request"
+// @Failure 401 {object} errors.Problem "Unauthorized"
+// @Failure 500 {object} errors.Problem "Internal server error"
+// @Router /api/v1/clusters/{id}/validate [post]
+func (h *Handler) ValidateCluster(c *gin.Context) {
+	clusterID := c.Param("id")
+	var config models.ClusterConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		_ = c.Error(validation.BindError(err))
+		return
+	}
+
+	// Validate cluster ID
+	if err := validation.ValidateClusterID(clusterID); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	config.ClusterID = clusterID
+
+	ctx := h.getRequestContext(c, 10*time.Second)
+	defer h.cancelRequestContext(c)
+
+	current, err := h.storage.GetClusterConfig(ctx, clusterID)
+	if err != nil {
+		logger.Errorw("failed to get cluster",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"cluster_id", clusterID,
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to validate cluster", err))
+		return
+	}
+
+	apps, err := h.storage.ListAppConfigs(ctx)
+	if err != nil {
+		logger.Errorw("failed to list apps",
+			"request_id", c.GetString(middleware.RequestIDKey),
+			"error", err,
+		)
+		_ = c.Error(errors.InternalServerError("failed to validate cluster", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, validation.DryRunCluster(&config, effectiveReservedRatio(&config), current, apps, h.capacityPolicy))
+}
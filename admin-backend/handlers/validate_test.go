@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"admin-backend/config"
+	"admin-backend/errors"
+	"admin-backend/models"
+	"admin-backend/validation"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// capacityProblem is the body of a capacity_exceeded response.
+type capacityProblem struct {
+	Status   int                         `json:"status"`
+	Code     string                      `json:"code"`
+	Detail   string                      `json:"detail"`
+	Errors   []errors.FieldError         `json:"errors"`
+	Headroom int64                       `json:"headroom"`
+	Capacity []validation.CapacityReport `json:"capacity"`
+}
+
+// newCapacityServer returns a testServer holding two clusters, c1 with 900
+// allocatable (1000 less 10% reserved) and c2 with 400 (500 less 20%), and
+// the apps a1 drawing 500 on c1 and a2 drawing 300 on c2.
+func newCapacityServer(t *testing.T, policy *config.CapacityConfig) *testServer {
+	t.Helper()
+
+	s := newTestServer(t)
+	if policy != nil {
+		s.handler.SetCapacityPolicy(policy)
+	}
+	ctx := context.Background()
+
+	for _, cluster := range []*models.ClusterConfig{
+		{ClusterID: "c1", MaxCapacity: 1000, ReservedRatio: 0.1, EmergencyThreshold: 0.9},
+		{ClusterID: "c2", MaxCapacity: 500, ReservedRatio: 0.2, EmergencyThreshold: 0.9},
+	} {
+		if err := s.store.SetClusterConfig(ctx, cluster); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, app := range []*models.AppConfig{
+		{AppID: "a1", GuaranteedQuota: 500, Clusters: []models.ClusterAssignment{{ClusterID: "c1"}}},
+		{AppID: "a2", GuaranteedQuota: 300, Clusters: []models.ClusterAssignment{{ClusterID: "c2"}}},
+	} {
+		if err := s.store.SetAppConfig(ctx, app); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestCapacityRejection(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		// wantField and wantClusters are the field blamed and the
+		// oversubscribed clusters; empty when the write is accepted
+		wantField    string
+		wantClusters []string
+		wantHeadroom int64
+	}{
+		{
+			name:   "create app within capacity",
+			method: http.MethodPost,
+			path:   "/api/v1/apps",
+			body:   models.AppConfig{AppID: "a3", GuaranteedQuota: 400, Clusters: []models.ClusterAssignment{{ClusterID: "c1"}}},
+		},
+		{
+			name:         "create app over capacity",
+			method:       http.MethodPost,
+			path:         "/api/v1/apps",
+			body:         models.AppConfig{AppID: "a3", GuaranteedQuota: 401, Clusters: []models.ClusterAssignment{{ClusterID: "c1"}}},
+			wantField:    "guaranteed_quota",
+			wantClusters: []string{"c1"},
+			wantHeadroom: 400,
+		},
+		{
+			name:   "per-cluster quota within capacity",
+			method: http.MethodPost,
+			path:   "/api/v1/apps",
+			body: models.AppConfig{AppID: "a3", GuaranteedQuota: 1000, Clusters: []models.ClusterAssignment{
+				{ClusterID: "c1", GuaranteedQuota: 400},
+				{ClusterID: "c2", GuaranteedQuota: 100},
+			}},
+		},
+		{
+			name:         "unassigned app counts against every cluster",
+			method:       http.MethodPost,
+			path:         "/api/v1/apps",
+			body:         models.AppConfig{AppID: "a3", GuaranteedQuota: 200},
+			wantField:    "guaranteed_quota",
+			wantClusters: []string{"c2"},
+			wantHeadroom: 100,
+		},
+		{
+			name:   "several clusters over capacity",
+			method: http.MethodPost,
+			path:   "/api/v1/apps",
+			body: models.AppConfig{AppID: "a3", GuaranteedQuota: 500, Clusters: []models.ClusterAssignment{
+				{ClusterID: "c1"},
+				{ClusterID: "c2", GuaranteedQuota: 150},
+			}},
+			wantField:    "guaranteed_quota",
+			wantClusters: []string{"c1", "c2"},
+			wantHeadroom: 100,
+		},
+		{
+			name:         "raise app quota over capacity",
+			method:       http.MethodPut,
+			path:         "/api/v1/apps/a1",
+			body:         models.AppConfig{GuaranteedQuota: 901, Clusters: []models.ClusterAssignment{{ClusterID: "c1"}}},
+			wantField:    "guaranteed_quota",
+			wantClusters: []string{"c1"},
+			wantHeadroom: 400,
+		},
+		{
+			name:   "raise app quota to the limit",
+			method: http.MethodPut,
+			path:   "/api/v1/apps/a1",
+			body:   models.AppConfig{GuaranteedQuota: 900, Clusters: []models.ClusterAssignment{{ClusterID: "c1"}}},
+		},
+		{
+			name:         "move app to a full cluster",
+			method:       http.MethodPut,
+			path:         "/api/v1/apps/a1",
+			body:         models.AppConfig{GuaranteedQuota: 500, Clusters: []models.ClusterAssignment{{ClusterID: "c2"}}},
+			wantField:    "guaranteed_quota",
+			wantClusters: []string{"c2"},
+			wantHeadroom: 100,
+		},
+		{
+			name:   "create cluster no app draws on",
+			method: http.MethodPost,
+			path:   "/api/v1/clusters",
+			body:   models.ClusterConfig{ClusterID: "c3", MaxCapacity: 100, ReservedRatio: 0.1, EmergencyThreshold: 0.9},
+		},
+		{
+			name:         "shrink cluster below its apps",
+			method:       http.MethodPut,
+			path:         "/api/v1/clusters/c1",
+			body:         models.ClusterConfig{MaxCapacity: 500, ReservedRatio: 0.1, EmergencyThreshold: 0.9},
+			wantField:    "max_capacity",
+			wantClusters: []string{"c1"},
+			wantHeadroom: -50,
+		},
+		{
+			name:         "raise reserve ratio past the apps",
+			method:       http.MethodPut,
+			path:         "/api/v1/clusters/c2",
+			body:         models.ClusterConfig{MaxCapacity: 500, ReservedRatio: 0.5, EmergencyThreshold: 0.9},
+			wantField:    "max_capacity",
+			wantClusters: []string{"c2"},
+			wantHeadroom: -50,
+		},
+		{
+			name:   "shrink cluster to its apps",
+			method: http.MethodPut,
+			path:   "/api/v1/clusters/c1",
+			body:   models.ClusterConfig{MaxCapacity: 556, ReservedRatio: 0.1, EmergencyThreshold: 0.9},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newCapacityServer(t, nil)
+			admin := s.bearer(s.createUser("root", models.RoleAdmin))
+
+			w := s.do(tt.method, tt.path, tt.body, admin)
+			if tt.wantField == "" {
+				if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+					t.Fatalf("status = %d, want success: %s", w.Code, w.Body.String())
+				}
+				return
+			}
+			if w.Code != http.StatusConflict {
+				t.Fatalf("status = %d, want 409: %s", w.Code, w.Body.String())
+			}
+
+			var problem capacityProblem
+			decodeResponse(t, w, &problem)
+			if problem.Code != errors.CodeCapacityExceeded {
+				t.Errorf("code = %s, want %s", problem.Code, errors.CodeCapacityExceeded)
+			}
+			if problem.Headroom != tt.wantHeadroom {
+				t.Errorf("headroom = %d, want %d", problem.Headroom, tt.wantHeadroom)
+			}
+			if len(problem.Errors) != len(tt.wantClusters) {
+				t.Fatalf("errors = %+v, want one per cluster %v", problem.Errors, tt.wantClusters)
+			}
+			for _, fe := range problem.Errors {
+				if fe.Field != tt.wantField || fe.Rule != validation.RuleClusterCapacity {
+					t.Errorf("field error = %+v, want %s %s", fe, tt.wantField, validation.RuleClusterCapacity)
+				}
+			}
+
+			var over []string
+			for _, report := range problem.Capacity {
+				if report.Guaranteed > report.Limit {
+					over = append(over, report.ClusterID)
+				}
+				if report.Headroom != report.Limit-report.Committed {
+					t.Errorf("report %+v: headroom is not limit - committed", report)
+				}
+			}
+			if !reflect.DeepEqual(over, tt.wantClusters) {
+				t.Errorf("clusters over capacity in the report = %v, want %v", over, tt.wantClusters)
+			}
+		})
+	}
+}
+
+func TestCapacityRejectionBody(t *testing.T) {
+	s := newCapacityServer(t, nil)
+	admin := s.bearer(s.createUser("root", models.RoleAdmin))
+
+	w := s.do(http.MethodPost, "/api/v1/apps",
+		models.AppConfig{AppID: "a3", GuaranteedQuota: 450, Clusters: []models.ClusterAssignment{{ClusterID: "c1"}}}, admin)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body.String())
+	}
+
+	var problem capacityProblem
+	decodeResponse(t, w, &problem)
+
+	const message = "sum of guaranteed quotas (950) exceeds the limit of cluster c1 (900, headroom 400)"
+	if problem.Status != http.StatusConflict || problem.Detail != message {
+		t.Errorf("problem = %d %q, want 409 %q", problem.Status, problem.Detail, message)
+	}
+	wantErrors := []errors.FieldError{{Field: "guaranteed_quota", Rule: validation.RuleClusterCapacity, Message: message}}
+	if !reflect.DeepEqual(problem.Errors, wantErrors) {
+		t.Errorf("errors = %+v, want %+v", problem.Errors, wantErrors)
+	}
+
+	wantCapacity := []validation.CapacityReport{
+		{ClusterID: "c1", MaxCapacity: 1000, ReservedRatio: 0.1, Allocatable: 900, Limit: 900, Committed: 500, Guaranteed: 950, Headroom: 400, Apps: 2},
+		{ClusterID: "c2", MaxCapacity: 500, ReservedRatio: 0.2, Allocatable: 400, Limit: 400, Committed: 300, Guaranteed: 300, Headroom: 100, Apps: 1},
+	}
+	if len(problem.Capacity) == 2 && problem.Capacity[0].ClusterID == "c2" {
+		problem.Capacity[0], problem.Capacity[1] = problem.Capacity[1], problem.Capacity[0]
+	}
+	if !reflect.DeepEqual(problem.Capacity, wantCapacity) {
+		t.Errorf("capacity = %+v, want %+v", problem.Capacity, wantCapacity)
+	}
+
+	// Nothing was written
+	if app, err := s.store.GetAppConfig(context.Background(), "a3"); err != nil || app != nil {
+		t.Errorf("rejected app stored: %+v, %v", app, err)
+	}
+}
+
+func TestCapacityPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy config.CapacityConfig
+		quota  int64
+		// wantStatus is the status of adding an app drawing quota on c1, which has 400 headroom
+		wantStatus   int
+		wantWarnings int
+	}{
+		{name: "reject", policy: config.CapacityConfig{OversubscriptionPolicy: validation.OversubscriptionReject}, quota: 401, wantStatus: http.StatusConflict},
+		{name: "warn", policy: config.CapacityConfig{OversubscriptionPolicy: validation.OversubscriptionWarn}, quota: 401, wantStatus: http.StatusCreated, wantWarnings: 1},
+		{name: "allow within factor", policy: config.CapacityConfig{OversubscriptionPolicy: validation.OversubscriptionAllow, OversubscriptionFactor: 1.5}, quota: 850, wantStatus: http.StatusCreated},
+		{name: "allow past factor", policy: config.CapacityConfig{OversubscriptionPolicy: validation.OversubscriptionAllow, OversubscriptionFactor: 1.5}, quota: 851, wantStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newCapacityServer(t, &tt.policy)
+			admin := s.bearer(s.createUser("root", models.RoleAdmin))
+
+			w := s.do(http.MethodPost, "/api/v1/apps",
+				models.AppConfig{AppID: "a3", GuaranteedQuota: tt.quota, Clusters: []models.ClusterAssignment{{ClusterID: "c1"}}}, admin)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code == http.StatusConflict {
+				if code := problemCode(t, w); code != errors.CodeCapacityExceeded {
+					t.Errorf("code = %s, want %s", code, errors.CodeCapacityExceeded)
+				}
+				return
+			}
+
+			var resp struct {
+				Warnings []errors.FieldError `json:"warnings"`
+			}
+			decodeResponse(t, w, &resp)
+			if len(resp.Warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %+v, want %d", resp.Warnings, tt.wantWarnings)
+			}
+			for _, warning := range resp.Warnings {
+				if warning.Rule != validation.RuleClusterCapacity {
+					t.Errorf("warning = %+v", warning)
+				}
+			}
+		})
+	}
+}
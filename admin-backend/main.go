@@ -3,20 +3,26 @@ package main
 
 import (
 	"admin-backend/config"
+	"admin-backend/errors"
 	"admin-backend/handlers"
 	"admin-backend/logger"
 	"admin-backend/middleware"
+	"admin-backend/models"
+	"admin-backend/oidc"
 	"admin-backend/storage"
+	"admin-backend/validation"
 	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 )
 
 const (
@@ -44,20 +50,30 @@ func main() {
 	)
 
 	// Initialize storage
-	store, err := storage.NewRedisStorage(&redis.Options{
-		Addr:         cfg.Redis.Addr,
-		Password:     cfg.Redis.Password,
-		DB:           cfg.Redis.DB,
-		PoolSize:     cfg.Redis.PoolSize,
-		MinIdleConns: cfg.Redis.MinIdleConns,
-		MaxRetries:   cfg.Redis.MaxRetries,
-		DialTimeout:  cfg.Redis.DialTimeout,
-		ReadTimeout:  cfg.Redis.ReadTimeout,
-		WriteTimeout: cfg.Redis.WriteTimeout,
-		PoolTimeout:  cfg.Redis.PoolTimeout,
-	})
-	if err != nil {
-		logger.Fatalw("failed to initialize storage", "error", err)
+	var store storage.Storage
+	switch cfg.Storage.Backend {
+	case "memory":
+		logger.Warn("using in-memory storage, data will not persist across restarts")
+		store = storage.NewMemoryStorage(
+			storage.WithGatewayCluster(cfg.Storage.GatewayClusterID),
+			storage.WithMaxHistory(cfg.Storage.MaxHistoryEntries),
+		)
+	default:
+		store, err = storage.NewRedisStorage(&redis.Options{
+			Addr:         cfg.Redis.Addr,
+			Password:     cfg.Redis.Password,
+			DB:           cfg.Redis.DB,
+			PoolSize:     cfg.Redis.PoolSize,
+			MinIdleConns: cfg.Redis.MinIdleConns,
+			MaxRetries:   cfg.Redis.MaxRetries,
+			DialTimeout:  cfg.Redis.DialTimeout,
+			ReadTimeout:  cfg.Redis.ReadTimeout,
+			WriteTimeout: cfg.Redis.WriteTimeout,
+			PoolTimeout:  cfg.Redis.PoolTimeout,
+		}, storage.WithGatewayCluster(cfg.Storage.GatewayClusterID), storage.WithMaxHistory(cfg.Storage.MaxHistoryEntries))
+		if err != nil {
+			logger.Fatalw("failed to initialize storage", "error", err)
+		}
 	}
 	defer func() {
 		if err := store.Close(); err != nil {
@@ -65,92 +81,208 @@ func main() {
 		}
 	}()
 
-	logger.Info("storage initialized successfully")
+	logger.Infow("storage initialized successfully", "backend", cfg.Storage.Backend)
 
 	// Initialize JWT
 	if err := middleware.InitJWT(&cfg.JWT); err != nil {
 		logger.Fatalw("failed to initialize JWT", "error", err)
 	}
 
+	// Initialize password hashing and make sure an admin can log in
+	if err := middleware.InitPasswordHashing(&cfg.Auth); err != nil {
+		logger.Fatalw("failed to initialize password hashing", "error", err)
+	}
+	if err := bootstrapAdmin(store, &cfg.Auth); err != nil {
+		logger.Fatalw("failed to bootstrap admin user", "error", err)
+	}
+
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 
 	// Create Gin engine
 	r := gin.New()
 
+	// Only take the client IP from X-Forwarded-For when it was set by a known proxy
+	if len(cfg.Server.TrustedProxies) > 0 {
+		if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+			logger.Fatalw("invalid trusted proxies", "error", err)
+		}
+	}
+
 	// Global middleware
 	r.Use(middleware.RecoveryMiddleware())
 	r.Use(middleware.RequestIDMiddleware())
 	r.Use(middleware.LoggingMiddleware())
+	r.Use(middleware.ErrorHandler())
 	r.Use(middleware.CORS(&cfg.CORS))
 
-	// Rate limiting middleware (if enabled)
-	if cfg.RateLimit.Enabled {
-		r.Use(middleware.RateLimitMiddleware(&cfg.RateLimit, store))
-	}
-
 	// Create handlers
 	h := handlers.NewHandler(store)
 	defer h.Close()
 
+	// Cluster oversubscription policy of app and cluster writes
+	h.SetCapacityPolicy(&cfg.Capacity)
+
+	// Deactivate emergencies once they expire
+	h.StartEmergencyReaper(cfg.Emergency.ReaperInterval)
+
+	// Login brute-force protection (if enabled)
+	if cfg.LoginProtection.Enabled {
+		h.EnableLoginProtection(&cfg.LoginProtection)
+	}
+
 	// Health check endpoint (no authentication required)
 	r.GET("/health", h.Health)
 
-	// Authentication routes
-	auth := r.Group("/api/v1/auth")
+	// Token verification keys for other services (no authentication required)
+	r.GET("/.well-known/jwks.json", h.JWKS)
+
+	// Rate limiting, shared by all replicas through the store. It runs after
+	// authentication on protected routes so callers are limited by user and role.
+	rateLimit := middleware.RateLimitMiddleware(&cfg.RateLimit, store)
+
+	// Authentication routes, limited per client IP
+	auth := r.Group("/api/v1/auth", rateLimit)
 	{
 		auth.POST("/login", h.Login)
 		auth.POST("/refresh", h.RefreshToken)
 	}
 
+	// Logout is authenticated, so like the api group it is limited per user and role
+	r.POST("/api/v1/auth/logout", middleware.AuthMiddleware(store), rateLimit, h.Logout)
+
+	// Single sign-on
+	if cfg.OIDC.Enabled {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		provider, err := oidc.NewProvider(ctx, &cfg.OIDC)
+		cancel()
+		if err != nil {
+			logger.Fatalw("failed to initialize OIDC provider", "issuer", cfg.OIDC.IssuerURL, "error", err)
+		}
+		if err := h.EnableOIDC(provider, &cfg.OIDC); err != nil {
+			logger.Fatalw("failed to enable OIDC", "error", err)
+		}
+		auth.GET("/oidc/login", h.OIDCLogin)
+		auth.GET("/oidc/callback", h.OIDCCallback)
+		logger.Infow("OIDC single sign-on enabled",
+			"issuer", cfg.OIDC.IssuerURL,
+			"password_login", !cfg.OIDC.DisablePasswordLogin,
+		)
+	}
+
 	// API routes (require authentication)
+	// require returns the middleware enforcing a permission on a route or group
+	require := func(permission middleware.Permission) gin.HandlerFunc {
+		return middleware.RequirePermission(store, permission)
+	}
+
 	api := r.Group("/api/v1")
-	api.Use(middleware.AuthMiddleware())
+	api.Use(middleware.AuthMiddleware(store), rateLimit)
 	{
 		// Application management
-		apps := api.Group("/apps")
+		apps := api.Group("/apps", require(middleware.PermAppsRead))
 		{
 			apps.GET("", h.ListApps)
-			apps.POST("", h.CreateApp)
+			apps.POST("", require(middleware.PermAppsWrite), h.CreateApp)
+			apps.POST("/import", require(middleware.PermAppsWrite), h.ImportApps)
+			apps.POST("/validate", h.ValidateApp)
 			apps.GET("/:id", h.GetApp)
-			apps.PUT("/:id", h.UpdateApp)
-			apps.DELETE("/:id", h.DeleteApp)
+			apps.PUT("/:id", require(middleware.PermAppsWrite), h.UpdateApp)
+			apps.DELETE("/:id", require(middleware.PermAppsDelete), h.DeleteApp)
+			apps.GET("/:id/versions", h.ListAppVersions)
+			apps.GET("/:id/versions/:revision", h.GetAppVersion)
+			apps.GET("/:id/diff", h.DiffAppVersions)
+			apps.POST("/:id/rollback", require(middleware.PermAppsWrite), h.RollbackApp)
+		}
+
+		// Model schemas for client-side validation
+		api.GET("/schemas", h.ListSchemas)
+		api.GET("/schemas/:model", h.GetSchema)
+
+		// User management
+		users := api.Group("/users")
+		{
+			users.GET("/me", h.GetCurrentUser)
+			users.PUT("/me/password", h.ChangePassword)
+
+			manage := users.Group("", require(middleware.PermUsersManage))
+			manage.GET("", h.ListUsers)
+			manage.POST("", h.CreateUser)
+			manage.GET("/:id", h.GetUser)
+			manage.PUT("/:id", h.UpdateUser)
+			manage.DELETE("/:id", h.DeleteUser)
+			manage.PUT("/:id/password", h.ResetPassword)
+			manage.POST("/:id/revoke-sessions", h.RevokeUserSessions)
+			manage.POST("/:id/unlock", h.UnlockUser)
+		}
+
+		// Failed login lockouts
+		lockouts := api.Group("/lockouts", require(middleware.PermUsersManage))
+		{
+			lockouts.GET("", h.ListLoginLockouts)
+			lockouts.DELETE("/:scope/:key", h.UnlockLogin)
+		}
+
+		// API keys for service accounts
+		apiKeys := api.Group("/api-keys", require(middleware.PermAPIKeysManage))
+		{
+			apiKeys.GET("", h.ListAPIKeys)
+			apiKeys.POST("", h.CreateAPIKey)
+			apiKeys.GET("/:id", h.GetAPIKey)
+			apiKeys.DELETE("/:id", h.DeleteAPIKey)
 		}
 
 		// Cluster management
-		clusters := api.Group("/clusters")
+		clusters := api.Group("/clusters", require(middleware.PermClustersRead))
 		{
 			clusters.GET("", h.ListClusters)
+			clusters.POST("", require(middleware.PermClustersWrite), h.CreateCluster)
 			clusters.GET("/:id", h.GetCluster)
-			clusters.PUT("/:id", h.UpdateCluster)
+			clusters.GET("/:id/apps", h.ListClusterApps)
+			clusters.GET("/:id/status", h.GetClusterStatus)
+			clusters.PUT("/:id", require(middleware.PermClustersWrite), h.UpdateCluster)
+			clusters.DELETE("/:id", require(middleware.PermClustersDelete), h.DeleteCluster)
+			clusters.POST("/:id/validate", h.ValidateCluster)
+			clusters.GET("/:id/versions", h.ListClusterVersions)
+			clusters.GET("/:id/versions/:revision", h.GetClusterVersion)
+			clusters.GET("/:id/diff", h.DiffClusterVersions)
+			clusters.POST("/:id/rollback", require(middleware.PermClustersWrite), h.RollbackCluster)
 		}
 
 		// Connection management
-		connections := api.Group("/connections")
+		connections := api.Group("/connections", require(middleware.PermConnectionsRead))
 		{
 			connections.GET("", h.GetConnectionStats)
-			connections.PUT("", h.UpdateConnectionLimit)
+			connections.PUT("", require(middleware.PermConnectionsWrite), h.UpdateConnectionLimit)
 		}
 
 		// Emergency mode
-		emergency := api.Group("/emergency")
+		emergency := api.Group("/emergency", require(middleware.PermEmergencyRead))
 		{
 			emergency.GET("", h.GetEmergencyStatus)
-			emergency.POST("/activate", h.ActivateEmergency)
-			emergency.POST("/deactivate", h.DeactivateEmergency)
+			emergency.GET("/history", h.GetEmergencyHistory)
+			emergency.POST("/activate", require(middleware.PermEmergencyActivate), h.ActivateEmergency)
+			emergency.POST("/deactivate", require(middleware.PermEmergencyDeactivate), h.DeactivateEmergency)
+			emergency.POST("/extend", require(middleware.PermEmergencyActivate), h.ExtendEmergency)
+			emergency.GET("/schedules", h.ListEmergencySchedules)
+			emergency.POST("/schedules", require(middleware.PermEmergencyActivate), h.CreateEmergencySchedule)
+			emergency.DELETE("/schedules/:id", require(middleware.PermEmergencyActivate), h.CancelEmergencySchedule)
 		}
 
 		// Metrics
-		metrics := api.Group("/metrics")
+		metrics := api.Group("/metrics", require(middleware.PermMetricsRead))
 		{
 			metrics.GET("", h.GetMetrics)
 			metrics.GET("/apps/:id", h.GetAppMetrics)
 			metrics.GET("/connections", h.GetConnectionMetrics)
 		}
+
+		// Audit log
+		api.GET("/audit", require(middleware.PermAuditRead), h.ListAuditEntries)
 	}
 
 	// WebSocket endpoint (requires authentication)
-	r.GET("/ws", middleware.AuthMiddleware(), h.WebSocketHandler)
+	r.GET("/ws", middleware.AuthMiddleware(store), rateLimit, require(middleware.PermMetricsRead), h.WebSocketHandler)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -196,3 +328,62 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Failed to sync logger: %v\n", err)
 	}
 }
+
+// bootstrapAdmin creates the first admin user when the user store is empty.
+// The password comes from BOOTSTRAP_ADMIN_PASSWORD or BOOTSTRAP_ADMIN_PASSWORD_FILE;
+// without either, the service starts with no users and logs a warning.
+func bootstrapAdmin(store storage.Storage, cfg *config.AuthConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users, err := store.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return nil
+	}
+
+	password := cfg.BootstrapAdminPassword
+	if cfg.BootstrapAdminPasswordFile != "" {
+		data, err := os.ReadFile(cfg.BootstrapAdminPasswordFile)
+		if err != nil {
+			return fmt.Errorf("read bootstrap admin password file: %w", err)
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	if password == "" {
+		logger.Warn("no users exist; set BOOTSTRAP_ADMIN_PASSWORD or BOOTSTRAP_ADMIN_PASSWORD_FILE to create the first admin")
+		return nil
+	}
+
+	if err := validation.ValidateUsername(cfg.BootstrapAdminUsername); err != nil {
+		return fmt.Errorf("bootstrap admin username: %w", err)
+	}
+	if err := validation.ValidatePassword(password); err != nil {
+		return fmt.Errorf("bootstrap admin password: %w", err)
+	}
+
+	hash, err := middleware.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user := &models.User{
+		ID:           uuid.NewString(),
+		Username:     cfg.BootstrapAdminUsername,
+		Role:         models.RoleAdmin,
+		PasswordHash: hash,
+	}
+	if err := store.CreateUser(ctx, user); err != nil {
+		// Another replica may have bootstrapped concurrently
+		var appErr *errors.AppError
+		if errors.As(err, &appErr) && appErr.Code == http.StatusConflict {
+			return nil
+		}
+		return err
+	}
+
+	logger.Infow("bootstrap admin user created", "user_id", user.ID, "username", user.Username)
+	return nil
+}
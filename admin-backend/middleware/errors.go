@@ -0,0 +1,37 @@
+// Package middleware provides the error handling middleware for the admin backend.
+package middleware
+
+import (
+	"admin-backend/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorHandler creates a middleware that turns the last error attached with
+// c.Error into an RFC 7807 problem+json response. Handlers report failures with
+//
+//	_ = c.Error(errors.NotFound("application not found", nil))
+//	return
+//
+// and middleware with c.Error followed by c.Abort. Nothing is written if the
+// handler already responded. It must run inside LoggingMiddleware so the
+// logged status is the one sent.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		WriteError(c, c.Errors.Last().Err)
+	}
+}
+
+// WriteError writes err as problem details and aborts the request.
+func WriteError(c *gin.Context, err error) {
+	problem := errors.NewProblem(err, c.GetString(RequestIDKey), c.Request.URL.Path)
+
+	c.Header("Content-Type", errors.ProblemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
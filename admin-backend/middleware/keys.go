@@ -0,0 +1,244 @@
+// Package middleware provides JWT signing key management and the JWKS document.
+package middleware
+
+import (
+	"admin-backend/errors"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// minRSAKeyBits is the smallest RSA modulus accepted for signing keys
+	minRSAKeyBits = 2048
+	// legacySecretKeyID identifies the shared HS256 secret, whose tokens carry no kid
+	legacySecretKeyID = ""
+)
+
+// signingKey is a key that verifies tokens and, if it has a private half, signs them.
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// keySet holds every key tokens may be verified with and the one new tokens are signed with.
+type keySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA public key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 public key
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// loadKeySet builds the key set from a directory of PEM keys named <kid>.pem and
+// an optional shared secret.
+//
+// Without a key directory the secret signs HS256 tokens. With one, the key named
+// activeKeyID signs (RS256 for RSA keys, EdDSA for Ed25519 keys) and every other
+// key only verifies, so tokens signed before a rotation stay valid until they
+// expire. A file holding only a public key is verify-only. A secret set alongside
+// a key directory is kept to verify HS256 tokens issued before the switch.
+func loadKeySet(keysDir, activeKeyID, secret string) (*keySet, error) {
+	set := &keySet{keys: make(map[string]*signingKey)}
+
+	if secret != "" {
+		set.keys[legacySecretKeyID] = &signingKey{
+			id:      legacySecretKeyID,
+			method:  jwt.SigningMethodHS256,
+			private: []byte(secret),
+			public:  []byte(secret),
+		}
+	}
+
+	if keysDir == "" {
+		set.active = set.keys[legacySecretKeyID]
+		if set.active == nil {
+			return nil, errors.BadRequest("JWT secret cannot be empty", nil)
+		}
+		return set, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(keysDir, "*.pem"))
+	if err != nil {
+		return nil, errors.BadRequest("invalid JWT keys directory", err)
+	}
+
+	var signers []string
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[key.id] = key
+		if key.private != nil {
+			signers = append(signers, key.id)
+		}
+	}
+
+	if activeKeyID == "" && len(signers) == 1 {
+		activeKeyID = signers[0]
+	}
+	if activeKeyID == "" {
+		return nil, errors.BadRequest(
+			fmt.Sprintf("JWT active key ID must be set when %s holds %d private keys", keysDir, len(signers)),
+			nil,
+		)
+	}
+
+	set.active = set.keys[activeKeyID]
+	if set.active == nil || set.active.private == nil {
+		return nil, errors.BadRequest(
+			fmt.Sprintf("JWT active key %q must be a private key in %s", activeKeyID, keysDir),
+			nil,
+		)
+	}
+
+	return set, nil
+}
+
+// loadSigningKey reads a PEM encoded RSA or Ed25519 key; its kid is the file name without ".pem".
+func loadSigningKey(path string) (*signingKey, error) {
+	kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+	if kid == "" {
+		return nil, errors.BadRequest(fmt.Sprintf("JWT key file %s has no key ID", path), nil)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.BadRequest(fmt.Sprintf("failed to read JWT key %s", path), err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.BadRequest(fmt.Sprintf("JWT key %s is not PEM encoded", path), nil)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, errors.BadRequest(fmt.Sprintf("failed to parse JWT key %s", path), err)
+	}
+
+	key := &signingKey{id: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, errors.BadRequest(fmt.Sprintf("JWT key %s must be an RSA or Ed25519 key", path), nil)
+	}
+
+	if pub, ok := key.public.(*rsa.PublicKey); ok && pub.N.BitLen() < minRSAKeyBits {
+		return nil, errors.BadRequest(
+			fmt.Sprintf("JWT key %s must be at least %d bits", path, minRSAKeyBits),
+			nil,
+		)
+	}
+
+	return key, nil
+}
+
+// verificationKey returns the key for a parsed token, matched by its kid header.
+func (s *keySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.public, nil
+}
+
+// sign signs claims with the active key, naming it in the kid header.
+func (s *keySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.active.method, claims)
+	if s.active.id != legacySecretKeyID {
+		token.Header["kid"] = s.active.id
+	}
+	return token.SignedString(s.active.private)
+}
+
+// jwks returns the public halves of all asymmetric keys, sorted by kid.
+// Shared secrets are never published.
+func (s *keySet) jwks() *JWKS {
+	out := &JWKS{Keys: []JWK{}}
+	for _, key := range s.keys {
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			out.Keys = append(out.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	sort.Slice(out.Keys, func(i, j int) bool { return out.Keys[i].Kid < out.Keys[j].Kid })
+	return out
+}
+
+// PublicJWKS returns the JSON Web Key Set other services use to verify tokens.
+func PublicJWKS() *JWKS {
+	if jwtKeys == nil {
+		return &JWKS{Keys: []JWK{}}
+	}
+	return jwtKeys.jwks()
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"admin-backend/config"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKey writes key to dir/<kid>.pem as PKCS #8 or, if public, PKIX.
+func writeKey(t *testing.T, dir, kid string, key interface{}, public bool) {
+	t.Helper()
+
+	var block *pem.Block
+	if public {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type keyFile struct {
+		kid    string
+		public bool
+	}
+	// Each stage is one deployment of the key directory. A stage that names
+	// issue signs a token, and every token issued so far is checked against
+	// wantValid.
+	stages := []struct {
+		name      string
+		files     []keyFile
+		active    string
+		secret    string
+		issue     string
+		wantKids  []string
+		wantValid map[string]bool
+	}{
+		{
+			name:      "shared secret",
+			secret:    secret,
+			issue:     "secret",
+			wantValid: map[string]bool{"secret": true},
+		},
+		{
+			name:      "first key, secret kept to verify",
+			files:     []keyFile{{kid: "k1"}},
+			secret:    secret,
+			issue:     "k1",
+			wantKids:  []string{"k1"},
+			wantValid: map[string]bool{"secret": true, "k1": true},
+		},
+		{
+			name:      "rotation overlap",
+			files:     []keyFile{{kid: "k1"}, {kid: "k2"}},
+			active:    "k2",
+			secret:    secret,
+			issue:     "k2",
+			wantKids:  []string{"k1", "k2"},
+			wantValid: map[string]bool{"secret": true, "k1": true, "k2": true},
+		},
+		{
+			name:      "previous key verify-only, secret retired",
+			files:     []keyFile{{kid: "k1", public: true}, {kid: "k2"}},
+			wantKids:  []string{"k1", "k2"},
+			wantValid: map[string]bool{"secret": false, "k1": true, "k2": true},
+		},
+		{
+			name:      "previous key retired",
+			files:     []keyFile{{kid: "k2"}},
+			wantKids:  []string{"k2"},
+			wantValid: map[string]bool{"secret": false, "k1": false, "k2": true},
+		},
+	}
+
+	keys := map[string]interface{}{"k1": edKey, "k2": rsaKey}
+	publicKeys := map[string]interface{}{"k1": edKey.Public(), "k2": &rsaKey.PublicKey}
+	tokens := make(map[string]string)
+
+	for _, stage := range stages {
+		t.Run(stage.name, func(t *testing.T) {
+			cfg := &config.JWTConfig{
+				Secret:            stage.secret,
+				ActiveKeyID:       stage.active,
+				AccessExpiration:  time.Hour,
+				RefreshExpiration: time.Hour,
+			}
+			if len(stage.files) > 0 {
+				cfg.KeysDir = t.TempDir()
+				for _, f := range stage.files {
+					if f.public {
+						writeKey(t, cfg.KeysDir, f.kid, publicKeys[f.kid], true)
+					} else {
+						writeKey(t, cfg.KeysDir, f.kid, keys[f.kid], false)
+					}
+				}
+			}
+			if err := InitJWT(cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			if stage.issue != "" {
+				access, _, err := GenerateToken("u1", "alice", "admin")
+				if err != nil {
+					t.Fatal(err)
+				}
+				tokens[stage.issue] = access
+			}
+
+			for name, token := range tokens {
+				_, err := ValidateToken(token, TokenTypeAccess)
+				if valid := err == nil; valid != stage.wantValid[name] {
+					t.Errorf("token signed with %s: valid = %t, want %t (%v)", name, valid, stage.wantValid[name], err)
+				}
+			}
+
+			var kids []string
+			for _, key := range PublicJWKS().Keys {
+				kids = append(kids, key.Kid)
+			}
+			if len(kids) != len(stage.wantKids) {
+				t.Fatalf("JWKS kids %v, want %v", kids, stage.wantKids)
+			}
+			for i := range kids {
+				if kids[i] != stage.wantKids[i] {
+					t.Errorf("JWKS kids %v, want %v", kids, stage.wantKids)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		write  func(dir string)
+		active string
+	}{
+		{name: "two private keys without an active key", write: func(dir string) {
+			writeKey(t, dir, "k1", edKey, false)
+			writeKey(t, dir, "k2", otherKey, false)
+		}},
+		{name: "active key is public only", active: "k1", write: func(dir string) {
+			writeKey(t, dir, "k1", edKey.Public(), true)
+		}},
+		{name: "unknown active key", active: "k9", write: func(dir string) {
+			writeKey(t, dir, "k1", edKey, false)
+		}},
+		{name: "RSA key too small", write: func(dir string) {
+			writeKey(t, dir, "k1", smallKey, false)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.write(dir)
+			if _, err := loadKeySet(dir, tt.active, ""); err == nil {
+				t.Fatal("key set loaded, want an error")
+			}
+		})
+	}
+}
@@ -8,10 +8,10 @@ import (
 	"admin-backend/storage"
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -28,11 +28,22 @@ const (
 	RoleKey = "role"
 	// RequestIDKey is the context key for request ID
 	RequestIDKey = "request_id"
+	// ClaimsKey is the context key for the validated token claims
+	ClaimsKey = "claims"
+)
+
+const (
+	// TokenTypeAccess marks tokens accepted by AuthMiddleware
+	TokenTypeAccess = "access"
+	// TokenTypeRefresh marks tokens accepted only by the refresh endpoint
+	TokenTypeRefresh = "refresh"
 )
 
 var (
-	jwtSecret []byte
-	jwtIssuer string
+	jwtKeys           *keySet
+	jwtIssuer         string
+	accessExpiration  = time.Hour
+	refreshExpiration = 7 * 24 * time.Hour
 )
 
 // InitJWT initializes JWT configuration from the application config.
@@ -40,101 +51,130 @@ func InitJWT(cfg *config.JWTConfig) error {
 	if cfg == nil {
 		return errors.BadRequest("JWT config cannot be nil", nil)
 	}
-	if cfg.Secret == "" {
-		return errors.BadRequest("JWT secret cannot be empty", nil)
+	if cfg.AccessExpiration <= 0 || cfg.RefreshExpiration <= 0 {
+		return errors.BadRequest("JWT expirations must be positive", nil)
 	}
-	jwtSecret = []byte(cfg.Secret)
+	keys, err := loadKeySet(cfg.KeysDir, cfg.ActiveKeyID, cfg.Secret)
+	if err != nil {
+		return err
+	}
+	jwtKeys = keys
 	jwtIssuer = cfg.Issuer
+	accessExpiration = cfg.AccessExpiration
+	refreshExpiration = cfg.RefreshExpiration
 	return nil
 }
 
+// AccessTokenTTL returns the lifetime of access tokens.
+func AccessTokenTTL() time.Duration {
+	return accessExpiration
+}
+
+// RefreshTokenTTL returns the lifetime of refresh tokens, the longest-lived token.
+func RefreshTokenTTL() time.Duration {
+	return refreshExpiration
+}
+
 // Claims represents JWT claims.
 type Claims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	TokenType string `json:"token_type"`
 	jwt.RegisteredClaims
 }
 
 // GenerateToken generates both access and refresh tokens for a user.
+// Each token gets a unique ID (jti) so it can be revoked individually.
 // Returns (accessToken, refreshToken, error).
 func GenerateToken(userID, username, role string) (string, string, error) {
 	now := time.Now()
 
 	// Access token (short-lived)
-	accessClaims := &Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    jwtIssuer,
-			Subject:   userID,
-			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(now),
-			NotBefore: jwt.NewNumericDate(now),
-		},
-	}
-
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString(jwtSecret)
+	accessTokenString, err := signToken(userID, username, role, TokenTypeAccess, now, accessExpiration)
 	if err != nil {
 		return "", "", errors.InternalServerError("failed to sign access token", err)
 	}
 
 	// Refresh token (long-lived)
-	refreshClaims := &Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+	refreshTokenString, err := signToken(userID, username, role, TokenTypeRefresh, now, refreshExpiration)
+	if err != nil {
+		return "", "", errors.InternalServerError("failed to sign refresh token", err)
+	}
+
+	return accessTokenString, refreshTokenString, nil
+}
+
+// signToken signs a token of the given type that expires after ttl.
+func signToken(userID, username, role, tokenType string, now time.Time, ttl time.Duration) (string, error) {
+	claims := &Claims{
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		TokenType: tokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			Issuer:    jwtIssuer,
 			Subject:   userID,
-			ExpiresAt: jwt.NewNumericDate(now.Add(7 * 24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshTokenString, err := refreshToken.SignedString(jwtSecret)
-	if err != nil {
-		return "", "", errors.InternalServerError("failed to sign refresh token", err)
-	}
-
-	return accessTokenString, refreshTokenString, nil
+	return jwtKeys.sign(claims)
 }
 
-// ValidateToken validates a JWT token string and returns the claims.
-func ValidateToken(tokenString string) (*Claims, error) {
+// ValidateToken validates a JWT token string of the expected type and returns the claims.
+func ValidateToken(tokenString, tokenType string) (*Claims, error) {
 	if tokenString == "" {
 		return nil, errors.Unauthorized("token is empty", nil)
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	// The key is chosen by kid and must match the token's signing method
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, jwtKeys.verificationKey)
 
 	if err != nil {
 		return nil, errors.Unauthorized("invalid token", err)
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid || claims.ID == "" || claims.IssuedAt == nil {
+		return nil, errors.Unauthorized("invalid token claims", nil)
 	}
 
-	return nil, errors.Unauthorized("invalid token claims", nil)
+	if claims.TokenType != tokenType {
+		return nil, errors.Unauthorized(fmt.Sprintf("expected %s token", tokenType), nil)
+	}
+
+	return claims, nil
+}
+
+// TokenRemainingTTL returns how long the token described by claims stays valid.
+func TokenRemainingTTL(claims *Claims) time.Duration {
+	if claims.ExpiresAt == nil {
+		return 0
+	}
+	return time.Until(claims.ExpiresAt.Time)
 }
 
-// AuthMiddleware creates a JWT authentication middleware.
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware creates an authentication middleware accepting JWT access
+// tokens ("Authorization: Bearer <token>") and API keys ("X-API-Key: <key>"
+// or "Authorization: ApiKey <key>"). Revoked tokens and expired keys are rejected.
+func AuthMiddleware(store CredentialStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key, ok := apiKeyFromRequest(c); ok {
+			if !authenticateAPIKey(c, store, key) {
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authorization header"})
+			_ = c.Error(errors.Unauthorized("missing authorization header", nil))
 			c.Abort()
 			return
 		}
@@ -142,15 +182,33 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Parse Bearer token
 		parts := strings.SplitN(authHeader, " ", 2)
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization format, expected 'Bearer <token>'"})
+			_ = c.Error(errors.Unauthorized("invalid authorization format, expected 'Bearer <token>' or 'ApiKey <key>'", nil))
 			c.Abort()
 			return
 		}
 
 		// Validate token
-		claims, err := ValidateToken(parts[1])
+		claims, err := ValidateToken(parts[1], TokenTypeAccess)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+			_ = c.Error(errors.Unauthorized("invalid or expired token", err))
+			c.Abort()
+			return
+		}
+
+		// Check revocation (fail closed if storage is unavailable)
+		revoked, err := store.IsTokenRevoked(c.Request.Context(), claims.ID, claims.UserID, claims.IssuedAt.Time)
+		if err != nil {
+			logger.Errorw("failed to check token revocation",
+				"request_id", c.GetString(RequestIDKey),
+				"user_id", claims.UserID,
+				"error", err,
+			)
+			_ = c.Error(errors.ServiceUnavailable("unable to verify token", err))
+			c.Abort()
+			return
+		}
+		if revoked {
+			_ = c.Error(errors.Unauthorized("token has been revoked", nil))
 			c.Abort()
 			return
 		}
@@ -159,6 +217,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Set(UserIDKey, claims.UserID)
 		c.Set(UsernameKey, claims.Username)
 		c.Set(RoleKey, claims.Role)
+		c.Set(ClaimsKey, claims)
 
 		logger.Infow("authenticated request",
 			"request_id", c.GetString(RequestIDKey),
@@ -217,27 +276,15 @@ func CORS(cfg *config.CORSConfig) gin.HandlerFunc {
 	}
 }
 
-// RateLimiterConfig holds rate limiter configuration.
-type RateLimiterConfig struct {
-	Storage         storage.Storage
-	RequestsPerWindow int
-	Window          time.Duration
-}
-
-// rateLimiter tracks request counts for rate limiting.
-type rateLimiter struct {
-	mu       sync.RWMutex
-	requests map[string]*requestTracker
-	cleanup  *time.Ticker
-}
-
-type requestTracker struct {
-	count     int
-	resetTime time.Time
-}
-
-// RateLimitMiddleware creates a rate limiting middleware using Redis.
-func RateLimitMiddleware(cfg *config.RateLimitConfig, store storage.Storage) gin.HandlerFunc {
+// RateLimitMiddleware creates a rate limiting middleware backed by store, so
+// every replica counts requests in the same sliding windows.
+//
+// Authenticated callers are counted per user or API key against their role's
+// limit (RequestsPerWindow if the role has none); anonymous callers are counted
+// per client IP. A request to a route with its own limit is also counted in a
+// separate window for that route. On authenticated routes it must run after
+// AuthMiddleware to see the caller. If the store fails, requests are let through.
+func RateLimitMiddleware(cfg *config.RateLimitConfig, store storage.RateLimitStorage) gin.HandlerFunc {
 	if !cfg.Enabled {
 		// Return a no-op middleware if rate limiting is disabled
 		return func(c *gin.Context) {
@@ -245,82 +292,89 @@ func RateLimitMiddleware(cfg *config.RateLimitConfig, store storage.Storage) gin
 		}
 	}
 
-	limiter := &rateLimiter{
-		requests: make(map[string]*requestTracker),
-		cleanup:  time.NewTicker(cfg.CleanupInterval),
-	}
-
-	// Start cleanup goroutine
-	go func() {
-		for range limiter.cleanup.C {
-			limiter.cleanupOldEntries()
-		}
-	}()
+	// Both were checked by config validation
+	roleLimits, _ := cfg.RoleLimitMap()
+	routeLimits, _ := cfg.RouteLimitMap()
 
 	return func(c *gin.Context) {
 		// Get client identifier (IP or user ID if authenticated)
-		identifier := c.ClientIP()
-		if userID, exists := c.Get(UserIDKey); exists {
-			identifier = fmt.Sprintf("user:%v", userID)
+		identifier := "ip:" + c.ClientIP()
+		limit := cfg.RequestsPerWindow
+		if userID := c.GetString(UserIDKey); userID != "" {
+			identifier = "user:" + userID
+			if roleLimit, ok := roleLimits[c.GetString(RoleKey)]; ok {
+				limit = roleLimit
+			}
 		}
 
-		// Check rate limit
-		allowed, resetTime := limiter.allow(identifier, cfg.RequestsPerWindow, cfg.Window)
-		if !allowed {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":      "rate limit exceeded",
-				"retry_after": resetTime.Unix(),
-			})
-			c.Abort()
-			return
+		buckets := []storage.RateLimitBucket{{Key: identifier, Limit: limit, Window: cfg.Window}}
+		for _, route := range []string{c.Request.Method + " " + c.FullPath(), c.FullPath()} {
+			if routeLimit, ok := routeLimits[route]; ok {
+				buckets = append(buckets, storage.RateLimitBucket{
+					Key:    identifier + ":" + route,
+					Limit:  routeLimit,
+					Window: cfg.Window,
+				})
+				break
+			}
 		}
 
-		// Set rate limit headers
-		c.Header("X-RateLimit-Limit", strconv.Itoa(cfg.RequestsPerWindow))
-		c.Header("X-RateLimit-Remaining", strconv.Itoa(cfg.RequestsPerWindow-1))
-		c.Header("X-RateLimit-Reset", strconv.FormatInt(resetTime.Unix(), 10))
-
-		c.Next()
-	}
-}
-
-// allow checks if a request is allowed under the rate limit.
-func (rl *rateLimiter) allow(identifier string, limit int, window time.Duration) (bool, time.Time) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	now := time.Now()
-
-	tracker, exists := rl.requests[identifier]
-	if !exists || now.After(tracker.resetTime) {
-		// Create new tracker or reset existing one
-		rl.requests[identifier] = &requestTracker{
-			count:     1,
-			resetTime: now.Add(window),
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		now := time.Now()
+		allowed, states, err := store.AllowRequest(ctx, buckets, now)
+		cancel()
+		if err != nil {
+			logger.Errorw("rate limit check failed",
+				"request_id", c.GetString(RequestIDKey),
+				"identifier", identifier,
+				"error", err,
+			)
+			c.Next()
+			return
 		}
-		return true, rl.requests[identifier].resetTime
-	}
 
-	// Check if limit exceeded
-	if tracker.count >= limit {
-		return false, tracker.resetTime
-	}
+		// Report the bucket that runs out first; a refused request waits for the
+		// last full bucket to free a slot
+		binding := -1
+		for i, st := range states {
+			switch {
+			case allowed && (binding < 0 || st.Remaining < states[binding].Remaining):
+				binding = i
+			case !allowed && st.Remaining == 0 && (binding < 0 || st.ResetAt.After(states[binding].ResetAt)):
+				binding = i
+			}
+		}
+		if binding < 0 {
+			binding = 0
+		}
+		state := states[binding]
 
-	// Increment counter
-	tracker.count++
-	return true, tracker.resetTime
-}
+		c.Header("X-RateLimit-Limit", strconv.Itoa(buckets[binding].Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(state.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(state.ResetAt.Unix(), 10))
 
-// cleanupOldEntries removes expired request trackers.
-func (rl *rateLimiter) cleanupOldEntries() {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+		if !allowed {
+			retryAfter := int64(math.Ceil(state.ResetAt.Sub(now).Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
 
-	now := time.Now()
-	for key, tracker := range rl.requests {
-		if now.After(tracker.resetTime) {
-			delete(rl.requests, key)
+			logger.Warnw("rate limit exceeded",
+				"request_id", c.GetString(RequestIDKey),
+				"identifier", identifier,
+				"bucket", buckets[binding].Key,
+				"path", c.Request.URL.Path,
+			)
+
+			c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+			_ = c.Error(errors.TooManyRequests("rate limit exceeded", nil).
+				WithErrorCode(errors.CodeRateLimited).
+				WithContext("retry_after", retryAfter))
+			c.Abort()
+			return
 		}
+
+		c.Next()
 	}
 }
 
@@ -376,6 +430,10 @@ func LoggingMiddleware() gin.HandlerFunc {
 			logEntry = append(logEntry, "query", query)
 		}
 
+		if len(c.Errors) > 0 {
+			logEntry = append(logEntry, "error", c.Errors.Last().Err)
+		}
+
 		// Log based on status code
 		if c.Writer.Status() >= 500 {
 			logger.Errorw("HTTP request completed with server error", logEntry...)
@@ -399,10 +457,7 @@ func RecoveryMiddleware() gin.HandlerFunc {
 					"stack", string(debugStack()),
 				)
 
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "internal server error",
-				})
-				c.Abort()
+				WriteError(c, errors.InternalServerError("internal server error", nil))
 			}
 		}()
 
@@ -442,10 +497,7 @@ func TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 			return
 		case <-ctx.Done():
 			// Timeout occurred
-			c.JSON(http.StatusGatewayTimeout, gin.H{
-				"error": "request timeout",
-			})
-			c.Abort()
+			WriteError(c, errors.NewAppError(http.StatusGatewayTimeout, "request timeout", ctx.Err()))
 		}
 	}
 }
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"admin-backend/config"
+	"admin-backend/logger"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	if err := logger.Init("fatal", "console", ""); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	type request struct {
+		method string
+		path   string
+		// ip is the client address; user and role stand in for AuthMiddleware
+		ip   string
+		user string
+		role string
+
+		wantStatus    int
+		wantLimit     int
+		wantRemaining int
+	}
+	get := func(user, role string, wantStatus, wantLimit, wantRemaining int) request {
+		return request{method: http.MethodGet, path: "/things", ip: "192.0.2.1", user: user, role: role,
+			wantStatus: wantStatus, wantLimit: wantLimit, wantRemaining: wantRemaining}
+	}
+
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{
+			name: "anonymous callers are limited per IP",
+			requests: []request{
+				get("", "", http.StatusOK, 3, 2),
+				get("", "", http.StatusOK, 3, 1),
+				get("", "", http.StatusOK, 3, 0),
+				get("", "", http.StatusTooManyRequests, 3, 0),
+				{method: http.MethodGet, path: "/things", ip: "192.0.2.2", wantStatus: http.StatusOK, wantLimit: 3, wantRemaining: 2},
+			},
+		},
+		{
+			name: "users are limited separately from their IP",
+			requests: []request{
+				get("u1", models.RoleViewer, http.StatusOK, 3, 2),
+				get("u1", models.RoleViewer, http.StatusOK, 3, 1),
+				get("u1", models.RoleViewer, http.StatusOK, 3, 0),
+				get("u1", models.RoleViewer, http.StatusTooManyRequests, 3, 0),
+				get("u2", models.RoleViewer, http.StatusOK, 3, 2),
+				get("", "", http.StatusOK, 3, 2),
+			},
+		},
+		{
+			name: "role limit",
+			requests: []request{
+				get("u1", models.RoleAdmin, http.StatusOK, 5, 4),
+				get("u1", models.RoleAdmin, http.StatusOK, 5, 3),
+			},
+		},
+		{
+			name: "route limit is counted separately",
+			requests: []request{
+				{method: http.MethodPost, path: "/things", ip: "192.0.2.1", user: "u1", role: models.RoleViewer,
+					wantStatus: http.StatusOK, wantLimit: 1, wantRemaining: 0},
+				{method: http.MethodPost, path: "/things", ip: "192.0.2.1", user: "u1", role: models.RoleViewer,
+					wantStatus: http.StatusTooManyRequests, wantLimit: 1, wantRemaining: 0},
+				// The refused request was not counted against the caller
+				get("u1", models.RoleViewer, http.StatusOK, 3, 1),
+			},
+		},
+	}
+
+	cfg := &config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerWindow: 3,
+		Window:            time.Minute,
+		RoleLimits:        "admin=5",
+		RouteLimits:       "POST /things=1",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			defer store.Close()
+
+			r := gin.New()
+			r.Use(ErrorHandler(), func(c *gin.Context) {
+				if user := c.GetHeader("X-Test-User"); user != "" {
+					c.Set(UserIDKey, user)
+					c.Set(RoleKey, c.GetHeader("X-Test-Role"))
+				}
+			}, RateLimitMiddleware(cfg, store))
+			ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+			r.GET("/things", ok)
+			r.POST("/things", ok)
+
+			for i, req := range tt.requests {
+				httpReq := httptest.NewRequest(req.method, req.path, nil)
+				httpReq.RemoteAddr = req.ip + ":1234"
+				httpReq.Header.Set("X-Test-User", req.user)
+				httpReq.Header.Set("X-Test-Role", req.role)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httpReq)
+
+				if w.Code != req.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d", i+1, w.Code, req.wantStatus)
+				}
+				if got := w.Header().Get("X-RateLimit-Limit"); got != strconv.Itoa(req.wantLimit) {
+					t.Errorf("request %d: X-RateLimit-Limit = %q, want %d", i+1, got, req.wantLimit)
+				}
+				if got := w.Header().Get("X-RateLimit-Remaining"); got != strconv.Itoa(req.wantRemaining) {
+					t.Errorf("request %d: X-RateLimit-Remaining = %q, want %d", i+1, got, req.wantRemaining)
+				}
+				if retryAfter := w.Header().Get("Retry-After"); (w.Code == http.StatusTooManyRequests) != (retryAfter != "") {
+					t.Errorf("request %d: Retry-After = %q with status %d", i+1, retryAfter, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddlewareDisabled(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	r := gin.New()
+	r.Use(RateLimitMiddleware(&config.RateLimitConfig{Enabled: false, RequestsPerWindow: 1, Window: time.Minute}, store))
+	r.GET("/things", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/things", nil))
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("request %d: status %d, X-RateLimit-Limit %q", i+1, w.Code, w.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+}
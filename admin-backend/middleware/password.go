@@ -0,0 +1,55 @@
+// Package middleware provides password hashing for console users.
+package middleware
+
+import (
+	"admin-backend/config"
+	"admin-backend/errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordHashCost is the bcrypt cost used by HashPassword.
+var passwordHashCost = bcrypt.DefaultCost
+
+// dummyPasswordHash is compared against when a login names an unknown user,
+// so that response times do not reveal which usernames exist.
+var dummyPasswordHash []byte
+
+// InitPasswordHashing initializes password hashing from the application config.
+func InitPasswordHashing(cfg *config.AuthConfig) error {
+	if cfg == nil {
+		return errors.BadRequest("auth config cannot be nil", nil)
+	}
+	if cfg.PasswordHashCost < bcrypt.MinCost || cfg.PasswordHashCost > bcrypt.MaxCost {
+		return errors.BadRequest("invalid password hash cost", nil)
+	}
+	passwordHashCost = cfg.PasswordHashCost
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy-password-0"), passwordHashCost)
+	if err != nil {
+		return errors.InternalServerError("failed to hash dummy password", err)
+	}
+	dummyPasswordHash = hash
+	return nil
+}
+
+// HashPassword returns the bcrypt hash of password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return "", errors.InternalServerError("failed to hash password", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches hash.
+// An empty hash never matches but costs the same as a real comparison.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		if dummyPasswordHash != nil {
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		}
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,170 @@
+// Package middleware provides role-based access control for the admin API.
+package middleware
+
+import (
+	"admin-backend/errors"
+	"admin-backend/logger"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permission names an action on a resource, e.g. "apps:delete".
+type Permission string
+
+// Permissions checked by the admin API.
+const (
+	PermAppsRead            Permission = "apps:read"
+	PermAppsWrite           Permission = "apps:write"
+	PermAppsDelete          Permission = "apps:delete"
+	PermClustersRead        Permission = "clusters:read"
+	PermClustersWrite       Permission = "clusters:write"
+	PermClustersDelete      Permission = "clusters:delete"
+	PermConnectionsRead     Permission = "connections:read"
+	PermConnectionsWrite    Permission = "connections:write"
+	PermEmergencyRead       Permission = "emergency:read"
+	PermEmergencyActivate   Permission = "emergency:activate"
+	PermEmergencyDeactivate Permission = "emergency:deactivate"
+	PermMetricsRead         Permission = "metrics:read"
+	PermUsersManage         Permission = "users:manage"
+	PermAuditRead           Permission = "audit:read"
+	PermAPIKeysManage       Permission = "apikeys:manage"
+)
+
+// viewerPermissions grant read-only access to configuration and metrics.
+var viewerPermissions = []Permission{
+	PermAppsRead,
+	PermClustersRead,
+	PermConnectionsRead,
+	PermEmergencyRead,
+	PermMetricsRead,
+}
+
+// operatorPermissions add day-to-day configuration changes and emergency control.
+var operatorPermissions = append([]Permission{
+	PermAppsWrite,
+	PermClustersWrite,
+	PermConnectionsWrite,
+	PermEmergencyActivate,
+	PermEmergencyDeactivate,
+}, viewerPermissions...)
+
+// adminPermissions add destructive operations, user and API key management and the audit log.
+var adminPermissions = append([]Permission{
+	PermAppsDelete,
+	PermClustersDelete,
+	PermUsersManage,
+	PermAuditRead,
+	PermAPIKeysManage,
+}, operatorPermissions...)
+
+// rolePermissions maps each role to the permissions it grants.
+var rolePermissions = map[string]map[Permission]bool{
+	models.RoleViewer:   permissionSet(viewerPermissions),
+	models.RoleOperator: permissionSet(operatorPermissions),
+	models.RoleAdmin:    permissionSet(adminPermissions),
+}
+
+// permissionSet builds a lookup set from a permission list.
+func permissionSet(perms []Permission) map[Permission]bool {
+	set := make(map[Permission]bool, len(perms))
+	for _, p := range perms {
+		set[p] = true
+	}
+	return set
+}
+
+// HasPermission reports whether role grants permission. Unknown roles grant nothing.
+func HasPermission(role string, permission Permission) bool {
+	return rolePermissions[role][permission]
+}
+
+// IsPermission reports whether p names a permission checked by the admin API.
+func IsPermission(p string) bool {
+	return rolePermissions[models.RoleAdmin][Permission(p)]
+}
+
+// Permitted reports whether the authenticated caller holds permission.
+// API keys scoped to explicit permissions are checked against that set;
+// everyone else gets the permissions of their role.
+func Permitted(c *gin.Context, permission Permission) bool {
+	if perms, ok := c.Get(PermissionsKey); ok {
+		set, _ := perms.(map[Permission]bool)
+		return set[permission]
+	}
+	return HasPermission(c.GetString(RoleKey), permission)
+}
+
+// RolePermissions returns the permissions granted by role, sorted.
+func RolePermissions(role string) []Permission {
+	perms := make([]Permission, 0, len(rolePermissions[role]))
+	for p := range rolePermissions[role] {
+		perms = append(perms, p)
+	}
+	sort.Slice(perms, func(i, j int) bool { return perms[i] < perms[j] })
+	return perms
+}
+
+// RequirePermission creates a middleware that only admits callers holding
+// permission (see Permitted). Denials get a 403 and are written to the audit log.
+// It must run after AuthMiddleware.
+func RequirePermission(audit storage.AuditStorage, permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString(RoleKey)
+		if Permitted(c, permission) {
+			c.Next()
+			return
+		}
+
+		appErr := errors.Forbidden(fmt.Sprintf("permission %s required", permission), nil).
+			WithErrorCode(errors.CodePermissionDenied).
+			WithContext("permission", permission)
+
+		logger.Warnw("permission denied",
+			"request_id", c.GetString(RequestIDKey),
+			"user_id", c.GetString(UserIDKey),
+			"role", role,
+			"permission", permission,
+			"path", c.Request.URL.Path,
+		)
+
+		RecordAudit(c, audit, string(permission), models.AuditDenied, appErr.Message)
+
+		_ = c.Error(appErr)
+		c.Abort()
+	}
+}
+
+// RecordAudit writes an audit entry describing the current request.
+// Failures are logged and otherwise ignored so auditing never blocks a response.
+func RecordAudit(c *gin.Context, audit storage.AuditStorage, action, outcome, detail string) {
+	entry := &models.AuditEntry{
+		Timestamp: time.Now(),
+		RequestID: c.GetString(RequestIDKey),
+		UserID:    c.GetString(UserIDKey),
+		Username:  c.GetString(UsernameKey),
+		Role:      c.GetString(RoleKey),
+		Action:    action,
+		Outcome:   outcome,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		ClientIP:  c.ClientIP(),
+		Detail:    detail,
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := audit.RecordAuditEntry(ctx, entry); err != nil {
+		logger.Errorw("failed to record audit entry",
+			"request_id", entry.RequestID,
+			"action", action,
+			"error", err,
+		)
+	}
+}
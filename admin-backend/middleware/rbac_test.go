@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"admin-backend/storage"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minimumRole is the least privileged role granted each permission.
+var minimumRole = map[Permission]string{
+	PermAppsRead:            models.RoleViewer,
+	PermAppsWrite:           models.RoleOperator,
+	PermAppsDelete:          models.RoleAdmin,
+	PermClustersRead:        models.RoleViewer,
+	PermClustersWrite:       models.RoleOperator,
+	PermClustersDelete:      models.RoleAdmin,
+	PermConnectionsRead:     models.RoleViewer,
+	PermConnectionsWrite:    models.RoleOperator,
+	PermEmergencyRead:       models.RoleViewer,
+	PermEmergencyActivate:   models.RoleOperator,
+	PermEmergencyDeactivate: models.RoleOperator,
+	PermMetricsRead:         models.RoleViewer,
+	PermUsersManage:         models.RoleAdmin,
+	PermAuditRead:           models.RoleAdmin,
+	PermAPIKeysManage:       models.RoleAdmin,
+}
+
+// roleRank orders the roles from least to most privileged.
+var roleRank = map[string]int{
+	models.RoleViewer:   1,
+	models.RoleOperator: 2,
+	models.RoleAdmin:    3,
+}
+
+// servePermission sends a request through RequirePermission as a caller with
+// the given role and, if not nil, API key permissions.
+func servePermission(store storage.AuditStorage, permission Permission, role string, keyPerms []Permission) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Use(RequestIDMiddleware(), ErrorHandler())
+	r.GET("/resource", func(c *gin.Context) {
+		c.Set(UserIDKey, "caller")
+		c.Set(RoleKey, role)
+		if keyPerms != nil {
+			c.Set(PermissionsKey, permissionSet(keyPerms))
+		}
+	}, RequirePermission(store, permission), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/resource", nil))
+	return w
+}
+
+func TestRequirePermissionMatrix(t *testing.T) {
+	if len(minimumRole) != len(RolePermissions(models.RoleAdmin)) {
+		t.Fatalf("minimumRole lists %d permissions, admin holds %d", len(minimumRole), len(RolePermissions(models.RoleAdmin)))
+	}
+
+	for permission, minimum := range minimumRole {
+		for _, role := range []string{models.RoleViewer, models.RoleOperator, models.RoleAdmin, "unknown"} {
+			allowed := roleRank[role] >= roleRank[minimum]
+
+			t.Run(string(permission)+"/"+role, func(t *testing.T) {
+				store := storage.NewMemoryStorage()
+				defer store.Close()
+
+				w := servePermission(store, permission, role, nil)
+				entries, err := store.ListAuditEntries(context.Background(), 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if allowed {
+					if w.Code != http.StatusOK {
+						t.Fatalf("status = %d, want 200", w.Code)
+					}
+					if len(entries) != 0 {
+						t.Errorf("allowed request audited: %+v", entries)
+					}
+					return
+				}
+
+				if w.Code != http.StatusForbidden {
+					t.Fatalf("status = %d, want 403", w.Code)
+				}
+				var problem errors.Problem
+				if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+					t.Fatal(err)
+				}
+				if problem.Code != errors.CodePermissionDenied {
+					t.Errorf("code = %q, want %q", problem.Code, errors.CodePermissionDenied)
+				}
+				if len(entries) != 1 || entries[0].Action != string(permission) ||
+					entries[0].Outcome != models.AuditDenied || entries[0].Role != role {
+					t.Errorf("denial audited as %+v", entries)
+				}
+			})
+		}
+	}
+}
+
+func TestRequirePermissionAPIKeyScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		keyPerms   []Permission
+		permission Permission
+		wantStatus int
+	}{
+		{name: "permission in scope", keyPerms: []Permission{PermAppsRead}, permission: PermAppsRead, wantStatus: http.StatusOK},
+		{name: "permission out of scope", keyPerms: []Permission{PermAppsRead}, permission: PermAppsWrite, wantStatus: http.StatusForbidden},
+		{name: "scope narrows the role", role: models.RoleAdmin, keyPerms: []Permission{PermAppsRead}, permission: PermAppsDelete, wantStatus: http.StatusForbidden},
+		{name: "empty scope", keyPerms: []Permission{}, permission: PermAppsRead, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			defer store.Close()
+
+			if w := servePermission(store, tt.permission, tt.role, tt.keyPerms); w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
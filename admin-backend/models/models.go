@@ -2,51 +2,201 @@ package models
 
 import "time"
 
-// AppConfig 应用配置
+// AppConfig 应用配置（校验规则见 validation.AppConfigSchema）
 type AppConfig struct {
-	AppID           string    `json:"app_id" binding:"required"`
-	GuaranteedQuota int64     `json:"guaranteed_quota" binding:"required,min=1"`
-	BurstQuota      int64     `json:"burst_quota"`
-	Priority        int       `json:"priority" binding:"min=0,max=3"`
-	MaxBorrow       int64     `json:"max_borrow"`
-	MaxConnections  int64     `json:"max_connections"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	AppID           string `json:"app_id"`
+	GuaranteedQuota int64  `json:"guaranteed_quota"`
+	BurstQuota      int64  `json:"burst_quota"`
+	Priority        int    `json:"priority"`
+	MaxBorrow       int64  `json:"max_borrow"`
+	MaxConnections  int64  `json:"max_connections"`
+	// Clusters 应用所属集群；为空时应用计入所有集群的容量
+	Clusters  []ClusterAssignment `json:"clusters,omitempty"`
+	Version   int64               `json:"version"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// ClusterAssignment 应用在集群上的配额分配
+type ClusterAssignment struct {
+	ClusterID string `json:"cluster_id"`
+	// GuaranteedQuota 应用在该集群上的保证配额；为 0 时使用应用的全部保证配额
+	GuaranteedQuota int64 `json:"guaranteed_quota,omitempty"`
+}
+
+// ClusterApp 集群成员应用
+type ClusterApp struct {
+	AppID string `json:"app_id"`
+	// GuaranteedQuota 应用在该集群上的保证配额
+	GuaranteedQuota int64      `json:"guaranteed_quota"`
+	App             *AppConfig `json:"app"`
 }
 
-// ClusterConfig 集群配置
+// ClusterConfig 集群配置（校验规则见 validation.ClusterConfigSchema）
 type ClusterConfig struct {
-	ClusterID          string    `json:"cluster_id" binding:"required"`
-	MaxCapacity        int64     `json:"max_capacity" binding:"required,min=1"`
+	ClusterID          string    `json:"cluster_id"`
+	MaxCapacity        int64     `json:"max_capacity"`
 	ReservedRatio      float64   `json:"reserved_ratio"`
 	EmergencyThreshold float64   `json:"emergency_threshold"`
 	MaxConnections     int64     `json:"max_connections"`
+	Version            int64     `json:"version"`
 	UpdatedAt          time.Time `json:"updated_at"`
 }
 
-// ConnectionLimit 连接限制配置
+// GatewayClusterStatus 网关 L1 集群桶的实时状态
+type GatewayClusterStatus struct {
+	Capacity int64 `json:"capacity"`
+	// Available 未分配给应用的容量
+	Available int64 `json:"available"`
+	// Allocated 已分配给应用的容量 (capacity - available)
+	Allocated     int64   `json:"allocated"`
+	ReservedRatio float64 `json:"reserved_ratio"`
+	// Remaining 仍可分配的容量 (available - 预留容量)，不小于 0
+	Remaining          int64      `json:"remaining"`
+	UsageRatio         float64    `json:"usage_ratio"`
+	EmergencyThreshold float64    `json:"emergency_threshold"`
+	EmergencyMode      bool       `json:"emergency_mode"`
+	LastReconcile      *time.Time `json:"last_reconcile,omitempty"`
+}
+
+// AppImportRequest 应用配置批量导入请求
+type AppImportRequest struct {
+	Apps []AppConfig `json:"apps"`
+}
+
+// 配置资源类型
+const (
+	ResourceApp     = "app"
+	ResourceCluster = "cluster"
+)
+
+// 配置变更动作
+const (
+	ChangeCreate   = "create"
+	ChangeUpdate   = "update"
+	ChangeDelete   = "delete"
+	ChangeRollback = "rollback"
+)
+
+// ConfigSnapshot 配置历史快照
+type ConfigSnapshot struct {
+	ResourceType string         `json:"resource_type"`
+	ResourceID   string         `json:"resource_id"`
+	Revision     int64          `json:"revision"`
+	Version      int64          `json:"version"`
+	Action       string         `json:"action"`
+	Author       string         `json:"author"`
+	Reason       string         `json:"reason"`
+	Timestamp    time.Time      `json:"timestamp"`
+	App          *AppConfig     `json:"app,omitempty"`
+	Cluster      *ClusterConfig `json:"cluster,omitempty"`
+}
+
+// FieldChange 配置字段差异
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// RollbackRequest 配置回滚请求
+type RollbackRequest struct {
+	Revision int64  `json:"revision" binding:"required,min=1"`
+	Reason   string `json:"reason"`
+}
+
+// ConnectionLimit 连接限制配置（校验规则见 validation.ConnectionLimitSchema）
+// 限制保存为目标应用或集群的 max_connections
 type ConnectionLimit struct {
-	TargetType string `json:"target_type" binding:"required,oneof=app cluster"`
-	TargetID   string `json:"target_id" binding:"required"`
-	Limit      int64  `json:"limit" binding:"required,min=1"`
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	Limit      int64  `json:"limit"`
 }
 
-// ConnectionStats 连接统计
+// ConnectionStats 连接统计（各网关节点汇总）
 type ConnectionStats struct {
-	Type     string `json:"type"`
-	ID       string `json:"id"`
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Current int64  `json:"current"`
+	// Limit 配置的连接限制；未配置时为节点上报的最大限制
+	Limit int64 `json:"limit"`
+	// Peak 各节点峰值中的最大值，为集群整体峰值的下限
+	Peak     int64 `json:"peak"`
+	Rejected int64 `json:"rejected"`
+	// Nodes 各网关节点的统计，仅在按节点查询时返回
+	Nodes []*NodeConnectionStats `json:"nodes,omitempty"`
+}
+
+// NodeConnectionStats 单个网关节点上的连接统计
+type NodeConnectionStats struct {
+	Node     string `json:"node"`
 	Current  int64  `json:"current"`
 	Limit    int64  `json:"limit"`
 	Peak     int64  `json:"peak"`
 	Rejected int64  `json:"rejected"`
 }
 
+// ConnectionNode 网关节点上报的连接统计汇总
+type ConnectionNode struct {
+	Node          string    `json:"node"`
+	RejectedTotal int64     `json:"rejected_total"`
+	LeakedTotal   int64     `json:"leaked_total"`
+	LastCleanup   time.Time `json:"last_cleanup"`
+	LastReport    time.Time `json:"last_report"`
+}
+
 // EmergencyStatus 紧急模式状态
 type EmergencyStatus struct {
 	Active      bool      `json:"active"`
 	Reason      string    `json:"reason"`
 	ActivatedAt time.Time `json:"activated_at"`
 	ExpiresAt   time.Time `json:"expires_at"`
-	Duration    int64     `json:"duration"`
+	// Duration 激活时设定的持续时间（秒）
+	Duration int64 `json:"duration"`
+	// RemainingSeconds 距过期的剩余秒数
+	RemainingSeconds int64 `json:"remaining_seconds"`
+}
+
+// 紧急模式历史动作
+const (
+	EmergencyActivated   = "activated"
+	EmergencyExtended    = "extended"
+	EmergencyDeactivated = "deactivated"
+	EmergencyExpired     = "expired"
+)
+
+// EmergencyEvent 紧急模式历史事件
+type EmergencyEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	// Actor 操作者用户 ID，自动过期时为 system
+	Actor string `json:"actor"`
+	// Reason 紧急模式的激活原因
+	Reason string `json:"reason"`
+	// Comment 本次操作的变更说明
+	Comment     string    `json:"comment,omitempty"`
+	ActivatedAt time.Time `json:"activated_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Duration 激活或调整时为设定时长，结束时为实际持续时长（秒）
+	Duration int64 `json:"duration"`
+	// Utilization 当时网关集群的使用率
+	Utilization float64 `json:"utilization"`
+}
+
+// EmergencyTotals 紧急模式历史汇总
+type EmergencyTotals struct {
+	Activations   int              `json:"activations"`
+	Extensions    int              `json:"extensions"`
+	Deactivations int              `json:"deactivations"`
+	Expirations   int              `json:"expirations"`
+	Seconds       int64            `json:"seconds"`
+	Weeks         []*EmergencyWeek `json:"weeks"`
+}
+
+// EmergencyWeek 每周（自周一 UTC 起）的紧急模式时长
+type EmergencyWeek struct {
+	WeekStart   time.Time `json:"week_start"`
+	Activations int       `json:"activations"`
+	Seconds     int64     `json:"seconds"`
 }
 
 // EmergencyRequest 紧急模式请求
@@ -55,15 +205,43 @@ type EmergencyRequest struct {
 	Duration int64  `json:"duration"`
 }
 
+// EmergencyExtendRequest 紧急模式时长调整请求
+type EmergencyExtendRequest struct {
+	// Duration 从现在起的剩余时长（秒），可延长或缩短
+	Duration int64 `json:"duration"`
+}
+
+// EmergencySchedule 计划紧急窗口
+type EmergencySchedule struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason"`
+	StartAt   time.Time `json:"start_at"`
+	EndAt     time.Time `json:"end_at"`
+	Duration  int64     `json:"duration"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// Attempts 启动失败的次数；失败的窗口放回待启动列表，下一轮重试直至窗口结束
+	Attempts int `json:"attempts,omitempty"`
+	// LastError 最近一次启动失败的原因
+	LastError string `json:"last_error,omitempty"`
+}
+
+// EmergencyScheduleRequest 计划紧急窗口请求
+type EmergencyScheduleRequest struct {
+	Reason   string    `json:"reason"`
+	StartAt  time.Time `json:"start_at"`
+	Duration int64     `json:"duration"`
+}
+
 // Metrics 系统指标
 type Metrics struct {
-	RequestsTotal       int64   `json:"requests_total"`
-	RejectedTotal       int64   `json:"rejected_total"`
-	L3Hits              int64   `json:"l3_hits"`
-	CacheHitRatio       float64 `json:"cache_hit_ratio"`
-	EmergencyActive     bool    `json:"emergency_active"`
-	DegradationLevel    string  `json:"degradation_level"`
-	ReconcileCorrections int64  `json:"reconcile_corrections"`
+	RequestsTotal        int64   `json:"requests_total"`
+	RejectedTotal        int64   `json:"rejected_total"`
+	L3Hits               int64   `json:"l3_hits"`
+	CacheHitRatio        float64 `json:"cache_hit_ratio"`
+	EmergencyActive      bool    `json:"emergency_active"`
+	DegradationLevel     string  `json:"degradation_level"`
+	ReconcileCorrections int64   `json:"reconcile_corrections"`
 }
 
 // AppMetrics 应用指标
@@ -75,11 +253,74 @@ type AppMetrics struct {
 	PendingCost     int64  `json:"pending_cost"`
 }
 
+// 用户角色
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+// 用户身份来源
+const (
+	IdentityProviderOIDC = "oidc"
+)
+
 // User 用户
 type User struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	ID               string    `json:"id"`
+	Username         string    `json:"username"`
+	Role             string    `json:"role"`
+	PasswordHash     string    `json:"-"`
+	IdentityProvider string    `json:"identity_provider,omitempty"`
+	ExternalID       string    `json:"external_id,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// CreateUserRequest 创建用户请求
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role" binding:"required"`
+}
+
+// UpdateUserRequest 更新用户请求
+type UpdateUserRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// ChangePasswordRequest 修改密码请求
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// APIKey 服务账号 API 密钥
+type APIKey struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	KeyHash     string     `json:"-"`
+	Role        string     `json:"role,omitempty"`
+	Permissions []string   `json:"permissions,omitempty"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreateAPIKeyRequest 创建 API 密钥请求
+type CreateAPIKeyRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+	ExpiresIn   int64    `json:"expires_in"`
+}
+
+// CreateAPIKeyResponse 创建 API 密钥响应（明文密钥仅返回一次）
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
 }
 
 // LoginRequest 登录请求
@@ -88,6 +329,29 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// OIDCLoginState OIDC 登录流程状态
+type OIDCLoginState struct {
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// 登录失败计数范围
+const (
+	LoginScopeUser = "user"
+	LoginScopeIP   = "ip"
+)
+
+// LoginAttempts 登录失败记录
+type LoginAttempts struct {
+	Scope         string     `json:"scope"`
+	Key           string     `json:"key"`
+	Failures      int64      `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"`
+	Locked        bool       `json:"locked"`
+}
+
 // TokenResponse Token 响应
 type TokenResponse struct {
 	AccessToken  string `json:"access_token"`
@@ -95,6 +359,30 @@ type TokenResponse struct {
 	ExpiresIn    int64  `json:"expires_in"`
 }
 
+// 审计结果
+const (
+	AuditAllowed = "allowed"
+	AuditDenied  = "denied"
+	AuditFailed  = "failed"
+	AuditBlocked = "blocked"
+	AuditLocked  = "locked"
+)
+
+// AuditEntry 审计日志条目
+type AuditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	Action    string    `json:"action"`
+	Outcome   string    `json:"outcome"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	ClientIP  string    `json:"client_ip"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
 // WebSocketMessage WebSocket 消息
 type WebSocketMessage struct {
 	Type      string      `json:"type"`
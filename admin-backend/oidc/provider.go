@@ -0,0 +1,267 @@
+// Package oidc provides OpenID Connect single sign-on against an external identity provider.
+package oidc
+
+import (
+	"admin-backend/config"
+	"admin-backend/errors"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// httpTimeout bounds every request to the identity provider
+	httpTimeout = 10 * time.Second
+	// clockSkew is the leeway allowed when checking ID token times
+	clockSkew = time.Minute
+	// maxResponseSize caps the size of identity provider responses
+	maxResponseSize = 1 << 20
+)
+
+// Identity is a user authenticated by the identity provider.
+type Identity struct {
+	// Subject is the provider's stable, unique user ID (the "sub" claim)
+	Subject string
+	// Username is the value of the configured username claim
+	Username string
+	// Email is the user's email address, if the provider shared it
+	Email string
+	// Groups are the values of the configured groups claim
+	Groups []string
+}
+
+// Provider authenticates users with the authorization code flow.
+// Implementations other than the discovery-based one are mainly useful as
+// local stand-ins for an issuer in tests.
+type Provider interface {
+	// AuthCodeURL returns the provider URL the browser is sent to for login.
+	// codeChallenge is the S256 PKCE challenge for the verifier passed to Exchange.
+	AuthCodeURL(state, nonce, codeChallenge string) string
+
+	// Exchange redeems an authorization code, verifies the returned ID token
+	// (signature, issuer, audience, expiry and nonce) and returns the identity it asserts.
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error)
+}
+
+// discoveryProvider is a Provider configured from the issuer's discovery document.
+type discoveryProvider struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	scopes        []string
+	usernameClaim string
+	groupsClaim   string
+
+	authEndpoint  string
+	tokenEndpoint string
+	keys          *remoteKeySet
+	client        *http.Client
+}
+
+// discoveryDocument holds the fields of /.well-known/openid-configuration used here.
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// tokenResponse is the token endpoint response (RFC 6749 section 5).
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// NewProvider fetches the issuer's discovery document and returns a Provider for it.
+func NewProvider(ctx context.Context, cfg *config.OIDCConfig) (Provider, error) {
+	if cfg == nil {
+		return nil, errors.BadRequest("OIDC config cannot be nil", nil)
+	}
+
+	client := &http.Client{Timeout: httpTimeout}
+	issuer := strings.TrimSuffix(cfg.IssuerURL, "/")
+
+	var doc discoveryDocument
+	if err := getJSON(ctx, client, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, errors.InternalServerError("failed to fetch OIDC discovery document", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, errors.BadRequest(
+			fmt.Sprintf("OIDC discovery issuer %q does not match %q", doc.Issuer, cfg.IssuerURL),
+			nil,
+		)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.BadRequest("OIDC discovery document is missing endpoints", nil)
+	}
+
+	return &discoveryProvider{
+		issuer:        doc.Issuer,
+		clientID:      cfg.ClientID,
+		clientSecret:  cfg.ClientSecret,
+		redirectURL:   cfg.RedirectURL,
+		scopes:        cfg.Scopes,
+		usernameClaim: cfg.UsernameClaim,
+		groupsClaim:   cfg.GroupsClaim,
+		authEndpoint:  doc.AuthorizationEndpoint,
+		tokenEndpoint: doc.TokenEndpoint,
+		keys:          newRemoteKeySet(client, doc.JWKSURI),
+		client:        client,
+	}, nil
+}
+
+// AuthCodeURL returns the authorization endpoint URL for a login attempt.
+func (p *discoveryProvider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {strings.Join(p.scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		sep = "&"
+	}
+	return p.authEndpoint + sep + params.Encode()
+}
+
+// Exchange redeems code at the token endpoint and verifies the ID token.
+func (p *discoveryProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.InternalServerError("failed to build token request", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.InternalServerError("token request failed", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tokens); err != nil {
+		return nil, errors.InternalServerError("invalid token response", err)
+	}
+	if tokens.Error != "" {
+		return nil, errors.Unauthorized(fmt.Sprintf("token request rejected: %s %s", tokens.Error, tokens.ErrorDescription), nil)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, errors.InternalServerError(fmt.Sprintf("token endpoint returned status %d without an ID token", resp.StatusCode), nil)
+	}
+
+	return p.verifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// verifyIDToken checks an ID token and extracts the identity it asserts.
+func (p *discoveryProvider) verifyIDToken(ctx context.Context, idToken, nonce string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.keys.key(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, errors.Unauthorized("invalid ID token", err)
+	}
+
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, errors.Unauthorized("ID token nonce does not match", nil)
+	}
+
+	identity := &Identity{
+		Username: stringClaim(claims, p.usernameClaim),
+		Email:    stringClaim(claims, "email"),
+		Groups:   stringsClaim(claims, p.groupsClaim),
+	}
+	identity.Subject, _ = claims.GetSubject()
+	if identity.Subject == "" {
+		return nil, errors.Unauthorized("ID token has no subject", nil)
+	}
+
+	return identity, nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge for verifier (RFC 7636).
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// stringClaim returns a string claim, or "" if it is missing or not a string.
+func stringClaim(claims jwt.MapClaims, name string) string {
+	v, _ := claims[name].(string)
+	return v
+}
+
+// stringsClaim returns a claim holding a list of strings; a single string is
+// treated as a one-element list. Non-string entries are skipped.
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// getJSON fetches url and decodes its JSON body into out.
+func getJSON(ctx context.Context, client *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(out)
+}
@@ -0,0 +1,221 @@
+package oidc
+
+import (
+	"admin-backend/config"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testIssuer is an identity provider serving discovery, JWKS and a token
+// endpoint that answers every code with an ID token carrying claims.
+type testIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	// signingKey, if set, signs tokens instead of the published key
+	signingKey *rsa.PrivateKey
+	// verifier is the code_verifier of the last token request
+	verifier string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(discoveryDocument{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kty: "RSA",
+			Kid: "k1",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		issuer.verifier = r.PostForm.Get("code_verifier")
+		_ = json.NewEncoder(w).Encode(tokenResponse{IDToken: issuer.sign(issuer.claims)})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+// sign returns claims as an RS256 ID token signed with the issuer's key.
+func (i *testIssuer) sign(claims jwt.MapClaims) string {
+	i.t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	key := i.key
+	if i.signingKey != nil {
+		key = i.signingKey
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	return signed
+}
+
+// validClaims returns the claims of an ID token the provider accepts for nonce.
+func (i *testIssuer) validClaims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                i.server.URL,
+		"aud":                "console",
+		"sub":                "user-123",
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"groups":             []string{"sre", "engineering"},
+	}
+}
+
+func (i *testIssuer) provider() Provider {
+	i.t.Helper()
+
+	p, err := NewProvider(context.Background(), &config.OIDCConfig{
+		IssuerURL:     i.server.URL,
+		ClientID:      "console",
+		ClientSecret:  "secret",
+		RedirectURL:   "https://console.example.com/api/v1/auth/oidc/callback",
+		Scopes:        []string{"openid", "profile"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+	})
+	if err != nil {
+		i.t.Fatalf("NewProvider: %v", err)
+	}
+	return p
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := newTestIssuer(t)
+	p := issuer.provider()
+
+	u, err := url.Parse(p.AuthCodeURL("the-state", "the-nonce", CodeChallenge("the-verifier")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != issuer.server.URL+"/authorize" {
+		t.Errorf("endpoint = %s", got)
+	}
+
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "console",
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"scope":                 "openid profile",
+		"code_challenge":        CodeChallenge("the-verifier"),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := q.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	p := issuer.provider()
+
+	issuer.claims = issuer.validClaims("the-nonce")
+	identity, err := p.Exchange(context.Background(), "code", "the-verifier", "the-nonce")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := &Identity{
+		Subject:  "user-123",
+		Username: "alice",
+		Email:    "alice@example.com",
+		Groups:   []string{"sre", "engineering"},
+	}
+	if !reflect.DeepEqual(identity, want) {
+		t.Errorf("identity = %+v, want %+v", identity, want)
+	}
+	if issuer.verifier != "the-verifier" {
+		t.Errorf("code_verifier = %q, want the-verifier", issuer.verifier)
+	}
+}
+
+func TestExchangeRejectsToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	p := issuer.provider()
+
+	tests := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+	}{
+		{name: "nonce of another login", modify: func(claims jwt.MapClaims) { claims["nonce"] = "other-nonce" }},
+		{name: "no nonce", modify: func(claims jwt.MapClaims) { delete(claims, "nonce") }},
+		{name: "other issuer", modify: func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }},
+		{name: "other audience", modify: func(claims jwt.MapClaims) { claims["aud"] = "another-client" }},
+		{name: "expired", modify: func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "no subject", modify: func(claims jwt.MapClaims) { delete(claims, "sub") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer.claims = issuer.validClaims("the-nonce")
+			tt.modify(issuer.claims)
+
+			if identity, err := p.Exchange(context.Background(), "code", "the-verifier", "the-nonce"); err == nil {
+				t.Errorf("Exchange accepted the token: %+v", identity)
+			}
+		})
+	}
+}
+
+func TestExchangeRejectsForeignSignature(t *testing.T) {
+	issuer := newTestIssuer(t)
+	p := issuer.provider()
+
+	// Same kid as the published key, signed with a different one
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.signingKey = other
+	issuer.claims = issuer.validClaims("the-nonce")
+
+	if identity, err := p.Exchange(context.Background(), "code", "the-verifier", "the-nonce"); err == nil {
+		t.Errorf("Exchange accepted a token signed with a foreign key: %+v", identity)
+	}
+}
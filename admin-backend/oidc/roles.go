@@ -0,0 +1,27 @@
+// Package oidc provides the mapping from identity provider groups to console roles.
+package oidc
+
+import "admin-backend/models"
+
+// roleRank orders console roles so the most privileged mapped role wins.
+var roleRank = map[string]int{
+	models.RoleViewer:   1,
+	models.RoleOperator: 2,
+	models.RoleAdmin:    3,
+}
+
+// MapRole returns the most privileged role mapped from any of groups, or
+// defaultRole if none is mapped. An empty result means the user may not log in.
+func MapRole(groups []string, roleMap map[string]string, defaultRole string) string {
+	role := ""
+	for _, group := range groups {
+		if mapped, ok := roleMap[group]; ok && roleRank[mapped] > roleRank[role] {
+			role = mapped
+		}
+	}
+
+	if role == "" {
+		return defaultRole
+	}
+	return role
+}
@@ -0,0 +1,38 @@
+package oidc
+
+import (
+	"admin-backend/models"
+	"testing"
+)
+
+func TestMapRole(t *testing.T) {
+	roleMap := map[string]string{
+		"platform-admins": models.RoleAdmin,
+		"sre":             models.RoleOperator,
+		"engineering":     models.RoleViewer,
+	}
+
+	tests := []struct {
+		name        string
+		groups      []string
+		defaultRole string
+		want        string
+	}{
+		{name: "single mapped group", groups: []string{"sre"}, want: models.RoleOperator},
+		{name: "most privileged group wins", groups: []string{"engineering", "platform-admins", "sre"}, want: models.RoleAdmin},
+		{name: "unmapped groups are ignored", groups: []string{"finance", "engineering"}, want: models.RoleViewer},
+		{name: "no mapped group falls back to the default", groups: []string{"finance"}, defaultRole: models.RoleViewer, want: models.RoleViewer},
+		{name: "no mapped group and no default denies", groups: []string{"finance"}, want: ""},
+		{name: "no groups", defaultRole: models.RoleViewer, want: models.RoleViewer},
+		{name: "mapped group beats the default", groups: []string{"engineering"}, defaultRole: models.RoleOperator, want: models.RoleViewer},
+		{name: "group names are case sensitive", groups: []string{"SRE"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MapRole(tt.groups, roleMap, tt.defaultRole); got != tt.want {
+				t.Errorf("MapRole(%v) = %q, want %q", tt.groups, got, tt.want)
+			}
+		})
+	}
+}
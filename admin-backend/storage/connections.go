@@ -0,0 +1,192 @@
+// Package storage provides the connection statistics aggregation shared by all storage backends.
+package storage
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connection statistics target types, as used in the gateway's report fields
+const (
+	connectionTargetApp     = "app"
+	connectionTargetCluster = "cluster"
+)
+
+// nodeReport is the connection report of one gateway node.
+type nodeReport struct {
+	node    *models.ConnectionNode
+	targets []nodeTarget
+}
+
+// nodeTarget is the statistics of one app or cluster on a gateway node.
+type nodeTarget struct {
+	key   string
+	stats *models.NodeConnectionStats
+}
+
+// parseNodeReport parses the hash a gateway node writes to connlimit:stats:node:<addr>.
+// Fields other than the totals are "app:<id>" or "cluster:<id>" holding the
+// JSON statistics of the target on that node.
+func parseNodeReport(node string, data map[string]string) *nodeReport {
+	unixTime := func(v string) time.Time {
+		ts, _ := strconv.ParseFloat(v, 64)
+		if ts <= 0 {
+			return time.Time{}
+		}
+		return time.Unix(int64(ts), 0)
+	}
+
+	report := &nodeReport{node: &models.ConnectionNode{Node: node}}
+	for field, v := range data {
+		switch field {
+		case "rejected_total":
+			report.node.RejectedTotal, _ = strconv.ParseInt(v, 10, 64)
+		case "leaked_total":
+			report.node.LeakedTotal, _ = strconv.ParseInt(v, 10, 64)
+		case "last_cleanup":
+			report.node.LastCleanup = unixTime(v)
+		case "last_report":
+			report.node.LastReport = unixTime(v)
+		default:
+			targetType, id, ok := strings.Cut(field, ":")
+			if !ok || id == "" || (targetType != connectionTargetApp && targetType != connectionTargetCluster) {
+				continue
+			}
+			stats := &models.NodeConnectionStats{}
+			if err := json.Unmarshal([]byte(v), stats); err != nil {
+				continue
+			}
+			stats.Node = node
+			report.targets = append(report.targets, nodeTarget{key: field, stats: stats})
+		}
+	}
+	return report
+}
+
+// aggregateConnections sums the node reports per target and applies the
+// configured limits. The peak is the highest node peak, a lower bound of the
+// cluster-wide peak. The limit is the cluster-wide max_connections whether or
+// not byNode is set; only unconfigured targets fall back to the highest limit
+// a node reports. Apps come before clusters, each ordered by ID.
+func aggregateConnections(apps []*models.AppConfig, clusters []*models.ClusterConfig, reports []*nodeReport, byNode bool) []*models.ConnectionStats {
+	// Keyed like the report fields, "<type>:<id>"
+	targets := make(map[string]*models.ConnectionStats)
+	configured := make(map[string]bool)
+	target := func(key string) *models.ConnectionStats {
+		stats, ok := targets[key]
+		if !ok {
+			targetType, id, _ := strings.Cut(key, ":")
+			stats = &models.ConnectionStats{Type: targetType, ID: id}
+			targets[key] = stats
+		}
+		return stats
+	}
+
+	for _, app := range apps {
+		key := connectionTargetApp + ":" + app.AppID
+		target(key).Limit = app.MaxConnections
+		configured[key] = true
+	}
+	for _, cluster := range clusters {
+		key := connectionTargetCluster + ":" + cluster.ClusterID
+		target(key).Limit = cluster.MaxConnections
+		configured[key] = true
+	}
+
+	for _, report := range reports {
+		for _, t := range report.targets {
+			stats := target(t.key)
+			stats.Current += t.stats.Current
+			if t.stats.Peak > stats.Peak {
+				stats.Peak = t.stats.Peak
+			}
+			stats.Rejected += t.stats.Rejected
+			if !configured[t.key] && t.stats.Limit > stats.Limit {
+				stats.Limit = t.stats.Limit
+			}
+			if byNode {
+				stats.Nodes = append(stats.Nodes, t.stats)
+			}
+		}
+	}
+
+	result := make([]*models.ConnectionStats, 0, len(targets))
+	for _, stats := range targets {
+		sort.Slice(stats.Nodes, func(i, j int) bool { return stats.Nodes[i].Node < stats.Nodes[j].Node })
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Type != result[j].Type {
+			return result[i].Type == connectionTargetApp
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// configStorage is the part of a backend that holds app and cluster configs.
+type configStorage interface {
+	AppStorage
+	ClusterStorage
+}
+
+// setConnectionLimit sets the max_connections of the limit's target, retrying
+// if the target changes underneath so no other field is overwritten.
+// Returns the target's new version.
+func setConnectionLimit(ctx context.Context, s configStorage, limit *models.ConnectionLimit) (int64, error) {
+	for attempt := 1; ; attempt++ {
+		var err error
+		var version int64
+		switch limit.TargetType {
+		case connectionTargetApp:
+			var config *models.AppConfig
+			if config, err = s.GetAppConfig(ctx, limit.TargetID); err != nil {
+				return 0, err
+			}
+			if config == nil {
+				return 0, errors.NotFound("app not found", nil)
+			}
+			config.MaxConnections = limit.Limit
+			err = s.CompareAndSetAppConfig(ctx, config, config.Version)
+			version = config.Version
+		case connectionTargetCluster:
+			var config *models.ClusterConfig
+			if config, err = s.GetClusterConfig(ctx, limit.TargetID); err != nil {
+				return 0, err
+			}
+			if config == nil {
+				return 0, errors.NotFound("cluster not found", nil)
+			}
+			config.MaxConnections = limit.Limit
+			err = s.CompareAndSetClusterConfig(ctx, config, config.Version)
+			version = config.Version
+		default:
+			return 0, errors.BadRequest("target type must be app or cluster", nil)
+		}
+
+		if err == nil {
+			return version, nil
+		}
+		if errors.Resolve(err).ErrorCode != errors.CodeVersionConflict || attempt >= maxVersionRetries {
+			return 0, err
+		}
+	}
+}
+
+// connectionLimits lists the configured limits of apps and clusters.
+func connectionLimits(apps []*models.AppConfig, clusters []*models.ClusterConfig) []*models.ConnectionLimit {
+	limits := make([]*models.ConnectionLimit, 0, len(apps)+len(clusters))
+	for _, app := range apps {
+		limits = append(limits, &models.ConnectionLimit{TargetType: connectionTargetApp, TargetID: app.AppID, Limit: app.MaxConnections})
+	}
+	for _, cluster := range clusters {
+		limits = append(limits, &models.ConnectionLimit{TargetType: connectionTargetCluster, TargetID: cluster.ClusterID, Limit: cluster.MaxConnections})
+	}
+	return limits
+}
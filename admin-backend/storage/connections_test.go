@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testNodeReports returns the reports of node-b and node-a, out of order,
+// as parsed from the hashes the gateway nodes write.
+func testNodeReports() []*nodeReport {
+	return []*nodeReport{
+		parseNodeReport("node-b", map[string]string{
+			"app:a1":         `{"current":15,"limit":60,"peak":25,"rejected":1}`,
+			"app:ghost":      `{"current":2,"limit":40,"peak":5}`,
+			"cluster:c1":     `{"current":5,"peak":90}`,
+			"rejected_total": "7",
+		}),
+		parseNodeReport("node-a", map[string]string{
+			"app:a1":     `{"current":10,"limit":50,"peak":30,"rejected":2}`,
+			"app:ghost":  `{"current":1,"limit":20,"peak":3}`,
+			"cluster:c1": `{"current":40,"peak":60}`,
+			// Not targets
+			"last_report": "1700000000",
+			"user:u1":     `{"current":1}`,
+			"app:":        `{"current":1}`,
+			"app:broken":  `{"current":`,
+		}),
+	}
+}
+
+func TestAggregateConnections(t *testing.T) {
+	apps := []*models.AppConfig{{AppID: "idle", MaxConnections: 7}, {AppID: "a1", MaxConnections: 100}}
+	clusters := []*models.ClusterConfig{{ClusterID: "c1", MaxConnections: 1000}}
+
+	tests := []struct {
+		name    string
+		reports []*nodeReport
+		byNode  bool
+		want    []*models.ConnectionStats
+	}{
+		{
+			name: "no reports",
+			want: []*models.ConnectionStats{
+				{Type: "app", ID: "a1", Limit: 100},
+				{Type: "app", ID: "idle", Limit: 7},
+				{Type: "cluster", ID: "c1", Limit: 1000},
+			},
+		},
+		{
+			// Current and rejected are summed; the peak is the highest node peak
+			name:    "summed across nodes",
+			reports: testNodeReports(),
+			want: []*models.ConnectionStats{
+				{Type: "app", ID: "a1", Current: 25, Limit: 100, Peak: 30, Rejected: 3},
+				{Type: "app", ID: "ghost", Current: 3, Limit: 40, Peak: 5},
+				{Type: "app", ID: "idle", Limit: 7},
+				{Type: "cluster", ID: "c1", Current: 45, Limit: 1000, Peak: 90},
+			},
+		},
+		{
+			name:    "by node",
+			reports: testNodeReports(),
+			byNode:  true,
+			want: []*models.ConnectionStats{
+				{Type: "app", ID: "a1", Current: 25, Limit: 100, Peak: 30, Rejected: 3, Nodes: []*models.NodeConnectionStats{
+					{Node: "node-a", Current: 10, Limit: 50, Peak: 30, Rejected: 2},
+					{Node: "node-b", Current: 15, Limit: 60, Peak: 25, Rejected: 1},
+				}},
+				{Type: "app", ID: "ghost", Current: 3, Limit: 40, Peak: 5, Nodes: []*models.NodeConnectionStats{
+					{Node: "node-a", Current: 1, Limit: 20, Peak: 3},
+					{Node: "node-b", Current: 2, Limit: 40, Peak: 5},
+				}},
+				{Type: "app", ID: "idle", Limit: 7},
+				{Type: "cluster", ID: "c1", Current: 45, Limit: 1000, Peak: 90, Nodes: []*models.NodeConnectionStats{
+					{Node: "node-a", Current: 40, Peak: 60},
+					{Node: "node-b", Current: 5, Peak: 90},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateConnections(apps, clusters, tt.reports, tt.byNode)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("stats = %s\nwant %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+// racingStorage is a configStorage that lets another writer change the target
+// before each of its first races compare-and-set writes.
+type racingStorage struct {
+	Storage
+	races    int
+	attempts int
+}
+
+func (s *racingStorage) CompareAndSetAppConfig(ctx context.Context, config *models.AppConfig, expectedVersion int64) error {
+	s.attempts++
+	if s.attempts <= s.races {
+		other, err := s.GetAppConfig(ctx, config.AppID)
+		if err != nil {
+			return err
+		}
+		other.GuaranteedQuota++
+		if err := s.SetAppConfig(ctx, other); err != nil {
+			return err
+		}
+	}
+	return s.Storage.CompareAndSetAppConfig(ctx, config, expectedVersion)
+}
+
+func (s *racingStorage) CompareAndSetClusterConfig(ctx context.Context, config *models.ClusterConfig, expectedVersion int64) error {
+	s.attempts++
+	if s.attempts <= s.races {
+		other, err := s.GetClusterConfig(ctx, config.ClusterID)
+		if err != nil {
+			return err
+		}
+		other.MaxCapacity++
+		if err := s.SetClusterConfig(ctx, other); err != nil {
+			return err
+		}
+	}
+	return s.Storage.CompareAndSetClusterConfig(ctx, config, expectedVersion)
+}
+
+func TestSetConnectionLimitRetries(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit models.ConnectionLimit
+		races int
+		// wantVersion and wantQuota are the target's version and its guaranteed
+		// quota or capacity, which the racing writes raise, after the write
+		wantVersion  int64
+		wantQuota    int64
+		wantAttempts int
+		// wantStatus is the HTTP status of the error, 0 when the write succeeds
+		wantStatus int
+	}{
+		{name: "app", limit: models.ConnectionLimit{TargetType: "app", TargetID: "a1", Limit: 20}, wantVersion: 2, wantQuota: 100, wantAttempts: 1},
+		{name: "app changed underneath", limit: models.ConnectionLimit{TargetType: "app", TargetID: "a1", Limit: 20}, races: 1, wantVersion: 3, wantQuota: 101, wantAttempts: 2},
+		{name: "cluster changed underneath twice", limit: models.ConnectionLimit{TargetType: "cluster", TargetID: "c1", Limit: 300}, races: 2, wantVersion: 4, wantQuota: 1002, wantAttempts: 3},
+		{
+			name:         "gives up after the last retry",
+			limit:        models.ConnectionLimit{TargetType: "app", TargetID: "a1", Limit: 20},
+			races:        maxVersionRetries,
+			wantVersion:  maxVersionRetries + 1,
+			wantQuota:    100 + maxVersionRetries,
+			wantAttempts: maxVersionRetries,
+			wantStatus:   http.StatusConflict,
+		},
+		{name: "unknown app", limit: models.ConnectionLimit{TargetType: "app", TargetID: "nope", Limit: 20}, wantStatus: http.StatusNotFound},
+		{name: "unknown cluster", limit: models.ConnectionLimit{TargetType: "cluster", TargetID: "nope", Limit: 20}, wantStatus: http.StatusNotFound},
+		{name: "unknown target type", limit: models.ConnectionLimit{TargetType: "node", TargetID: "a1", Limit: 20}, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, backend := range testBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				store := backend.open(t)
+				ctx := context.Background()
+
+				if err := store.SetAppConfig(ctx, &models.AppConfig{AppID: "a1", GuaranteedQuota: 100, MaxConnections: 10}); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.SetClusterConfig(ctx, &models.ClusterConfig{ClusterID: "c1", MaxCapacity: 1000, ReservedRatio: 0.1, EmergencyThreshold: 0.9, MaxConnections: 100}); err != nil {
+					t.Fatal(err)
+				}
+
+				racing := &racingStorage{Storage: store, races: tt.races}
+				version, err := setConnectionLimit(ctx, racing, &tt.limit)
+				if tt.wantStatus != 0 {
+					if status := errors.Resolve(err).Code; status != tt.wantStatus {
+						t.Fatalf("error %v, want status %d", err, tt.wantStatus)
+					}
+				} else if err != nil {
+					t.Fatal(err)
+				} else if version != tt.wantVersion {
+					t.Errorf("version = %d, want %d", version, tt.wantVersion)
+				}
+				if racing.attempts != tt.wantAttempts {
+					t.Errorf("attempts = %d, want %d", racing.attempts, tt.wantAttempts)
+				}
+				if tt.wantVersion == 0 {
+					return
+				}
+
+				// The limit is set without undoing the racing writes
+				var stored struct{ version, quota, limit int64 }
+				if tt.limit.TargetType == "app" {
+					app, err := store.GetAppConfig(ctx, tt.limit.TargetID)
+					if err != nil {
+						t.Fatal(err)
+					}
+					stored.version, stored.quota, stored.limit = app.Version, app.GuaranteedQuota, app.MaxConnections
+				} else {
+					cluster, err := store.GetClusterConfig(ctx, tt.limit.TargetID)
+					if err != nil {
+						t.Fatal(err)
+					}
+					stored.version, stored.quota, stored.limit = cluster.Version, cluster.MaxCapacity, cluster.MaxConnections
+				}
+				wantLimit := tt.limit.Limit
+				if tt.wantStatus != 0 {
+					wantLimit = 10
+				}
+				if stored.version != tt.wantVersion || stored.quota != tt.wantQuota || stored.limit != wantLimit {
+					t.Errorf("stored version %d, quota %d, limit %d, want %d, %d, %d",
+						stored.version, stored.quota, stored.limit, tt.wantVersion, tt.wantQuota, wantLimit)
+				}
+			})
+		}
+	}
+}
+
+// configUpdateChannel returns the channel store publishes config updates on.
+func configUpdateChannel(store Storage) string {
+	switch s := store.(type) {
+	case *memoryStorage:
+		return s.configUpdateChannel
+	case *redisStorage:
+		return s.configUpdateChannel
+	}
+	return ""
+}
+
+func TestSetConnectionLimitEvent(t *testing.T) {
+	limits := []models.ConnectionLimit{
+		{TargetType: "app", TargetID: "a1", Limit: 20},
+		{TargetType: "cluster", TargetID: "c1", Limit: 300},
+	}
+
+	for _, backend := range testBackends {
+		for _, limit := range limits {
+			t.Run(backend.name+"/"+limit.TargetType, func(t *testing.T) {
+				store := backend.open(t)
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				if err := store.SetAppConfig(ctx, &models.AppConfig{AppID: "a1", GuaranteedQuota: 100}); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.SetClusterConfig(ctx, &models.ClusterConfig{ClusterID: "c1", MaxCapacity: 1000, ReservedRatio: 0.1, EmergencyThreshold: 0.9}); err != nil {
+					t.Fatal(err)
+				}
+
+				channel := configUpdateChannel(store)
+				messages, err := store.Subscribe(ctx, channel)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				version, err := store.SetConnectionLimit(ctx, &limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Cluster writes publish their config on the same channel first
+				timeout := time.After(2 * time.Second)
+				for {
+					var msg *PubSubMessage
+					select {
+					case msg = <-messages:
+					case <-timeout:
+						t.Fatal("no connection_limit event published")
+					}
+					if msg.Channel != channel {
+						t.Fatalf("message on %s, want %s", msg.Channel, channel)
+					}
+
+					var event struct {
+						Type       string `json:"type"`
+						TargetType string `json:"target_type"`
+						TargetID   string `json:"target_id"`
+						Limit      int64  `json:"limit"`
+						Version    int64  `json:"version"`
+						Timestamp  int64  `json:"timestamp"`
+					}
+					if err := json.Unmarshal(msg.Payload, &event); err != nil {
+						t.Fatalf("payload %s: %v", msg.Payload, err)
+					}
+					if event.Type != "connection_limit" {
+						continue
+					}
+					if event.TargetType != limit.TargetType || event.TargetID != limit.TargetID || event.Limit != limit.Limit ||
+						event.Version != version || event.Timestamp == 0 {
+						t.Errorf("event = %s, want %+v at version %d", msg.Payload, limit, version)
+					}
+					return
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Package storage provides defaulting rules shared by all storage backends.
+package storage
+
+import "admin-backend/models"
+
+const (
+	// DefaultBurstMultiplier is applied to the guaranteed quota when no burst quota is set
+	DefaultBurstMultiplier = 5
+	// DefaultAppMaxConnections is the per-app connection limit when none is set
+	DefaultAppMaxConnections = 1000
+	// DefaultReservedRatio is the cluster reserved ratio when none is set
+	DefaultReservedRatio = 0.1
+	// DefaultEmergencyThreshold is the cluster emergency threshold when none is set
+	DefaultEmergencyThreshold = 0.95
+	// DefaultClusterMaxConnections is the per-cluster connection limit when none is set
+	DefaultClusterMaxConnections = 5000
+	// DefaultEmergencyDuration is the emergency duration in seconds when none is set
+	DefaultEmergencyDuration = 300
+)
+
+// withAppDefaults returns a copy of config with zero values replaced by defaults.
+func withAppDefaults(config *models.AppConfig) models.AppConfig {
+	out := *config
+	out.Clusters = append([]models.ClusterAssignment(nil), config.Clusters...)
+
+	if out.BurstQuota == 0 {
+		out.BurstQuota = out.GuaranteedQuota * DefaultBurstMultiplier
+	}
+	if out.MaxBorrow == 0 {
+		out.MaxBorrow = out.GuaranteedQuota
+	}
+	if out.MaxConnections == 0 {
+		out.MaxConnections = DefaultAppMaxConnections
+	}
+
+	return out
+}
+
+// withClusterDefaults returns a copy of config with zero values replaced by defaults.
+func withClusterDefaults(config *models.ClusterConfig) models.ClusterConfig {
+	out := *config
+
+	if out.ReservedRatio == 0 {
+		out.ReservedRatio = DefaultReservedRatio
+	}
+	if out.EmergencyThreshold == 0 {
+		out.EmergencyThreshold = DefaultEmergencyThreshold
+	}
+	if out.MaxConnections == 0 {
+		out.MaxConnections = DefaultClusterMaxConnections
+	}
+
+	return out
+}
@@ -0,0 +1,101 @@
+// Package storage provides the emergency mode rules shared by all storage backends.
+package storage
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"admin-backend/validation"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// EmergencyExpiryGrace is how long emergency keys outlive expires_at, so an
+	// expired emergency can still be deactivated and announced after a restart
+	EmergencyExpiryGrace = 10 * time.Minute
+	// EmergencyReasonExpired is the emergency_deactivated reason of the reaper
+	EmergencyReasonExpired = "expired"
+	// EmergencyReasonManual is the emergency_deactivated reason of an operator
+	EmergencyReasonManual = "manual"
+	// EmergencyActorSystem is the history actor of changes made without a user
+	EmergencyActorSystem = "system"
+)
+
+// emergencyStatus returns the status of an emergency activated at activatedAt
+// until expiresAt, as seen at now. An expired emergency is reported inactive
+// even before it is deactivated.
+func emergencyStatus(reason string, activatedAt, expiresAt, now time.Time) *models.EmergencyStatus {
+	if !expiresAt.IsZero() && !now.Before(expiresAt) {
+		return &models.EmergencyStatus{}
+	}
+
+	status := &models.EmergencyStatus{
+		Active:      true,
+		Reason:      reason,
+		ActivatedAt: activatedAt,
+		ExpiresAt:   expiresAt,
+	}
+	if !expiresAt.IsZero() {
+		status.RemainingSeconds = int64(expiresAt.Sub(now) / time.Second)
+		if !activatedAt.IsZero() {
+			status.Duration = int64(expiresAt.Sub(activatedAt) / time.Second)
+		}
+	}
+	return status
+}
+
+// newEmergencyEvent builds a history event for an action on the emergency
+// described by state, taken at the given time. Ended emergencies record how
+// long they actually lasted; gateway, if known, provides the utilization.
+func newEmergencyEvent(ctx context.Context, action string, state *models.EmergencyStatus, at time.Time, gateway *models.GatewayClusterStatus) *models.EmergencyEvent {
+	info := ChangeInfoFrom(ctx)
+
+	actor := info.Author
+	if actor == "" {
+		actor = EmergencyActorSystem
+	}
+
+	event := &models.EmergencyEvent{
+		Timestamp:   time.Unix(at.Unix(), 0),
+		Action:      action,
+		Actor:       actor,
+		Reason:      state.Reason,
+		Comment:     info.Reason,
+		ActivatedAt: state.ActivatedAt,
+		ExpiresAt:   state.ExpiresAt,
+	}
+
+	// An emergency ends when it is deactivated or expires, whichever is first
+	end := at
+	if action != models.EmergencyDeactivated || (!state.ExpiresAt.IsZero() && state.ExpiresAt.Before(at)) {
+		end = state.ExpiresAt
+	}
+	if !state.ActivatedAt.IsZero() && end.After(state.ActivatedAt) {
+		event.Duration = int64(end.Sub(state.ActivatedAt) / time.Second)
+	}
+	if gateway != nil {
+		event.Utilization = gateway.UsageRatio
+	}
+	return event
+}
+
+// sortEmergencySchedules orders windows by start time, then ID.
+func sortEmergencySchedules(schedules []*models.EmergencySchedule) {
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].StartAt.Equal(schedules[j].StartAt) {
+			return schedules[i].StartAt.Before(schedules[j].StartAt)
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+}
+
+// emergencyTooLong returns the conflict refusing an extension that would run
+// the emergency activated at activatedAt past validation.MaxEmergencyDuration.
+func emergencyTooLong(activatedAt time.Time) error {
+	return errors.Conflict(
+		fmt.Sprintf("emergency mode cannot run longer than %d seconds from activation", validation.MaxEmergencyDuration),
+		nil,
+	).WithContext("max_expires_at", activatedAt.Add(validation.MaxEmergencyDuration*time.Second))
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"admin-backend/models"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEmergencySchedulePutBack(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			ctx := context.Background()
+			now := time.Unix(time.Now().Unix(), 0)
+
+			window := &models.EmergencySchedule{ID: "w1", Reason: "sale", StartAt: now.Add(-time.Minute), EndAt: now.Add(time.Hour), Duration: 3660}
+			if err := store.CreateEmergencySchedule(ctx, window); err != nil {
+				t.Fatal(err)
+			}
+
+			claimed, err := store.ClaimEmergencySchedules(ctx, now)
+			if err != nil || len(claimed) != 1 {
+				t.Fatalf("claimed = %+v, %v, want w1", claimed, err)
+			}
+			if pending, _ := store.ListEmergencySchedules(ctx); len(pending) != 0 {
+				t.Fatalf("pending after claim = %+v, want none", pending)
+			}
+			if _, err := store.CancelEmergencySchedule(ctx, "w1"); err == nil {
+				t.Error("claimed window cancelled")
+			}
+
+			// Putting it back replaces rather than duplicates it
+			claimed[0].Attempts, claimed[0].LastError = 1, "refused"
+			for i := 0; i < 2; i++ {
+				if err := store.CreateEmergencySchedule(ctx, claimed[0]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pending, err := store.ListEmergencySchedules(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError != "refused" || !pending[0].StartAt.Equal(window.StartAt) {
+				t.Fatalf("pending = %+v, want w1 with its failure", pending)
+			}
+
+			// and makes it due again
+			claimed, err = store.ClaimEmergencySchedules(ctx, now)
+			if err != nil || len(claimed) != 1 || claimed[0].Attempts != 1 {
+				t.Fatalf("claimed again = %+v, %v, want w1", claimed, err)
+			}
+		})
+	}
+}
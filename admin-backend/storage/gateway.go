@@ -0,0 +1,80 @@
+// Package storage provides the gateway L1 bucket rules shared by all storage backends.
+package storage
+
+import (
+	"admin-backend/models"
+	"math"
+	"time"
+)
+
+// DefaultGatewayClusterID is the cluster whose config drives the gateway's L1 bucket by default.
+const DefaultGatewayClusterID = "default"
+
+// Options configures a storage backend.
+type Options struct {
+	// GatewayClusterID is the cluster whose config drives the gateway's L1 bucket
+	GatewayClusterID string
+	// MaxHistoryEntries is the number of snapshots kept in each config's history
+	MaxHistoryEntries int
+}
+
+// Option sets a storage option.
+type Option func(*Options)
+
+// WithGatewayCluster sets the cluster whose writes are propagated to the gateway's L1 bucket.
+func WithGatewayCluster(clusterID string) Option {
+	return func(o *Options) {
+		o.GatewayClusterID = clusterID
+	}
+}
+
+// WithMaxHistory sets the number of snapshots kept in each config's history.
+// Older snapshots are dropped; the revisions of the rest do not change.
+func WithMaxHistory(entries int) Option {
+	return func(o *Options) {
+		if entries > 0 {
+			o.MaxHistoryEntries = entries
+		}
+	}
+}
+
+// newOptions applies opts to the defaults.
+func newOptions(opts []Option) Options {
+	o := Options{GatewayClusterID: DefaultGatewayClusterID, MaxHistoryEntries: DefaultMaxHistoryEntries}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// scaleAvailable keeps the share of the L1 capacity that is unallocated when
+// the capacity changes, as the gateway's set_capacity does. A bucket without
+// a capacity yet starts fully available.
+func scaleAvailable(oldCapacity, oldAvailable float64, capacity int64) int64 {
+	if oldCapacity <= 0 {
+		return capacity
+	}
+	return int64(math.Floor(oldAvailable * float64(capacity) / oldCapacity))
+}
+
+// gatewayStatus derives the status of an L1 bucket from its raw values.
+// Reserved capacity is withheld from allocation, as in the gateway's allocate_quota.
+func gatewayStatus(capacity, available, reservedRatio, emergencyThreshold float64, emergencyMode bool, lastReconcile float64) *models.GatewayClusterStatus {
+	status := &models.GatewayClusterStatus{
+		Capacity:           int64(capacity),
+		Available:          int64(available),
+		Allocated:          int64(capacity - available),
+		ReservedRatio:      reservedRatio,
+		Remaining:          int64(math.Max(0, available-capacity*reservedRatio)),
+		EmergencyThreshold: emergencyThreshold,
+		EmergencyMode:      emergencyMode,
+	}
+	if capacity > 0 {
+		status.UsageRatio = 1 - available/capacity
+	}
+	if lastReconcile > 0 {
+		t := time.Unix(int64(lastReconcile), 0)
+		status.LastReconcile = &t
+	}
+	return status
+}
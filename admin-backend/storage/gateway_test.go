@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"context"
+	"testing"
+)
+
+// allocateL1 takes amount from the gateway's L1 bucket, as gateway allocations do.
+func allocateL1(t *testing.T, store Storage, amount int64) {
+	t.Helper()
+
+	switch s := store.(type) {
+	case *memoryStorage:
+		s.mu.Lock()
+		s.l1.available -= float64(amount)
+		s.mu.Unlock()
+	case *redisStorage:
+		if err := s.client.DecrBy(context.Background(), s.l1KeyPrefix+"available", amount).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGatewayClusterL1(t *testing.T) {
+	const gateway = "gw"
+
+	type l1 struct {
+		capacity, available int64
+		reservedRatio       float64
+		emergencyThreshold  float64
+	}
+	tests := []struct {
+		name string
+		// allocated is taken from the bucket after the initial write
+		allocated int64
+		write     *models.ClusterConfig
+		expected  int64
+		want      l1
+		wantErr   bool
+	}{
+		{
+			name:  "gateway cluster writes the bucket",
+			write: &models.ClusterConfig{ClusterID: gateway, MaxCapacity: 1000, ReservedRatio: 0.2, EmergencyThreshold: 0.8},
+			want:  l1{capacity: 1000, available: 1000, reservedRatio: 0.2, emergencyThreshold: 0.8},
+		},
+		{
+			name:      "capacity change keeps the unallocated share",
+			allocated: 400,
+			write:     &models.ClusterConfig{ClusterID: gateway, MaxCapacity: 2000, ReservedRatio: 0.1, EmergencyThreshold: 0.9},
+			want:      l1{capacity: 2000, available: 400, reservedRatio: 0.1, emergencyThreshold: 0.9},
+		},
+		{
+			name:      "other cluster leaves the bucket alone",
+			allocated: 400,
+			write:     &models.ClusterConfig{ClusterID: "c1", MaxCapacity: 5000, ReservedRatio: 0.3, EmergencyThreshold: 0.5},
+			want:      l1{capacity: 500, available: 100, reservedRatio: 0.1, emergencyThreshold: 0.9},
+		},
+		{
+			name:      "rejected write leaves the bucket alone",
+			allocated: 400,
+			write:     &models.ClusterConfig{ClusterID: gateway, MaxCapacity: 2000, ReservedRatio: 0.2, EmergencyThreshold: 0.8},
+			expected:  5,
+			want:      l1{capacity: 500, available: 100, reservedRatio: 0.1, emergencyThreshold: 0.9},
+			wantErr:   true,
+		},
+	}
+
+	for _, backend := range testBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				store := backend.open(t, WithGatewayCluster(gateway))
+				ctx := context.Background()
+
+				if tt.write.ClusterID != gateway || tt.allocated > 0 {
+					initial := &models.ClusterConfig{ClusterID: gateway, MaxCapacity: 500, ReservedRatio: 0.1, EmergencyThreshold: 0.9}
+					if err := store.SetClusterConfig(ctx, initial); err != nil {
+						t.Fatal(err)
+					}
+					allocateL1(t, store, tt.allocated)
+				}
+
+				expected := tt.expected
+				if expected == 0 {
+					expected = AnyVersion
+				}
+				err := store.CompareAndSetClusterConfig(ctx, tt.write, expected)
+				if tt.wantErr {
+					if code := errors.Resolve(err).ErrorCode; code != errors.CodeVersionConflict {
+						t.Fatalf("error %v, want a version conflict", err)
+					}
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				status, err := store.GetGatewayClusterStatus(ctx, gateway)
+				if err != nil || status == nil {
+					t.Fatalf("gateway status = %+v, %v", status, err)
+				}
+				got := l1{status.Capacity, status.Available, status.ReservedRatio, status.EmergencyThreshold}
+				if got != tt.want {
+					t.Errorf("L1 bucket = %+v, want %+v", got, tt.want)
+				}
+
+				// Only the gateway cluster has a bucket
+				if status, err := store.GetGatewayClusterStatus(ctx, "c1"); err != nil || status != nil {
+					t.Errorf("status of c1 = %+v, %v, want none", status, err)
+				}
+			})
+		}
+	}
+}
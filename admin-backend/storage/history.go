@@ -0,0 +1,66 @@
+// Package storage provides helpers for recording configuration history.
+package storage
+
+import (
+	"admin-backend/models"
+	"context"
+	"time"
+)
+
+// ChangeInfo describes who made a configuration change and why.
+// It travels with the request context so storage writes can record it
+// in the configuration history without widening every write signature.
+type ChangeInfo struct {
+	// Author is the ID of the user making the change
+	Author string
+	// Reason is a free-form explanation of the change
+	Reason string
+	// Action overrides the recorded action (e.g. rollback); empty to infer it
+	Action string
+}
+
+// changeInfoKey is the context key for ChangeInfo.
+type changeInfoKey struct{}
+
+// WithChangeInfo returns a context carrying info for subsequent storage writes.
+func WithChangeInfo(ctx context.Context, info ChangeInfo) context.Context {
+	return context.WithValue(ctx, changeInfoKey{}, info)
+}
+
+// ChangeInfoFrom returns the ChangeInfo carried by ctx, if any.
+func ChangeInfoFrom(ctx context.Context) ChangeInfo {
+	info, _ := ctx.Value(changeInfoKey{}).(ChangeInfo)
+	return info
+}
+
+// newSnapshot builds a history snapshot for a write to a resource.
+// previousVersion is the version being replaced (0 if the resource did not exist).
+func newSnapshot(ctx context.Context, resourceType, resourceID string, previousVersion int64, action string) *models.ConfigSnapshot {
+	info := ChangeInfoFrom(ctx)
+
+	if action == "" {
+		action = models.ChangeUpdate
+		if previousVersion == 0 {
+			action = models.ChangeCreate
+		}
+	}
+	if info.Action != "" && action != models.ChangeDelete {
+		action = info.Action
+	}
+
+	// A deletion records the last state, so it keeps that state's version
+	version := previousVersion + 1
+	if action == models.ChangeDelete {
+		version = previousVersion
+	}
+
+	return &models.ConfigSnapshot{
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Version:      version,
+		Action:       action,
+		Author:       info.Author,
+		Reason:       info.Reason,
+		Timestamp:    time.Unix(time.Now().Unix(), 0),
+	}
+}
@@ -0,0 +1,64 @@
+// Package storage provides the app-to-cluster membership rules shared by all storage backends.
+package storage
+
+import (
+	"admin-backend/models"
+	"sort"
+)
+
+// assignedApps returns the apps explicitly assigned to clusterID, keeping their order.
+func assignedApps(apps []*models.AppConfig, clusterID string) []*models.AppConfig {
+	members := make([]*models.AppConfig, 0)
+	for _, app := range apps {
+		for _, assignment := range app.Clusters {
+			if assignment.ClusterID == clusterID {
+				members = append(members, app)
+				break
+			}
+		}
+	}
+	return members
+}
+
+// assignedClusterIDs returns the IDs of the clusters config is assigned to.
+func assignedClusterIDs(config *models.AppConfig) []string {
+	ids := make([]string, len(config.Clusters))
+	for i, assignment := range config.Clusters {
+		ids[i] = assignment.ClusterID
+	}
+	return ids
+}
+
+// clusterMembers indexes the IDs of the apps assigned to each cluster by cluster ID.
+type clusterMembers map[string]map[string]struct{}
+
+// update moves appID from the clusters previous is assigned to to those of
+// next. previous is nil when the app is created, next when it is deleted.
+func (c clusterMembers) update(appID string, previous, next *models.AppConfig) {
+	if previous != nil {
+		for _, assignment := range previous.Clusters {
+			delete(c[assignment.ClusterID], appID)
+			if len(c[assignment.ClusterID]) == 0 {
+				delete(c, assignment.ClusterID)
+			}
+		}
+	}
+	if next != nil {
+		for _, assignment := range next.Clusters {
+			if c[assignment.ClusterID] == nil {
+				c[assignment.ClusterID] = make(map[string]struct{})
+			}
+			c[assignment.ClusterID][appID] = struct{}{}
+		}
+	}
+}
+
+// ids returns the IDs of the apps assigned to clusterID, sorted.
+func (c clusterMembers) ids(clusterID string) []string {
+	ids := make([]string, 0, len(c[clusterID]))
+	for id := range c[clusterID] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,1521 @@
+// Package storage provides an in-memory implementation of the storage interface.
+package storage
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"admin-backend/validation"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStorage implements the Storage interface in process memory.
+// It is intended for local development and tests; data is lost on restart.
+type memoryStorage struct {
+	mu       sync.RWMutex
+	closed   bool
+	apps     map[string]*models.AppConfig
+	clusters map[string]*models.ClusterConfig
+	history  map[string][]*models.ConfigSnapshot
+	users    map[string]*models.User
+	apiKeys  map[string]*models.APIKey
+	audit    []*models.AuditEntry
+
+	// clusterApps indexes the apps assigned to each cluster
+	clusterApps clusterMembers
+
+	// maxHistory is the number of snapshots kept per history
+	maxHistory int
+
+	// Token revocations, keyed by token ID and user ID, with their expiry times
+	revokedTokens map[string]time.Time
+	revokedUsers  map[string]memoryUserRevocation
+	loginStates   map[string]memoryLoginState
+	loginAttempts map[string]memoryLoginAttempts
+	requestLog    map[string]*memoryRequestLog
+	lastLogSweep  time.Time
+	emergency     models.EmergencyStatus
+	emergencyLog  []*models.EmergencyEvent
+	schedules     map[string]*models.EmergencySchedule
+
+	// The gateway's L1 bucket, driven by the config of gatewayClusterID
+	l1               *memoryL1Cluster
+	gatewayClusterID string
+
+	subMu       sync.RWMutex
+	subscribers map[*memorySubscriber]struct{}
+
+	// Config constants
+	eventChannel        string
+	configUpdateChannel string
+}
+
+// memoryL1Cluster stands in for the gateway's L1 bucket. Without a gateway
+// nothing allocates from it, so it only changes with the gateway cluster's config.
+type memoryL1Cluster struct {
+	capacity           float64
+	available          float64
+	reservedRatio      float64
+	emergencyThreshold float64
+}
+
+// memorySubscriber is a single in-process subscription.
+type memorySubscriber struct {
+	channels map[string]bool
+	ch       chan *PubSubMessage
+}
+
+// memoryUserRevocation revokes a user's tokens issued before cutoff until expiresAt.
+type memoryUserRevocation struct {
+	cutoff    int64
+	expiresAt time.Time
+}
+
+// memoryLoginState is a login flow state that is valid until expiresAt.
+type memoryLoginState struct {
+	data      models.OIDCLoginState
+	expiresAt time.Time
+}
+
+// memoryLoginAttempts is a failed login record that is kept until expiresAt.
+type memoryLoginAttempts struct {
+	failures      int64
+	lastFailureAt time.Time
+	blockedUntil  time.Time
+	locked        bool
+	expiresAt     time.Time
+}
+
+// memoryRequestLog holds the times of the requests in a rate limit bucket's window.
+type memoryRequestLog struct {
+	times  []time.Time
+	window time.Duration
+}
+
+// NewMemoryStorage creates a new in-memory storage instance.
+func NewMemoryStorage(options ...Option) Storage {
+	opts := newOptions(options)
+	return &memoryStorage{
+		apps:                make(map[string]*models.AppConfig),
+		clusterApps:         make(clusterMembers),
+		clusters:            make(map[string]*models.ClusterConfig),
+		history:             make(map[string][]*models.ConfigSnapshot),
+		maxHistory:          opts.MaxHistoryEntries,
+		users:               make(map[string]*models.User),
+		apiKeys:             make(map[string]*models.APIKey),
+		revokedTokens:       make(map[string]time.Time),
+		revokedUsers:        make(map[string]memoryUserRevocation),
+		loginStates:         make(map[string]memoryLoginState),
+		loginAttempts:       make(map[string]memoryLoginAttempts),
+		requestLog:          make(map[string]*memoryRequestLog),
+		schedules:           make(map[string]*models.EmergencySchedule),
+		subscribers:         make(map[*memorySubscriber]struct{}),
+		eventChannel:        "ratelimit:events",
+		configUpdateChannel: "ratelimit:config_update",
+		gatewayClusterID:    opts.GatewayClusterID,
+	}
+}
+
+// Close releases all subscribers and marks the storage as closed.
+func (m *memoryStorage) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for sub := range m.subscribers {
+		delete(m.subscribers, sub)
+		close(sub.ch)
+	}
+
+	return nil
+}
+
+// Ping reports whether the storage is still open.
+func (m *memoryStorage) Ping(ctx context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return errors.ServiceUnavailable("memory storage is closed", nil)
+	}
+	return ctx.Err()
+}
+
+// AppConfig operations
+
+// GetAppConfig retrieves an application configuration by ID.
+func (m *memoryStorage) GetAppConfig(ctx context.Context, appID string) (*models.AppConfig, error) {
+	if appID == "" {
+		return nil, errors.BadRequest("app ID cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	config, ok := m.apps[appID]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyApp(config), nil
+}
+
+// copyApp returns a copy of config that shares no memory with it.
+func copyApp(config *models.AppConfig) *models.AppConfig {
+	out := *config
+	out.Clusters = append([]models.ClusterAssignment(nil), config.Clusters...)
+	return &out
+}
+
+// SetAppConfig creates or updates an application configuration.
+// The stored version is incremented and copied back into config.Version.
+func (m *memoryStorage) SetAppConfig(ctx context.Context, config *models.AppConfig) error {
+	return m.CompareAndSetAppConfig(ctx, config, AnyVersion)
+}
+
+// CompareAndSetAppConfig writes config only if the stored version equals expectedVersion.
+func (m *memoryStorage) CompareAndSetAppConfig(ctx context.Context, config *models.AppConfig, expectedVersion int64) error {
+	if config == nil {
+		return errors.BadRequest("config cannot be nil", nil)
+	}
+	if config.AppID == "" {
+		return errors.BadRequest("app ID cannot be empty", nil)
+	}
+
+	now := time.Now().Unix()
+
+	// Set defaults for zero values
+	cfg := withAppDefaults(config)
+	cfg.UpdatedAt = time.Unix(now, 0)
+
+	m.mu.Lock()
+	var current int64
+	existing := m.apps[cfg.AppID]
+	if existing != nil {
+		current = existing.Version
+	}
+	if expectedVersion >= 0 && current != expectedVersion {
+		m.mu.Unlock()
+		return versionConflict("app config", current, expectedVersion)
+	}
+	snap := newSnapshot(ctx, models.ResourceApp, cfg.AppID, current, "")
+	cfg.Version = snap.Version
+	m.apps[cfg.AppID] = &cfg
+	m.clusterApps.update(cfg.AppID, existing, &cfg)
+	app := cfg
+	snap.App = &app
+	m.appendSnapshot(snap)
+	m.mu.Unlock()
+
+	config.Version = cfg.Version
+
+	// Publish configuration update event
+	m.publishEvent(m.configUpdateChannel, map[string]interface{}{
+		"type":      "app_config",
+		"app_id":    cfg.AppID,
+		"version":   cfg.Version,
+		"clusters":  assignedClusterIDs(&cfg),
+		"timestamp": now,
+	})
+
+	return nil
+}
+
+// DeleteAppConfig removes an application configuration.
+func (m *memoryStorage) DeleteAppConfig(ctx context.Context, appID string) error {
+	if appID == "" {
+		return errors.BadRequest("app ID cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	current, ok := m.apps[appID]
+	if !ok {
+		m.mu.Unlock()
+		return errors.NotFound("app config not found", nil)
+	}
+	snap := newSnapshot(ctx, models.ResourceApp, appID, current.Version, models.ChangeDelete)
+	snap.App = current
+	m.appendSnapshot(snap)
+	delete(m.apps, appID)
+	m.clusterApps.update(appID, current, nil)
+	m.mu.Unlock()
+
+	// Publish deletion event
+	m.publishEvent(m.configUpdateChannel, map[string]interface{}{
+		"type":      "app_deleted",
+		"app_id":    appID,
+		"timestamp": time.Now().Unix(),
+	})
+
+	return nil
+}
+
+// ListAppConfigs returns all application configurations ordered by ID.
+func (m *memoryStorage) ListAppConfigs(ctx context.Context) ([]*models.AppConfig, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	configs := make([]*models.AppConfig, 0, len(m.apps))
+	for _, id := range sortedKeys(m.apps) {
+		configs = append(configs, copyApp(m.apps[id]))
+	}
+
+	return configs, nil
+}
+
+// ScanAppConfigs returns one page of application configurations ordered by ID.
+// The cursor is the last app ID of the previous page.
+func (m *memoryStorage) ScanAppConfigs(ctx context.Context, cursor string, limit int) ([]*models.AppConfig, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids, next := pageIDs(sortedKeys(m.apps), cursor, limit)
+	configs := make([]*models.AppConfig, 0, len(ids))
+	for _, id := range ids {
+		configs = append(configs, copyApp(m.apps[id]))
+	}
+
+	return configs, next, nil
+}
+
+// ClusterConfig operations
+
+// GetClusterConfig retrieves a cluster configuration by ID.
+func (m *memoryStorage) GetClusterConfig(ctx context.Context, clusterID string) (*models.ClusterConfig, error) {
+	if clusterID == "" {
+		return nil, errors.BadRequest("cluster ID cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	config, ok := m.clusters[clusterID]
+	if !ok {
+		return nil, nil
+	}
+
+	out := *config
+	return &out, nil
+}
+
+// SetClusterConfig creates or updates a cluster configuration.
+// The stored version is incremented and copied back into config.Version.
+func (m *memoryStorage) SetClusterConfig(ctx context.Context, config *models.ClusterConfig) error {
+	return m.CompareAndSetClusterConfig(ctx, config, AnyVersion)
+}
+
+// CompareAndSetClusterConfig writes config only if the stored version equals expectedVersion.
+func (m *memoryStorage) CompareAndSetClusterConfig(ctx context.Context, config *models.ClusterConfig, expectedVersion int64) error {
+	if config == nil {
+		return errors.BadRequest("config cannot be nil", nil)
+	}
+	if config.ClusterID == "" {
+		return errors.BadRequest("cluster ID cannot be empty", nil)
+	}
+
+	// Set defaults for zero values
+	cfg := withClusterDefaults(config)
+	cfg.UpdatedAt = time.Unix(time.Now().Unix(), 0)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var current int64
+	if existing, ok := m.clusters[cfg.ClusterID]; ok {
+		current = existing.Version
+	}
+	if expectedVersion >= 0 && current != expectedVersion {
+		return versionConflict("cluster config", current, expectedVersion)
+	}
+	snap := newSnapshot(ctx, models.ResourceCluster, cfg.ClusterID, current, "")
+	cfg.Version = snap.Version
+	m.clusters[cfg.ClusterID] = &cfg
+	cluster := cfg
+	snap.Cluster = &cluster
+	m.appendSnapshot(snap)
+
+	config.Version = cfg.Version
+
+	// Publish configuration update event so gateways pick up capacity changes
+	m.publishEvent(m.configUpdateChannel, map[string]interface{}{
+		"type":                "cluster_config",
+		"cluster_id":          cfg.ClusterID,
+		"version":             cfg.Version,
+		"max_capacity":        cfg.MaxCapacity,
+		"reserved_ratio":      cfg.ReservedRatio,
+		"emergency_threshold": cfg.EmergencyThreshold,
+		"timestamp":           cfg.UpdatedAt.Unix(),
+	})
+
+	if cfg.ClusterID == m.gatewayClusterID {
+		var oldCapacity, oldAvailable float64
+		if m.l1 != nil {
+			oldCapacity, oldAvailable = m.l1.capacity, m.l1.available
+		}
+		m.l1 = &memoryL1Cluster{
+			capacity:           float64(cfg.MaxCapacity),
+			available:          float64(scaleAvailable(oldCapacity, oldAvailable, cfg.MaxCapacity)),
+			reservedRatio:      cfg.ReservedRatio,
+			emergencyThreshold: cfg.EmergencyThreshold,
+		}
+
+		m.publishEvent(m.eventChannel, map[string]interface{}{
+			"type":                "capacity_changed",
+			"cluster_id":          cfg.ClusterID,
+			"old_capacity":        int64(oldCapacity),
+			"new_capacity":        cfg.MaxCapacity,
+			"available":           int64(m.l1.available),
+			"reserved_ratio":      cfg.ReservedRatio,
+			"emergency_threshold": cfg.EmergencyThreshold,
+			"timestamp":           cfg.UpdatedAt.Unix(),
+		})
+	}
+
+	return nil
+}
+
+// Connection limit operations
+
+// SetConnectionLimit sets the max_connections of the limit's target and
+// publishes it for the gateways' connection limiters.
+func (m *memoryStorage) SetConnectionLimit(ctx context.Context, limit *models.ConnectionLimit) (int64, error) {
+	if limit == nil {
+		return 0, errors.BadRequest("limit cannot be nil", nil)
+	}
+
+	version, err := setConnectionLimit(ctx, m, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	m.publishEvent(m.configUpdateChannel, map[string]interface{}{
+		"type":        "connection_limit",
+		"target_type": limit.TargetType,
+		"target_id":   limit.TargetID,
+		"limit":       limit.Limit,
+		"version":     version,
+		"timestamp":   time.Now().Unix(),
+	})
+
+	return version, nil
+}
+
+// ListConnectionLimits returns the max_connections of every app and cluster.
+func (m *memoryStorage) ListConnectionLimits(ctx context.Context) ([]*models.ConnectionLimit, error) {
+	apps, err := m.ListAppConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := m.ListClusterConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return connectionLimits(apps, clusters), nil
+}
+
+// GetGatewayClusterStatus returns the stand-in L1 bucket if clusterID drives it.
+func (m *memoryStorage) GetGatewayClusterStatus(ctx context.Context, clusterID string) (*models.GatewayClusterStatus, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if clusterID != m.gatewayClusterID || m.l1 == nil {
+		return nil, nil
+	}
+	l1 := m.l1
+	return gatewayStatus(l1.capacity, l1.available, l1.reservedRatio, l1.emergencyThreshold, false, 0), nil
+}
+
+// DeleteClusterConfig removes a cluster configuration.
+func (m *memoryStorage) DeleteClusterConfig(ctx context.Context, clusterID string) error {
+	if clusterID == "" {
+		return errors.BadRequest("cluster ID cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	current, ok := m.clusters[clusterID]
+	if !ok {
+		m.mu.Unlock()
+		return errors.NotFound("cluster config not found", nil)
+	}
+	snap := newSnapshot(ctx, models.ResourceCluster, clusterID, current.Version, models.ChangeDelete)
+	snap.Cluster = current
+	m.appendSnapshot(snap)
+	delete(m.clusters, clusterID)
+	m.mu.Unlock()
+
+	// Publish deletion event
+	m.publishEvent(m.configUpdateChannel, map[string]interface{}{
+		"type":       "cluster_deleted",
+		"cluster_id": clusterID,
+		"timestamp":  time.Now().Unix(),
+	})
+
+	return nil
+}
+
+// ListClusterConfigs returns all cluster configurations ordered by ID.
+func (m *memoryStorage) ListClusterConfigs(ctx context.Context) ([]*models.ClusterConfig, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	configs := make([]*models.ClusterConfig, 0, len(m.clusters))
+	for _, id := range sortedKeys(m.clusters) {
+		out := *m.clusters[id]
+		configs = append(configs, &out)
+	}
+
+	return configs, nil
+}
+
+// ListClusterApps returns the applications assigned to a cluster ordered by ID.
+func (m *memoryStorage) ListClusterApps(ctx context.Context, clusterID string) ([]*models.AppConfig, error) {
+	if clusterID == "" {
+		return nil, errors.BadRequest("cluster ID cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := m.clusterApps.ids(clusterID)
+	apps := make([]*models.AppConfig, 0, len(ids))
+	for _, id := range ids {
+		apps = append(apps, copyApp(m.apps[id]))
+	}
+	return apps, nil
+}
+
+// ScanClusterConfigs returns one page of cluster configurations ordered by ID.
+// The cursor is the last cluster ID of the previous page.
+func (m *memoryStorage) ScanClusterConfigs(ctx context.Context, cursor string, limit int) ([]*models.ClusterConfig, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids, next := pageIDs(sortedKeys(m.clusters), cursor, limit)
+	configs := make([]*models.ClusterConfig, 0, len(ids))
+	for _, id := range ids {
+		out := *m.clusters[id]
+		configs = append(configs, &out)
+	}
+
+	return configs, next, nil
+}
+
+// History operations
+
+// ListConfigHistory returns up to limit snapshots of a resource, newest first.
+func (m *memoryStorage) ListConfigHistory(ctx context.Context, resourceType, resourceID string, limit int) ([]*models.ConfigSnapshot, error) {
+	if resourceID == "" {
+		return nil, errors.BadRequest("resource ID cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := m.history[resourceType+":"+resourceID]
+	n := len(entries)
+	if limit > 0 && n > limit {
+		n = limit
+	}
+
+	snapshots := make([]*models.ConfigSnapshot, 0, n)
+	for i := len(entries) - 1; i >= len(entries)-n; i-- {
+		snapshots = append(snapshots, copySnapshot(entries[i]))
+	}
+
+	return snapshots, nil
+}
+
+// GetConfigSnapshot retrieves a snapshot by revision.
+func (m *memoryStorage) GetConfigSnapshot(ctx context.Context, resourceType, resourceID string, revision int64) (*models.ConfigSnapshot, error) {
+	if resourceID == "" {
+		return nil, errors.BadRequest("resource ID cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := m.history[resourceType+":"+resourceID]
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	// Revisions are consecutive from the oldest snapshot still retained
+	i := revision - entries[0].Revision
+	if i < 0 || i >= int64(len(entries)) {
+		return nil, nil
+	}
+
+	return copySnapshot(entries[i]), nil
+}
+
+// appendSnapshot records snap as the next revision of its resource, dropping
+// the oldest snapshots beyond maxHistory. Callers must hold m.mu.
+func (m *memoryStorage) appendSnapshot(snap *models.ConfigSnapshot) {
+	key := snap.ResourceType + ":" + snap.ResourceID
+	entries := m.history[key]
+
+	snap.Revision = 1
+	if len(entries) > 0 {
+		snap.Revision = entries[len(entries)-1].Revision + 1
+	}
+	entries = append(entries, snap)
+	if len(entries) > m.maxHistory {
+		entries = append([]*models.ConfigSnapshot(nil), entries[len(entries)-m.maxHistory:]...)
+	}
+	m.history[key] = entries
+}
+
+// copySnapshot returns a deep copy so callers cannot mutate recorded history.
+func copySnapshot(snap *models.ConfigSnapshot) *models.ConfigSnapshot {
+	out := *snap
+	if snap.App != nil {
+		app := *snap.App
+		out.App = &app
+	}
+	if snap.Cluster != nil {
+		cluster := *snap.Cluster
+		out.Cluster = &cluster
+	}
+	return &out
+}
+
+// User operations
+
+// GetUser retrieves a user by ID.
+func (m *memoryStorage) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	if userID == "" {
+		return nil, errors.BadRequest("user ID cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	out := *user
+	return &out, nil
+}
+
+// GetUserByUsername retrieves a user by username.
+func (m *memoryStorage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	if username == "" {
+		return nil, errors.BadRequest("username cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Username == username {
+			out := *user
+			return &out, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateUser stores a new user.
+func (m *memoryStorage) CreateUser(ctx context.Context, user *models.User) error {
+	if user == nil {
+		return errors.BadRequest("user cannot be nil", nil)
+	}
+	if user.ID == "" || user.Username == "" {
+		return errors.BadRequest("user ID and username cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.users {
+		if existing.Username == user.Username {
+			return errors.Conflict(fmt.Sprintf("username %q already exists", user.Username), nil)
+		}
+	}
+
+	now := time.Unix(time.Now().Unix(), 0)
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	stored := *user
+	m.users[user.ID] = &stored
+	return nil
+}
+
+// UpdateUser replaces the role and password hash of an existing user.
+func (m *memoryStorage) UpdateUser(ctx context.Context, user *models.User) error {
+	if user == nil {
+		return errors.BadRequest("user cannot be nil", nil)
+	}
+	if user.ID == "" {
+		return errors.BadRequest("user ID cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[user.ID]
+	if !ok {
+		return errors.NotFound("user not found", nil)
+	}
+
+	stored.Role = user.Role
+	stored.PasswordHash = user.PasswordHash
+	stored.UpdatedAt = time.Unix(time.Now().Unix(), 0)
+	user.UpdatedAt = stored.UpdatedAt
+	return nil
+}
+
+// DeleteUser removes a user.
+func (m *memoryStorage) DeleteUser(ctx context.Context, userID string) error {
+	if userID == "" {
+		return errors.BadRequest("user ID cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return errors.NotFound("user not found", nil)
+	}
+	delete(m.users, userID)
+	return nil
+}
+
+// ListUsers returns all users sorted by username.
+func (m *memoryStorage) ListUsers(ctx context.Context) ([]*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]*models.User, 0, len(m.users))
+	for _, user := range m.users {
+		out := *user
+		users = append(users, &out)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// API key operations
+
+// GetAPIKey retrieves an API key by ID.
+func (m *memoryStorage) GetAPIKey(ctx context.Context, keyID string) (*models.APIKey, error) {
+	if keyID == "" {
+		return nil, errors.BadRequest("API key ID cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.apiKeys[keyID]
+	if !ok {
+		return nil, nil
+	}
+	return copyAPIKey(key), nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its secret.
+func (m *memoryStorage) GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	if keyHash == "" {
+		return nil, errors.BadRequest("API key hash cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.apiKeys {
+		if key.KeyHash == keyHash {
+			return copyAPIKey(key), nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateAPIKey stores a new API key.
+func (m *memoryStorage) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if key == nil {
+		return errors.BadRequest("API key cannot be nil", nil)
+	}
+	if key.ID == "" || key.KeyHash == "" {
+		return errors.BadRequest("API key ID and hash cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.apiKeys {
+		if existing.KeyHash == key.KeyHash {
+			return errors.Conflict("API key already exists", nil)
+		}
+	}
+
+	key.CreatedAt = time.Unix(time.Now().Unix(), 0)
+	key.LastUsedAt = nil
+	m.apiKeys[key.ID] = copyAPIKey(key)
+	return nil
+}
+
+// DeleteAPIKey removes an API key.
+func (m *memoryStorage) DeleteAPIKey(ctx context.Context, keyID string) error {
+	if keyID == "" {
+		return errors.BadRequest("API key ID cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.apiKeys[keyID]; !ok {
+		return errors.NotFound("API key not found", nil)
+	}
+	delete(m.apiKeys, keyID)
+	return nil
+}
+
+// ListAPIKeys returns all API keys sorted by name.
+func (m *memoryStorage) ListAPIKeys(ctx context.Context) ([]*models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]*models.APIKey, 0, len(m.apiKeys))
+	for _, key := range m.apiKeys {
+		keys = append(keys, copyAPIKey(key))
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Name != keys[j].Name {
+			return keys[i].Name < keys[j].Name
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// TouchAPIKey records the last use of an API key.
+func (m *memoryStorage) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	if keyID == "" {
+		return errors.BadRequest("API key ID cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.apiKeys[keyID]; ok {
+		lastUsedAt := time.Unix(usedAt.Unix(), 0)
+		key.LastUsedAt = &lastUsedAt
+	}
+	return nil
+}
+
+// copyAPIKey returns a deep copy of key so callers never share its slices or times.
+func copyAPIKey(key *models.APIKey) *models.APIKey {
+	out := *key
+	out.Permissions = append([]string(nil), key.Permissions...)
+	if key.ExpiresAt != nil {
+		expiresAt := *key.ExpiresAt
+		out.ExpiresAt = &expiresAt
+	}
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		out.LastUsedAt = &lastUsedAt
+	}
+	return &out
+}
+
+// Login state operations
+
+// SaveLoginState stores the state of a login attempt for ttl.
+func (m *memoryStorage) SaveLoginState(ctx context.Context, state string, data *models.OIDCLoginState, ttl time.Duration) error {
+	if state == "" || data == nil {
+		return errors.BadRequest("login state cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for s, ls := range m.loginStates {
+		if !now.Before(ls.expiresAt) {
+			delete(m.loginStates, s)
+		}
+	}
+	m.loginStates[state] = memoryLoginState{data: *data, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// ConsumeLoginState returns and deletes the state of a login attempt.
+func (m *memoryStorage) ConsumeLoginState(ctx context.Context, state string) (*models.OIDCLoginState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ls, ok := m.loginStates[state]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.loginStates, state)
+
+	if !time.Now().Before(ls.expiresAt) {
+		return nil, nil
+	}
+	data := ls.data
+	return &data, nil
+}
+
+// Login attempt operations
+
+// GetLoginAttempts retrieves the failures recorded for a username or client IP.
+func (m *memoryStorage) GetLoginAttempts(ctx context.Context, scope, key string) (*models.LoginAttempts, error) {
+	if scope == "" || key == "" {
+		return nil, errors.BadRequest("login attempt scope and key cannot be empty", nil)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	la, ok := m.loginAttempts[scope+":"+key]
+	if !ok || !now.Before(la.expiresAt) {
+		return nil, nil
+	}
+	return la.toModel(scope, key, now), nil
+}
+
+// RecordLoginFailure counts a failed login.
+func (m *memoryStorage) RecordLoginFailure(ctx context.Context, scope, key string, at time.Time, window time.Duration) (*models.LoginAttempts, error) {
+	if scope == "" || key == "" {
+		return nil, errors.BadRequest("login attempt scope and key cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruneLoginAttempts(at)
+
+	la := m.loginAttempts[scope+":"+key]
+	la.failures++
+	la.lastFailureAt = at
+	la.expiresAt = at.Add(window)
+	if la.blockedUntil.After(la.expiresAt) {
+		la.expiresAt = la.blockedUntil
+	}
+	m.loginAttempts[scope+":"+key] = la
+
+	return la.toModel(scope, key, at), nil
+}
+
+// BlockLogin refuses logins for a username or client IP until the given time.
+func (m *memoryStorage) BlockLogin(ctx context.Context, scope, key string, until time.Time, locked bool) error {
+	if scope == "" || key == "" {
+		return errors.BadRequest("login attempt scope and key cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	la, ok := m.loginAttempts[scope+":"+key]
+	if !ok || !time.Now().Before(la.expiresAt) || !until.After(la.blockedUntil) {
+		return nil
+	}
+	la.blockedUntil = until
+	la.locked = locked
+	if until.After(la.expiresAt) {
+		la.expiresAt = until
+	}
+	m.loginAttempts[scope+":"+key] = la
+	return nil
+}
+
+// ClearLoginAttempts deletes the failure record of a username or client IP.
+func (m *memoryStorage) ClearLoginAttempts(ctx context.Context, scope, key string) (bool, error) {
+	if scope == "" || key == "" {
+		return false, errors.BadRequest("login attempt scope and key cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	la, ok := m.loginAttempts[scope+":"+key]
+	delete(m.loginAttempts, scope+":"+key)
+	return ok && time.Now().Before(la.expiresAt), nil
+}
+
+// ListLoginAttempts returns every tracked username and client IP.
+func (m *memoryStorage) ListLoginAttempts(ctx context.Context) ([]*models.LoginAttempts, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	attempts := make([]*models.LoginAttempts, 0, len(m.loginAttempts))
+	for id, la := range m.loginAttempts {
+		if !now.Before(la.expiresAt) {
+			continue
+		}
+		scope, key, _ := strings.Cut(id, ":")
+		attempts = append(attempts, la.toModel(scope, key, now))
+	}
+
+	sort.Slice(attempts, func(i, j int) bool {
+		if attempts[i].Scope != attempts[j].Scope {
+			return attempts[i].Scope < attempts[j].Scope
+		}
+		return attempts[i].Key < attempts[j].Key
+	})
+	return attempts, nil
+}
+
+// toModel converts a record to LoginAttempts, leaving out a block that has ended.
+func (la memoryLoginAttempts) toModel(scope, key string, now time.Time) *models.LoginAttempts {
+	attempts := &models.LoginAttempts{
+		Scope:         scope,
+		Key:           key,
+		Failures:      la.failures,
+		LastFailureAt: la.lastFailureAt,
+	}
+	if la.blockedUntil.After(now) {
+		until := la.blockedUntil
+		attempts.BlockedUntil = &until
+		attempts.Locked = la.locked
+	}
+	return attempts
+}
+
+// pruneLoginAttempts drops expired failure records. Callers must hold m.mu.
+func (m *memoryStorage) pruneLoginAttempts(now time.Time) {
+	for id, la := range m.loginAttempts {
+		if !now.Before(la.expiresAt) {
+			delete(m.loginAttempts, id)
+		}
+	}
+}
+
+// Rate limit operations
+
+// requestLogSweepInterval is how often buckets no request has touched recently are dropped.
+const requestLogSweepInterval = time.Minute
+
+// AllowRequest counts a request against the buckets, keeping each bucket's
+// request times within its window.
+func (m *memoryStorage) AllowRequest(ctx context.Context, buckets []RateLimitBucket, now time.Time) (bool, []RateLimitState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastLogSweep) >= requestLogSweepInterval {
+		m.sweepRequestLog(now)
+	}
+
+	allowed := true
+	logs := make([]*memoryRequestLog, len(buckets))
+	for i, b := range buckets {
+		log, ok := m.requestLog[b.Key]
+		if !ok {
+			log = &memoryRequestLog{}
+			m.requestLog[b.Key] = log
+		}
+		log.window = b.Window
+		log.dropBefore(now.Add(-b.Window))
+		if len(log.times) >= b.Limit {
+			allowed = false
+		}
+		logs[i] = log
+	}
+
+	states := make([]RateLimitState, len(buckets))
+	for i, b := range buckets {
+		log := logs[i]
+		if allowed {
+			log.times = append(log.times, now)
+		}
+		oldest := now
+		if len(log.times) > 0 {
+			oldest = log.times[0]
+		}
+		states[i] = RateLimitState{
+			Remaining: remainingRequests(b.Limit, int64(len(log.times))),
+			ResetAt:   oldest.Add(b.Window),
+		}
+	}
+
+	return allowed, states, nil
+}
+
+// sweepRequestLog drops buckets with no request left in their window.
+// Callers must hold m.mu.
+func (m *memoryStorage) sweepRequestLog(now time.Time) {
+	m.lastLogSweep = now
+	for key, log := range m.requestLog {
+		log.dropBefore(now.Add(-log.window))
+		if len(log.times) == 0 {
+			delete(m.requestLog, key)
+		}
+	}
+}
+
+// dropBefore removes the request times at or before cutoff.
+func (l *memoryRequestLog) dropBefore(cutoff time.Time) {
+	i := sort.Search(len(l.times), func(i int) bool { return l.times[i].After(cutoff) })
+	l.times = l.times[i:]
+}
+
+// Audit log operations
+
+// RecordAuditEntry appends an entry to the audit log, dropping the oldest beyond MaxAuditEntries.
+func (m *memoryStorage) RecordAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	if entry == nil {
+		return errors.BadRequest("audit entry cannot be nil", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *entry
+	m.audit = append(m.audit, &stored)
+	if len(m.audit) > MaxAuditEntries {
+		m.audit = m.audit[len(m.audit)-MaxAuditEntries:]
+	}
+	return nil
+}
+
+// ListAuditEntries returns up to limit entries, newest first.
+func (m *memoryStorage) ListAuditEntries(ctx context.Context, limit int) ([]*models.AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := len(m.audit)
+	if limit > 0 && n > limit {
+		n = limit
+	}
+
+	entries := make([]*models.AuditEntry, 0, n)
+	for i := len(m.audit) - 1; i >= len(m.audit)-n; i-- {
+		entry := *m.audit[i]
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+// Token revocation operations
+
+// RevokeToken adds a token ID to the denylist for ttl.
+func (m *memoryStorage) RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) (bool, error) {
+	if tokenID == "" {
+		return false, errors.BadRequest("token ID cannot be empty", nil)
+	}
+	if ttl <= 0 {
+		return true, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if expiresAt, ok := m.revokedTokens[tokenID]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	m.revokedTokens[tokenID] = now.Add(ttl)
+	m.pruneRevocations(now)
+	return true, nil
+}
+
+// RevokeUserTokens revokes all of a user's tokens issued up to the end of the current second.
+func (m *memoryStorage) RevokeUserTokens(ctx context.Context, userID string, ttl time.Duration) error {
+	if userID == "" {
+		return errors.BadRequest("user ID cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.revokedUsers[userID] = memoryUserRevocation{cutoff: now.Unix() + 1, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// IsTokenRevoked checks the token denylist and the user's revocation cutoff.
+func (m *memoryStorage) IsTokenRevoked(ctx context.Context, tokenID, userID string, issuedAt time.Time) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	if expiresAt, ok := m.revokedTokens[tokenID]; ok && now.Before(expiresAt) {
+		return true, nil
+	}
+	if rev, ok := m.revokedUsers[userID]; ok && now.Before(rev.expiresAt) && issuedAt.Unix() < rev.cutoff {
+		return true, nil
+	}
+	return false, nil
+}
+
+// pruneRevocations drops expired token revocations. Callers must hold m.mu.
+func (m *memoryStorage) pruneRevocations(now time.Time) {
+	for tokenID, expiresAt := range m.revokedTokens {
+		if !now.Before(expiresAt) {
+			delete(m.revokedTokens, tokenID)
+		}
+	}
+}
+
+// Emergency operations
+
+// GetEmergencyStatus retrieves the current emergency mode status.
+func (m *memoryStorage) GetEmergencyStatus(ctx context.Context) (*models.EmergencyStatus, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.emergency.Active {
+		return &models.EmergencyStatus{}, nil
+	}
+	return emergencyStatus(m.emergency.Reason, m.emergency.ActivatedAt, m.emergency.ExpiresAt, time.Now()), nil
+}
+
+// ActivateEmergency activates emergency mode with the given reason and duration.
+func (m *memoryStorage) ActivateEmergency(ctx context.Context, reason string, duration int64) error {
+	now := time.Now()
+	if duration == 0 {
+		duration = DefaultEmergencyDuration
+	}
+
+	m.mu.Lock()
+	m.emergency = models.EmergencyStatus{
+		Active:      true,
+		Reason:      reason,
+		ActivatedAt: time.Unix(now.Unix(), 0),
+		ExpiresAt:   time.Unix(now.Unix()+duration, 0),
+	}
+	activated := m.emergency
+	m.mu.Unlock()
+
+	m.recordEmergencyEvent(ctx, models.EmergencyActivated, &activated, now)
+
+	// Publish emergency activation event
+	m.publishEvent(m.eventChannel, map[string]interface{}{
+		"type":      "emergency_activated",
+		"reason":    reason,
+		"duration":  duration,
+		"timestamp": now.Unix(),
+	})
+
+	return nil
+}
+
+// DeactivateEmergency deactivates emergency mode.
+func (m *memoryStorage) DeactivateEmergency(ctx context.Context) error {
+	now := time.Now()
+
+	m.mu.Lock()
+	deactivated := m.emergency
+	m.emergency = models.EmergencyStatus{}
+	m.mu.Unlock()
+
+	if deactivated.Active {
+		m.recordEmergencyEvent(ctx, models.EmergencyDeactivated, &deactivated, now)
+	}
+
+	// Publish emergency deactivation event
+	m.publishEvent(m.eventChannel, map[string]interface{}{
+		"type":      "emergency_deactivated",
+		"reason":    EmergencyReasonManual,
+		"timestamp": now.Unix(),
+	})
+
+	return nil
+}
+
+// ExpireEmergency deactivates emergency mode if it has expired.
+func (m *memoryStorage) ExpireEmergency(ctx context.Context) (*models.EmergencyStatus, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	if !m.emergency.Active || now.Before(m.emergency.ExpiresAt) {
+		m.mu.Unlock()
+		return nil, nil
+	}
+	expired := m.emergency
+	expired.Active = false
+	expired.Duration = int64(expired.ExpiresAt.Sub(expired.ActivatedAt) / time.Second)
+	m.emergency = models.EmergencyStatus{}
+	m.mu.Unlock()
+
+	m.recordEmergencyEvent(ctx, models.EmergencyExpired, &expired, now)
+
+	m.publishEvent(m.eventChannel, map[string]interface{}{
+		"type":       "emergency_deactivated",
+		"reason":     EmergencyReasonExpired,
+		"expires_at": expired.ExpiresAt.Unix(),
+		"timestamp":  now.Unix(),
+	})
+
+	return &expired, nil
+}
+
+// ExtendEmergency makes the active emergency expire duration seconds from now.
+func (m *memoryStorage) ExtendEmergency(ctx context.Context, duration int64) (*models.EmergencyStatus, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	if !m.emergency.Active || !now.Before(m.emergency.ExpiresAt) {
+		m.mu.Unlock()
+		return nil, errors.Conflict("emergency mode is not active", nil)
+	}
+	if now.Unix()+duration > m.emergency.ActivatedAt.Unix()+validation.MaxEmergencyDuration {
+		activatedAt := m.emergency.ActivatedAt
+		m.mu.Unlock()
+		return nil, emergencyTooLong(activatedAt)
+	}
+	m.emergency.ExpiresAt = time.Unix(now.Unix()+duration, 0)
+	extended := m.emergency
+	m.mu.Unlock()
+
+	m.recordEmergencyEvent(ctx, models.EmergencyExtended, &extended, now)
+
+	m.publishEvent(m.eventChannel, map[string]interface{}{
+		"type":       "emergency_extended",
+		"expires_at": extended.ExpiresAt.Unix(),
+		"duration":   duration,
+		"timestamp":  now.Unix(),
+	})
+
+	return emergencyStatus(extended.Reason, extended.ActivatedAt, extended.ExpiresAt, now), nil
+}
+
+// recordEmergencyEvent appends a history event for an action on the emergency
+// described by state, dropping the oldest beyond MaxEmergencyEvents.
+func (m *memoryStorage) recordEmergencyEvent(ctx context.Context, action string, state *models.EmergencyStatus, at time.Time) {
+	gateway, _ := m.GetGatewayClusterStatus(ctx, m.gatewayClusterID)
+	event := newEmergencyEvent(ctx, action, state, at, gateway)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.emergencyLog = append(m.emergencyLog, event)
+	if len(m.emergencyLog) > MaxEmergencyEvents {
+		m.emergencyLog = m.emergencyLog[len(m.emergencyLog)-MaxEmergencyEvents:]
+	}
+}
+
+// ListEmergencyEvents returns the history events between from and to, newest first.
+func (m *memoryStorage) ListEmergencyEvents(ctx context.Context, from, to time.Time) ([]*models.EmergencyEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := make([]*models.EmergencyEvent, 0)
+	for i := len(m.emergencyLog) - 1; i >= 0; i-- {
+		event := *m.emergencyLog[i]
+		if (!from.IsZero() && event.Timestamp.Before(from)) || (!to.IsZero() && event.Timestamp.After(to)) {
+			continue
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+// CreateEmergencySchedule stores a pending window.
+func (m *memoryStorage) CreateEmergencySchedule(ctx context.Context, schedule *models.EmergencySchedule) error {
+	if schedule == nil || schedule.ID == "" {
+		return errors.BadRequest("schedule ID cannot be empty", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *schedule
+	m.schedules[schedule.ID] = &stored
+	return nil
+}
+
+// ListEmergencySchedules returns the pending windows ordered by start time.
+func (m *memoryStorage) ListEmergencySchedules(ctx context.Context) ([]*models.EmergencySchedule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	schedules := make([]*models.EmergencySchedule, 0, len(m.schedules))
+	for _, schedule := range m.schedules {
+		copied := *schedule
+		schedules = append(schedules, &copied)
+	}
+	sortEmergencySchedules(schedules)
+	return schedules, nil
+}
+
+// CancelEmergencySchedule removes a pending window and returns it.
+func (m *memoryStorage) CancelEmergencySchedule(ctx context.Context, id string) (*models.EmergencySchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedule, ok := m.schedules[id]
+	if !ok {
+		return nil, errors.NotFound("emergency schedule not found", nil)
+	}
+	delete(m.schedules, id)
+	return schedule, nil
+}
+
+// ClaimEmergencySchedules removes and returns the windows starting at or before now.
+func (m *memoryStorage) ClaimEmergencySchedules(ctx context.Context, now time.Time) ([]*models.EmergencySchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	claimed := make([]*models.EmergencySchedule, 0)
+	for id, schedule := range m.schedules {
+		if schedule.StartAt.After(now) {
+			continue
+		}
+		delete(m.schedules, id)
+		claimed = append(claimed, schedule)
+	}
+	sortEmergencySchedules(claimed)
+	return claimed, nil
+}
+
+// Metrics operations
+
+// GetSystemMetrics retrieves aggregated system metrics.
+// No gateway reports into memory, so only emergency state is reflected.
+func (m *memoryStorage) GetSystemMetrics(ctx context.Context) (*models.Metrics, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return &models.Metrics{
+		EmergencyActive:  m.emergency.Active,
+		DegradationLevel: "normal",
+	}, nil
+}
+
+// GetAppMetrics retrieves metrics for a specific application.
+func (m *memoryStorage) GetAppMetrics(ctx context.Context, appID string) (*models.AppMetrics, error) {
+	if appID == "" {
+		return nil, errors.BadRequest("app ID cannot be empty", nil)
+	}
+
+	return &models.AppMetrics{AppID: appID}, nil
+}
+
+// GetConnectionMetrics returns the configured limits. No gateway reports to
+// the memory backend, so usage is always zero.
+func (m *memoryStorage) GetConnectionMetrics(ctx context.Context, byNode bool) ([]*models.ConnectionStats, error) {
+	apps, err := m.ListAppConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := m.ListClusterConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregateConnections(apps, clusters, nil, byNode), nil
+}
+
+// ListConnectionNodes returns no nodes; no gateway reports to the memory backend.
+func (m *memoryStorage) ListConnectionNodes(ctx context.Context) ([]*models.ConnectionNode, error) {
+	return []*models.ConnectionNode{}, nil
+}
+
+// PubSub operations
+
+// Subscribe subscribes to one or more channels and returns a message channel.
+// The returned channel is closed when ctx is done or the storage is closed.
+func (m *memoryStorage) Subscribe(ctx context.Context, channels ...string) (<-chan *PubSubMessage, error) {
+	if len(channels) == 0 {
+		return nil, errors.BadRequest("at least one channel required", nil)
+	}
+
+	// Default channels if none specified
+	if len(channels) == 1 && channels[0] == "" {
+		channels = []string{m.eventChannel, m.configUpdateChannel}
+	}
+
+	sub := &memorySubscriber{
+		channels: make(map[string]bool, len(channels)),
+		ch:       make(chan *PubSubMessage, 100),
+	}
+	for _, channel := range channels {
+		sub.channels[channel] = true
+	}
+
+	m.subMu.Lock()
+	m.subscribers[sub] = struct{}{}
+	m.subMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		m.subMu.Lock()
+		defer m.subMu.Unlock()
+		if _, ok := m.subscribers[sub]; ok {
+			delete(m.subscribers, sub)
+			close(sub.ch)
+		}
+	}()
+
+	return sub.ch, nil
+}
+
+// Publish publishes a message to a channel.
+func (m *memoryStorage) Publish(ctx context.Context, channel string, message interface{}) error {
+	if channel == "" {
+		return errors.BadRequest("channel cannot be empty", nil)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return errors.InternalServerError("failed to marshal message", err)
+	}
+
+	m.fanOut(channel, data)
+	return nil
+}
+
+// publishEvent marshals and publishes an internal event, ignoring marshal errors
+// the same way the Redis implementation does.
+func (m *memoryStorage) publishEvent(channel string, event map[string]interface{}) {
+	eventJSON, _ := json.Marshal(event)
+	m.fanOut(channel, eventJSON)
+}
+
+// fanOut delivers a payload to every subscriber of channel.
+// Like Redis pub/sub, delivery is best effort: slow subscribers drop messages.
+func (m *memoryStorage) fanOut(channel string, payload []byte) {
+	m.subMu.RLock()
+	defer m.subMu.RUnlock()
+
+	for sub := range m.subscribers {
+		if !sub.channels[channel] {
+			continue
+		}
+		select {
+		case sub.ch <- &PubSubMessage{Channel: channel, Payload: payload}:
+		default:
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pageIDs returns up to limit IDs that sort after cursor, plus the cursor for
+// the next page ("" when there are no more IDs).
+func pageIDs(ids []string, cursor string, limit int) ([]string, string) {
+	start := sort.SearchStrings(ids, cursor)
+	if start < len(ids) && ids[start] == cursor {
+		start++
+	}
+	if limit <= 0 || start+limit >= len(ids) {
+		return ids[start:], ""
+	}
+
+	page := ids[start : start+limit]
+	return page, page[len(page)-1]
+}
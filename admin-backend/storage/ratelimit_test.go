@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestAllowRequest runs the sliding-window log against the memory backend and,
+// when REDIS_ADDR is set, against allowRequestScript.
+func TestAllowRequest(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	user := RateLimitBucket{Key: "user:u1", Limit: 3, Window: time.Minute}
+	route := RateLimitBucket{Key: "route:POST /apps:user:u1", Limit: 1, Window: time.Minute}
+
+	type request struct {
+		// at is the request time relative to start
+		at            time.Duration
+		buckets       []RateLimitBucket
+		wantAllowed   bool
+		wantRemaining []int
+		// wantResetAt is relative to start, per bucket
+		wantResetAt []time.Duration
+	}
+
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{
+			name: "remaining counts down to a refusal",
+			requests: []request{
+				{at: 0, buckets: []RateLimitBucket{user}, wantAllowed: true, wantRemaining: []int{2}, wantResetAt: []time.Duration{time.Minute}},
+				{at: time.Second, buckets: []RateLimitBucket{user}, wantAllowed: true, wantRemaining: []int{1}, wantResetAt: []time.Duration{time.Minute}},
+				{at: 2 * time.Second, buckets: []RateLimitBucket{user}, wantAllowed: true, wantRemaining: []int{0}, wantResetAt: []time.Duration{time.Minute}},
+				{at: 3 * time.Second, buckets: []RateLimitBucket{user}, wantAllowed: false, wantRemaining: []int{0}, wantResetAt: []time.Duration{time.Minute}},
+			},
+		},
+		{
+			name: "window slides past the oldest request",
+			requests: []request{
+				{at: 0, buckets: []RateLimitBucket{user}, wantAllowed: true, wantRemaining: []int{2}, wantResetAt: []time.Duration{time.Minute}},
+				{at: 10 * time.Second, buckets: []RateLimitBucket{user}, wantAllowed: true, wantRemaining: []int{1}, wantResetAt: []time.Duration{time.Minute}},
+				{at: 20 * time.Second, buckets: []RateLimitBucket{user}, wantAllowed: true, wantRemaining: []int{0}, wantResetAt: []time.Duration{time.Minute}},
+				{at: time.Minute - time.Millisecond, buckets: []RateLimitBucket{user}, wantAllowed: false, wantRemaining: []int{0}, wantResetAt: []time.Duration{time.Minute}},
+				// The first request leaves the window exactly one window later
+				{at: time.Minute, buckets: []RateLimitBucket{user}, wantAllowed: true, wantRemaining: []int{0}, wantResetAt: []time.Duration{70 * time.Second}},
+				{at: time.Minute + time.Second, buckets: []RateLimitBucket{user}, wantAllowed: false, wantRemaining: []int{0}, wantResetAt: []time.Duration{70 * time.Second}},
+			},
+		},
+		{
+			name: "a full bucket refuses without recording in the others",
+			requests: []request{
+				{at: 0, buckets: []RateLimitBucket{user, route}, wantAllowed: true, wantRemaining: []int{2, 0}, wantResetAt: []time.Duration{time.Minute, time.Minute}},
+				{at: time.Second, buckets: []RateLimitBucket{user, route}, wantAllowed: false, wantRemaining: []int{2, 0}, wantResetAt: []time.Duration{time.Minute, time.Minute}},
+				{at: 2 * time.Second, buckets: []RateLimitBucket{user}, wantAllowed: true, wantRemaining: []int{1}, wantResetAt: []time.Duration{time.Minute}},
+			},
+		},
+		{
+			name: "empty bucket resets one window from now",
+			requests: []request{
+				{at: 0, buckets: []RateLimitBucket{{Key: "ip:192.0.2.1", Limit: 0, Window: time.Minute}}, wantAllowed: false, wantRemaining: []int{0}, wantResetAt: []time.Duration{time.Minute}},
+			},
+		},
+	}
+
+	for _, backend := range testBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				store := backend.open(t)
+				ctx := context.Background()
+
+				for i, req := range tt.requests {
+					allowed, states, err := store.AllowRequest(ctx, req.buckets, start.Add(req.at))
+					if err != nil {
+						t.Fatalf("request %d: %v", i+1, err)
+					}
+					if allowed != req.wantAllowed {
+						t.Errorf("request %d: allowed = %t, want %t", i+1, allowed, req.wantAllowed)
+					}
+					if len(states) != len(req.buckets) {
+						t.Fatalf("request %d: %d states for %d buckets", i+1, len(states), len(req.buckets))
+					}
+					for j, state := range states {
+						if state.Remaining != req.wantRemaining[j] {
+							t.Errorf("request %d, bucket %s: remaining = %d, want %d", i+1, req.buckets[j].Key, state.Remaining, req.wantRemaining[j])
+						}
+						if want := start.Add(req.wantResetAt[j]); !state.ResetAt.Equal(want) {
+							t.Errorf("request %d, bucket %s: reset at %s, want %s", i+1, req.buckets[j].Key, state.ResetAt, want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -4,14 +4,27 @@ package storage
 import (
 	"admin-backend/errors"
 	"admin-backend/models"
+	"admin-backend/validation"
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// scanBatchSize is the COUNT hint used when walking a keyspace with SCAN
+	scanBatchSize = 500
+	// pipelineBatchSize is the number of commands sent per pipelined round trip
+	pipelineBatchSize = 100
+	// maxVersionRetries bounds the retries of unconditional writes that race with other writers
+	maxVersionRetries = 5
 )
 
 // redisStorage implements the Storage interface using Redis.
@@ -19,18 +32,40 @@ type redisStorage struct {
 	client *redis.Client
 	mu     sync.RWMutex
 	// Config constants
-	appKeyPrefix         string
-	clusterKeyPrefix     string
-	emergencyKeyPrefix   string
-	metricsKeyPrefix     string
-	statsKeyPrefix       string
-	eventChannel         string
-	configUpdateChannel  string
+	appKeyPrefix        string
+	clusterKeyPrefix    string
+	clusterAppsPrefix   string
+	clusterAppsIndexKey string
+	emergencyKeyPrefix  string
+	metricsKeyPrefix    string
+	statsKeyPrefix      string
+	connStatsKeyPrefix  string
+	historyKeyPrefix    string
+	userKeyPrefix       string
+	usernameIndexKey    string
+	auditLogKey         string
+	emergencyLogKey     string
+	scheduleKey         string
+	scheduleIndexKey    string
+	revokedTokenPrefix  string
+	revokedUserPrefix   string
+	apiKeyPrefix        string
+	apiKeyIndexKey      string
+	loginStatePrefix    string
+	loginAttemptPrefix  string
+	requestLimitPrefix  string
+	eventChannel        string
+	configUpdateChannel string
+	// l1KeyPrefix names the gateway's L1 bucket keys, driven by gatewayClusterID
+	l1KeyPrefix      string
+	gatewayClusterID string
+	// maxHistory is the number of snapshots kept per history list
+	maxHistory int
 }
 
 // NewRedisStorage creates a new Redis storage instance.
-// The options parameter configures the Redis connection.
-func NewRedisStorage(opts *redis.Options) (Storage, error) {
+// The opts parameter configures the Redis connection; options configure the storage.
+func NewRedisStorage(opts *redis.Options, options ...Option) (Storage, error) {
 	if opts == nil {
 		return nil, errors.BadRequest("redis options cannot be nil", nil)
 	}
@@ -45,16 +80,42 @@ func NewRedisStorage(opts *redis.Options) (Storage, error) {
 		return nil, errors.InternalServerError("failed to connect to redis", err)
 	}
 
-	return &redisStorage{
-		client:             client,
-		appKeyPrefix:       "ratelimit:app:",
-		clusterKeyPrefix:   "ratelimit:cluster:",
-		emergencyKeyPrefix: "ratelimit:emergency:",
-		metricsKeyPrefix:   "ratelimit:app_metrics:",
-		statsKeyPrefix:     "ratelimit:stats:",
-		eventChannel:       "ratelimit:events",
+	o := newOptions(options)
+	r := &redisStorage{
+		client:              client,
+		appKeyPrefix:        "ratelimit:app:",
+		clusterKeyPrefix:    "ratelimit:cluster:",
+		clusterAppsPrefix:   "ratelimit:cluster_apps:",
+		clusterAppsIndexKey: "ratelimit:cluster_apps_indexed",
+		emergencyKeyPrefix:  "ratelimit:emergency:",
+		metricsKeyPrefix:    "ratelimit:app_metrics:",
+		statsKeyPrefix:      "ratelimit:stats:",
+		connStatsKeyPrefix:  "connlimit:stats:node:",
+		historyKeyPrefix:    "ratelimit:history:",
+		userKeyPrefix:       "ratelimit:user:",
+		usernameIndexKey:    "ratelimit:user_index",
+		auditLogKey:         "ratelimit:audit_log",
+		emergencyLogKey:     "ratelimit:emergency_log",
+		scheduleKey:         "ratelimit:emergency_schedules",
+		scheduleIndexKey:    "ratelimit:emergency_schedule_index",
+		revokedTokenPrefix:  "ratelimit:revoked_token:",
+		revokedUserPrefix:   "ratelimit:revoked_user:",
+		apiKeyPrefix:        "ratelimit:api_key:",
+		apiKeyIndexKey:      "ratelimit:api_key_index",
+		loginStatePrefix:    "ratelimit:login_state:",
+		loginAttemptPrefix:  "ratelimit:login_attempts:",
+		requestLimitPrefix:  "ratelimit:admin_requests:",
+		eventChannel:        "ratelimit:events",
 		configUpdateChannel: "ratelimit:config_update",
-	}, nil
+		l1KeyPrefix:         "ratelimit:l1:cluster:",
+		gatewayClusterID:    o.GatewayClusterID,
+		maxHistory:          o.MaxHistoryEntries,
+	}
+
+	if err := r.indexClusterApps(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 // Close closes the Redis connection.
@@ -81,8 +142,14 @@ func (r *redisStorage) GetAppConfig(ctx context.Context, appID string) (*models.
 		return nil, errors.InternalServerError("failed to get app config", err)
 	}
 
+	return parseAppConfig(appID, data), nil
+}
+
+// parseAppConfig builds an AppConfig from its Redis hash fields.
+// Returns nil if the hash is empty (key does not exist).
+func parseAppConfig(appID string, data map[string]string) *models.AppConfig {
 	if len(data) == 0 {
-		return nil, nil
+		return nil
 	}
 
 	config := &models.AppConfig{AppID: appID}
@@ -103,16 +170,38 @@ func (r *redisStorage) GetAppConfig(ctx context.Context, appID string) (*models.
 	if v, ok := data["max_connections"]; ok {
 		config.MaxConnections, _ = strconv.ParseInt(v, 10, 64)
 	}
+	if v := data["clusters"]; v != "" {
+		_ = json.Unmarshal([]byte(v), &config.Clusters)
+	}
+	if v, ok := data["version"]; ok {
+		config.Version, _ = strconv.ParseInt(v, 10, 64)
+	}
 	if v, ok := data["updated_at"]; ok {
 		ts, _ := strconv.ParseFloat(v, 64)
 		config.UpdatedAt = time.Unix(int64(ts), 0)
 	}
 
-	return config, nil
+	return config
+}
+
+// encodeClusters encodes cluster assignments for the clusters hash field,
+// which is empty when the app is assigned to no cluster.
+func encodeClusters(assignments []models.ClusterAssignment) string {
+	if len(assignments) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(assignments)
+	return string(data)
 }
 
 // SetAppConfig creates or updates an application configuration.
+// The stored version is incremented and copied back into config.Version.
 func (r *redisStorage) SetAppConfig(ctx context.Context, config *models.AppConfig) error {
+	return r.CompareAndSetAppConfig(ctx, config, AnyVersion)
+}
+
+// CompareAndSetAppConfig writes config only if the stored version equals expectedVersion.
+func (r *redisStorage) CompareAndSetAppConfig(ctx context.Context, config *models.AppConfig, expectedVersion int64) error {
 	if config == nil {
 		return errors.BadRequest("config cannot be nil", nil)
 	}
@@ -124,44 +213,52 @@ func (r *redisStorage) SetAppConfig(ctx context.Context, config *models.AppConfi
 	now := time.Now().Unix()
 
 	// Set defaults for zero values
-	burstQuota := config.BurstQuota
-	if burstQuota == 0 {
-		burstQuota = config.GuaranteedQuota * 5
-	}
-
-	maxBorrow := config.MaxBorrow
-	if maxBorrow == 0 {
-		maxBorrow = config.GuaranteedQuota
-	}
-
-	maxConnections := config.MaxConnections
-	if maxConnections == 0 {
-		maxConnections = 1000
-	}
-
-	// Use pipeline for atomic operation
-	pipe := r.client.Pipeline()
-	pipe.HSet(ctx, key,
-		"app_id", config.AppID,
-		"guaranteed_quota", config.GuaranteedQuota,
-		"burst_quota", burstQuota,
-		"priority", config.Priority,
-		"max_borrow", maxBorrow,
-		"max_connections", maxConnections,
+	cfg := withAppDefaults(config)
+	cfg.UpdatedAt = time.Unix(now, 0)
+
+	// The script moves the app between the member sets of its old and new clusters
+	clusterIDs := assignedClusterIDs(&cfg)
+	membership := make([]interface{}, 0, len(clusterIDs)+3)
+	membership = append(membership, r.clusterAppsPrefix, cfg.AppID, len(clusterIDs))
+	for _, id := range clusterIDs {
+		membership = append(membership, id)
+	}
+
+	keys := append([]string{key}, r.historyKeys(models.ResourceApp, cfg.AppID)...)
+	res, err := r.runVersionedWrite(ctx, compareAndSetAppScript, keys, membership, expectedVersion,
+		func(previous int64) *models.ConfigSnapshot {
+			snap := newSnapshot(ctx, models.ResourceApp, cfg.AppID, previous, "")
+			app := cfg
+			app.Version = snap.Version
+			snap.App = &app
+			return snap
+		},
+		"app_id", cfg.AppID,
+		"guaranteed_quota", cfg.GuaranteedQuota,
+		"burst_quota", cfg.BurstQuota,
+		"priority", cfg.Priority,
+		"max_borrow", cfg.MaxBorrow,
+		"max_connections", cfg.MaxConnections,
+		"clusters", encodeClusters(cfg.Clusters),
 		"updated_at", now,
 	)
+	if err != nil {
+		return wrapStorageError("failed to set app config", err)
+	}
+	version := res[1]
+	config.Version = version
 
 	// Publish configuration update event
 	event := map[string]interface{}{
 		"type":      "app_config",
 		"app_id":    config.AppID,
+		"version":   version,
+		"clusters":  clusterIDs,
 		"timestamp": now,
 	}
 	eventJSON, _ := json.Marshal(event)
-	pipe.Publish(ctx, r.configUpdateChannel, eventJSON)
-
-	if _, err := pipe.Exec(ctx); err != nil {
-		return errors.InternalServerError("failed to set app config", err)
+	if err := r.client.Publish(ctx, r.configUpdateChannel, eventJSON).Err(); err != nil {
+		return errors.InternalServerError("failed to publish app config update", err)
 	}
 
 	return nil
@@ -175,64 +272,81 @@ func (r *redisStorage) DeleteAppConfig(ctx context.Context, appID string) error
 
 	key := r.appKeyPrefix + appID
 
-	// Use pipeline for atomic operation
-	pipe := r.client.Pipeline()
-	pipe.Del(ctx, key)
+	// Record the last state, retrying if the app changes underneath us
+	for attempt := 1; ; attempt++ {
+		current, err := r.GetAppConfig(ctx, appID)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return errors.NotFound("app config not found", nil)
+		}
 
-	// Publish deletion event
-	event := map[string]interface{}{
-		"type":      "app_deleted",
-		"app_id":    appID,
-		"timestamp": time.Now().Unix(),
-	}
-	eventJSON, _ := json.Marshal(event)
-	pipe.Publish(ctx, r.configUpdateChannel, eventJSON)
+		snap := newSnapshot(ctx, models.ResourceApp, appID, current.Version, models.ChangeDelete)
+		snap.App = current
+		snapJSON, _ := json.Marshal(snap)
 
-	if _, err := pipe.Exec(ctx); err != nil {
-		return errors.InternalServerError("failed to delete app config", err)
-	}
+		// The deletion event is published by the script, only if the app is deleted
+		event := map[string]interface{}{
+			"type":      "app_deleted",
+			"app_id":    appID,
+			"timestamp": time.Now().Unix(),
+		}
+		eventJSON, _ := json.Marshal(event)
 
-	return nil
+		keys := append([]string{key}, r.historyKeys(models.ResourceApp, appID)...)
+		res, err := deleteAppScript.Run(ctx, r.client, keys,
+			current.Version, snapJSON, r.maxHistory, r.configUpdateChannel, eventJSON, r.clusterAppsPrefix, appID).Int64Slice()
+		if err != nil {
+			return errors.InternalServerError("failed to delete app config", err)
+		}
+		if res[0] == 1 {
+			return nil
+		}
+		if attempt >= maxVersionRetries {
+			return versionConflict("app config", res[1], current.Version)
+		}
+	}
 }
 
-// ListAppConfigs returns all application configurations.
+// ListAppConfigs returns all application configurations ordered by ID.
 func (r *redisStorage) ListAppConfigs(ctx context.Context) ([]*models.AppConfig, error) {
-	keys, err := r.client.Keys(ctx, r.appKeyPrefix+"*").Result()
+	keys, err := r.scanKeys(ctx, r.appKeyPrefix+"*")
 	if err != nil {
 		return nil, errors.InternalServerError("failed to list app configs", err)
 	}
 
-	var configs []*models.AppConfig
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	errChan := make(chan error, len(keys))
+	return r.getAppConfigs(ctx, idsFromKeys(keys, r.appKeyPrefix))
+}
 
-	for _, key := range keys {
-		wg.Add(1)
-		go func(k string) {
-			defer wg.Done()
-			appID := k[len(r.appKeyPrefix):]
-			config, err := r.GetAppConfig(ctx, appID)
-			if err != nil {
-				errChan <- err
-				return
-			}
-			if config != nil {
-				mu.Lock()
-				configs = append(configs, config)
-				mu.Unlock()
-			}
-		}(key)
+// ScanAppConfigs returns one page of application configurations.
+func (r *redisStorage) ScanAppConfigs(ctx context.Context, cursor string, limit int) ([]*models.AppConfig, string, error) {
+	ids, next, err := r.scanIDPage(ctx, r.appKeyPrefix, cursor, limit)
+	if err != nil {
+		return nil, "", err
 	}
 
-	wg.Wait()
-	close(errChan)
+	configs, err := r.getAppConfigs(ctx, ids)
+	if err != nil {
+		return nil, "", err
+	}
 
-	// Check for errors
-	for err := range errChan {
-		if err != nil {
-			return nil, err
+	return configs, next, nil
+}
+
+// getAppConfigs fetches the application configurations for the given IDs
+// with pipelined HGETALLs and returns them sorted by app ID.
+func (r *redisStorage) getAppConfigs(ctx context.Context, appIDs []string) ([]*models.AppConfig, error) {
+	sort.Strings(appIDs)
+	configs := make([]*models.AppConfig, 0, len(appIDs))
+
+	err := r.hgetAllBatched(ctx, r.appKeyPrefix, appIDs, func(id string, data map[string]string) {
+		if config := parseAppConfig(id, data); config != nil {
+			configs = append(configs, config)
 		}
+	})
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get app configs", err)
 	}
 
 	return configs, nil
@@ -252,8 +366,14 @@ func (r *redisStorage) GetClusterConfig(ctx context.Context, clusterID string) (
 		return nil, errors.InternalServerError("failed to get cluster config", err)
 	}
 
+	return parseClusterConfig(clusterID, data), nil
+}
+
+// parseClusterConfig builds a ClusterConfig from its Redis hash fields.
+// Returns nil if the hash is empty (key does not exist).
+func parseClusterConfig(clusterID string, data map[string]string) *models.ClusterConfig {
 	if len(data) == 0 {
-		return nil, nil
+		return nil
 	}
 
 	config := &models.ClusterConfig{ClusterID: clusterID}
@@ -270,16 +390,25 @@ func (r *redisStorage) GetClusterConfig(ctx context.Context, clusterID string) (
 	if v, ok := data["max_connections"]; ok {
 		config.MaxConnections, _ = strconv.ParseInt(v, 10, 64)
 	}
+	if v, ok := data["version"]; ok {
+		config.Version, _ = strconv.ParseInt(v, 10, 64)
+	}
 	if v, ok := data["updated_at"]; ok {
 		ts, _ := strconv.ParseFloat(v, 64)
 		config.UpdatedAt = time.Unix(int64(ts), 0)
 	}
 
-	return config, nil
+	return config
 }
 
 // SetClusterConfig creates or updates a cluster configuration.
+// The stored version is incremented and copied back into config.Version.
 func (r *redisStorage) SetClusterConfig(ctx context.Context, config *models.ClusterConfig) error {
+	return r.CompareAndSetClusterConfig(ctx, config, AnyVersion)
+}
+
+// CompareAndSetClusterConfig writes config only if the stored version equals expectedVersion.
+func (r *redisStorage) CompareAndSetClusterConfig(ctx context.Context, config *models.ClusterConfig, expectedVersion int64) error {
 	if config == nil {
 		return errors.BadRequest("config cannot be nil", nil)
 	}
@@ -291,73 +420,295 @@ func (r *redisStorage) SetClusterConfig(ctx context.Context, config *models.Clus
 	now := time.Now().Unix()
 
 	// Set defaults for zero values
-	reservedRatio := config.ReservedRatio
-	if reservedRatio == 0 {
-		reservedRatio = 0.1
+	cfg := withClusterDefaults(config)
+	cfg.UpdatedAt = time.Unix(now, 0)
+
+	// The gateway cluster's L1 bucket is written in the same atomic step as its config
+	script := compareAndSetScript
+	keys := append([]string{key}, r.historyKeys(models.ResourceCluster, cfg.ClusterID)...)
+	var extra []interface{}
+	gateway := cfg.ClusterID == r.gatewayClusterID
+	if gateway {
+		script = compareAndSetClusterScript
+		keys = append(keys,
+			r.l1KeyPrefix+"capacity",
+			r.l1KeyPrefix+"available",
+			r.l1KeyPrefix+"reserved_ratio",
+			r.l1KeyPrefix+"emergency_threshold",
+		)
+		extra = []interface{}{cfg.MaxCapacity, cfg.ReservedRatio, cfg.EmergencyThreshold}
+	}
+
+	res, err := r.runVersionedWrite(ctx, script, keys, extra, expectedVersion,
+		func(previous int64) *models.ConfigSnapshot {
+			snap := newSnapshot(ctx, models.ResourceCluster, cfg.ClusterID, previous, "")
+			cluster := cfg
+			cluster.Version = snap.Version
+			snap.Cluster = &cluster
+			return snap
+		},
+		"cluster_id", cfg.ClusterID,
+		"max_capacity", cfg.MaxCapacity,
+		"reserved_ratio", cfg.ReservedRatio,
+		"emergency_threshold", cfg.EmergencyThreshold,
+		"max_connections", cfg.MaxConnections,
+		"updated_at", now,
+	)
+	if err != nil {
+		return wrapStorageError("failed to set cluster config", err)
 	}
+	version := res[1]
+	config.Version = version
+
+	pipe := r.client.Pipeline()
 
-	emergencyThreshold := config.EmergencyThreshold
-	if emergencyThreshold == 0 {
-		emergencyThreshold = 0.95
+	// Publish configuration update event so gateways pick up capacity changes
+	event := map[string]interface{}{
+		"type":                "cluster_config",
+		"cluster_id":          cfg.ClusterID,
+		"version":             version,
+		"max_capacity":        cfg.MaxCapacity,
+		"reserved_ratio":      cfg.ReservedRatio,
+		"emergency_threshold": cfg.EmergencyThreshold,
+		"timestamp":           now,
 	}
+	eventJSON, _ := json.Marshal(event)
+	pipe.Publish(ctx, r.configUpdateChannel, eventJSON)
 
-	maxConnections := config.MaxConnections
-	if maxConnections == 0 {
-		maxConnections = 5000
+	if gateway {
+		// Same event the gateway's set_capacity publishes
+		event := map[string]interface{}{
+			"type":                "capacity_changed",
+			"cluster_id":          cfg.ClusterID,
+			"old_capacity":        res[2],
+			"new_capacity":        cfg.MaxCapacity,
+			"available":           res[3],
+			"reserved_ratio":      cfg.ReservedRatio,
+			"emergency_threshold": cfg.EmergencyThreshold,
+			"timestamp":           now,
+		}
+		eventJSON, _ := json.Marshal(event)
+		pipe.Publish(ctx, r.eventChannel, eventJSON)
 	}
 
-	if err := r.client.HSet(ctx, key,
-		"cluster_id", config.ClusterID,
-		"max_capacity", config.MaxCapacity,
-		"reserved_ratio", reservedRatio,
-		"emergency_threshold", emergencyThreshold,
-		"max_connections", maxConnections,
-		"updated_at", now,
-	).Err(); err != nil {
-		return errors.InternalServerError("failed to set cluster config", err)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.InternalServerError("failed to publish cluster config update", err)
 	}
 
 	return nil
 }
 
-// ListClusterConfigs returns all cluster configurations.
+// DeleteClusterConfig removes a cluster configuration.
+func (r *redisStorage) DeleteClusterConfig(ctx context.Context, clusterID string) error {
+	if clusterID == "" {
+		return errors.BadRequest("cluster ID cannot be empty", nil)
+	}
+
+	key := r.clusterKeyPrefix + clusterID
+
+	// Record the last state, retrying if the cluster changes underneath us
+	for attempt := 1; ; attempt++ {
+		current, err := r.GetClusterConfig(ctx, clusterID)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return errors.NotFound("cluster config not found", nil)
+		}
+
+		snap := newSnapshot(ctx, models.ResourceCluster, clusterID, current.Version, models.ChangeDelete)
+		snap.Cluster = current
+		snapJSON, _ := json.Marshal(snap)
+
+		// The deletion event is published by the script, only if the cluster is deleted
+		event := map[string]interface{}{
+			"type":       "cluster_deleted",
+			"cluster_id": clusterID,
+			"timestamp":  time.Now().Unix(),
+		}
+		eventJSON, _ := json.Marshal(event)
+
+		keys := append([]string{key}, r.historyKeys(models.ResourceCluster, clusterID)...)
+		res, err := deleteVersionedScript.Run(ctx, r.client, keys,
+			current.Version, snapJSON, r.maxHistory, r.configUpdateChannel, eventJSON).Int64Slice()
+		if err != nil {
+			return errors.InternalServerError("failed to delete cluster config", err)
+		}
+		if res[0] == 1 {
+			return nil
+		}
+		if attempt >= maxVersionRetries {
+			return versionConflict("cluster config", res[1], current.Version)
+		}
+	}
+}
+
+// Connection limit operations
+
+// SetConnectionLimit sets the max_connections of the limit's target and
+// publishes it for the gateways' connection limiters.
+func (r *redisStorage) SetConnectionLimit(ctx context.Context, limit *models.ConnectionLimit) (int64, error) {
+	if limit == nil {
+		return 0, errors.BadRequest("limit cannot be nil", nil)
+	}
+
+	version, err := setConnectionLimit(ctx, r, limit)
+	if err != nil {
+		return 0, wrapStorageError("failed to set connection limit", err)
+	}
+
+	event := map[string]interface{}{
+		"type":        "connection_limit",
+		"target_type": limit.TargetType,
+		"target_id":   limit.TargetID,
+		"limit":       limit.Limit,
+		"version":     version,
+		"timestamp":   time.Now().Unix(),
+	}
+	eventJSON, _ := json.Marshal(event)
+	if err := r.client.Publish(ctx, r.configUpdateChannel, eventJSON).Err(); err != nil {
+		return 0, errors.InternalServerError("failed to publish connection limit update", err)
+	}
+
+	return version, nil
+}
+
+// ListConnectionLimits returns the max_connections of every app and cluster.
+func (r *redisStorage) ListConnectionLimits(ctx context.Context) ([]*models.ConnectionLimit, error) {
+	apps, err := r.ListAppConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := r.ListClusterConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return connectionLimits(apps, clusters), nil
+}
+
+// GetGatewayClusterStatus reads the gateway's L1 bucket if clusterID drives it.
+func (r *redisStorage) GetGatewayClusterStatus(ctx context.Context, clusterID string) (*models.GatewayClusterStatus, error) {
+	if clusterID != r.gatewayClusterID {
+		return nil, nil
+	}
+
+	values, err := r.client.MGet(ctx,
+		r.l1KeyPrefix+"capacity",
+		r.l1KeyPrefix+"available",
+		r.l1KeyPrefix+"reserved_ratio",
+		r.l1KeyPrefix+"emergency_threshold",
+		r.l1KeyPrefix+"emergency_mode",
+		r.l1KeyPrefix+"last_reconcile",
+	).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get gateway cluster status", err)
+	}
+	if values[0] == nil {
+		return nil, nil
+	}
+
+	number := func(v interface{}) float64 {
+		s, _ := v.(string)
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	}
+	capacity := number(values[0])
+	available := capacity
+	if values[1] != nil {
+		available = number(values[1])
+	}
+	emergencyMode, _ := values[4].(string)
+
+	return gatewayStatus(capacity, available, number(values[2]), number(values[3]), emergencyMode == "true", number(values[5])), nil
+}
+
+// ListClusterConfigs returns all cluster configurations ordered by ID.
 func (r *redisStorage) ListClusterConfigs(ctx context.Context) ([]*models.ClusterConfig, error) {
-	keys, err := r.client.Keys(ctx, r.clusterKeyPrefix+"*").Result()
+	keys, err := r.scanKeys(ctx, r.clusterKeyPrefix+"*")
 	if err != nil {
 		return nil, errors.InternalServerError("failed to list cluster configs", err)
 	}
 
-	var configs []*models.ClusterConfig
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	errChan := make(chan error, len(keys))
+	return r.getClusterConfigs(ctx, idsFromKeys(keys, r.clusterKeyPrefix))
+}
 
-	for _, key := range keys {
-		wg.Add(1)
-		go func(k string) {
-			defer wg.Done()
-			clusterID := k[len(r.clusterKeyPrefix):]
-			config, err := r.GetClusterConfig(ctx, clusterID)
-			if err != nil {
-				errChan <- err
-				return
-			}
-			if config != nil {
-				mu.Lock()
-				configs = append(configs, config)
-				mu.Unlock()
-			}
-		}(key)
+// ListClusterApps returns the applications assigned to a cluster ordered by ID.
+func (r *redisStorage) ListClusterApps(ctx context.Context, clusterID string) ([]*models.AppConfig, error) {
+	if clusterID == "" {
+		return nil, errors.BadRequest("cluster ID cannot be empty", nil)
 	}
 
-	wg.Wait()
-	close(errChan)
+	ids, err := r.client.SMembers(ctx, r.clusterAppsPrefix+clusterID).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list cluster apps", err)
+	}
+	apps, err := r.getAppConfigs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	// A member moved off the cluster while indexClusterApps ran is left in the set
+	return assignedApps(apps, clusterID), nil
+}
 
-	// Check for errors
-	for err := range errChan {
-		if err != nil {
-			return nil, err
+// indexClusterApps builds the member sets of the clusters from the stored
+// apps, once, for apps written before the sets were kept. Writes since keep
+// them up to date, and a write racing the build can only leave a stale
+// member, which ListClusterApps filters out.
+func (r *redisStorage) indexClusterApps(ctx context.Context) error {
+	indexed, err := r.client.Exists(ctx, r.clusterAppsIndexKey).Result()
+	if err != nil {
+		return errors.InternalServerError("failed to index cluster apps", err)
+	}
+	if indexed > 0 {
+		return nil
+	}
+
+	apps, err := r.ListAppConfigs(ctx)
+	if err != nil {
+		return err
+	}
+	pipe := r.client.Pipeline()
+	for _, app := range apps {
+		for _, clusterID := range assignedClusterIDs(app) {
+			pipe.SAdd(ctx, r.clusterAppsPrefix+clusterID, app.AppID)
+		}
+	}
+	pipe.Set(ctx, r.clusterAppsIndexKey, time.Now().Unix(), 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.InternalServerError("failed to index cluster apps", err)
+	}
+	return nil
+}
+
+// ScanClusterConfigs returns one page of cluster configurations.
+func (r *redisStorage) ScanClusterConfigs(ctx context.Context, cursor string, limit int) ([]*models.ClusterConfig, string, error) {
+	ids, next, err := r.scanIDPage(ctx, r.clusterKeyPrefix, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	configs, err := r.getClusterConfigs(ctx, ids)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return configs, next, nil
+}
+
+// getClusterConfigs fetches the cluster configurations for the given IDs
+// with pipelined HGETALLs and returns them sorted by cluster ID.
+func (r *redisStorage) getClusterConfigs(ctx context.Context, clusterIDs []string) ([]*models.ClusterConfig, error) {
+	sort.Strings(clusterIDs)
+	configs := make([]*models.ClusterConfig, 0, len(clusterIDs))
+
+	err := r.hgetAllBatched(ctx, r.clusterKeyPrefix, clusterIDs, func(id string, data map[string]string) {
+		if config := parseClusterConfig(id, data); config != nil {
+			configs = append(configs, config)
 		}
+	})
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get cluster configs", err)
 	}
 
 	return configs, nil
@@ -367,52 +718,74 @@ func (r *redisStorage) ListClusterConfigs(ctx context.Context) ([]*models.Cluste
 
 // GetEmergencyStatus retrieves the current emergency mode status.
 func (r *redisStorage) GetEmergencyStatus(ctx context.Context) (*models.EmergencyStatus, error) {
-	status := &models.EmergencyStatus{}
-
-	active, err := r.client.Get(ctx, r.emergencyKeyPrefix+"active").Result()
-	if err != nil && err != redis.Nil {
+	// Fetch all emergency keys in a single round trip
+	values, err := r.client.MGet(ctx, r.emergencyKeys()...).Result()
+	if err != nil {
 		return nil, errors.InternalServerError("failed to get emergency status", err)
 	}
 
-	status.Active = active == "1"
-
-	if status.Active {
-		status.Reason, _ = r.client.Get(ctx, r.emergencyKeyPrefix+"reason").Result()
+	active, _ := values[0].(string)
+	if active != "1" {
+		return &models.EmergencyStatus{}, nil
+	}
 
-		activatedAt, _ := r.client.Get(ctx, r.emergencyKeyPrefix+"activated_at").Result()
-		if ts, err := strconv.ParseFloat(activatedAt, 64); err == nil {
-			status.ActivatedAt = time.Unix(int64(ts), 0)
-		}
+	reason, _ := values[1].(string)
+	activatedAt, _ := values[2].(string)
+	expiresAt, _ := values[3].(string)
+	return emergencyStatus(reason, parseUnixTime(activatedAt), parseUnixTime(expiresAt), time.Now()), nil
+}
 
-		expiresAt, _ := r.client.Get(ctx, r.emergencyKeyPrefix+"expires_at").Result()
-		if ts, err := strconv.ParseFloat(expiresAt, 64); err == nil {
-			status.ExpiresAt = time.Unix(int64(ts), 0)
-		}
+// emergencyKeys returns the active, reason, activated_at and expires_at keys.
+func (r *redisStorage) emergencyKeys() []string {
+	return []string{
+		r.emergencyKeyPrefix + "active",
+		r.emergencyKeyPrefix + "reason",
+		r.emergencyKeyPrefix + "activated_at",
+		r.emergencyKeyPrefix + "expires_at",
 	}
+}
 
-	return status, nil
+// parseUnixTime parses a Unix timestamp in seconds, returning the zero time if it is not one.
+func parseUnixTime(v string) time.Time {
+	ts, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(ts), 0)
 }
 
 // ActivateEmergency activates emergency mode with the given reason and duration.
 func (r *redisStorage) ActivateEmergency(ctx context.Context, reason string, duration int64) error {
-	now := time.Now().Unix()
+	now := time.Now()
 	if duration == 0 {
-		duration = 300 // Default 5 minutes
+		duration = DefaultEmergencyDuration
+	}
+	activated := &models.EmergencyStatus{
+		Reason:      reason,
+		ActivatedAt: time.Unix(now.Unix(), 0),
+		ExpiresAt:   time.Unix(now.Unix()+duration, 0),
 	}
 
+	// The keys expire on their own should no backend be running to deactivate
+	// the emergency; the grace leaves time to announce the expiry
+	ttl := time.Duration(duration)*time.Second + EmergencyExpiryGrace
+
 	// Use pipeline for atomic operation
 	pipe := r.client.Pipeline()
-	pipe.Set(ctx, r.emergencyKeyPrefix+"active", "1", 0)
-	pipe.Set(ctx, r.emergencyKeyPrefix+"reason", reason, 0)
-	pipe.Set(ctx, r.emergencyKeyPrefix+"activated_at", now, 0)
-	pipe.Set(ctx, r.emergencyKeyPrefix+"expires_at", now+duration, 0)
+	pipe.Set(ctx, r.emergencyKeyPrefix+"active", "1", ttl)
+	pipe.Set(ctx, r.emergencyKeyPrefix+"reason", reason, ttl)
+	pipe.Set(ctx, r.emergencyKeyPrefix+"activated_at", activated.ActivatedAt.Unix(), ttl)
+	pipe.Set(ctx, r.emergencyKeyPrefix+"expires_at", activated.ExpiresAt.Unix(), ttl)
+	if err := r.recordEmergencyEvent(ctx, pipe, models.EmergencyActivated, activated, now); err != nil {
+		return err
+	}
 
 	// Publish emergency activation event
 	event := map[string]interface{}{
 		"type":      "emergency_activated",
 		"reason":    reason,
 		"duration":  duration,
-		"timestamp": now,
+		"timestamp": now.Unix(),
 	}
 	eventJSON, _ := json.Marshal(event)
 	pipe.Publish(ctx, r.eventChannel, eventJSON)
@@ -426,17 +799,24 @@ func (r *redisStorage) ActivateEmergency(ctx context.Context, reason string, dur
 
 // DeactivateEmergency deactivates emergency mode.
 func (r *redisStorage) DeactivateEmergency(ctx context.Context) error {
-	// Use pipeline for atomic operation
+	now := time.Now()
+	deactivated, err := r.clearEmergency(ctx, now, true)
+	if err != nil {
+		return errors.InternalServerError("failed to deactivate emergency mode", err)
+	}
+
 	pipe := r.client.Pipeline()
-	pipe.Set(ctx, r.emergencyKeyPrefix+"active", "0", 0)
-	pipe.Del(ctx, r.emergencyKeyPrefix+"reason")
-	pipe.Del(ctx, r.emergencyKeyPrefix+"activated_at")
-	pipe.Del(ctx, r.emergencyKeyPrefix+"expires_at")
+	if deactivated != nil {
+		if err := r.recordEmergencyEvent(ctx, pipe, models.EmergencyDeactivated, deactivated, now); err != nil {
+			return err
+		}
+	}
 
 	// Publish emergency deactivation event
 	event := map[string]interface{}{
 		"type":      "emergency_deactivated",
-		"timestamp": time.Now().Unix(),
+		"reason":    EmergencyReasonManual,
+		"timestamp": now.Unix(),
 	}
 	eventJSON, _ := json.Marshal(event)
 	pipe.Publish(ctx, r.eventChannel, eventJSON)
@@ -448,67 +828,288 @@ func (r *redisStorage) DeactivateEmergency(ctx context.Context) error {
 	return nil
 }
 
-// Metrics operations
+// ExpireEmergency deactivates emergency mode if it has expired.
+func (r *redisStorage) ExpireEmergency(ctx context.Context) (*models.EmergencyStatus, error) {
+	now := time.Now()
+	expired, err := r.clearEmergency(ctx, now, false)
+	if err != nil {
+		return nil, errors.InternalServerError("failed to expire emergency mode", err)
+	}
+	if expired == nil {
+		return nil, nil
+	}
 
-// GetSystemMetrics retrieves aggregated system metrics.
-func (r *redisStorage) GetSystemMetrics(ctx context.Context) (*models.Metrics, error) {
-	metrics := &models.Metrics{}
+	pipe := r.client.Pipeline()
+	if err := r.recordEmergencyEvent(ctx, pipe, models.EmergencyExpired, expired, now); err != nil {
+		return nil, err
+	}
 
-	// Get all stats keys
-	keys, err := r.client.Keys(ctx, r.statsKeyPrefix+"*").Result()
-	if err != nil {
-		return nil, errors.InternalServerError("failed to get metrics keys", err)
+	event := map[string]interface{}{
+		"type":       "emergency_deactivated",
+		"reason":     EmergencyReasonExpired,
+		"expires_at": expired.ExpiresAt.Unix(),
+		"timestamp":  now.Unix(),
 	}
+	eventJSON, _ := json.Marshal(event)
+	pipe.Publish(ctx, r.eventChannel, eventJSON)
 
-	// Aggregate metrics from all apps
-	for _, key := range keys {
-		data, err := r.client.HGetAll(ctx, key).Result()
-		if err != nil {
-			continue
-		}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.InternalServerError("failed to publish emergency expiry", err)
+	}
 
-		for _, v := range data {
-			var stats map[string]interface{}
-			if err := json.Unmarshal([]byte(v), &stats); err != nil {
-				continue
-			}
+	return expired, nil
+}
 
-			if rt, ok := stats["requests_total"].(float64); ok {
-				metrics.RequestsTotal += int64(rt)
-			}
-			if rj, ok := stats["rejected_total"].(float64); ok {
-				metrics.RejectedTotal += int64(rj)
-			}
-			if l3, ok := stats["l3_hits"].(float64); ok {
-				metrics.L3Hits += int64(l3)
-			}
+// ExtendEmergency makes the active emergency expire duration seconds from now.
+func (r *redisStorage) ExtendEmergency(ctx context.Context, duration int64) (*models.EmergencyStatus, error) {
+	now := time.Now()
+	expiresAt := now.Unix() + duration
+	ttl := time.Duration(duration)*time.Second + EmergencyExpiryGrace
+
+	res, err := extendEmergencyScript.Run(ctx, r.client, r.emergencyKeys(),
+		now.Unix(), expiresAt, int64(ttl/time.Second), validation.MaxEmergencyDuration).StringSlice()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, errors.Conflict("emergency mode is not active", nil)
 		}
+		return nil, errors.InternalServerError("failed to extend emergency mode", err)
+	}
+	if res[2] != "1" {
+		return nil, emergencyTooLong(parseUnixTime(res[1]))
 	}
 
-	if metrics.RequestsTotal > 0 {
-		metrics.CacheHitRatio = float64(metrics.L3Hits) / float64(metrics.RequestsTotal)
+	extended := &models.EmergencyStatus{
+		Reason:      res[0],
+		ActivatedAt: parseUnixTime(res[1]),
+		ExpiresAt:   time.Unix(expiresAt, 0),
 	}
 
-	// Get emergency status
-	status, err := r.GetEmergencyStatus(ctx)
-	if err == nil {
-		metrics.EmergencyActive = status.Active
+	pipe := r.client.Pipeline()
+	if err := r.recordEmergencyEvent(ctx, pipe, models.EmergencyExtended, extended, now); err != nil {
+		return nil, err
 	}
 
-	// Get degradation level
-	level, _ := r.client.Get(ctx, "ratelimit:degradation:level").Result()
-	if level == "" {
-		level = "normal"
+	event := map[string]interface{}{
+		"type":       "emergency_extended",
+		"expires_at": expiresAt,
+		"duration":   duration,
+		"timestamp":  now.Unix(),
 	}
-	metrics.DegradationLevel = level
+	eventJSON, _ := json.Marshal(event)
+	pipe.Publish(ctx, r.eventChannel, eventJSON)
 
-	return metrics, nil
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.InternalServerError("failed to extend emergency mode", err)
+	}
+
+	return emergencyStatus(extended.Reason, extended.ActivatedAt, extended.ExpiresAt, now), nil
 }
 
-// GetAppMetrics retrieves metrics for a specific application.
-func (r *redisStorage) GetAppMetrics(ctx context.Context, appID string) (*models.AppMetrics, error) {
-	if appID == "" {
-		return nil, errors.BadRequest("app ID cannot be empty", nil)
+// clearEmergency atomically deactivates emergency mode if it is active and
+// either force is set or it has expired by now. Returns the emergency that
+// was cleared, or nil if there was none.
+func (r *redisStorage) clearEmergency(ctx context.Context, now time.Time, force bool) (*models.EmergencyStatus, error) {
+	forceArg := "0"
+	if force {
+		forceArg = "1"
+	}
+
+	res, err := clearEmergencyScript.Run(ctx, r.client, r.emergencyKeys(), now.Unix(), forceArg).StringSlice()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	cleared := &models.EmergencyStatus{
+		Reason:      res[0],
+		ActivatedAt: parseUnixTime(res[1]),
+		ExpiresAt:   parseUnixTime(res[2]),
+	}
+	if !cleared.ActivatedAt.IsZero() {
+		cleared.Duration = int64(cleared.ExpiresAt.Sub(cleared.ActivatedAt) / time.Second)
+	}
+	return cleared, nil
+}
+
+// recordEmergencyEvent queues a history event for an action on the emergency
+// described by state on pipe, trimming the history to MaxEmergencyEvents.
+func (r *redisStorage) recordEmergencyEvent(ctx context.Context, pipe redis.Pipeliner, action string, state *models.EmergencyStatus, at time.Time) error {
+	// Utilization is informational; an unreadable L1 bucket must not block an emergency
+	gateway, _ := r.GetGatewayClusterStatus(ctx, r.gatewayClusterID)
+
+	event := newEmergencyEvent(ctx, action, state, at, gateway)
+	data, err := json.Marshal(event)
+	if err != nil {
+		return errors.InternalServerError("failed to encode emergency event", err)
+	}
+
+	pipe.ZAdd(ctx, r.emergencyLogKey, &redis.Z{Score: float64(event.Timestamp.Unix()), Member: data})
+	pipe.ZRemRangeByRank(ctx, r.emergencyLogKey, 0, -MaxEmergencyEvents-1)
+	return nil
+}
+
+// ListEmergencyEvents returns the history events between from and to, newest first.
+func (r *redisStorage) ListEmergencyEvents(ctx context.Context, from, to time.Time) ([]*models.EmergencyEvent, error) {
+	rangeBy := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+	if !from.IsZero() {
+		rangeBy.Min = strconv.FormatInt(from.Unix(), 10)
+	}
+	if !to.IsZero() {
+		rangeBy.Max = strconv.FormatInt(to.Unix(), 10)
+	}
+
+	items, err := r.client.ZRevRangeByScore(ctx, r.emergencyLogKey, rangeBy).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list emergency events", err)
+	}
+
+	events := make([]*models.EmergencyEvent, 0, len(items))
+	for _, item := range items {
+		var event models.EmergencyEvent
+		if err := json.Unmarshal([]byte(item), &event); err != nil {
+			continue
+		}
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+// CreateEmergencySchedule stores a pending window, indexed by start time.
+func (r *redisStorage) CreateEmergencySchedule(ctx context.Context, schedule *models.EmergencySchedule) error {
+	if schedule == nil || schedule.ID == "" {
+		return errors.BadRequest("schedule ID cannot be empty", nil)
+	}
+
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return errors.InternalServerError("failed to encode emergency schedule", err)
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, r.scheduleKey, schedule.ID, data)
+	pipe.ZAdd(ctx, r.scheduleIndexKey, &redis.Z{Score: float64(schedule.StartAt.Unix()), Member: schedule.ID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.InternalServerError("failed to create emergency schedule", err)
+	}
+
+	return nil
+}
+
+// ListEmergencySchedules returns the pending windows ordered by start time.
+func (r *redisStorage) ListEmergencySchedules(ctx context.Context) ([]*models.EmergencySchedule, error) {
+	items, err := r.client.HVals(ctx, r.scheduleKey).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list emergency schedules", err)
+	}
+
+	return parseEmergencySchedules(items), nil
+}
+
+// CancelEmergencySchedule removes a pending window and returns it.
+func (r *redisStorage) CancelEmergencySchedule(ctx context.Context, id string) (*models.EmergencySchedule, error) {
+	data, err := cancelScheduleScript.Run(ctx, r.client, []string{r.scheduleIndexKey, r.scheduleKey}, id).Text()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, errors.NotFound("emergency schedule not found", nil)
+		}
+		return nil, errors.InternalServerError("failed to cancel emergency schedule", err)
+	}
+
+	var schedule models.EmergencySchedule
+	if err := json.Unmarshal([]byte(data), &schedule); err != nil {
+		return nil, errors.InternalServerError("failed to decode emergency schedule", err)
+	}
+	return &schedule, nil
+}
+
+// ClaimEmergencySchedules removes and returns the windows starting at or before now.
+func (r *redisStorage) ClaimEmergencySchedules(ctx context.Context, now time.Time) ([]*models.EmergencySchedule, error) {
+	items, err := claimSchedulesScript.Run(ctx, r.client, []string{r.scheduleIndexKey, r.scheduleKey}, now.Unix()).StringSlice()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to claim emergency schedules", err)
+	}
+
+	return parseEmergencySchedules(items), nil
+}
+
+// parseEmergencySchedules decodes stored windows, skipping unreadable ones,
+// and orders them by start time.
+func parseEmergencySchedules(items []string) []*models.EmergencySchedule {
+	schedules := make([]*models.EmergencySchedule, 0, len(items))
+	for _, item := range items {
+		var schedule models.EmergencySchedule
+		if err := json.Unmarshal([]byte(item), &schedule); err != nil {
+			continue
+		}
+		schedules = append(schedules, &schedule)
+	}
+
+	sortEmergencySchedules(schedules)
+	return schedules
+}
+
+// Metrics operations
+
+// GetSystemMetrics retrieves aggregated system metrics.
+func (r *redisStorage) GetSystemMetrics(ctx context.Context) (*models.Metrics, error) {
+	metrics := &models.Metrics{}
+
+	// Get all stats keys
+	keys, err := r.scanKeys(ctx, r.statsKeyPrefix+"*")
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get metrics keys", err)
+	}
+
+	// Aggregate metrics from all apps
+	err = r.hgetAllBatched(ctx, "", keys, func(_ string, data map[string]string) {
+		for _, v := range data {
+			var stats map[string]interface{}
+			if err := json.Unmarshal([]byte(v), &stats); err != nil {
+				continue
+			}
+
+			if rt, ok := stats["requests_total"].(float64); ok {
+				metrics.RequestsTotal += int64(rt)
+			}
+			if rj, ok := stats["rejected_total"].(float64); ok {
+				metrics.RejectedTotal += int64(rj)
+			}
+			if l3, ok := stats["l3_hits"].(float64); ok {
+				metrics.L3Hits += int64(l3)
+			}
+		}
+	})
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get metrics", err)
+	}
+
+	if metrics.RequestsTotal > 0 {
+		metrics.CacheHitRatio = float64(metrics.L3Hits) / float64(metrics.RequestsTotal)
+	}
+
+	// Get emergency status
+	status, err := r.GetEmergencyStatus(ctx)
+	if err == nil {
+		metrics.EmergencyActive = status.Active
+	}
+
+	// Get degradation level
+	level, _ := r.client.Get(ctx, "ratelimit:degradation:level").Result()
+	if level == "" {
+		level = "normal"
+	}
+	metrics.DegradationLevel = level
+
+	return metrics, nil
+}
+
+// GetAppMetrics retrieves metrics for a specific application.
+func (r *redisStorage) GetAppMetrics(ctx context.Context, appID string) (*models.AppMetrics, error) {
+	if appID == "" {
+		return nil, errors.BadRequest("app ID cannot be empty", nil)
 	}
 
 	metrics := &models.AppMetrics{AppID: appID}
@@ -535,11 +1136,65 @@ func (r *redisStorage) GetAppMetrics(ctx context.Context, appID string) (*models
 	return metrics, nil
 }
 
-// GetConnectionMetrics retrieves connection statistics.
-func (r *redisStorage) GetConnectionMetrics(ctx context.Context) ([]*models.ConnectionStats, error) {
-	// This would need to be implemented based on actual metrics storage
-	// For now, return empty slice
-	return []*models.ConnectionStats{}, nil
+// GetConnectionMetrics aggregates the gateway nodes' connection reports with the configured limits.
+func (r *redisStorage) GetConnectionMetrics(ctx context.Context, byNode bool) ([]*models.ConnectionStats, error) {
+	apps, err := r.ListAppConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := r.ListClusterConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reports, err := r.nodeReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregateConnections(apps, clusters, reports, byNode), nil
+}
+
+// ListConnectionNodes returns the totals reported by each gateway node.
+func (r *redisStorage) ListConnectionNodes(ctx context.Context) ([]*models.ConnectionNode, error) {
+	reports, err := r.nodeReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*models.ConnectionNode, len(reports))
+	for i, report := range reports {
+		nodes[i] = report.node
+	}
+	return nodes, nil
+}
+
+// nodeReports reads the connection report of every gateway node, ordered by node.
+// Node addresses may contain colons (IPv6), so keys are not filtered by idsFromKeys.
+func (r *redisStorage) nodeReports(ctx context.Context) ([]*nodeReport, error) {
+	keys, err := r.scanKeys(ctx, r.connStatsKeyPrefix+"*")
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list connection reports", err)
+	}
+
+	nodes := make([]string, len(keys))
+	for i, key := range keys {
+		nodes[i] = strings.TrimPrefix(key, r.connStatsKeyPrefix)
+	}
+	sort.Strings(nodes)
+
+	reports := make([]*nodeReport, 0, len(nodes))
+	err = r.hgetAllBatched(ctx, r.connStatsKeyPrefix, nodes, func(node string, data map[string]string) {
+		// The report expired since the scan
+		if len(data) == 0 {
+			return
+		}
+		reports = append(reports, parseNodeReport(node, data))
+	})
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get connection reports", err)
+	}
+
+	return reports, nil
 }
 
 // PubSub operations
@@ -599,3 +1254,1251 @@ func (r *redisStorage) Publish(ctx context.Context, channel string, message inte
 
 	return nil
 }
+
+// History operations
+
+// ListConfigHistory returns up to limit snapshots of a resource, newest first.
+func (r *redisStorage) ListConfigHistory(ctx context.Context, resourceType, resourceID string, limit int) ([]*models.ConfigSnapshot, error) {
+	if resourceID == "" {
+		return nil, errors.BadRequest("resource ID cannot be empty", nil)
+	}
+
+	keys := r.historyKeys(resourceType, resourceID)
+
+	start := int64(0)
+	if limit > 0 {
+		start = -int64(limit)
+	}
+
+	// Read the list and its trimmed count together, so a concurrent trim
+	// cannot shift the revisions
+	pipe := r.client.TxPipeline()
+	lengthCmd := pipe.LLen(ctx, keys[0])
+	entriesCmd := pipe.LRange(ctx, keys[0], start, -1)
+	trimmedCmd := pipe.Get(ctx, keys[1])
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, errors.InternalServerError("failed to get config history", err)
+	}
+	entries := entriesCmd.Val()
+	trimmed, _ := trimmedCmd.Int64()
+
+	// Revision of the first entry returned
+	first := trimmed + lengthCmd.Val() - int64(len(entries)) + 1
+
+	snapshots := make([]*models.ConfigSnapshot, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		snap, err := decodeSnapshot(entries[i], first+int64(i))
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	return snapshots, nil
+}
+
+// GetConfigSnapshot retrieves a snapshot by revision.
+func (r *redisStorage) GetConfigSnapshot(ctx context.Context, resourceType, resourceID string, revision int64) (*models.ConfigSnapshot, error) {
+	if resourceID == "" {
+		return nil, errors.BadRequest("resource ID cannot be empty", nil)
+	}
+	if revision < 1 {
+		return nil, nil
+	}
+
+	entry, err := getSnapshotScript.Run(ctx, r.client, r.historyKeys(resourceType, resourceID), revision).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get config snapshot", err)
+	}
+
+	return decodeSnapshot(entry, revision)
+}
+
+// historyKeys returns the list key holding the history of a resource and the
+// key counting the snapshots trimmed from its head. They live outside the app
+// and cluster prefixes so SCAN over them never sees them.
+func (r *redisStorage) historyKeys(resourceType, resourceID string) []string {
+	key := r.historyKeyPrefix + resourceType + ":" + resourceID
+	return []string{key, key + ":trimmed"}
+}
+
+// getSnapshotScript reads a snapshot by revision: the 1-based list position
+// plus the number of snapshots trimmed before it.
+// KEYS[1] = history list key, KEYS[2] = trimmed count key
+// ARGV[1] = revision
+// Returns the snapshot JSON, or nil if it is not retained.
+var getSnapshotScript = redis.NewScript(`
+local index = tonumber(ARGV[1]) - 1 - tonumber(redis.call('GET', KEYS[2]) or '0')
+if index < 0 then
+	return false
+end
+return redis.call('LINDEX', KEYS[1], index)
+`)
+
+// decodeSnapshot parses a stored snapshot with its revision.
+func decodeSnapshot(entry string, revision int64) (*models.ConfigSnapshot, error) {
+	snap := &models.ConfigSnapshot{}
+	if err := json.Unmarshal([]byte(entry), snap); err != nil {
+		return nil, errors.InternalServerError("failed to decode config snapshot", err)
+	}
+	snap.Revision = revision
+	return snap, nil
+}
+
+// User operations
+
+// GetUser retrieves a user by ID.
+func (r *redisStorage) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	if userID == "" {
+		return nil, errors.BadRequest("user ID cannot be empty", nil)
+	}
+
+	data, err := r.client.HGetAll(ctx, r.userKeyPrefix+userID).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get user", err)
+	}
+
+	return parseUser(userID, data), nil
+}
+
+// GetUserByUsername retrieves a user by username.
+func (r *redisStorage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	if username == "" {
+		return nil, errors.BadRequest("username cannot be empty", nil)
+	}
+
+	userID, err := r.client.HGet(ctx, r.usernameIndexKey, username).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.InternalServerError("failed to look up username", err)
+	}
+
+	return r.GetUser(ctx, userID)
+}
+
+// parseUser builds a User from its Redis hash fields.
+// Returns nil if the hash is empty (key does not exist).
+func parseUser(userID string, data map[string]string) *models.User {
+	if len(data) == 0 {
+		return nil
+	}
+
+	user := &models.User{
+		ID:               userID,
+		Username:         data["username"],
+		Role:             data["role"],
+		PasswordHash:     data["password_hash"],
+		IdentityProvider: data["identity_provider"],
+		ExternalID:       data["external_id"],
+	}
+
+	if v, ok := data["created_at"]; ok {
+		ts, _ := strconv.ParseInt(v, 10, 64)
+		user.CreatedAt = time.Unix(ts, 0)
+	}
+	if v, ok := data["updated_at"]; ok {
+		ts, _ := strconv.ParseInt(v, 10, 64)
+		user.UpdatedAt = time.Unix(ts, 0)
+	}
+
+	return user
+}
+
+// CreateUser stores a new user, claiming its username in the index atomically.
+func (r *redisStorage) CreateUser(ctx context.Context, user *models.User) error {
+	if user == nil {
+		return errors.BadRequest("user cannot be nil", nil)
+	}
+	if user.ID == "" || user.Username == "" {
+		return errors.BadRequest("user ID and username cannot be empty", nil)
+	}
+
+	now := time.Now().Unix()
+	created, err := createIndexedScript.Run(ctx, r.client,
+		[]string{r.usernameIndexKey, r.userKeyPrefix + user.ID},
+		user.Username, user.ID,
+		"username", user.Username,
+		"role", user.Role,
+		"password_hash", user.PasswordHash,
+		"identity_provider", user.IdentityProvider,
+		"external_id", user.ExternalID,
+		"created_at", now,
+		"updated_at", now,
+	).Int()
+	if err != nil {
+		return errors.InternalServerError("failed to create user", err)
+	}
+	if created == 0 {
+		return errors.Conflict(fmt.Sprintf("username %q already exists", user.Username), nil)
+	}
+
+	user.CreatedAt = time.Unix(now, 0)
+	user.UpdatedAt = user.CreatedAt
+	return nil
+}
+
+// UpdateUser replaces the role and password hash of an existing user.
+func (r *redisStorage) UpdateUser(ctx context.Context, user *models.User) error {
+	if user == nil {
+		return errors.BadRequest("user cannot be nil", nil)
+	}
+	if user.ID == "" {
+		return errors.BadRequest("user ID cannot be empty", nil)
+	}
+
+	now := time.Now().Unix()
+	updated, err := updateExistingScript.Run(ctx, r.client,
+		[]string{r.userKeyPrefix + user.ID},
+		"role", user.Role,
+		"password_hash", user.PasswordHash,
+		"updated_at", now,
+	).Int()
+	if err != nil {
+		return errors.InternalServerError("failed to update user", err)
+	}
+	if updated == 0 {
+		return errors.NotFound("user not found", nil)
+	}
+
+	user.UpdatedAt = time.Unix(now, 0)
+	return nil
+}
+
+// DeleteUser removes a user and releases its username.
+func (r *redisStorage) DeleteUser(ctx context.Context, userID string) error {
+	if userID == "" {
+		return errors.BadRequest("user ID cannot be empty", nil)
+	}
+
+	user, err := r.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.NotFound("user not found", nil)
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.userKeyPrefix+userID)
+		pipe.HDel(ctx, r.usernameIndexKey, user.Username)
+		return nil
+	})
+	if err != nil {
+		return errors.InternalServerError("failed to delete user", err)
+	}
+
+	return nil
+}
+
+// ListUsers returns all users sorted by username.
+func (r *redisStorage) ListUsers(ctx context.Context) ([]*models.User, error) {
+	index, err := r.client.HGetAll(ctx, r.usernameIndexKey).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list users", err)
+	}
+
+	userIDs := make([]string, 0, len(index))
+	for _, userID := range index {
+		userIDs = append(userIDs, userID)
+	}
+
+	users := make([]*models.User, 0, len(userIDs))
+	err = r.hgetAllBatched(ctx, r.userKeyPrefix, userIDs, func(userID string, data map[string]string) {
+		if user := parseUser(userID, data); user != nil {
+			users = append(users, user)
+		}
+	})
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list users", err)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// createIndexedScript claims a unique field in an index hash and writes the
+// entity hash it points to, e.g. a username or an API key hash.
+// KEYS[1] = index hash, KEYS[2] = entity hash key
+// ARGV[1] = index field, ARGV[2] = entity ID, ARGV[3..] = field/value pairs
+// Returns 1 on success or 0 if the index field is taken.
+var createIndexedScript = redis.NewScript(`
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[2], unpack(ARGV, 3))
+return 1
+`)
+
+// updateExistingScript writes field/value pairs to a hash only if it exists.
+// KEYS[1] = hash key
+// ARGV = field/value pairs
+// Returns 1 on success or 0 if the hash does not exist.
+var updateExistingScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV))
+return 1
+`)
+
+// API key operations
+
+// GetAPIKey retrieves an API key by ID.
+func (r *redisStorage) GetAPIKey(ctx context.Context, keyID string) (*models.APIKey, error) {
+	if keyID == "" {
+		return nil, errors.BadRequest("API key ID cannot be empty", nil)
+	}
+
+	data, err := r.client.HGetAll(ctx, r.apiKeyPrefix+keyID).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get API key", err)
+	}
+
+	return parseAPIKey(keyID, data), nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its secret.
+func (r *redisStorage) GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	if keyHash == "" {
+		return nil, errors.BadRequest("API key hash cannot be empty", nil)
+	}
+
+	keyID, err := r.client.HGet(ctx, r.apiKeyIndexKey, keyHash).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.InternalServerError("failed to look up API key", err)
+	}
+
+	return r.GetAPIKey(ctx, keyID)
+}
+
+// parseAPIKey builds an APIKey from its Redis hash fields.
+// Returns nil if the hash is empty (key does not exist).
+func parseAPIKey(keyID string, data map[string]string) *models.APIKey {
+	if len(data) == 0 {
+		return nil
+	}
+
+	key := &models.APIKey{
+		ID:        keyID,
+		Name:      data["name"],
+		Prefix:    data["prefix"],
+		KeyHash:   data["key_hash"],
+		Role:      data["role"],
+		CreatedBy: data["created_by"],
+	}
+
+	if v := data["permissions"]; v != "" {
+		key.Permissions = strings.Split(v, ",")
+	}
+	if v, ok := data["created_at"]; ok {
+		ts, _ := strconv.ParseInt(v, 10, 64)
+		key.CreatedAt = time.Unix(ts, 0)
+	}
+	if ts, _ := strconv.ParseInt(data["expires_at"], 10, 64); ts > 0 {
+		expiresAt := time.Unix(ts, 0)
+		key.ExpiresAt = &expiresAt
+	}
+	if ts, _ := strconv.ParseInt(data["last_used_at"], 10, 64); ts > 0 {
+		lastUsedAt := time.Unix(ts, 0)
+		key.LastUsedAt = &lastUsedAt
+	}
+
+	return key
+}
+
+// CreateAPIKey stores a new API key, claiming its hash in the index atomically.
+func (r *redisStorage) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if key == nil {
+		return errors.BadRequest("API key cannot be nil", nil)
+	}
+	if key.ID == "" || key.KeyHash == "" {
+		return errors.BadRequest("API key ID and hash cannot be empty", nil)
+	}
+
+	var expiresAt int64
+	if key.ExpiresAt != nil {
+		expiresAt = key.ExpiresAt.Unix()
+	}
+
+	now := time.Now().Unix()
+	created, err := createIndexedScript.Run(ctx, r.client,
+		[]string{r.apiKeyIndexKey, r.apiKeyPrefix + key.ID},
+		key.KeyHash, key.ID,
+		"name", key.Name,
+		"prefix", key.Prefix,
+		"key_hash", key.KeyHash,
+		"role", key.Role,
+		"permissions", strings.Join(key.Permissions, ","),
+		"created_by", key.CreatedBy,
+		"created_at", now,
+		"expires_at", expiresAt,
+	).Int()
+	if err != nil {
+		return errors.InternalServerError("failed to create API key", err)
+	}
+	if created == 0 {
+		return errors.Conflict("API key already exists", nil)
+	}
+
+	key.CreatedAt = time.Unix(now, 0)
+	return nil
+}
+
+// DeleteAPIKey removes an API key and its hash index entry.
+func (r *redisStorage) DeleteAPIKey(ctx context.Context, keyID string) error {
+	key, err := r.GetAPIKey(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errors.NotFound("API key not found", nil)
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.apiKeyPrefix+keyID)
+		pipe.HDel(ctx, r.apiKeyIndexKey, key.KeyHash)
+		return nil
+	})
+	if err != nil {
+		return errors.InternalServerError("failed to delete API key", err)
+	}
+
+	return nil
+}
+
+// ListAPIKeys returns all API keys sorted by name.
+func (r *redisStorage) ListAPIKeys(ctx context.Context) ([]*models.APIKey, error) {
+	index, err := r.client.HGetAll(ctx, r.apiKeyIndexKey).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list API keys", err)
+	}
+
+	keyIDs := make([]string, 0, len(index))
+	for _, keyID := range index {
+		keyIDs = append(keyIDs, keyID)
+	}
+
+	keys := make([]*models.APIKey, 0, len(keyIDs))
+	err = r.hgetAllBatched(ctx, r.apiKeyPrefix, keyIDs, func(keyID string, data map[string]string) {
+		if key := parseAPIKey(keyID, data); key != nil {
+			keys = append(keys, key)
+		}
+	})
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list API keys", err)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Name != keys[j].Name {
+			return keys[i].Name < keys[j].Name
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// TouchAPIKey records the last use of an API key.
+func (r *redisStorage) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	if keyID == "" {
+		return errors.BadRequest("API key ID cannot be empty", nil)
+	}
+
+	// The script skips deleted keys so a late touch cannot resurrect one
+	if err := updateExistingScript.Run(ctx, r.client,
+		[]string{r.apiKeyPrefix + keyID},
+		"last_used_at", usedAt.Unix(),
+	).Err(); err != nil {
+		return errors.InternalServerError("failed to record API key use", err)
+	}
+
+	return nil
+}
+
+// Login state operations
+
+// SaveLoginState stores the state of a login attempt with a TTL.
+func (r *redisStorage) SaveLoginState(ctx context.Context, state string, data *models.OIDCLoginState, ttl time.Duration) error {
+	if state == "" || data == nil {
+		return errors.BadRequest("login state cannot be empty", nil)
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return errors.InternalServerError("failed to encode login state", err)
+	}
+
+	if err := r.client.Set(ctx, r.loginStatePrefix+state, encoded, ttl).Err(); err != nil {
+		return errors.InternalServerError("failed to save login state", err)
+	}
+
+	return nil
+}
+
+// ConsumeLoginState reads and deletes the state of a login attempt in one transaction,
+// so a state can only be used once even if the callback is replayed concurrently.
+func (r *redisStorage) ConsumeLoginState(ctx context.Context, state string) (*models.OIDCLoginState, error) {
+	if state == "" {
+		return nil, nil
+	}
+
+	key := r.loginStatePrefix + state
+	var get *redis.StringCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.InternalServerError("failed to consume login state", err)
+	}
+
+	var data models.OIDCLoginState
+	if err := json.Unmarshal([]byte(get.Val()), &data); err != nil {
+		return nil, errors.InternalServerError("failed to decode login state", err)
+	}
+
+	return &data, nil
+}
+
+// Login attempt operations
+
+// recordLoginFailureScript atomically counts a failure and keeps the record
+// until window after it, or until its block ends if that is later.
+// KEYS[1] = attempts hash key
+// ARGV[1] = failure time (unix ms), ARGV[2] = window (ms)
+// Returns the hash as a flat field/value list.
+var recordLoginFailureScript = redis.NewScript(`
+redis.call('HINCRBY', KEYS[1], 'failures', 1)
+redis.call('HSET', KEYS[1], 'last_failure_at', ARGV[1])
+local expireAt = tonumber(ARGV[1]) + tonumber(ARGV[2])
+local blocked = tonumber(redis.call('HGET', KEYS[1], 'blocked_until') or '0')
+if blocked > expireAt then
+	expireAt = blocked
+end
+redis.call('PEXPIREAT', KEYS[1], expireAt)
+return redis.call('HGETALL', KEYS[1])
+`)
+
+// blockLoginScript sets a block on an existing attempts hash unless a later one
+// is already set, extending the hash's expiry to cover it.
+// KEYS[1] = attempts hash key
+// ARGV[1] = blocked until (unix ms), ARGV[2] = locked (0 or 1), ARGV[3] = now (unix ms)
+var blockLoginScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local blockedUntil = tonumber(ARGV[1])
+local current = tonumber(redis.call('HGET', KEYS[1], 'blocked_until') or '0')
+if blockedUntil <= current then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'blocked_until', ARGV[1], 'locked', ARGV[2])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 or tonumber(ARGV[3]) + ttl < blockedUntil then
+	redis.call('PEXPIREAT', KEYS[1], blockedUntil)
+end
+return 1
+`)
+
+// loginAttemptKey returns the hash key for a username or client IP.
+func (r *redisStorage) loginAttemptKey(scope, key string) string {
+	return r.loginAttemptPrefix + scope + ":" + key
+}
+
+// GetLoginAttempts retrieves the failures recorded for a username or client IP.
+func (r *redisStorage) GetLoginAttempts(ctx context.Context, scope, key string) (*models.LoginAttempts, error) {
+	if scope == "" || key == "" {
+		return nil, errors.BadRequest("login attempt scope and key cannot be empty", nil)
+	}
+
+	data, err := r.client.HGetAll(ctx, r.loginAttemptKey(scope, key)).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to get login attempts", err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	return parseLoginAttempts(scope, key, data, time.Now()), nil
+}
+
+// parseLoginAttempts converts Redis hash data to LoginAttempts. A block that
+// has ended is left out.
+func parseLoginAttempts(scope, key string, data map[string]string, now time.Time) *models.LoginAttempts {
+	attempts := &models.LoginAttempts{Scope: scope, Key: key}
+	attempts.Failures, _ = strconv.ParseInt(data["failures"], 10, 64)
+	if ms, err := strconv.ParseInt(data["last_failure_at"], 10, 64); err == nil {
+		attempts.LastFailureAt = time.UnixMilli(ms)
+	}
+	if ms, err := strconv.ParseInt(data["blocked_until"], 10, 64); err == nil {
+		if until := time.UnixMilli(ms); until.After(now) {
+			attempts.BlockedUntil = &until
+			attempts.Locked = data["locked"] == "1"
+		}
+	}
+	return attempts
+}
+
+// RecordLoginFailure counts a failed login.
+func (r *redisStorage) RecordLoginFailure(ctx context.Context, scope, key string, at time.Time, window time.Duration) (*models.LoginAttempts, error) {
+	if scope == "" || key == "" {
+		return nil, errors.BadRequest("login attempt scope and key cannot be empty", nil)
+	}
+
+	result, err := recordLoginFailureScript.Run(ctx, r.client,
+		[]string{r.loginAttemptKey(scope, key)},
+		at.UnixMilli(), window.Milliseconds(),
+	).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to record login failure", err)
+	}
+
+	pairs, _ := result.([]interface{})
+	data := make(map[string]string, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		field, _ := pairs[i].(string)
+		value, _ := pairs[i+1].(string)
+		data[field] = value
+	}
+
+	return parseLoginAttempts(scope, key, data, at), nil
+}
+
+// BlockLogin refuses logins for a username or client IP until the given time.
+func (r *redisStorage) BlockLogin(ctx context.Context, scope, key string, until time.Time, locked bool) error {
+	if scope == "" || key == "" {
+		return errors.BadRequest("login attempt scope and key cannot be empty", nil)
+	}
+
+	lockedFlag := 0
+	if locked {
+		lockedFlag = 1
+	}
+	if err := blockLoginScript.Run(ctx, r.client,
+		[]string{r.loginAttemptKey(scope, key)},
+		until.UnixMilli(), lockedFlag, time.Now().UnixMilli(),
+	).Err(); err != nil {
+		return errors.InternalServerError("failed to block login", err)
+	}
+
+	return nil
+}
+
+// ClearLoginAttempts deletes the failure record of a username or client IP.
+func (r *redisStorage) ClearLoginAttempts(ctx context.Context, scope, key string) (bool, error) {
+	if scope == "" || key == "" {
+		return false, errors.BadRequest("login attempt scope and key cannot be empty", nil)
+	}
+
+	deleted, err := r.client.Del(ctx, r.loginAttemptKey(scope, key)).Result()
+	if err != nil {
+		return false, errors.InternalServerError("failed to clear login attempts", err)
+	}
+
+	return deleted > 0, nil
+}
+
+// ListLoginAttempts returns every tracked username and client IP.
+func (r *redisStorage) ListLoginAttempts(ctx context.Context) ([]*models.LoginAttempts, error) {
+	keys, err := r.scanKeys(ctx, r.loginAttemptPrefix+"*")
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list login attempts", err)
+	}
+
+	// The key part may itself contain colons (IPv6 addresses), so only the
+	// prefix and scope are stripped
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, r.loginAttemptPrefix))
+	}
+
+	now := time.Now()
+	attempts := make([]*models.LoginAttempts, 0, len(ids))
+	err = r.hgetAllBatched(ctx, r.loginAttemptPrefix, ids, func(id string, data map[string]string) {
+		scope, key, ok := strings.Cut(id, ":")
+		if !ok || len(data) == 0 {
+			// Expired between SCAN and HGETALL
+			return
+		}
+		attempts = append(attempts, parseLoginAttempts(scope, key, data, now))
+	})
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list login attempts", err)
+	}
+
+	sort.Slice(attempts, func(i, j int) bool {
+		if attempts[i].Scope != attempts[j].Scope {
+			return attempts[i].Scope < attempts[j].Scope
+		}
+		return attempts[i].Key < attempts[j].Key
+	})
+	return attempts, nil
+}
+
+// Rate limit operations
+
+// allowRequestScript implements a sliding-window log per bucket: each bucket is
+// a sorted set of request times. Expired entries are dropped, and the request is
+// added to every bucket only if all of them have room.
+// KEYS = bucket keys
+// ARGV[1] = now (unix ms), ARGV[2] = unique request member,
+// ARGV[1+2i], ARGV[2+2i] = limit and window (ms) of bucket i
+// Returns {allowed, count_1, oldest_1, count_2, oldest_2, ...} where oldest is
+// the time (unix ms) of the oldest request still counted.
+var allowRequestScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local counts = {}
+local allowed = 1
+for i, key in ipairs(KEYS) do
+	local window = tonumber(ARGV[2 + i * 2])
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+	counts[i] = redis.call('ZCARD', key)
+	if counts[i] >= tonumber(ARGV[1 + i * 2]) then
+		allowed = 0
+	end
+end
+local out = {allowed}
+for i, key in ipairs(KEYS) do
+	if allowed == 1 then
+		redis.call('ZADD', key, now, ARGV[2])
+		redis.call('PEXPIRE', key, ARGV[2 + i * 2])
+		counts[i] = counts[i] + 1
+	end
+	local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+	out[#out + 1] = counts[i]
+	out[#out + 1] = tonumber(oldest[2] or now)
+end
+return out
+`)
+
+// AllowRequest counts a request against the buckets in one atomic step.
+func (r *redisStorage) AllowRequest(ctx context.Context, buckets []RateLimitBucket, now time.Time) (bool, []RateLimitState, error) {
+	if len(buckets) == 0 {
+		return true, nil, nil
+	}
+
+	keys := make([]string, len(buckets))
+	args := make([]interface{}, 0, 2+2*len(buckets))
+	// The member only has to be unique; two requests in the same millisecond both count
+	args = append(args, now.UnixMilli(), uuid.NewString())
+	for i, b := range buckets {
+		keys[i] = r.requestLimitPrefix + b.Key
+		args = append(args, b.Limit, b.Window.Milliseconds())
+	}
+
+	values, err := allowRequestScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return false, nil, errors.InternalServerError("failed to check rate limit", err)
+	}
+	if len(values) != 1+2*len(buckets) {
+		return false, nil, errors.InternalServerError("unexpected rate limit script result", nil)
+	}
+
+	states := make([]RateLimitState, len(buckets))
+	for i, b := range buckets {
+		count, oldest := values[1+2*i], values[2+2*i]
+		states[i] = RateLimitState{
+			Remaining: remainingRequests(b.Limit, count),
+			ResetAt:   time.UnixMilli(oldest).Add(b.Window),
+		}
+	}
+
+	return values[0] == 1, states, nil
+}
+
+// Audit log operations
+
+// RecordAuditEntry prepends an entry to the audit log and trims it to MaxAuditEntries.
+func (r *redisStorage) RecordAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	if entry == nil {
+		return errors.BadRequest("audit entry cannot be nil", nil)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.InternalServerError("failed to encode audit entry", err)
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.LPush(ctx, r.auditLogKey, data)
+	pipe.LTrim(ctx, r.auditLogKey, 0, MaxAuditEntries-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.InternalServerError("failed to record audit entry", err)
+	}
+
+	return nil
+}
+
+// ListAuditEntries returns up to limit entries, newest first.
+func (r *redisStorage) ListAuditEntries(ctx context.Context, limit int) ([]*models.AuditEntry, error) {
+	if limit <= 0 || limit > MaxAuditEntries {
+		limit = MaxAuditEntries
+	}
+
+	items, err := r.client.LRange(ctx, r.auditLogKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, errors.InternalServerError("failed to list audit entries", err)
+	}
+
+	entries := make([]*models.AuditEntry, 0, len(items))
+	for _, item := range items {
+		var entry models.AuditEntry
+		if err := json.Unmarshal([]byte(item), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// Token revocation operations
+
+// RevokeToken adds a token ID to the denylist for ttl.
+func (r *redisStorage) RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) (bool, error) {
+	if tokenID == "" {
+		return false, errors.BadRequest("token ID cannot be empty", nil)
+	}
+	if ttl <= 0 {
+		// Already expired, nothing to deny
+		return true, nil
+	}
+
+	revoked, err := r.client.SetNX(ctx, r.revokedTokenPrefix+tokenID, 1, ttl).Result()
+	if err != nil {
+		return false, errors.InternalServerError("failed to revoke token", err)
+	}
+
+	return revoked, nil
+}
+
+// RevokeUserTokens records the time before which all of a user's tokens are revoked.
+// Tokens carry second-precision issue times, so the cutoff is rounded up to the next
+// second; a token issued within the same second as the revocation is revoked too.
+func (r *redisStorage) RevokeUserTokens(ctx context.Context, userID string, ttl time.Duration) error {
+	if userID == "" {
+		return errors.BadRequest("user ID cannot be empty", nil)
+	}
+
+	cutoff := time.Now().Unix() + 1
+	if err := r.client.Set(ctx, r.revokedUserPrefix+userID, cutoff, ttl).Err(); err != nil {
+		return errors.InternalServerError("failed to revoke user tokens", err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked checks the token denylist and the user's revocation cutoff in one round trip.
+func (r *redisStorage) IsTokenRevoked(ctx context.Context, tokenID, userID string, issuedAt time.Time) (bool, error) {
+	values, err := r.client.MGet(ctx, r.revokedTokenPrefix+tokenID, r.revokedUserPrefix+userID).Result()
+	if err != nil {
+		return false, errors.InternalServerError("failed to check token revocation", err)
+	}
+
+	if values[0] != nil {
+		return true, nil
+	}
+	if v, ok := values[1].(string); ok {
+		cutoff, _ := strconv.ParseInt(v, 10, 64)
+		if issuedAt.Unix() < cutoff {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Versioning helpers
+
+// compareAndSetScript atomically checks the version field of a hash, writes the
+// given field/value pairs, increments the version and appends a history snapshot.
+// The history is trimmed to the newest ARGV[3] snapshots, counting the dropped
+// ones in KEYS[3] so the revisions of the rest do not change.
+// KEYS[1] = hash key, KEYS[2] = history list key, KEYS[3] = history trimmed count key
+// ARGV[1] = expected version, ARGV[2] = snapshot JSON, ARGV[3] = max history entries,
+// ARGV[4..] = field/value pairs
+// Returns {1, new version} on success or {0, current version} on mismatch.
+var compareAndSetScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if current ~= tonumber(ARGV[1]) then
+	return {0, current}
+end
+local version = current + 1
+redis.call('HSET', KEYS[1], 'version', version, unpack(ARGV, 4))
+local excess = redis.call('RPUSH', KEYS[2], ARGV[2]) - tonumber(ARGV[3])
+if excess > 0 then
+	redis.call('LTRIM', KEYS[2], excess, -1)
+	redis.call('INCRBY', KEYS[3], excess)
+end
+return {1, version}
+`)
+
+// compareAndSetAppScript is compareAndSetScript for application configs: in
+// the same atomic step it moves the app from the member sets of the clusters
+// it was assigned to, read from the clusters field being replaced, to those
+// of the clusters it is now assigned to.
+// KEYS[1] = hash key, KEYS[2] = history list key, KEYS[3] = history trimmed count key
+// ARGV[1] = expected version, ARGV[2] = snapshot JSON, ARGV[3] = max history entries,
+// ARGV[4] = member set key prefix, ARGV[5] = app ID, ARGV[6] = number of clusters n,
+// ARGV[7..6+n] = cluster IDs, ARGV[7+n..] = field/value pairs
+// Returns {1, new version} on success or {0, current version} on mismatch.
+var compareAndSetAppScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if current ~= tonumber(ARGV[1]) then
+	return {0, current}
+end
+local previous = redis.call('HGET', KEYS[1], 'clusters')
+local count = tonumber(ARGV[6])
+local version = current + 1
+redis.call('HSET', KEYS[1], 'version', version, unpack(ARGV, 7 + count))
+local excess = redis.call('RPUSH', KEYS[2], ARGV[2]) - tonumber(ARGV[3])
+if excess > 0 then
+	redis.call('LTRIM', KEYS[2], excess, -1)
+	redis.call('INCRBY', KEYS[3], excess)
+end
+if previous and previous ~= '' then
+	for _, assignment in ipairs(cjson.decode(previous)) do
+		redis.call('SREM', ARGV[4] .. assignment.cluster_id, ARGV[5])
+	end
+end
+for i = 7, 6 + count do
+	redis.call('SADD', ARGV[4] .. ARGV[i], ARGV[5])
+end
+return {1, version}
+`)
+
+// deleteVersionedScript atomically deletes a hash if its version matches,
+// appends a history snapshot of the deleted state, trimming the history as
+// compareAndSetScript does, and publishes the deletion.
+// KEYS[1] = hash key, KEYS[2] = history list key, KEYS[3] = history trimmed count key
+// ARGV[1] = expected version, ARGV[2] = snapshot JSON, ARGV[3] = max history entries,
+// ARGV[4] = event channel, ARGV[5] = event JSON
+// Returns {1, version} on success or {0, current version} on mismatch.
+var deleteVersionedScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if current ~= tonumber(ARGV[1]) or redis.call('EXISTS', KEYS[1]) == 0 then
+	return {0, current}
+end
+redis.call('DEL', KEYS[1])
+local excess = redis.call('RPUSH', KEYS[2], ARGV[2]) - tonumber(ARGV[3])
+if excess > 0 then
+	redis.call('LTRIM', KEYS[2], excess, -1)
+	redis.call('INCRBY', KEYS[3], excess)
+end
+redis.call('PUBLISH', ARGV[4], ARGV[5])
+return {1, current}
+`)
+
+// deleteAppScript is deleteVersionedScript for application configs: in the
+// same atomic step it removes the app from the member sets of its clusters.
+// KEYS and ARGV[1..5] as deleteVersionedScript,
+// ARGV[6] = member set key prefix, ARGV[7] = app ID
+var deleteAppScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if current ~= tonumber(ARGV[1]) or redis.call('EXISTS', KEYS[1]) == 0 then
+	return {0, current}
+end
+local previous = redis.call('HGET', KEYS[1], 'clusters')
+redis.call('DEL', KEYS[1])
+local excess = redis.call('RPUSH', KEYS[2], ARGV[2]) - tonumber(ARGV[3])
+if excess > 0 then
+	redis.call('LTRIM', KEYS[2], excess, -1)
+	redis.call('INCRBY', KEYS[3], excess)
+end
+if previous and previous ~= '' then
+	for _, assignment in ipairs(cjson.decode(previous)) do
+		redis.call('SREM', ARGV[6] .. assignment.cluster_id, ARGV[7])
+	end
+end
+redis.call('PUBLISH', ARGV[4], ARGV[5])
+return {1, current}
+`)
+
+// compareAndSetClusterScript is compareAndSetScript for the gateway cluster:
+// in the same atomic step it sets the capacity, reserved ratio and emergency
+// threshold of the gateway's L1 bucket, scaling the available capacity so the
+// share already allocated to apps is kept, as the gateway's set_capacity does.
+// KEYS[1] = hash key, KEYS[2] = history list key, KEYS[3] = history trimmed count key,
+// KEYS[4..7] = L1 capacity, available, reserved_ratio and emergency_threshold keys
+// ARGV[1] = expected version, ARGV[2] = snapshot JSON, ARGV[3] = max history entries,
+// ARGV[4] = capacity, ARGV[5] = reserved ratio, ARGV[6] = emergency threshold,
+// ARGV[7..] = field/value pairs
+// Returns {1, new version, old capacity, new available} on success or
+// {0, current version} on mismatch.
+var compareAndSetClusterScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if current ~= tonumber(ARGV[1]) then
+	return {0, current}
+end
+local version = current + 1
+redis.call('HSET', KEYS[1], 'version', version, unpack(ARGV, 7))
+local excess = redis.call('RPUSH', KEYS[2], ARGV[2]) - tonumber(ARGV[3])
+if excess > 0 then
+	redis.call('LTRIM', KEYS[2], excess, -1)
+	redis.call('INCRBY', KEYS[3], excess)
+end
+
+local capacity = tonumber(ARGV[4])
+local old_capacity = tonumber(redis.call('GET', KEYS[4]) or '') or 0
+local available = capacity
+if old_capacity > 0 then
+	local old_available = tonumber(redis.call('GET', KEYS[5]) or '') or old_capacity
+	available = math.floor(old_available * capacity / old_capacity)
+end
+redis.call('SET', KEYS[4], capacity)
+redis.call('SET', KEYS[5], available)
+redis.call('SET', KEYS[6], ARGV[5])
+redis.call('SET', KEYS[7], ARGV[6])
+return {1, version, old_capacity, available}
+`)
+
+// clearEmergencyScript deactivates emergency mode if it is active and expired,
+// or regardless of expiry if forced.
+// KEYS[1..4] = active, reason, activated_at and expires_at keys
+// ARGV[1] = current Unix time, ARGV[2] = "1" to force
+// Returns {reason, activated_at, expires_at} of the cleared emergency, or nil.
+var clearEmergencyScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= '1' then
+	return false
+end
+local expires_at = redis.call('GET', KEYS[4]) or ''
+if ARGV[2] ~= '1' then
+	local ts = tonumber(expires_at)
+	if not ts or ts > tonumber(ARGV[1]) then
+		return false
+	end
+end
+local cleared = {redis.call('GET', KEYS[2]) or '', redis.call('GET', KEYS[3]) or '', expires_at}
+redis.call('SET', KEYS[1], '0')
+redis.call('DEL', KEYS[2], KEYS[3], KEYS[4])
+return cleared
+`)
+
+// extendEmergencyScript moves the expiry of the active, unexpired emergency
+// and refreshes the TTL of its keys, unless the new expiry is more than the
+// maximum duration after activation.
+// KEYS[1..4] = active, reason, activated_at and expires_at keys
+// ARGV[1] = current Unix time, ARGV[2] = new expires_at, ARGV[3] = key TTL in seconds,
+// ARGV[4] = maximum duration in seconds
+// Returns {reason, activated_at, extended} where extended is 1, or 0 if the
+// maximum duration would be exceeded; nil if no emergency is active.
+var extendEmergencyScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= '1' then
+	return false
+end
+local expires_at = tonumber(redis.call('GET', KEYS[4]) or '')
+if expires_at and expires_at <= tonumber(ARGV[1]) then
+	return false
+end
+local reason = redis.call('GET', KEYS[2]) or ''
+local activated_at = redis.call('GET', KEYS[3]) or ''
+local activated = tonumber(activated_at)
+if activated and tonumber(ARGV[2]) > activated + tonumber(ARGV[4]) then
+	return {reason, activated_at, '0'}
+end
+redis.call('SET', KEYS[4], ARGV[2], 'EX', ARGV[3])
+for i = 1, 3 do
+	redis.call('EXPIRE', KEYS[i], ARGV[3])
+end
+return {reason, activated_at, '1'}
+`)
+
+// cancelScheduleScript removes a pending emergency window.
+// KEYS[1] = schedule index (sorted set), KEYS[2] = schedules (hash)
+// ARGV[1] = schedule ID
+// Returns the removed window, or nil if it is not pending.
+var cancelScheduleScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return false
+end
+local schedule = redis.call('HGET', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return schedule
+`)
+
+// claimSchedulesScript removes the emergency windows that are due.
+// KEYS[1] = schedule index (sorted set), KEYS[2] = schedules (hash)
+// ARGV[1] = current Unix time
+// Returns the removed windows.
+var claimSchedulesScript = redis.NewScript(`
+local claimed = {}
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])) do
+	redis.call('ZREM', KEYS[1], id)
+	local schedule = redis.call('HGET', KEYS[2], id)
+	if schedule then
+		redis.call('HDEL', KEYS[2], id)
+		table.insert(claimed, schedule)
+	end
+end
+return claimed
+`)
+
+// runVersionedWrite runs script, which takes compareAndSetScript's keys and
+// arguments, to write the field/value pairs to the hash at keys[0] if its
+// version equals expectedVersion, appending the snapshot built by
+// snapshot(previousVersion) to the history in the same atomic step. Extra keys
+// follow the history keys and extra arguments go between the history limit
+// and the field/value pairs. With AnyVersion the current version is read first
+// and the write retried if another writer races in between. Returns the
+// script's result, whose second element is the new version.
+func (r *redisStorage) runVersionedWrite(ctx context.Context, script *redis.Script, keys []string, extra []interface{}, expectedVersion int64,
+	snapshot func(previousVersion int64) *models.ConfigSnapshot, fieldsAndValues ...interface{}) ([]int64, error) {
+	for attempt := 1; ; attempt++ {
+		expected := expectedVersion
+		if expected == AnyVersion {
+			current, err := r.client.HGet(ctx, keys[0], "version").Int64()
+			if err != nil && err != redis.Nil {
+				return nil, err
+			}
+			expected = current
+		}
+
+		snapJSON, err := json.Marshal(snapshot(expected))
+		if err != nil {
+			return nil, err
+		}
+
+		args := make([]interface{}, 0, len(extra)+len(fieldsAndValues)+3)
+		args = append(args, expected, snapJSON, r.maxHistory)
+		args = append(args, extra...)
+		args = append(args, fieldsAndValues...)
+
+		res, err := script.Run(ctx, r.client, keys, args...).Int64Slice()
+		if err != nil {
+			return nil, err
+		}
+		if res[0] == 1 {
+			return res, nil
+		}
+		if expectedVersion != AnyVersion || attempt >= maxVersionRetries {
+			return nil, versionConflict("config", res[1], expected)
+		}
+	}
+}
+
+// Key iteration helpers
+
+// scanKeys returns every key matching pattern using incremental SCAN,
+// so large keyspaces never block Redis the way KEYS does.
+func (r *redisStorage) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	seen := make(map[string]bool)
+	var keys []string
+	var cursor uint64
+
+	for {
+		batch, next, err := r.client.Scan(ctx, cursor, pattern, scanBatchSize).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		// SCAN may return a key more than once
+		for _, key := range batch {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// scanIDPage returns the IDs of at least limit entities stored under prefix,
+// starting at cursor. Keys under prefix that are not entity keys are skipped
+// before counting, and SCAN continues until the page is full or the keyspace
+// is exhausted, so only the last page is short. SCAN returns whole batches, so
+// a page may hold slightly more than limit IDs.
+// The returned cursor is "" once the keyspace has been fully iterated.
+func (r *redisStorage) scanIDPage(ctx context.Context, prefix, cursor string, limit int) ([]string, string, error) {
+	var pos uint64
+	if cursor != "" {
+		var err error
+		if pos, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", errors.BadRequest("invalid cursor", err)
+		}
+	}
+	if limit <= 0 {
+		limit = scanBatchSize
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for {
+		batch, next, err := r.client.Scan(ctx, pos, prefix+"*", int64(limit)).Result()
+		if err != nil {
+			return nil, "", errors.InternalServerError("failed to scan keys", err)
+		}
+
+		// SCAN may return a key more than once
+		for _, id := range idsFromKeys(batch, prefix) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		pos = next
+
+		if pos == 0 || len(ids) >= limit {
+			break
+		}
+	}
+
+	if pos == 0 {
+		return ids, "", nil
+	}
+	return ids, strconv.FormatUint(pos, 10), nil
+}
+
+// hgetAllBatched runs HGETALL on prefix+id for every id, pipelining
+// pipelineBatchSize commands per round trip. fn is called in input order.
+func (r *redisStorage) hgetAllBatched(ctx context.Context, prefix string, ids []string, fn func(id string, data map[string]string)) error {
+	for start := 0; start < len(ids); start += pipelineBatchSize {
+		end := start + pipelineBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		pipe := r.client.Pipeline()
+		cmds := make([]*redis.StringStringMapCmd, 0, end-start)
+		for _, id := range ids[start:end] {
+			cmds = append(cmds, pipe.HGetAll(ctx, prefix+id))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		for i, cmd := range cmds {
+			fn(ids[start+i], cmd.Val())
+		}
+	}
+
+	return nil
+}
+
+// idsFromKeys strips prefix from each key and drops keys that are not
+// top-level entity hashes (e.g. "<prefix><id>:<suffix>").
+func idsFromKeys(keys []string, prefix string) []string {
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		id := strings.TrimPrefix(key, prefix)
+		if id == "" || strings.Contains(id, ":") {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
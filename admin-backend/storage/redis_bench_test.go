@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"admin-backend/models"
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// The benchmarks in this file need a live Redis and are skipped unless
+// REDIS_ADDR is set, e.g.:
+//
+//	REDIS_ADDR=localhost:6379 go test ./storage -run '^$' -bench . -benchmem
+//
+// Each benchmark reports "roundtrips/op": the number of requests sent to
+// Redis per call, counting a whole pipeline as one. All keys are written
+// under a random prefix and removed afterwards.
+
+// roundTripCounter is a redis.Hook that counts network round trips.
+type roundTripCounter struct {
+	n int64
+}
+
+func (h *roundTripCounter) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	atomic.AddInt64(&h.n, 1)
+	return ctx, nil
+}
+
+func (h *roundTripCounter) AfterProcess(context.Context, redis.Cmder) error {
+	return nil
+}
+
+func (h *roundTripCounter) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	atomic.AddInt64(&h.n, 1)
+	return ctx, nil
+}
+
+func (h *roundTripCounter) AfterProcessPipeline(context.Context, []redis.Cmder) error {
+	return nil
+}
+
+// newBenchStorage connects to REDIS_ADDR and isolates all keys under a unique prefix.
+func newBenchStorage(b testing.TB, options ...Option) (*redisStorage, *roundTripCounter) {
+	b.Helper()
+
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		b.Skip("REDIS_ADDR not set")
+	}
+
+	store, err := NewRedisStorage(&redis.Options{Addr: addr, PoolSize: 10}, options...)
+	if err != nil {
+		b.Fatalf("connect to redis: %v", err)
+	}
+
+	r := store.(*redisStorage)
+	prefix := "bench:" + uuid.NewString() + ":"
+	r.appKeyPrefix = prefix + r.appKeyPrefix
+	r.clusterKeyPrefix = prefix + r.clusterKeyPrefix
+	r.clusterAppsPrefix = prefix + r.clusterAppsPrefix
+	r.clusterAppsIndexKey = prefix + r.clusterAppsIndexKey
+	r.l1KeyPrefix = prefix + r.l1KeyPrefix
+	r.emergencyKeyPrefix = prefix + r.emergencyKeyPrefix
+	r.scheduleKey = prefix + r.scheduleKey
+	r.scheduleIndexKey = prefix + r.scheduleIndexKey
+	r.statsKeyPrefix = prefix + r.statsKeyPrefix
+	r.historyKeyPrefix = prefix + r.historyKeyPrefix
+	r.userKeyPrefix = prefix + r.userKeyPrefix
+	r.usernameIndexKey = prefix + r.usernameIndexKey
+	r.auditLogKey = prefix + r.auditLogKey
+	r.revokedTokenPrefix = prefix + r.revokedTokenPrefix
+	r.revokedUserPrefix = prefix + r.revokedUserPrefix
+	r.apiKeyPrefix = prefix + r.apiKeyPrefix
+	r.apiKeyIndexKey = prefix + r.apiKeyIndexKey
+	r.loginStatePrefix = prefix + r.loginStatePrefix
+	r.loginAttemptPrefix = prefix + r.loginAttemptPrefix
+	r.requestLimitPrefix = prefix + r.requestLimitPrefix
+	r.configUpdateChannel = prefix + r.configUpdateChannel
+	r.eventChannel = prefix + r.eventChannel
+
+	b.Cleanup(func() {
+		ctx := context.Background()
+		if keys, err := r.scanKeys(ctx, prefix+"*"); err == nil && len(keys) > 0 {
+			r.client.Del(ctx, keys...)
+		}
+		r.Close()
+	})
+
+	counter := &roundTripCounter{}
+	r.client.AddHook(counter)
+
+	return r, counter
+}
+
+// seedApps writes n app configs.
+func seedApps(b *testing.B, r *redisStorage, n int) {
+	b.Helper()
+
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		err := r.SetAppConfig(ctx, &models.AppConfig{
+			AppID:           fmt.Sprintf("app-%05d", i),
+			GuaranteedQuota: int64(100 + i),
+			Priority:        i % 4,
+		})
+		if err != nil {
+			b.Fatalf("seed app: %v", err)
+		}
+	}
+}
+
+// reportRoundTrips resets the counter before the timed loop and reports the average afterwards.
+func reportRoundTrips(b *testing.B, counter *roundTripCounter, fn func()) {
+	b.Helper()
+
+	atomic.StoreInt64(&counter.n, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fn()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&counter.n))/float64(b.N), "roundtrips/op")
+}
+
+func BenchmarkListAppConfigs(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("apps=%d", n), func(b *testing.B) {
+			r, counter := newBenchStorage(b)
+			seedApps(b, r, n)
+			ctx := context.Background()
+
+			reportRoundTrips(b, counter, func() {
+				configs, err := r.ListAppConfigs(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(configs) != n {
+					b.Fatalf("got %d configs, want %d", len(configs), n)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkScanAppConfigs(b *testing.B) {
+	r, counter := newBenchStorage(b)
+	seedApps(b, r, 1000)
+	ctx := context.Background()
+
+	reportRoundTrips(b, counter, func() {
+		if _, _, err := r.ScanAppConfigs(ctx, "", 100); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkListClusterConfigs(b *testing.B) {
+	r, counter := newBenchStorage(b)
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		err := r.SetClusterConfig(ctx, &models.ClusterConfig{
+			ClusterID:   fmt.Sprintf("cluster-%03d", i),
+			MaxCapacity: 100000,
+		})
+		if err != nil {
+			b.Fatalf("seed cluster: %v", err)
+		}
+	}
+
+	reportRoundTrips(b, counter, func() {
+		if _, err := r.ListClusterConfigs(ctx); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkGetEmergencyStatus(b *testing.B) {
+	r, counter := newBenchStorage(b)
+	ctx := context.Background()
+	if err := r.ActivateEmergency(ctx, "benchmark", 300); err != nil {
+		b.Fatalf("activate emergency: %v", err)
+	}
+
+	reportRoundTrips(b, counter, func() {
+		status, err := r.GetEmergencyStatus(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !status.Active {
+			b.Fatal("expected emergency to be active")
+		}
+	})
+}
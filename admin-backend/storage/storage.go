@@ -3,8 +3,23 @@
 package storage
 
 import (
+	"admin-backend/errors"
 	"admin-backend/models"
 	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// AnyVersion skips the version check in compare-and-set operations.
+	AnyVersion int64 = -1
+	// MaxAuditEntries is the number of audit entries retained.
+	MaxAuditEntries = 10000
+	// MaxEmergencyEvents is the number of emergency history events retained.
+	MaxEmergencyEvents = 10000
+	// DefaultMaxHistoryEntries is the number of snapshots retained per config
+	// history unless WithMaxHistory sets another limit.
+	DefaultMaxHistoryEntries = 1000
 )
 
 // Storage defines the interface for all data persistence operations.
@@ -14,6 +29,24 @@ type Storage interface {
 	AppStorage
 	// Cluster operations
 	ClusterStorage
+	// Connection limit operations
+	ConnectionLimitStorage
+	// Configuration history operations
+	HistoryStorage
+	// User operations
+	UserStorage
+	// Audit log operations
+	AuditStorage
+	// Token revocation operations
+	TokenStorage
+	// API key operations
+	APIKeyStorage
+	// Login flow state operations
+	LoginStateStorage
+	// Failed login tracking operations
+	LoginAttemptStorage
+	// API rate limiting operations
+	RateLimitStorage
 	// Emergency operations
 	EmergencyStorage
 	// Metrics operations
@@ -31,13 +64,30 @@ type AppStorage interface {
 	GetAppConfig(ctx context.Context, appID string) (*models.AppConfig, error)
 
 	// SetAppConfig creates or updates an application configuration.
+	// The stored version is incremented and written back to config.Version.
 	SetAppConfig(ctx context.Context, config *models.AppConfig) error
 
+	// CompareAndSetAppConfig behaves like SetAppConfig but only writes if the
+	// stored version equals expectedVersion (0 for a config that does not exist).
+	// Returns a Conflict error on mismatch.
+	CompareAndSetAppConfig(ctx context.Context, config *models.AppConfig, expectedVersion int64) error
+
 	// DeleteAppConfig removes an application configuration.
+	// The last state is recorded in the configuration history.
+	// Returns a NotFound error if the app does not exist.
 	DeleteAppConfig(ctx context.Context, appID string) error
 
 	// ListAppConfigs returns all application configurations.
 	ListAppConfigs(ctx context.Context) ([]*models.AppConfig, error)
+
+	// ScanAppConfigs returns one page of application configurations.
+	// cursor is the opaque value returned by the previous call ("" to start)
+	// and is only meaningful to the backend that issued it. Every page but
+	// the last holds at least limit items; the Redis backend may return a few
+	// more because SCAN works in batches. The returned cursor is "" once
+	// iteration is complete, so a non-empty cursor is never returned with an
+	// empty page.
+	ScanAppConfigs(ctx context.Context, cursor string, limit int) ([]*models.AppConfig, string, error)
 }
 
 // ClusterStorage defines cluster configuration operations.
@@ -47,13 +97,212 @@ type ClusterStorage interface {
 	GetClusterConfig(ctx context.Context, clusterID string) (*models.ClusterConfig, error)
 
 	// SetClusterConfig creates or updates a cluster configuration.
+	// The stored version is incremented and written back to config.Version.
 	SetClusterConfig(ctx context.Context, config *models.ClusterConfig) error
 
+	// CompareAndSetClusterConfig behaves like SetClusterConfig but only writes if
+	// the stored version equals expectedVersion. Returns a Conflict error on mismatch.
+	// Writes to the gateway cluster also update the gateway's L1 bucket atomically.
+	CompareAndSetClusterConfig(ctx context.Context, config *models.ClusterConfig, expectedVersion int64) error
+
+	// DeleteClusterConfig removes a cluster configuration.
+	// The last state is recorded in the configuration history. Returns a
+	// NotFound error if the cluster does not exist. Apps assigned
+	// to the cluster are left unchanged; callers detach them first.
+	DeleteClusterConfig(ctx context.Context, clusterID string) error
+
 	// ListClusterConfigs returns all cluster configurations.
 	ListClusterConfigs(ctx context.Context) ([]*models.ClusterConfig, error)
+
+	// ScanClusterConfigs returns one page of cluster configurations.
+	// Cursor semantics match ScanAppConfigs.
+	ScanClusterConfigs(ctx context.Context, cursor string, limit int) ([]*models.ClusterConfig, string, error)
+
+	// ListClusterApps returns the applications assigned to a cluster, ordered by ID.
+	// Membership is part of each AppConfig, so deleting an app removes it.
+	// Applications assigned to no cluster are not listed.
+	ListClusterApps(ctx context.Context, clusterID string) ([]*models.AppConfig, error)
+
+	// GetGatewayClusterStatus returns the live L1 bucket of the gateway.
+	// Returns nil if clusterID is not the gateway cluster or the bucket does not exist yet.
+	GetGatewayClusterStatus(ctx context.Context, clusterID string) (*models.GatewayClusterStatus, error)
+}
+
+// ConnectionLimitStorage defines connection limit operations.
+// A limit is stored as the max_connections of its app or cluster, so it is
+// versioned and recorded in the history with the target's config.
+type ConnectionLimitStorage interface {
+	// SetConnectionLimit sets the max_connections of the limit's target and
+	// publishes a connection_limit event. Returns the target's new version,
+	// or a NotFound error if the target does not exist.
+	SetConnectionLimit(ctx context.Context, limit *models.ConnectionLimit) (int64, error)
+
+	// ListConnectionLimits returns the configured limit of every app and
+	// cluster, apps first, each ordered by ID.
+	ListConnectionLimits(ctx context.Context) ([]*models.ConnectionLimit, error)
+}
+
+// HistoryStorage defines configuration history operations.
+// Snapshots are appended by the app and cluster write operations, using the
+// ChangeInfo carried by the context, and are never modified afterwards.
+type HistoryStorage interface {
+	// ListConfigHistory returns up to limit snapshots of a resource, newest first.
+	// A limit <= 0 returns the full retained history. Only the newest
+	// MaxHistoryEntries snapshots are retained; dropping older ones does not
+	// renumber the rest.
+	ListConfigHistory(ctx context.Context, resourceType, resourceID string, limit int) ([]*models.ConfigSnapshot, error)
+
+	// GetConfigSnapshot retrieves a snapshot by revision.
+	// Returns nil if not found or no longer retained.
+	GetConfigSnapshot(ctx context.Context, resourceType, resourceID string, revision int64) (*models.ConfigSnapshot, error)
+}
+
+// UserStorage defines console user operations.
+// Usernames are unique; a user's username cannot be changed after creation.
+type UserStorage interface {
+	// GetUser retrieves a user by ID.
+	// Returns nil if not found.
+	GetUser(ctx context.Context, userID string) (*models.User, error)
+
+	// GetUserByUsername retrieves a user by username.
+	// Returns nil if not found.
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+
+	// CreateUser stores a new user, setting CreatedAt and UpdatedAt.
+	// Returns a Conflict error if the username is taken.
+	CreateUser(ctx context.Context, user *models.User) error
+
+	// UpdateUser replaces the role and password hash of an existing user.
+	// The identity provider fields are fixed at creation.
+	// Returns a NotFound error if the user does not exist.
+	UpdateUser(ctx context.Context, user *models.User) error
+
+	// DeleteUser removes a user.
+	// Returns a NotFound error if the user does not exist.
+	DeleteUser(ctx context.Context, userID string) error
+
+	// ListUsers returns all users sorted by username.
+	ListUsers(ctx context.Context) ([]*models.User, error)
+}
+
+// AuditStorage defines audit log operations.
+// The log is bounded; the oldest entries are dropped beyond MaxAuditEntries.
+type AuditStorage interface {
+	// RecordAuditEntry appends an entry to the audit log.
+	RecordAuditEntry(ctx context.Context, entry *models.AuditEntry) error
+
+	// ListAuditEntries returns up to limit entries, newest first.
+	ListAuditEntries(ctx context.Context, limit int) ([]*models.AuditEntry, error)
+}
+
+// TokenStorage defines token revocation operations.
+// Revocations only need to outlive the tokens they cover, so every entry has a TTL.
+type TokenStorage interface {
+	// RevokeToken adds a token ID (jti) to the denylist for ttl.
+	// Returns false if the token was already revoked, so a caller can
+	// consume single-use tokens atomically.
+	RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) (bool, error)
+
+	// RevokeUserTokens revokes every token issued to userID up to now.
+	// The revocation is kept for ttl, which should cover the longest token lifetime.
+	RevokeUserTokens(ctx context.Context, userID string, ttl time.Duration) error
+
+	// IsTokenRevoked reports whether the token with the given ID, issued to
+	// userID at issuedAt, has been revoked individually or with all of the user's tokens.
+	IsTokenRevoked(ctx context.Context, tokenID, userID string, issuedAt time.Time) (bool, error)
+}
+
+// APIKeyStorage defines service account API key operations.
+// Only the SHA-256 hash of a key is stored; the plaintext is never persisted.
+type APIKeyStorage interface {
+	// GetAPIKey retrieves an API key by ID.
+	// Returns nil if not found.
+	GetAPIKey(ctx context.Context, keyID string) (*models.APIKey, error)
+
+	// GetAPIKeyByHash retrieves an API key by the hash of its secret.
+	// Returns nil if not found.
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+
+	// CreateAPIKey stores a new API key, setting CreatedAt.
+	// Returns a Conflict error if the key hash is already registered.
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+
+	// DeleteAPIKey removes an API key, revoking it immediately.
+	// Returns a NotFound error if the key does not exist.
+	DeleteAPIKey(ctx context.Context, keyID string) error
+
+	// ListAPIKeys returns all API keys sorted by name.
+	ListAPIKeys(ctx context.Context) ([]*models.APIKey, error)
+
+	// TouchAPIKey records usedAt as the key's last use.
+	// Deleted keys are ignored.
+	TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error
+}
+
+// LoginStateStorage defines single-use state for browser login flows such as OIDC.
+type LoginStateStorage interface {
+	// SaveLoginState stores the state of a login attempt for ttl.
+	SaveLoginState(ctx context.Context, state string, data *models.OIDCLoginState, ttl time.Duration) error
+
+	// ConsumeLoginState returns and deletes the state of a login attempt.
+	// Returns nil if it does not exist, has expired or was already consumed.
+	ConsumeLoginState(ctx context.Context, state string) (*models.OIDCLoginState, error)
+}
+
+// LoginAttemptStorage defines failed login tracking for brute-force protection.
+// Failures are kept per scope (models.LoginScopeUser or models.LoginScopeIP) and
+// key, and expire on their own once the failure window and any block have passed.
+type LoginAttemptStorage interface {
+	// GetLoginAttempts returns the failures recorded for a username or client IP.
+	// Returns nil if there are none.
+	GetLoginAttempts(ctx context.Context, scope, key string) (*models.LoginAttempts, error)
+
+	// RecordLoginFailure counts a failed login at the given time and returns the
+	// updated record. The failures are forgotten window after the last one.
+	RecordLoginFailure(ctx context.Context, scope, key string, at time.Time, window time.Duration) (*models.LoginAttempts, error)
+
+	// BlockLogin refuses further logins until the given time; locked marks the
+	// block as a lockout rather than a backoff delay. An existing later block is kept.
+	BlockLogin(ctx context.Context, scope, key string, until time.Time, locked bool) error
+
+	// ClearLoginAttempts forgets the failures and any block of a username or client IP.
+	// Returns false if there was nothing to clear.
+	ClearLoginAttempts(ctx context.Context, scope, key string) (bool, error)
+
+	// ListLoginAttempts returns every tracked username and client IP, sorted by scope and key.
+	ListLoginAttempts(ctx context.Context) ([]*models.LoginAttempts, error)
+}
+
+// RateLimitStorage defines sliding-window request counting for the admin API,
+// shared by every replica that uses the same backend.
+type RateLimitStorage interface {
+	// AllowRequest records a request at now in every bucket if none of them is
+	// full, and returns whether it was allowed with the state of each bucket in
+	// input order. A refused request is not recorded.
+	AllowRequest(ctx context.Context, buckets []RateLimitBucket, now time.Time) (bool, []RateLimitState, error)
+}
+
+// RateLimitBucket is a sliding window of requests counted under one key.
+type RateLimitBucket struct {
+	// Key identifies the caller and, for route limits, the route
+	Key string
+	// Limit is the number of requests allowed per window
+	Limit int
+	// Window is the length of the sliding window
+	Window time.Duration
+}
+
+// RateLimitState is a bucket's state after a request.
+type RateLimitState struct {
+	// Remaining is the number of further requests allowed now
+	Remaining int
+	// ResetAt is when the oldest counted request leaves the window, freeing a slot
+	ResetAt time.Time
 }
 
 // EmergencyStorage defines emergency mode operations.
+// Activations, extensions, deactivations and expiries are recorded in a history bounded
+// by MaxEmergencyEvents, attributed to the ChangeInfo author of the context.
 type EmergencyStorage interface {
 	// GetEmergencyStatus retrieves the current emergency mode status.
 	GetEmergencyStatus(ctx context.Context) (*models.EmergencyStatus, error)
@@ -63,6 +312,39 @@ type EmergencyStorage interface {
 
 	// DeactivateEmergency deactivates emergency mode.
 	DeactivateEmergency(ctx context.Context) error
+
+	// ExpireEmergency deactivates emergency mode if it has expired, publishing
+	// emergency_deactivated with reason EmergencyReasonExpired. Returns the
+	// expired emergency, or nil if there was none. The check and deactivation
+	// are atomic, so only one of several backends deactivates an emergency.
+	ExpireEmergency(ctx context.Context) (*models.EmergencyStatus, error)
+
+	// ExtendEmergency makes the active emergency expire duration seconds from
+	// now, which may extend or shorten it. Returns the updated status, or a
+	// conflict error if no emergency is active or the new expiry is more than
+	// validation.MaxEmergencyDuration after activation.
+	ExtendEmergency(ctx context.Context, duration int64) (*models.EmergencyStatus, error)
+
+	// ListEmergencyEvents returns the history events recorded between from and
+	// to inclusive, newest first. A zero from or to leaves that end open.
+	ListEmergencyEvents(ctx context.Context, from, to time.Time) ([]*models.EmergencyEvent, error)
+
+	// CreateEmergencySchedule stores a pending scheduled emergency window,
+	// replacing the window with the same ID. Windows claimed but not started
+	// are put back with it.
+	CreateEmergencySchedule(ctx context.Context, schedule *models.EmergencySchedule) error
+
+	// ListEmergencySchedules returns the pending windows ordered by start time.
+	ListEmergencySchedules(ctx context.Context) ([]*models.EmergencySchedule, error)
+
+	// CancelEmergencySchedule removes a pending window and returns it.
+	// Returns a not found error if it does not exist or has already started.
+	CancelEmergencySchedule(ctx context.Context, id string) (*models.EmergencySchedule, error)
+
+	// ClaimEmergencySchedules removes and returns the pending windows starting
+	// at or before now, ordered by start time. The claim is atomic, so each
+	// window is started by only one of several backends.
+	ClaimEmergencySchedules(ctx context.Context, now time.Time) ([]*models.EmergencySchedule, error)
 }
 
 // MetricsStorage defines metrics operations.
@@ -73,8 +355,15 @@ type MetricsStorage interface {
 	// GetAppMetrics retrieves metrics for a specific application.
 	GetAppMetrics(ctx context.Context, appID string) (*models.AppMetrics, error)
 
-	// GetConnectionMetrics retrieves connection statistics.
-	GetConnectionMetrics(ctx context.Context) ([]*models.ConnectionStats, error)
+	// GetConnectionMetrics aggregates the connection statistics reported by
+	// the gateway nodes with the configured limits, per app and per cluster.
+	// Configured targets without reports are included with zero usage.
+	// byNode adds each target's per-node statistics.
+	GetConnectionMetrics(ctx context.Context, byNode bool) ([]*models.ConnectionStats, error)
+
+	// ListConnectionNodes returns the totals reported by each gateway node, ordered by node.
+	// Nodes that stopped reporting drop out once their report expires.
+	ListConnectionNodes(ctx context.Context) ([]*models.ConnectionNode, error)
 }
 
 // PubSubStorage defines pub/sub operations.
@@ -102,3 +391,34 @@ type HealthChecker interface {
 	// Close closes any open connections.
 	Close() error
 }
+
+// versionConflict builds the Conflict error returned on a version mismatch.
+func versionConflict(what string, current, expected int64) error {
+	err := errors.Conflict(
+		fmt.Sprintf("%s was modified concurrently (expected version %d, current version %d)", what, expected, current),
+		nil,
+	).WithErrorCode(errors.CodeVersionConflict)
+	err.Context = map[string]interface{}{
+		"current_version":  current,
+		"expected_version": expected,
+	}
+	return err
+}
+
+// remainingRequests returns how many more requests a bucket holding count allows.
+func remainingRequests(limit int, count int64) int {
+	if remaining := int64(limit) - count; remaining > 0 {
+		return int(remaining)
+	}
+	return 0
+}
+
+// wrapStorageError passes AppErrors through unchanged and wraps any other
+// error as an internal server error.
+func wrapStorageError(message string, err error) error {
+	var appErr *errors.AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return errors.InternalServerError(message, err)
+}
@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testBackends lists the backends storage tests run against. The Redis backend
+// needs REDIS_ADDR, like the benchmarks in redis_bench_test.go.
+var testBackends = []struct {
+	name string
+	open func(t *testing.T, options ...Option) Storage
+}{
+	{
+		name: "memory",
+		open: func(t *testing.T, options ...Option) Storage {
+			store := NewMemoryStorage(options...)
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	},
+	{
+		name: "redis",
+		open: func(t *testing.T, options ...Option) Storage {
+			r, _ := newBenchStorage(t, options...)
+			return r
+		},
+	},
+}
+
+func TestScanAppConfigs(t *testing.T) {
+	const apps = 7
+
+	for _, backend := range testBackends {
+		for _, limit := range []int{1, 3, apps, apps + 3} {
+			t.Run(fmt.Sprintf("%s/limit %d", backend.name, limit), func(t *testing.T) {
+				store := backend.open(t)
+				ctx := context.Background()
+
+				for i := 0; i < apps; i++ {
+					if err := store.SetAppConfig(ctx, &models.AppConfig{AppID: fmt.Sprintf("app%d", i), GuaranteedQuota: 10}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				// Keys under the app prefix that are not apps must not count toward a page
+				if r, ok := store.(*redisStorage); ok {
+					for i := 0; i < 50; i++ {
+						if err := r.client.Set(ctx, fmt.Sprintf("%sapp0:extra%d", r.appKeyPrefix, i), "x", 0).Err(); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+
+				seen := make(map[string]bool)
+				cursor := ""
+				for page := 1; ; page++ {
+					configs, next, err := store.ScanAppConfigs(ctx, cursor, limit)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if next != "" && len(configs) < limit {
+						t.Fatalf("page %d: %d apps with next cursor %q, want at least %d", page, len(configs), next, limit)
+					}
+					if _, memory := store.(*memoryStorage); memory && len(configs) > limit {
+						t.Fatalf("page %d: %d apps, want at most %d", page, len(configs), limit)
+					}
+					for _, config := range configs {
+						if seen[config.AppID] {
+							t.Fatalf("page %d: %s returned twice", page, config.AppID)
+						}
+						seen[config.AppID] = true
+					}
+
+					if next == "" {
+						break
+					}
+					if page > apps {
+						t.Fatal("pagination does not terminate")
+					}
+					cursor = next
+				}
+
+				if len(seen) != apps {
+					t.Errorf("saw %d apps, want %d", len(seen), apps)
+				}
+			})
+		}
+	}
+}
+
+func TestCompareAndSetAppConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing is the number of writes before the tested one
+		existing    int
+		expected    int64
+		wantVersion int64
+		wantErr     bool
+	}{
+		{name: "create at version 0", expected: 0, wantVersion: 1},
+		{name: "create at any version", expected: AnyVersion, wantVersion: 1},
+		{name: "create over an existing app", existing: 1, expected: 0, wantVersion: 1, wantErr: true},
+		{name: "update at the current version", existing: 2, expected: 2, wantVersion: 3},
+		{name: "update at a stale version", existing: 2, expected: 1, wantVersion: 2, wantErr: true},
+		{name: "update at any version", existing: 2, expected: AnyVersion, wantVersion: 3},
+	}
+
+	for _, backend := range testBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				store := backend.open(t)
+				ctx := context.Background()
+
+				for i := 0; i < tt.existing; i++ {
+					if err := store.SetAppConfig(ctx, &models.AppConfig{AppID: "app1", GuaranteedQuota: int64(i)}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				config := &models.AppConfig{AppID: "app1", GuaranteedQuota: 100}
+				err := store.CompareAndSetAppConfig(ctx, config, tt.expected)
+				if tt.wantErr {
+					if code := errors.Resolve(err).ErrorCode; code != errors.CodeVersionConflict {
+						t.Fatalf("error %v, want a version conflict", err)
+					}
+				} else if err != nil {
+					t.Fatal(err)
+				} else if config.Version != tt.wantVersion {
+					t.Errorf("version = %d, want %d", config.Version, tt.wantVersion)
+				}
+
+				stored, err := store.GetAppConfig(ctx, "app1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Version != tt.wantVersion {
+					t.Errorf("stored version = %d, want %d", stored.Version, tt.wantVersion)
+				}
+			})
+		}
+	}
+}
+
+func TestConfigHistoryLimit(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t, WithMaxHistory(3))
+			ctx := context.Background()
+
+			// Revisions 1-4 are writes, 5 the deletion and 6 a new create
+			for quota := int64(1); quota <= 4; quota++ {
+				if err := store.SetAppConfig(ctx, &models.AppConfig{AppID: "app1", GuaranteedQuota: quota}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.DeleteAppConfig(ctx, "app1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetAppConfig(ctx, &models.AppConfig{AppID: "app1", GuaranteedQuota: 6}); err != nil {
+				t.Fatal(err)
+			}
+
+			snapshots, err := store.ListConfigHistory(ctx, models.ResourceApp, "app1", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var revisions []int64
+			for _, snap := range snapshots {
+				revisions = append(revisions, snap.Revision)
+			}
+			if fmt.Sprint(revisions) != "[6 5 4]" {
+				t.Fatalf("revisions %v, want [6 5 4]", revisions)
+			}
+
+			newest, err := store.ListConfigHistory(ctx, models.ResourceApp, "app1", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(newest) != 2 || newest[0].Revision != 6 || newest[1].Revision != 5 {
+				t.Errorf("limited history %+v, want revisions 6 and 5", newest)
+			}
+
+			tests := []struct {
+				revision   int64
+				wantAction string
+				wantQuota  int64
+			}{
+				{revision: 1},
+				{revision: 3},
+				{revision: 4, wantAction: models.ChangeUpdate, wantQuota: 4},
+				{revision: 5, wantAction: models.ChangeDelete, wantQuota: 4},
+				{revision: 6, wantAction: models.ChangeCreate, wantQuota: 6},
+				{revision: 7},
+			}
+			for _, tt := range tests {
+				snap, err := store.GetConfigSnapshot(ctx, models.ResourceApp, "app1", tt.revision)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.wantAction == "" {
+					if snap != nil {
+						t.Errorf("revision %d: got %+v, want none", tt.revision, snap)
+					}
+					continue
+				}
+				if snap == nil || snap.Revision != tt.revision || snap.Action != tt.wantAction || snap.App.GuaranteedQuota != tt.wantQuota {
+					t.Errorf("revision %d: got %+v, want a %s with quota %d", tt.revision, snap, tt.wantAction, tt.wantQuota)
+				}
+			}
+		})
+	}
+}
+
+func TestConsumeLoginState(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			ctx := context.Background()
+
+			saved := &models.OIDCLoginState{Nonce: "n", CodeVerifier: "v", CreatedAt: time.Now().Truncate(time.Second)}
+			if err := store.SaveLoginState(ctx, "state1", saved, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.ConsumeLoginState(ctx, "state1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || got.Nonce != "n" || got.CodeVerifier != "v" || !got.CreatedAt.Equal(saved.CreatedAt) {
+				t.Fatalf("first consume = %+v, want %+v", got, saved)
+			}
+
+			// A login state is good for one callback only
+			if got, err := store.ConsumeLoginState(ctx, "state1"); err != nil || got != nil {
+				t.Errorf("second consume = %+v, %v; want nil", got, err)
+			}
+			if got, err := store.ConsumeLoginState(ctx, "unknown"); err != nil || got != nil {
+				t.Errorf("consume of an unknown state = %+v, %v; want nil", got, err)
+			}
+
+			// Redis expires keys itself; the memory backend checks the deadline on read
+			if backend.name != "memory" {
+				return
+			}
+			if err := store.SaveLoginState(ctx, "expiring", saved, time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(20 * time.Millisecond)
+			if got, err := store.ConsumeLoginState(ctx, "expiring"); err != nil || got != nil {
+				t.Errorf("consume of an expired state = %+v, %v; want nil", got, err)
+			}
+		})
+	}
+}
+
+func TestListClusterApps(t *testing.T) {
+	assigned := func(clusterIDs ...string) []models.ClusterAssignment {
+		assignments := make([]models.ClusterAssignment, len(clusterIDs))
+		for i, id := range clusterIDs {
+			assignments[i].ClusterID = id
+		}
+		return assignments
+	}
+
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			ctx := context.Background()
+
+			members := func(clusterID string) []string {
+				t.Helper()
+				apps, err := store.ListClusterApps(ctx, clusterID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids := make([]string, 0, len(apps))
+				for _, app := range apps {
+					ids = append(ids, app.AppID)
+				}
+				return ids
+			}
+			expect := func(step string, want map[string][]string) {
+				t.Helper()
+				for clusterID, ids := range want {
+					if got := members(clusterID); fmt.Sprint(got) != fmt.Sprint(ids) {
+						t.Errorf("%s: apps of %s = %v, want %v", step, clusterID, got, ids)
+					}
+				}
+			}
+			write := func(app *models.AppConfig) {
+				t.Helper()
+				if err := store.SetAppConfig(ctx, app); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			write(&models.AppConfig{AppID: "b", GuaranteedQuota: 10, Clusters: assigned("c1", "c2")})
+			write(&models.AppConfig{AppID: "a", GuaranteedQuota: 10, Clusters: assigned("c2")})
+			write(&models.AppConfig{AppID: "unassigned", GuaranteedQuota: 10})
+			expect("created", map[string][]string{"c1": {"b"}, "c2": {"a", "b"}, "c3": {}})
+
+			write(&models.AppConfig{AppID: "b", GuaranteedQuota: 10, Clusters: assigned("c3")})
+			expect("moved", map[string][]string{"c1": {}, "c2": {"a"}, "c3": {"b"}})
+
+			// A write lost to a version conflict leaves the membership alone
+			err := store.CompareAndSetAppConfig(ctx, &models.AppConfig{AppID: "a", GuaranteedQuota: 10, Clusters: assigned("c1")}, 99)
+			if errors.Resolve(err).ErrorCode != errors.CodeVersionConflict {
+				t.Fatalf("stale write: %v", err)
+			}
+			expect("conflict", map[string][]string{"c1": {}, "c2": {"a"}})
+
+			write(&models.AppConfig{AppID: "b", GuaranteedQuota: 10})
+			expect("unassigned", map[string][]string{"c3": {}})
+
+			if err := store.DeleteAppConfig(ctx, "a"); err != nil {
+				t.Fatal(err)
+			}
+			expect("deleted", map[string][]string{"c2": {}})
+
+			r, ok := store.(*redisStorage)
+			if !ok {
+				return
+			}
+			// Emptied member sets are removed
+			if n, err := r.client.Exists(ctx, r.clusterAppsPrefix+"c1", r.clusterAppsPrefix+"c2", r.clusterAppsPrefix+"c3").Result(); err != nil || n != 0 {
+				t.Errorf("%d empty member sets left, %v", n, err)
+			}
+
+			// Apps written before the member sets were kept are indexed once
+			if err := r.client.HSet(ctx, r.appKeyPrefix+"legacy", "app_id", "legacy", "guaranteed_quota", 10,
+				"clusters", `[{"cluster_id":"c1"},{"cluster_id":"c4","guaranteed_quota":5}]`, "version", 3).Err(); err != nil {
+				t.Fatal(err)
+			}
+			expect("legacy app before indexing", map[string][]string{"c1": {}})
+			if err := r.client.Del(ctx, r.clusterAppsIndexKey).Err(); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.indexClusterApps(ctx); err != nil {
+				t.Fatal(err)
+			}
+			expect("legacy app indexed", map[string][]string{"c1": {"legacy"}, "c4": {"legacy"}})
+
+			// and written through the scripts afterwards
+			write(&models.AppConfig{AppID: "legacy", GuaranteedQuota: 10, Clusters: assigned("c4")})
+			expect("legacy app moved", map[string][]string{"c1": {}, "c4": {"legacy"}})
+		})
+	}
+}
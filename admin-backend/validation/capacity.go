@@ -0,0 +1,220 @@
+// Package validation provides the cluster capacity checks of config writes.
+package validation
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Oversubscription policies decide what happens when the guaranteed quotas
+// drawing on a cluster exceed its allocatable capacity,
+// max_capacity * (1 - reserved_ratio).
+const (
+	// OversubscriptionReject refuses the write
+	OversubscriptionReject = "reject"
+	// OversubscriptionWarn accepts the write and reports a warning
+	OversubscriptionWarn = "warn"
+	// OversubscriptionAllow accepts the write up to Factor times the allocatable capacity
+	OversubscriptionAllow = "allow"
+)
+
+// CapacityPolicy is the oversubscription policy applied to config writes.
+type CapacityPolicy struct {
+	// Mode is OversubscriptionReject, OversubscriptionWarn or OversubscriptionAllow
+	Mode string
+	// Factor is the multiple of the allocatable capacity OversubscriptionAllow permits
+	Factor float64
+}
+
+// DefaultCapacityPolicy rejects any oversubscription.
+var DefaultCapacityPolicy = CapacityPolicy{Mode: OversubscriptionReject, Factor: 1}
+
+// Limit returns the guaranteed total the policy permits on allocatable capacity.
+func (p CapacityPolicy) Limit(allocatable int64) int64 {
+	if p.Mode == OversubscriptionAllow && p.Factor > 1 {
+		return int64(math.Floor(float64(allocatable)*p.Factor + 1e-9))
+	}
+	return allocatable
+}
+
+// CapacityReport compares the limit of a cluster with the sum of the
+// guaranteed quotas drawing on it, before and after a change.
+type CapacityReport struct {
+	ClusterID     string  `json:"cluster_id"`
+	MaxCapacity   int64   `json:"max_capacity"`
+	ReservedRatio float64 `json:"reserved_ratio"`
+	// Allocatable is MaxCapacity * (1 - ReservedRatio)
+	Allocatable int64 `json:"allocatable"`
+	// Limit is the guaranteed total the oversubscription policy permits
+	Limit int64 `json:"limit"`
+	// Committed is the sum of the guaranteed quotas before the change
+	Committed int64 `json:"committed"`
+	// Guaranteed is the sum of the guaranteed quotas after the change
+	Guaranteed int64 `json:"guaranteed"`
+	// Headroom is Limit - Committed, the guaranteed quota that can still be
+	// added; negative when the cluster is already oversubscribed
+	Headroom int64 `json:"headroom"`
+	Apps     int   `json:"apps"`
+
+	// worsened is set when the change raised the guaranteed total or lowered the limit
+	worsened bool
+}
+
+// Exceeded reports whether the guaranteed quotas exceed the limit after the change.
+func (r *CapacityReport) Exceeded() bool {
+	return r.Guaranteed > r.Limit
+}
+
+// Oversubscribes reports whether the change leaves the cluster over its limit
+// and made it worse. Changes that shrink an existing oversubscription, such as
+// lowering a quota, are not held against the policy.
+func (r *CapacityReport) Oversubscribes() bool {
+	return r.Exceeded() && r.worsened
+}
+
+// allocatable returns max_capacity * (1 - reservedRatio).
+func allocatable(maxCapacity int64, reservedRatio float64) int64 {
+	// The epsilon keeps float error from rounding e.g. 699.9999 down to 699
+	return int64(math.Floor(float64(maxCapacity)*(1-reservedRatio) + 1e-9))
+}
+
+// ClusterQuota returns the guaranteed quota app draws on the cluster, and
+// whether it draws on it at all. An app assigned to no cluster draws its full
+// guaranteed quota on every cluster.
+func ClusterQuota(app *models.AppConfig, clusterID string) (int64, bool) {
+	if len(app.Clusters) == 0 {
+		return app.GuaranteedQuota, true
+	}
+	for _, assignment := range app.Clusters {
+		if assignment.ClusterID != clusterID {
+			continue
+		}
+		if assignment.GuaranteedQuota > 0 {
+			return assignment.GuaranteedQuota, true
+		}
+		return app.GuaranteedQuota, true
+	}
+	return 0, false
+}
+
+// sumGuaranteed returns the sum of the guaranteed quotas apps draw on the
+// cluster and the number of apps drawing on it.
+func sumGuaranteed(apps []*models.AppConfig, clusterID string) (int64, int) {
+	var sum int64
+	var count int
+	for _, app := range apps {
+		if quota, ok := ClusterQuota(app, clusterID); ok {
+			sum += quota
+			count++
+		}
+	}
+	return sum, count
+}
+
+// report builds the capacity report of cluster, whose reserved ratio in effect is reservedRatio.
+func (p CapacityPolicy) report(cluster *models.ClusterConfig, reservedRatio float64, committed, guaranteed int64, apps int) CapacityReport {
+	report := CapacityReport{
+		ClusterID:     cluster.ClusterID,
+		MaxCapacity:   cluster.MaxCapacity,
+		ReservedRatio: reservedRatio,
+		Allocatable:   allocatable(cluster.MaxCapacity, reservedRatio),
+		Committed:     committed,
+		Guaranteed:    guaranteed,
+		Apps:          apps,
+	}
+	report.Limit = p.Limit(report.Allocatable)
+	report.Headroom = report.Limit - report.Committed
+	return report
+}
+
+// AppCapacity reports the capacity of each cluster if configs replaced their
+// stored versions in existing, counting the quota each app draws on the
+// cluster (see ClusterQuota). clusters are stored configs, so their reserved
+// ratio is the one in effect.
+func (p CapacityPolicy) AppCapacity(clusters []*models.ClusterConfig, existing []*models.AppConfig, configs ...*models.AppConfig) []CapacityReport {
+	replaced := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		replaced[config.AppID] = true
+	}
+	apps := make([]*models.AppConfig, 0, len(existing)+len(configs))
+	for _, app := range existing {
+		if !replaced[app.AppID] {
+			apps = append(apps, app)
+		}
+	}
+	apps = append(apps, configs...)
+
+	reports := make([]CapacityReport, 0, len(clusters))
+	for _, cluster := range clusters {
+		committed, _ := sumGuaranteed(existing, cluster.ClusterID)
+		guaranteed, count := sumGuaranteed(apps, cluster.ClusterID)
+		report := p.report(cluster, cluster.ReservedRatio, committed, guaranteed, count)
+		report.worsened = guaranteed > committed
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// ClusterCapacity reports the capacity of config, whose reserved ratio in
+// effect is reservedRatio, against the guaranteed quotas apps draw on it.
+// current is the stored cluster, or nil when config creates it.
+func (p CapacityPolicy) ClusterCapacity(config *models.ClusterConfig, reservedRatio float64, current *models.ClusterConfig, apps []*models.AppConfig) CapacityReport {
+	guaranteed, count := sumGuaranteed(apps, config.ClusterID)
+	report := p.report(config, reservedRatio, guaranteed, guaranteed, count)
+	report.worsened = current == nil || report.Limit < p.Limit(allocatable(current.MaxCapacity, current.ReservedRatio))
+	return report
+}
+
+// Check applies the policy to the reports of a change. Oversubscription the
+// change causes is a violation of field, or only a warning under
+// OversubscriptionWarn.
+func (p CapacityPolicy) Check(field string, reports []CapacityReport) (violations, warnings []errors.FieldError) {
+	for i := range reports {
+		if !reports[i].Oversubscribes() {
+			continue
+		}
+		v := capacityViolation(field, reports[i])
+		if p.Mode == OversubscriptionWarn {
+			warnings = append(warnings, v)
+		} else {
+			violations = append(violations, v)
+		}
+	}
+	return violations, warnings
+}
+
+// capacityViolation reports an oversubscribed cluster against field.
+func capacityViolation(field string, report CapacityReport) errors.FieldError {
+	return errors.FieldError{
+		Field: field,
+		Rule:  RuleClusterCapacity,
+		Message: fmt.Sprintf("sum of guaranteed quotas (%d) exceeds the limit of cluster %s (%d, headroom %d)",
+			report.Guaranteed, report.ClusterID, report.Limit, report.Headroom),
+	}
+}
+
+// CapacityExceeded returns the 409 error refusing a write that oversubscribes
+// clusters. It carries the reports and the smallest headroom among the
+// oversubscribed clusters.
+func CapacityExceeded(violations []errors.FieldError, reports []CapacityReport) *errors.AppError {
+	messages := make([]string, len(violations))
+	for i, v := range violations {
+		messages[i] = v.Message
+	}
+
+	headroom := int64(math.MaxInt64)
+	for i := range reports {
+		if reports[i].Oversubscribes() && reports[i].Headroom < headroom {
+			headroom = reports[i].Headroom
+		}
+	}
+
+	return errors.Conflict(strings.Join(messages, "; "), nil).
+		WithErrorCode(errors.CodeCapacityExceeded).
+		WithFields(violations...).
+		WithContext("headroom", headroom).
+		WithContext("capacity", reports)
+}
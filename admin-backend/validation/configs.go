@@ -0,0 +1,188 @@
+// Package validation provides the validation schemas of application and cluster configs.
+package validation
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"fmt"
+	"sort"
+)
+
+const (
+	// MaxIDLength is the maximum length of application and cluster IDs
+	MaxIDLength = 100
+	// MaxImportSize is the maximum number of configs in one bulk import
+	MaxImportSize = 1000
+	// MaxAppClusters is the maximum number of clusters an application is assigned to
+	MaxAppClusters = 100
+)
+
+// ClusterAssignmentSchema declares the rules of models.ClusterAssignment.
+var ClusterAssignmentSchema = &Schema{
+	Name:        "cluster_assignment",
+	Title:       "ClusterAssignment",
+	Description: "Assignment of an application to a cluster",
+	Fields: []Field{
+		{Name: "cluster_id", Type: "string", Description: "Cluster ID", Required: true, MaxLength: MaxIDLength, Pattern: idPattern},
+		{Name: "guaranteed_quota", Type: "integer", Description: "Guaranteed requests per second on this cluster, 0 for the application's guaranteed quota", Min: bound(0)},
+	},
+}
+
+// AppConfigSchema declares the rules of models.AppConfig.
+var AppConfigSchema = (&Schema{
+	Name:        "app",
+	Title:       "AppConfig",
+	Description: "Application rate limit configuration",
+	Fields: []Field{
+		{Name: "app_id", Type: "string", Description: "Application ID", Required: true, MaxLength: MaxIDLength, Pattern: idPattern},
+		{Name: "guaranteed_quota", Type: "integer", Description: "Guaranteed requests per second", Required: true, Min: bound(1)},
+		{Name: "burst_quota", Type: "integer", Description: "Burst requests per second, 0 for 5x the guaranteed quota", Min: bound(0)},
+		{Name: "priority", Type: "integer", Description: "Priority from 0 (P0, highest) to 3", Min: bound(0), Max: bound(3)},
+		{Name: "max_borrow", Type: "integer", Description: "Maximum quota borrowed from the cluster, 0 for the guaranteed quota", Min: bound(0)},
+		{Name: "max_connections", Type: "integer", Description: "Maximum concurrent connections, 0 for the default (1000)", Min: bound(0)},
+		{Name: "clusters", Type: "array", Description: "Clusters serving the application; without any, it counts against every cluster's capacity", Items: ClusterAssignmentSchema, MaxItems: MaxAppClusters, UniqueBy: "cluster_id"},
+		{Name: "version", Type: "integer", Description: "Version incremented on every write", ReadOnly: true},
+		{Name: "updated_at", Type: "string", Format: "date-time", ReadOnly: true},
+	},
+	Checks: []Check{
+		{Field: "burst_quota", Rule: RuleGteField, Other: "guaranteed_quota", SkipZero: true},
+		{Field: "clusters[].guaranteed_quota", Rule: RuleLteField, Other: "guaranteed_quota", SkipZero: true},
+	},
+}).compile()
+
+// ClusterConfigSchema declares the rules of models.ClusterConfig.
+var ClusterConfigSchema = (&Schema{
+	Name:        "cluster",
+	Title:       "ClusterConfig",
+	Description: "Cluster capacity configuration",
+	Fields: []Field{
+		{Name: "cluster_id", Type: "string", Description: "Cluster ID", Required: true, MaxLength: MaxIDLength, Pattern: idPattern},
+		{Name: "max_capacity", Type: "integer", Description: "Maximum requests per second of the cluster", Required: true, Min: bound(1)},
+		{Name: "reserved_ratio", Type: "number", Description: "Share of capacity reserved for emergencies", Min: bound(0), Max: bound(1)},
+		{Name: "emergency_threshold", Type: "number", Description: "Utilisation that triggers emergency mode", Min: bound(0), Max: bound(1)},
+		{Name: "max_connections", Type: "integer", Description: "Maximum concurrent connections, 0 for the default (5000)", Min: bound(0)},
+		{Name: "version", Type: "integer", Description: "Version incremented on every write", ReadOnly: true},
+		{Name: "updated_at", Type: "string", Format: "date-time", ReadOnly: true},
+	},
+	Checks: []Check{
+		{Field: "reserved_ratio", Rule: RuleLtField, Other: "emergency_threshold"},
+	},
+}).compile()
+
+// ConnectionLimitSchema declares the rules of models.ConnectionLimit.
+var ConnectionLimitSchema = (&Schema{
+	Name:        "connection_limit",
+	Title:       "ConnectionLimit",
+	Description: "Maximum concurrent connections of an application or cluster",
+	Fields: []Field{
+		{Name: "target_type", Type: "string", Description: "app or cluster", Required: true, Pattern: "^(app|cluster)$"},
+		{Name: "target_id", Type: "string", Description: "Application or cluster ID", Required: true, MaxLength: MaxIDLength, Pattern: idPattern},
+		{Name: "limit", Type: "integer", Description: "Maximum concurrent connections", Required: true, Min: bound(1)},
+	},
+}).compile()
+
+// schemas indexes the published schemas by name.
+var schemas = map[string]*Schema{
+	AppConfigSchema.Name:       AppConfigSchema,
+	ClusterConfigSchema.Name:   ClusterConfigSchema,
+	ConnectionLimitSchema.Name: ConnectionLimitSchema,
+}
+
+// LookupSchema returns the schema with the given name, or nil.
+func LookupSchema(name string) *Schema {
+	return schemas[name]
+}
+
+// SchemaNames returns the names of the published schemas, sorted.
+func SchemaNames() []string {
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateAppConfig validates an application configuration, reporting every violation.
+func ValidateAppConfig(config *models.AppConfig) error {
+	if violations := AppConfigSchema.Validate(config); len(violations) > 0 {
+		return ValidationFailed(violations)
+	}
+	return nil
+}
+
+// ValidateClusterConfig validates a cluster configuration, reporting every violation.
+func ValidateClusterConfig(config *models.ClusterConfig) error {
+	if violations := ClusterConfigSchema.Validate(config); len(violations) > 0 {
+		return ValidationFailed(violations)
+	}
+	return nil
+}
+
+// ValidateConnectionLimit validates a connection limit, reporting every violation.
+func ValidateConnectionLimit(limit *models.ConnectionLimit) error {
+	if violations := ConnectionLimitSchema.Validate(limit); len(violations) > 0 {
+		return ValidationFailed(violations)
+	}
+	return nil
+}
+
+// ValidateClusterRefs checks that every cluster config is assigned to exists
+// in clusters, reporting each unknown cluster as clusters[i].cluster_id.
+func ValidateClusterRefs(config *models.AppConfig, clusters []*models.ClusterConfig) []errors.FieldError {
+	known := make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		known[cluster.ClusterID] = true
+	}
+
+	var violations []errors.FieldError
+	for i, assignment := range config.Clusters {
+		if !known[assignment.ClusterID] {
+			field := fmt.Sprintf("clusters[%d].cluster_id", i)
+			violations = append(violations, errors.FieldError{
+				Field:   field,
+				Rule:    RuleExists,
+				Message: fmt.Sprintf("%s: cluster %s does not exist", field, assignment.ClusterID),
+			})
+		}
+	}
+	return violations
+}
+
+// ValidateAppImport validates the configs of a bulk import. Violations are
+// reported for every config, qualified as apps[i].field, and app IDs must be
+// unique within the import.
+func ValidateAppImport(configs []models.AppConfig) error {
+	if len(configs) == 0 {
+		return ValidationFailed([]errors.FieldError{{Field: "apps", Rule: RuleRequired, Message: "apps is required"}})
+	}
+	if len(configs) > MaxImportSize {
+		return ValidationFailed([]errors.FieldError{{
+			Field:   "apps",
+			Rule:    RuleMax,
+			Message: fmt.Sprintf("apps must not contain more than %d configs", MaxImportSize),
+		}})
+	}
+
+	var violations []errors.FieldError
+	seen := make(map[string]int, len(configs))
+	for i := range configs {
+		prefix := fmt.Sprintf("apps[%d]", i)
+		violations = append(violations, PrefixFields(prefix, AppConfigSchema.Validate(&configs[i]))...)
+
+		if first, ok := seen[configs[i].AppID]; ok && configs[i].AppID != "" {
+			violations = append(violations, errors.FieldError{
+				Field:   prefix + ".app_id",
+				Rule:    RuleUnique,
+				Message: fmt.Sprintf("%s.app_id duplicates apps[%d].app_id", prefix, first),
+			})
+			continue
+		}
+		seen[configs[i].AppID] = i
+	}
+
+	if len(violations) > 0 {
+		return ValidationFailed(violations)
+	}
+	return nil
+}
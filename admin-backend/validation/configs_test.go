@@ -0,0 +1,213 @@
+package validation
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fieldErrors returns the field errors of a validation error as "field:rule".
+func fieldErrors(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+	appErr := errors.Resolve(err)
+	if appErr.Code != 400 || appErr.ErrorCode != errors.CodeValidationFailed {
+		t.Fatalf("error = %d %s %q, want a validation failure", appErr.Code, appErr.ErrorCode, appErr.Message)
+	}
+	return violationKeys(appErr.Fields)
+}
+
+func TestValidateAppConfig(t *testing.T) {
+	valid := func() *models.AppConfig {
+		return &models.AppConfig{
+			AppID:           "app-1",
+			GuaranteedQuota: 100,
+			BurstQuota:      200,
+			Priority:        1,
+			MaxBorrow:       50,
+			MaxConnections:  10,
+			Clusters: []models.ClusterAssignment{
+				{ClusterID: "c1", GuaranteedQuota: 60},
+				{ClusterID: "c2"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(config *models.AppConfig)
+		want   []string
+	}{
+		{name: "valid", modify: func(config *models.AppConfig) {}},
+		{name: "defaults left unset", modify: func(config *models.AppConfig) {
+			config.BurstQuota, config.MaxBorrow, config.MaxConnections, config.Clusters = 0, 0, 0, nil
+		}},
+		{name: "app_id missing", modify: func(config *models.AppConfig) { config.AppID = "" }, want: []string{"app_id:required"}},
+		{name: "app_id too long", modify: func(config *models.AppConfig) { config.AppID = strings.Repeat("a", MaxIDLength+1) }, want: []string{"app_id:max_length"}},
+		{name: "app_id characters", modify: func(config *models.AppConfig) { config.AppID = "app 1" }, want: []string{"app_id:pattern"}},
+		{name: "guaranteed_quota missing", modify: func(config *models.AppConfig) {
+			config.GuaranteedQuota = 0
+			config.Clusters = nil
+		}, want: []string{"guaranteed_quota:required"}},
+		{name: "guaranteed_quota negative", modify: func(config *models.AppConfig) { config.GuaranteedQuota = -5 }, want: []string{"guaranteed_quota:min"}},
+		{name: "burst_quota negative", modify: func(config *models.AppConfig) { config.BurstQuota = -1 }, want: []string{"burst_quota:min"}},
+		{name: "burst_quota below guaranteed_quota", modify: func(config *models.AppConfig) { config.BurstQuota = 99 }, want: []string{"burst_quota:gte_field"}},
+		{name: "priority negative", modify: func(config *models.AppConfig) { config.Priority = -1 }, want: []string{"priority:min"}},
+		{name: "priority above P3", modify: func(config *models.AppConfig) { config.Priority = 4 }, want: []string{"priority:max"}},
+		{name: "max_borrow negative", modify: func(config *models.AppConfig) { config.MaxBorrow = -1 }, want: []string{"max_borrow:min"}},
+		{name: "max_connections negative", modify: func(config *models.AppConfig) { config.MaxConnections = -1 }, want: []string{"max_connections:min"}},
+		{name: "cluster_id missing", modify: func(config *models.AppConfig) { config.Clusters[1].ClusterID = "" }, want: []string{"clusters[1].cluster_id:required"}},
+		{name: "cluster_id characters", modify: func(config *models.AppConfig) { config.Clusters[0].ClusterID = "c/1" }, want: []string{"clusters[0].cluster_id:pattern"}},
+		{name: "cluster assigned twice", modify: func(config *models.AppConfig) { config.Clusters[1].ClusterID = "c1" }, want: []string{"clusters[1].cluster_id:unique"}},
+		{name: "cluster quota negative", modify: func(config *models.AppConfig) { config.Clusters[0].GuaranteedQuota = -1 }, want: []string{"clusters[0].guaranteed_quota:min"}},
+		{name: "cluster quota above guaranteed_quota", modify: func(config *models.AppConfig) { config.Clusters[0].GuaranteedQuota = 101 }, want: []string{"clusters[0].guaranteed_quota:lte_field"}},
+		{name: "too many clusters", modify: func(config *models.AppConfig) {
+			config.Clusters = make([]models.ClusterAssignment, MaxAppClusters+1)
+			for i := range config.Clusters {
+				config.Clusters[i].ClusterID = fmt.Sprintf("c%d", i)
+			}
+		}, want: []string{"clusters:max_items"}},
+		{name: "several fields at once", modify: func(config *models.AppConfig) {
+			config.AppID = ""
+			config.BurstQuota = 10
+			config.Priority = 9
+			config.MaxConnections = -1
+			config.Clusters[1].GuaranteedQuota = 500
+		}, want: []string{
+			"app_id:required",
+			"priority:max",
+			"max_connections:min",
+			"burst_quota:gte_field",
+			"clusters[1].guaranteed_quota:lte_field",
+		}},
+		{name: "cross-field check skipped once its array failed", modify: func(config *models.AppConfig) {
+			config.Clusters[0].ClusterID = "bad id"
+			config.Clusters[1].GuaranteedQuota = 500
+		}, want: []string{"clusters[0].cluster_id:pattern"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid()
+			tt.modify(config)
+
+			if got := fieldErrors(t, ValidateAppConfig(config)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("field errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateClusterConfig(t *testing.T) {
+	valid := func() *models.ClusterConfig {
+		return &models.ClusterConfig{
+			ClusterID:          "cluster-1",
+			MaxCapacity:        1000,
+			ReservedRatio:      0.1,
+			EmergencyThreshold: 0.9,
+			MaxConnections:     100,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(config *models.ClusterConfig)
+		want   []string
+	}{
+		{name: "valid", modify: func(config *models.ClusterConfig) {}},
+		{name: "cluster_id missing", modify: func(config *models.ClusterConfig) { config.ClusterID = "" }, want: []string{"cluster_id:required"}},
+		{name: "cluster_id too long", modify: func(config *models.ClusterConfig) { config.ClusterID = strings.Repeat("c", MaxIDLength+1) }, want: []string{"cluster_id:max_length"}},
+		{name: "cluster_id characters", modify: func(config *models.ClusterConfig) { config.ClusterID = "cluster.1" }, want: []string{"cluster_id:pattern"}},
+		{name: "max_capacity missing", modify: func(config *models.ClusterConfig) { config.MaxCapacity = 0 }, want: []string{"max_capacity:required"}},
+		{name: "max_capacity negative", modify: func(config *models.ClusterConfig) { config.MaxCapacity = -1 }, want: []string{"max_capacity:min"}},
+		{name: "reserved_ratio negative", modify: func(config *models.ClusterConfig) { config.ReservedRatio = -0.1 }, want: []string{"reserved_ratio:min"}},
+		{name: "emergency_threshold above 1", modify: func(config *models.ClusterConfig) { config.EmergencyThreshold = 1.5 }, want: []string{"emergency_threshold:max"}},
+		{name: "reserved_ratio not below emergency_threshold", modify: func(config *models.ClusterConfig) { config.ReservedRatio = 0.9 }, want: []string{"reserved_ratio:lt_field"}},
+		{name: "max_connections negative", modify: func(config *models.ClusterConfig) { config.MaxConnections = -1 }, want: []string{"max_connections:min"}},
+		{name: "several fields at once", modify: func(config *models.ClusterConfig) {
+			config.ClusterID = ""
+			config.MaxCapacity = 0
+			config.ReservedRatio = 2
+			config.MaxConnections = -1
+		}, want: []string{"cluster_id:required", "max_capacity:required", "reserved_ratio:max", "max_connections:min"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid()
+			tt.modify(config)
+
+			if got := fieldErrors(t, ValidateClusterConfig(config)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("field errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConnectionLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit models.ConnectionLimit
+		want  []string
+	}{
+		{name: "app", limit: models.ConnectionLimit{TargetType: "app", TargetID: "app-1", Limit: 10}},
+		{name: "cluster", limit: models.ConnectionLimit{TargetType: "cluster", TargetID: "c1", Limit: 10}},
+		{name: "unknown target type", limit: models.ConnectionLimit{TargetType: "user", TargetID: "u1", Limit: 10}, want: []string{"target_type:pattern"}},
+		{name: "target_id characters", limit: models.ConnectionLimit{TargetType: "app", TargetID: "a b", Limit: 10}, want: []string{"target_id:pattern"}},
+		{name: "limit below 1", limit: models.ConnectionLimit{TargetType: "app", TargetID: "app-1", Limit: -3}, want: []string{"limit:min"}},
+		{name: "everything missing", want: []string{"target_type:required", "target_id:required", "limit:required"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldErrors(t, ValidateConnectionLimit(&tt.limit)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("field errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAppImport(t *testing.T) {
+	app := func(id string, quota int64) models.AppConfig {
+		return models.AppConfig{AppID: id, GuaranteedQuota: quota}
+	}
+
+	tests := []struct {
+		name    string
+		configs []models.AppConfig
+		want    []string
+	}{
+		{name: "valid", configs: []models.AppConfig{app("a", 1), app("b", 2)}},
+		{name: "empty", want: []string{"apps:required"}},
+		{name: "too many", configs: make([]models.AppConfig, MaxImportSize+1), want: []string{"apps:max"}},
+		{name: "violations of every config", configs: []models.AppConfig{app("a", 0), app("b", 1), app("", 1)}, want: []string{
+			"apps[0].guaranteed_quota:required",
+			"apps[2].app_id:required",
+		}},
+		{name: "duplicate app IDs", configs: []models.AppConfig{app("a", 1), app("b", 1), app("a", 1)}, want: []string{"apps[2].app_id:unique"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldErrors(t, ValidateAppImport(tt.configs)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("field errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateClusterRefs(t *testing.T) {
+	clusters := []*models.ClusterConfig{{ClusterID: "c1"}, {ClusterID: "c2"}}
+	config := &models.AppConfig{Clusters: []models.ClusterAssignment{{ClusterID: "c1"}, {ClusterID: "gone"}, {ClusterID: "c2"}, {ClusterID: "nope"}}}
+
+	want := []string{"clusters[1].cluster_id:exists", "clusters[3].cluster_id:exists"}
+	if got := violationKeys(ValidateClusterRefs(config, clusters)); !reflect.DeepEqual(got, want) {
+		t.Errorf("field errors = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+// Package validation provides dry-run checks of configs against cluster capacity.
+package validation
+
+import (
+	"admin-backend/errors"
+	"admin-backend/models"
+	"fmt"
+)
+
+// Dry-run rule names, reported alongside the schema rules
+const (
+	RuleClusterCapacity       = "cluster_capacity"
+	RuleMaxBurstRatio         = "max_burst_ratio"
+	RuleCriticalPriority      = "critical_priority"
+	RuleMinReservedRatio      = "min_reserved_ratio"
+	RuleMaxEmergencyThreshold = "max_emergency_threshold"
+)
+
+// Dry-run warning thresholds, matching the gateway's config_validator.dry_run
+const (
+	// WarnBurstRatio warns when burst_quota exceeds this multiple of guaranteed_quota
+	WarnBurstRatio = 10
+	// WarnReservedRatio warns when reserved_ratio is below this share
+	WarnReservedRatio = 0.05
+	// WarnEmergencyThreshold warns when emergency_threshold is above this utilisation
+	WarnEmergencyThreshold = 0.98
+)
+
+// DryRunResult reports whether a config would be accepted, without writing it.
+type DryRunResult struct {
+	Valid      bool                `json:"valid"`
+	ConfigType string              `json:"config_type"`
+	Errors     []errors.FieldError `json:"errors"`
+	Warnings   []errors.FieldError `json:"warnings"`
+	// Capacity describes each cluster the config was checked against
+	Capacity []CapacityReport `json:"capacity"`
+}
+
+// DryRunApp checks an application config as if it were written: the schema
+// rules, that its clusters exist, warnings for risky values and, under policy,
+// whether the guaranteed quotas of existing apps (with config replacing its
+// stored version) still fit in each cluster, or only in the cluster named by
+// only when it is not empty. clusters are stored configs, so their reserved
+// ratio is the one in effect.
+func DryRunApp(config *models.AppConfig, clusters []*models.ClusterConfig, existing []*models.AppConfig, policy CapacityPolicy, only string) *DryRunResult {
+	result := &DryRunResult{
+		ConfigType: models.ResourceApp,
+		Errors:     []errors.FieldError{},
+		Warnings:   []errors.FieldError{},
+		Capacity:   []CapacityReport{},
+	}
+	if violations := AppConfigSchema.Validate(config); len(violations) > 0 {
+		result.Errors = violations
+		return result
+	}
+	if violations := ValidateClusterRefs(config, clusters); len(violations) > 0 {
+		result.Errors = violations
+		return result
+	}
+
+	if config.BurstQuota > config.GuaranteedQuota*WarnBurstRatio {
+		result.Warnings = append(result.Warnings, errors.FieldError{
+			Field:   "burst_quota",
+			Rule:    RuleMaxBurstRatio,
+			Message: fmt.Sprintf("burst_quota is more than %dx guaranteed_quota", WarnBurstRatio),
+		})
+	}
+	if config.Priority == 0 {
+		result.Warnings = append(result.Warnings, errors.FieldError{
+			Field:   "priority",
+			Rule:    RuleCriticalPriority,
+			Message: "P0 priority should be reserved for critical apps",
+		})
+	}
+
+	if len(clusters) == 0 {
+		result.Warnings = append(result.Warnings, errors.FieldError{
+			Field:   "guaranteed_quota",
+			Rule:    RuleClusterCapacity,
+			Message: "no clusters are configured, cluster capacity was not checked",
+		})
+	}
+	for _, report := range policy.AppCapacity(clusters, existing, config) {
+		if only == "" || report.ClusterID == only {
+			result.Capacity = append(result.Capacity, report)
+		}
+	}
+	result.addCapacityViolations(policy, "guaranteed_quota")
+
+	result.Valid = len(result.Errors) == 0
+	return result
+}
+
+// DryRunCluster checks a cluster config as if it were written: the schema
+// rules, warnings for risky values and, under policy, whether the guaranteed
+// quotas of apps still fit in the new capacity. reservedRatio is the ratio
+// that would be in effect, since an unset ratio means the default, and current
+// is the stored cluster, or nil when config creates it.
+func DryRunCluster(config *models.ClusterConfig, reservedRatio float64, current *models.ClusterConfig, apps []*models.AppConfig, policy CapacityPolicy) *DryRunResult {
+	result := &DryRunResult{
+		ConfigType: models.ResourceCluster,
+		Errors:     []errors.FieldError{},
+		Warnings:   []errors.FieldError{},
+		Capacity:   []CapacityReport{},
+	}
+	if violations := ClusterConfigSchema.Validate(config); len(violations) > 0 {
+		result.Errors = violations
+		return result
+	}
+
+	if reservedRatio < WarnReservedRatio {
+		result.Warnings = append(result.Warnings, errors.FieldError{
+			Field:   "reserved_ratio",
+			Rule:    RuleMinReservedRatio,
+			Message: fmt.Sprintf("reserved_ratio below %.0f%% may cause issues", WarnReservedRatio*100),
+		})
+	}
+	if config.EmergencyThreshold > WarnEmergencyThreshold {
+		result.Warnings = append(result.Warnings, errors.FieldError{
+			Field:   "emergency_threshold",
+			Rule:    RuleMaxEmergencyThreshold,
+			Message: fmt.Sprintf("emergency_threshold above %.0f%% may trigger too late", WarnEmergencyThreshold*100),
+		})
+	}
+
+	result.Capacity = append(result.Capacity, policy.ClusterCapacity(config, reservedRatio, current, apps))
+	result.addCapacityViolations(policy, "max_capacity")
+
+	result.Valid = len(result.Errors) == 0
+	return result
+}
+
+// addCapacityViolations applies policy to the capacity reports, as a write would.
+func (r *DryRunResult) addCapacityViolations(policy CapacityPolicy, field string) {
+	violations, warnings := policy.Check(field, r.Capacity)
+	r.Errors = append(r.Errors, violations...)
+	r.Warnings = append(r.Warnings, warnings...)
+}
@@ -0,0 +1,465 @@
+// Package validation provides declarative, field-level validation of models.
+package validation
+
+import (
+	"admin-backend/errors"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Validation rule names reported in errors.FieldError.Rule
+const (
+	RuleRequired  = "required"
+	RuleType      = "type"
+	RuleMin       = "min"
+	RuleMax       = "max"
+	RuleMaxLength = "max_length"
+	RulePattern   = "pattern"
+	RuleUnique    = "unique"
+	RuleGteField  = "gte_field"
+	RuleLteField  = "lte_field"
+	RuleLtField   = "lt_field"
+	RuleMaxItems  = "max_items"
+	RuleExists    = "exists"
+)
+
+// Field declares the rules of one JSON field of a model.
+type Field struct {
+	// Name is the JSON name of the field
+	Name string
+	// Type is the JSON Schema type: string, integer, number or array
+	Type        string
+	Description string
+	// Required fields must not be empty or zero
+	Required  bool
+	Min       *float64
+	Max       *float64
+	MaxLength int
+	Pattern   string
+	// Format is the JSON Schema format, e.g. date-time
+	Format string
+	// ReadOnly fields are assigned by the server and not validated
+	ReadOnly bool
+	// Items declares the objects of an array field
+	Items *Schema
+	// MaxItems limits the length of an array field
+	MaxItems int
+	// UniqueBy names the item field that must be unique within an array field
+	UniqueBy string
+}
+
+// Check declares a rule comparing two fields of a model.
+type Check struct {
+	// Field is the field reported when the check fails. "items[].field"
+	// checks field of every object in the array field items.
+	Field string
+	// Rule is RuleGteField, RuleLteField or RuleLtField
+	Rule string
+	// Other is the field Field is compared with
+	Other string
+	// SkipZero skips the check while Field is zero (unset)
+	SkipZero bool
+}
+
+// Schema declares the validation rules of a model. The same declaration
+// validates requests and is published as JSON Schema, so the frontend checks
+// forms with the rules the API enforces.
+type Schema struct {
+	// Name identifies the schema in the schema endpoint
+	Name        string
+	Title       string
+	Description string
+	Fields      []Field
+	Checks      []Check
+
+	patterns map[string]*regexp.Regexp
+}
+
+// compile prepares the schema's patterns, including those of array items.
+// Invalid patterns are programming errors.
+func (s *Schema) compile() *Schema {
+	s.patterns = make(map[string]*regexp.Regexp)
+	for _, f := range s.Fields {
+		if f.Pattern != "" {
+			s.patterns[f.Name] = regexp.MustCompile(f.Pattern)
+		}
+		if f.Items != nil {
+			f.Items.compile()
+		}
+	}
+	return s
+}
+
+// Validate checks v, a model or pointer to one, against every rule of the
+// schema and returns all violations, or nil if v is valid. A field that fails
+// a rule is not checked further.
+func (s *Schema) Validate(v interface{}) []errors.FieldError {
+	values, err := toValues(v)
+	if err != nil {
+		return []errors.FieldError{{Message: "value cannot be validated"}}
+	}
+	return s.validateValues(values)
+}
+
+// validateValues checks the JSON field values of an object.
+func (s *Schema) validateValues(values map[string]interface{}) []errors.FieldError {
+	var violations []errors.FieldError
+	failed := make(map[string]bool)
+	for _, f := range s.Fields {
+		if f.ReadOnly {
+			continue
+		}
+		if f.Type == "array" {
+			if fes := f.checkArray(values[f.Name]); len(fes) > 0 {
+				violations = append(violations, fes...)
+				failed[f.Name] = true
+			}
+			continue
+		}
+		if fe := s.checkField(f, values[f.Name]); fe != nil {
+			violations = append(violations, *fe)
+			failed[f.Name] = true
+		}
+	}
+
+	for _, check := range s.Checks {
+		array, _, _ := strings.Cut(check.Field, "[].")
+		if failed[array] || failed[check.Other] {
+			continue
+		}
+		violations = append(violations, runCheck(check, values)...)
+	}
+
+	return violations
+}
+
+// checkArray applies an array field's rules to its value and validates each
+// item against Items, qualifying item violations as name[i].field.
+func (f Field) checkArray(value interface{}) []errors.FieldError {
+	items, _ := value.([]interface{})
+	if len(items) == 0 {
+		if f.Required {
+			return []errors.FieldError{{Field: f.Name, Rule: RuleRequired, Message: f.Name + " is required"}}
+		}
+		return nil
+	}
+	if f.MaxItems > 0 && len(items) > f.MaxItems {
+		return []errors.FieldError{{
+			Field:   f.Name,
+			Rule:    RuleMaxItems,
+			Message: fmt.Sprintf("%s must not contain more than %d items", f.Name, f.MaxItems),
+		}}
+	}
+
+	var violations []errors.FieldError
+	seen := make(map[interface{}]int, len(items))
+	for i, item := range items {
+		prefix := fmt.Sprintf("%s[%d]", f.Name, i)
+		values, ok := item.(map[string]interface{})
+		if !ok {
+			violations = append(violations, errors.FieldError{Field: prefix, Rule: RuleType, Message: prefix + " must be of type object"})
+			continue
+		}
+		if f.Items != nil {
+			violations = append(violations, PrefixFields(prefix, f.Items.validateValues(values))...)
+		}
+
+		if f.UniqueBy == "" || values[f.UniqueBy] == nil {
+			continue
+		}
+		key := values[f.UniqueBy]
+		if first, ok := seen[key]; ok {
+			violations = append(violations, errors.FieldError{
+				Field:   prefix + "." + f.UniqueBy,
+				Rule:    RuleUnique,
+				Message: fmt.Sprintf("%s.%s duplicates %s[%d].%s", prefix, f.UniqueBy, f.Name, first, f.UniqueBy),
+			})
+			continue
+		}
+		seen[key] = i
+	}
+	return violations
+}
+
+// checkField applies a field's rules to its value.
+func (s *Schema) checkField(f Field, value interface{}) *errors.FieldError {
+	violation := func(rule, format string, args ...interface{}) *errors.FieldError {
+		return &errors.FieldError{Field: f.Name, Rule: rule, Message: f.Name + " " + fmt.Sprintf(format, args...)}
+	}
+
+	switch f.Type {
+	case "string":
+		str, _ := value.(string)
+		str = strings.TrimSpace(str)
+		if str == "" {
+			if f.Required {
+				return violation(RuleRequired, "is required")
+			}
+			return nil
+		}
+		if f.MaxLength > 0 && len(str) > f.MaxLength {
+			return violation(RuleMaxLength, "must not exceed %d characters", f.MaxLength)
+		}
+		if re := s.patterns[f.Name]; re != nil && !re.MatchString(str) {
+			return violation(RulePattern, "must match %s", f.Pattern)
+		}
+
+	case "integer", "number":
+		num, _ := value.(float64)
+		if num == 0 && f.Required {
+			return violation(RuleRequired, "is required")
+		}
+		if f.Min != nil && num < *f.Min {
+			return violation(RuleMin, "must be at least %s", formatNumber(*f.Min))
+		}
+		if f.Max != nil && num > *f.Max {
+			return violation(RuleMax, "must be at most %s", formatNumber(*f.Max))
+		}
+	}
+
+	return nil
+}
+
+// runCheck applies a cross-field check, to every item for an "items[].field" check.
+func runCheck(check Check, values map[string]interface{}) []errors.FieldError {
+	b, _ := values[check.Other].(float64)
+
+	array, field, ok := strings.Cut(check.Field, "[].")
+	if !ok {
+		a, _ := values[check.Field].(float64)
+		if fe := compareFields(check, check.Field, a, b); fe != nil {
+			return []errors.FieldError{*fe}
+		}
+		return nil
+	}
+
+	var violations []errors.FieldError
+	items, _ := values[array].([]interface{})
+	for i, item := range items {
+		itemValues, _ := item.(map[string]interface{})
+		a, _ := itemValues[field].(float64)
+		if fe := compareFields(check, fmt.Sprintf("%s[%d].%s", array, i, field), a, b); fe != nil {
+			violations = append(violations, *fe)
+		}
+	}
+	return violations
+}
+
+// compareFields compares a, the value of the field named name, with b, the
+// value of check.Other.
+func compareFields(check Check, name string, a, b float64) *errors.FieldError {
+	if check.SkipZero && a == 0 {
+		return nil
+	}
+
+	var relation string
+	switch check.Rule {
+	case RuleGteField:
+		if a >= b {
+			return nil
+		}
+		relation = "greater than or equal to"
+	case RuleLteField:
+		if a <= b {
+			return nil
+		}
+		relation = "less than or equal to"
+	case RuleLtField:
+		if a < b {
+			return nil
+		}
+		relation = "less than"
+	default:
+		return nil
+	}
+
+	return &errors.FieldError{
+		Field:   name,
+		Rule:    check.Rule,
+		Message: fmt.Sprintf("%s must be %s %s", name, relation, check.Other),
+	}
+}
+
+// JSONSchema returns the schema as a JSON Schema (draft 2020-12) document.
+// Cross-field checks, which JSON Schema cannot express, are listed under x-checks.
+func (s *Schema) JSONSchema() map[string]interface{} {
+	checks := make([]map[string]interface{}, 0, len(s.Checks))
+	for _, check := range s.Checks {
+		checks = append(checks, map[string]interface{}{
+			"field":     check.Field,
+			"rule":      check.Rule,
+			"other":     check.Other,
+			"skip_zero": check.SkipZero,
+		})
+	}
+
+	schema := s.objectSchema()
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = "/api/v1/schemas/" + s.Name
+	schema["title"] = s.Title
+	schema["x-checks"] = checks
+	return schema
+}
+
+// objectSchema returns the JSON Schema of the object the fields describe.
+func (s *Schema) objectSchema() map[string]interface{} {
+	properties := make(map[string]interface{}, len(s.Fields))
+	required := []string{}
+	for _, f := range s.Fields {
+		prop := map[string]interface{}{"type": f.Type}
+		if f.Format != "" {
+			prop["format"] = f.Format
+		}
+		if f.Description != "" {
+			prop["description"] = f.Description
+		}
+		if f.ReadOnly {
+			prop["readOnly"] = true
+		}
+		if f.Min != nil {
+			prop["minimum"] = *f.Min
+		}
+		if f.Max != nil {
+			prop["maximum"] = *f.Max
+		}
+		if f.MaxLength > 0 {
+			prop["maxLength"] = f.MaxLength
+		}
+		if f.Pattern != "" {
+			prop["pattern"] = f.Pattern
+		}
+		if f.Items != nil {
+			prop["items"] = f.Items.objectSchema()
+		}
+		if f.MaxItems > 0 {
+			prop["maxItems"] = f.MaxItems
+		}
+		if f.UniqueBy != "" {
+			prop["x-unique-by"] = f.UniqueBy
+		}
+		if f.Required {
+			required = append(required, f.Name)
+			switch f.Type {
+			case "string":
+				prop["minLength"] = 1
+			case "array":
+				prop["minItems"] = 1
+			}
+		}
+		properties[f.Name] = prop
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+	if s.Description != "" {
+		schema["description"] = s.Description
+	}
+	return schema
+}
+
+// ValidationFailed returns the 400 error reporting violations.
+// Its message lists every violation for clients that only show the message.
+func ValidationFailed(violations []errors.FieldError) *errors.AppError {
+	messages := make([]string, len(violations))
+	for i, v := range violations {
+		messages[i] = v.Message
+	}
+	return errors.BadRequest(strings.Join(messages, "; "), nil).
+		WithErrorCode(errors.CodeValidationFailed).
+		WithFields(violations...)
+}
+
+// PrefixFields qualifies violations with the path of the object they belong
+// to, e.g. "apps[3]" turns "burst_quota" into "apps[3].burst_quota".
+func PrefixFields(prefix string, violations []errors.FieldError) []errors.FieldError {
+	for i := range violations {
+		violations[i].Message = prefix + "." + violations[i].Message
+		if violations[i].Field == "" {
+			violations[i].Field = prefix
+			continue
+		}
+		violations[i].Field = prefix + "." + violations[i].Field
+	}
+	return violations
+}
+
+// BindError converts a request body decoding error into a validation error,
+// naming the field when a value has the wrong JSON type.
+func BindError(err error) *errors.AppError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		field := fieldPath(typeErr.Field)
+		return ValidationFailed([]errors.FieldError{{
+			Field:   field,
+			Rule:    RuleType,
+			Message: fmt.Sprintf("%s must be of type %s", field, jsonType(typeErr.Type)),
+		}})
+	}
+	return errors.BadRequest("invalid request format", err)
+}
+
+// fieldPath turns a decoder field path such as "apps.1.clusters.0" into the
+// notation of validation errors, "apps[1].clusters[0]".
+func fieldPath(decoderPath string) string {
+	var path strings.Builder
+	for i, part := range strings.Split(decoderPath, ".") {
+		if _, err := strconv.Atoi(part); err == nil && i > 0 {
+			path.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			path.WriteString(".")
+		}
+		path.WriteString(part)
+	}
+	return path.String()
+}
+
+// jsonType names the JSON type a Go type is decoded from.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return t.String()
+	}
+}
+
+// toValues returns the JSON fields of v.
+func toValues(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// formatNumber formats a rule bound without a trailing ".0".
+func formatNumber(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
+
+// bound returns a pointer to a rule bound.
+func bound(f float64) *float64 {
+	return &f
+}
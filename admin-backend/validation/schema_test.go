@@ -0,0 +1,244 @@
+package validation
+
+import (
+	"admin-backend/errors"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testSchema exercises every rule of the schema language.
+var testSchema = (&Schema{
+	Name: "test",
+	Fields: []Field{
+		{Name: "name", Type: "string", Required: true, MaxLength: 8, Pattern: `^[a-z]+$`},
+		{Name: "note", Type: "string"},
+		{Name: "low", Type: "integer", Required: true, Min: bound(1), Max: bound(100)},
+		{Name: "high", Type: "integer", Min: bound(0)},
+		{Name: "ratio", Type: "number", Min: bound(0), Max: bound(1)},
+		{Name: "limit", Type: "number", Max: bound(1)},
+		{Name: "items", Type: "array", Required: true, MaxItems: 3, UniqueBy: "key", Items: (&Schema{
+			Fields: []Field{
+				{Name: "key", Type: "string", Required: true, Pattern: `^k[0-9]$`},
+				{Name: "size", Type: "integer", Min: bound(0)},
+			},
+		}).compile()},
+		{Name: "version", Type: "integer", Required: true, ReadOnly: true},
+	},
+	Checks: []Check{
+		{Field: "high", Rule: RuleGteField, Other: "low", SkipZero: true},
+		{Field: "ratio", Rule: RuleLtField, Other: "limit"},
+		{Field: "items[].size", Rule: RuleLteField, Other: "low", SkipZero: true},
+	},
+}).compile()
+
+// validTestValues returns values that pass testSchema.
+func validTestValues() map[string]interface{} {
+	return map[string]interface{}{
+		"name":  "alpha",
+		"low":   10.0,
+		"high":  20.0,
+		"ratio": 0.2,
+		"limit": 0.5,
+		"items": []interface{}{
+			map[string]interface{}{"key": "k1", "size": 5.0},
+			map[string]interface{}{"key": "k2"},
+		},
+	}
+}
+
+// violationKeys returns violations as "field:rule", the way tests compare them.
+func violationKeys(violations []errors.FieldError) []string {
+	keys := make([]string, 0, len(violations))
+	for _, v := range violations {
+		keys = append(keys, v.Field+":"+v.Rule)
+	}
+	return keys
+}
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(values map[string]interface{})
+		want   []string
+	}{
+		{name: "valid", modify: func(values map[string]interface{}) {}},
+		{name: "optional fields unset", modify: func(values map[string]interface{}) {
+			delete(values, "high")
+			delete(values, "note")
+		}},
+		{name: "required string missing", modify: func(values map[string]interface{}) { delete(values, "name") }, want: []string{"name:required"}},
+		{name: "required string blank", modify: func(values map[string]interface{}) { values["name"] = "   " }, want: []string{"name:required"}},
+		{name: "string too long", modify: func(values map[string]interface{}) { values["name"] = "abcdefghi" }, want: []string{"name:max_length"}},
+		{name: "string not matching pattern", modify: func(values map[string]interface{}) { values["name"] = "Alpha" }, want: []string{"name:pattern"}},
+		{name: "required number zero", modify: func(values map[string]interface{}) { values["low"] = 0.0 }, want: []string{"low:required"}},
+		{name: "below minimum", modify: func(values map[string]interface{}) { values["high"] = -1.0 }, want: []string{"high:min"}},
+		{name: "above maximum", modify: func(values map[string]interface{}) { values["low"] = 101.0 }, want: []string{"low:max"}},
+		{name: "gte_field", modify: func(values map[string]interface{}) { values["high"] = 5.0 }, want: []string{"high:gte_field"}},
+		{name: "gte_field equal", modify: func(values map[string]interface{}) { values["high"] = 10.0 }},
+		{name: "lt_field equal", modify: func(values map[string]interface{}) { values["ratio"] = 0.5 }, want: []string{"ratio:lt_field"}},
+		{name: "check skipped when its field failed", modify: func(values map[string]interface{}) { values["high"] = -1.0 }, want: []string{"high:min"}},
+		{name: "check skipped when the other field failed", modify: func(values map[string]interface{}) { values["low"] = 0.0 }, want: []string{"low:required"}},
+		{name: "required array empty", modify: func(values map[string]interface{}) { values["items"] = []interface{}{} }, want: []string{"items:required"}},
+		{name: "too many items", modify: func(values map[string]interface{}) {
+			values["items"] = []interface{}{
+				map[string]interface{}{"key": "k1"},
+				map[string]interface{}{"key": "k2"},
+				map[string]interface{}{"key": "k3"},
+				map[string]interface{}{"key": "k4"},
+			}
+		}, want: []string{"items:max_items"}},
+		{name: "item not an object", modify: func(values map[string]interface{}) {
+			values["items"] = []interface{}{map[string]interface{}{"key": "k1"}, "k2"}
+		}, want: []string{"items[1]:type"}},
+		{name: "item fields", modify: func(values map[string]interface{}) {
+			values["items"] = []interface{}{
+				map[string]interface{}{"key": "x1", "size": -1.0},
+				map[string]interface{}{},
+			}
+		}, want: []string{"items[0].key:pattern", "items[0].size:min", "items[1].key:required"}},
+		{name: "duplicate item key", modify: func(values map[string]interface{}) {
+			values["items"] = []interface{}{
+				map[string]interface{}{"key": "k1"},
+				map[string]interface{}{"key": "k2"},
+				map[string]interface{}{"key": "k1"},
+			}
+		}, want: []string{"items[2].key:unique"}},
+		{name: "item check", modify: func(values map[string]interface{}) {
+			values["items"] = []interface{}{
+				map[string]interface{}{"key": "k1", "size": 11.0},
+				map[string]interface{}{"key": "k2", "size": 10.0},
+			}
+		}, want: []string{"items[0].size:lte_field"}},
+		{name: "read-only field ignored", modify: func(values map[string]interface{}) { delete(values, "version") }},
+		{name: "every violation reported", modify: func(values map[string]interface{}) {
+			values["name"] = ""
+			values["low"] = 500.0
+			values["ratio"] = 0.9
+			values["items"] = []interface{}{map[string]interface{}{"key": "k1", "size": -2.0}}
+		}, want: []string{"name:required", "low:max", "items[0].size:min", "ratio:lt_field"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := validTestValues()
+			tt.modify(values)
+
+			got := violationKeys(testSchema.Validate(values))
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaValidateMessages(t *testing.T) {
+	values := validTestValues()
+	values["low"] = 500.0
+	values["items"] = []interface{}{map[string]interface{}{"key": "k1"}, map[string]interface{}{"key": "k1"}}
+
+	want := []errors.FieldError{
+		{Field: "low", Rule: RuleMax, Message: "low must be at most 100"},
+		{Field: "items[1].key", Rule: RuleUnique, Message: "items[1].key duplicates items[0].key"},
+	}
+	if got := testSchema.Validate(values); !reflect.DeepEqual(got, want) {
+		t.Errorf("violations = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidationFailed(t *testing.T) {
+	err := ValidationFailed([]errors.FieldError{
+		{Field: "a", Rule: RuleRequired, Message: "a is required"},
+		{Field: "b", Rule: RuleMin, Message: "b must be at least 1"},
+	})
+
+	if err.Code != 400 || err.ErrorCode != errors.CodeValidationFailed {
+		t.Errorf("error = %d %s, want 400 %s", err.Code, err.ErrorCode, errors.CodeValidationFailed)
+	}
+	if err.Message != "a is required; b must be at least 1" {
+		t.Errorf("message = %q", err.Message)
+	}
+	if got := violationKeys(err.Fields); !reflect.DeepEqual(got, []string{"a:required", "b:min"}) {
+		t.Errorf("fields = %v", got)
+	}
+}
+
+func TestBindError(t *testing.T) {
+	type assignment struct {
+		Quota int64 `json:"quota"`
+	}
+	type app struct {
+		Name     string       `json:"name"`
+		Clusters []assignment `json:"clusters"`
+	}
+	type request struct {
+		Apps []app `json:"apps"`
+	}
+
+	tests := []struct {
+		name      string
+		body      string
+		wantField string
+		wantMsg   string
+	}{
+		{name: "top-level field", body: `{"apps":"x"}`, wantField: "apps", wantMsg: "apps must be of type array"},
+		{name: "array item field", body: `{"apps":[{"name":"a"},{"name":3}]}`, wantField: "apps[1].name", wantMsg: "apps[1].name must be of type string"},
+		{name: "nested array item field", body: `{"apps":[{"clusters":[{"quota":1},{"quota":"many"}]}]}`, wantField: "apps[0].clusters[1].quota", wantMsg: "apps[0].clusters[1].quota must be of type integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req request
+			err := BindError(json.Unmarshal([]byte(tt.body), &req))
+			if err.ErrorCode != errors.CodeValidationFailed || len(err.Fields) != 1 {
+				t.Fatalf("error = %+v", err)
+			}
+			if fe := err.Fields[0]; fe.Field != tt.wantField || fe.Rule != RuleType || fe.Message != tt.wantMsg {
+				t.Errorf("field error = %+v, want %s %q", fe, tt.wantField, tt.wantMsg)
+			}
+		})
+	}
+
+	// Malformed JSON has no field to name
+	var req request
+	if err := BindError(json.Unmarshal([]byte(`{"apps":`), &req)); err.ErrorCode == errors.CodeValidationFailed {
+		t.Errorf("syntax error reported as %+v", err)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := testSchema.JSONSchema()
+
+	if got := schema["required"]; !reflect.DeepEqual(got, []string{"name", "low", "items", "version"}) {
+		t.Errorf("required = %v", got)
+	}
+	properties := schema["properties"].(map[string]interface{})
+
+	name := properties["name"].(map[string]interface{})
+	if name["maxLength"] != 8 || name["pattern"] != `^[a-z]+$` || name["minLength"] != 1 {
+		t.Errorf("name = %v", name)
+	}
+	low := properties["low"].(map[string]interface{})
+	if low["minimum"] != 1.0 || low["maximum"] != 100.0 {
+		t.Errorf("low = %v", low)
+	}
+	items := properties["items"].(map[string]interface{})
+	if items["maxItems"] != 3 || items["x-unique-by"] != "key" || items["minItems"] != 1 {
+		t.Errorf("items = %v", items)
+	}
+	if version := properties["version"].(map[string]interface{}); version["readOnly"] != true {
+		t.Errorf("version = %v", version)
+	}
+
+	checks := schema["x-checks"].([]map[string]interface{})
+	if len(checks) != len(testSchema.Checks) || checks[0]["rule"] != RuleGteField || checks[0]["skip_zero"] != true {
+		t.Errorf("x-checks = %v", checks)
+	}
+	if !strings.HasSuffix(schema["$id"].(string), "/test") {
+		t.Errorf("$id = %v", schema["$id"])
+	}
+}
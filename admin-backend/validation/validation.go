@@ -3,10 +3,12 @@ package validation
 
 import (
 	"admin-backend/errors"
+	"admin-backend/models"
 	"fmt"
 	"net/mail"
 	"regexp"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -15,19 +17,28 @@ const (
 	MaxUsernameLength = 50
 	// MinUsernameLength is the minimum allowed username length
 	MinUsernameLength = 3
-	// MaxPasswordLength is the maximum allowed password length
-	MaxPasswordLength = 100
+	// MaxPasswordLength is the maximum allowed password length (bcrypt hashes at most 72 bytes)
+	MaxPasswordLength = 72
 	// MinPasswordLength is the minimum allowed password length
 	MinPasswordLength = 8
 	// MaxReasonLength is the maximum length for emergency reason
 	MaxReasonLength = 500
+	// MaxEmergencyDuration is the longest (in seconds) an emergency may run from activation or extension
+	MaxEmergencyDuration = 24 * 3600
+	// MaxAPIKeyNameLength is the maximum length of an API key name
+	MaxAPIKeyNameLength = 100
+	// MaxAPIKeyLifetime is the longest expiry (in seconds) an API key may be given
+	MaxAPIKeyLifetime = 365 * 24 * 3600
 )
 
+// idPattern matches application and cluster IDs (alphanumeric, hyphens, underscores)
+const idPattern = `^[a-zA-Z0-9_-]+$`
+
 var (
-	// appIDRegex validates application IDs (alphanumeric, hyphens, underscores)
-	appIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
-	// clusterIDRegex validates cluster IDs (alphanumeric, hyphens, underscores)
-	clusterIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	// appIDRegex validates application IDs
+	appIDRegex = regexp.MustCompile(idPattern)
+	// clusterIDRegex validates cluster IDs
+	clusterIDRegex = regexp.MustCompile(idPattern)
 )
 
 // ValidateUsername validates a username.
@@ -107,6 +118,55 @@ func ValidatePassword(password string) error {
 	return nil
 }
 
+// ValidateRole validates a user role.
+func ValidateRole(role string) error {
+	switch role {
+	case models.RoleAdmin, models.RoleOperator, models.RoleViewer:
+		return nil
+	case "":
+		return errors.BadRequest("role is required", nil)
+	default:
+		return errors.BadRequest(
+			fmt.Sprintf("invalid role %q (must be %s, %s or %s)", role, models.RoleAdmin, models.RoleOperator, models.RoleViewer),
+			nil,
+		)
+	}
+}
+
+// ValidateAPIKeyName validates the display name of an API key.
+func ValidateAPIKeyName(name string) error {
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return errors.BadRequest("name is required", nil)
+	}
+
+	if len(name) > MaxAPIKeyNameLength {
+		return errors.BadRequest(
+			fmt.Sprintf("name must not exceed %d characters", MaxAPIKeyNameLength),
+			nil,
+		)
+	}
+
+	return nil
+}
+
+// ValidateAPIKeyExpiry validates an API key lifetime in seconds; 0 means the key never expires.
+func ValidateAPIKeyExpiry(expiresIn int64) error {
+	if expiresIn < 0 {
+		return errors.BadRequest("expires_in must not be negative", nil)
+	}
+
+	if expiresIn > MaxAPIKeyLifetime {
+		return errors.BadRequest(
+			fmt.Sprintf("expires_in must not exceed %d seconds (365 days)", MaxAPIKeyLifetime),
+			nil,
+		)
+	}
+
+	return nil
+}
+
 // ValidateEmail validates an email address.
 func ValidateEmail(email string) error {
 	if email == "" {
@@ -134,7 +194,7 @@ func ValidateAppID(appID string) error {
 		return errors.BadRequest("app ID cannot be empty", nil)
 	}
 
-	if len(appID) > 100 {
+	if len(appID) > MaxIDLength {
 		return errors.BadRequest("app ID must not exceed 100 characters", nil)
 	}
 
@@ -157,7 +217,7 @@ func ValidateClusterID(clusterID string) error {
 		return errors.BadRequest("cluster ID cannot be empty", nil)
 	}
 
-	if len(clusterID) > 100 {
+	if len(clusterID) > MaxIDLength {
 		return errors.BadRequest("cluster ID must not exceed 100 characters", nil)
 	}
 
@@ -168,69 +228,100 @@ func ValidateClusterID(clusterID string) error {
 	return nil
 }
 
-// ValidateAppConfig validates application configuration.
-func ValidateAppConfig(guaranteedQuota int64, burstQuota int64, priority int) error {
-	if guaranteedQuota <= 0 {
-		return errors.BadRequest("guaranteed quota must be positive", nil)
+// ValidateEmergencyRequest validates emergency activation request.
+func ValidateEmergencyRequest(reason string, duration int64) error {
+	reason = strings.TrimSpace(reason)
+
+	if reason == "" {
+		return errors.BadRequest("reason is required", nil)
 	}
 
-	if burstQuota < 0 {
-		return errors.BadRequest("burst quota cannot be negative", nil)
+	if len(reason) > MaxReasonLength {
+		return errors.BadRequest(
+			fmt.Sprintf("reason must not exceed %d characters", MaxReasonLength),
+			nil,
+		)
 	}
 
-	if burstQuota > 0 && burstQuota < guaranteedQuota {
-		return errors.BadRequest("burst quota must be greater than or equal to guaranteed quota", nil)
+	return ValidateEmergencyDuration(duration)
+}
+
+// ValidateEmergencyDuration validates the duration of an emergency in seconds.
+func ValidateEmergencyDuration(duration int64) error {
+	if duration <= 0 {
+		return errors.BadRequest("duration must be positive", nil)
 	}
 
-	if priority < 0 || priority > 3 {
-		return errors.BadRequest("priority must be between 0 and 3", nil)
+	if duration > MaxEmergencyDuration {
+		return errors.BadRequest(
+			fmt.Sprintf("duration must not exceed 24 hours (%d seconds)", MaxEmergencyDuration),
+			nil,
+		)
 	}
 
 	return nil
 }
 
-// ValidateClusterConfig validates cluster configuration.
-func ValidateClusterConfig(maxCapacity int64, reservedRatio float64, emergencyThreshold float64) error {
-	if maxCapacity <= 0 {
-		return errors.BadRequest("max capacity must be positive", nil)
+// ValidateEmergencySchedule validates a scheduled emergency window, which
+// must start after now and obeys the limits of an immediate activation.
+func ValidateEmergencySchedule(reason string, startAt time.Time, duration int64, now time.Time) error {
+	if startAt.IsZero() {
+		return errors.BadRequest("start_at is required", nil)
 	}
 
-	if reservedRatio < 0 || reservedRatio > 1 {
-		return errors.BadRequest("reserved ratio must be between 0 and 1", nil)
+	if !startAt.After(now) {
+		return errors.BadRequest("start_at must be in the future", nil)
 	}
 
-	if emergencyThreshold < 0 || emergencyThreshold > 1 {
-		return errors.BadRequest("emergency threshold must be between 0 and 1", nil)
+	return ValidateEmergencyRequest(reason, duration)
+}
+
+// ValidateScheduleConflicts checks a window from startAt to endAt against the
+// pending windows and the current emergency. Windows must not overlap, since
+// overlapping windows would run as one emergency; a window starting while the
+// current emergency is active extends it, so it must end within
+// MaxEmergencyDuration of that emergency's activation.
+func ValidateScheduleConflicts(startAt, endAt time.Time, pending []*models.EmergencySchedule, current *models.EmergencyStatus) error {
+	for _, schedule := range pending {
+		if startAt.Before(schedule.EndAt) && schedule.StartAt.Before(endAt) {
+			return errors.Conflict(
+				fmt.Sprintf("window overlaps scheduled window %s from %s to %s",
+					schedule.ID, schedule.StartAt.UTC().Format(time.RFC3339), schedule.EndAt.UTC().Format(time.RFC3339)),
+				nil,
+			).WithContext("schedule_id", schedule.ID)
+		}
 	}
 
-	if reservedRatio >= emergencyThreshold {
-		return errors.BadRequest("reserved ratio must be less than emergency threshold", nil)
+	if current != nil && current.Active && startAt.Before(current.ExpiresAt) {
+		maxEndAt := current.ActivatedAt.Add(MaxEmergencyDuration * time.Second)
+		if endAt.After(maxEndAt) {
+			return errors.Conflict(
+				fmt.Sprintf("window would extend the active emergency past 24 hours from its activation (%s)",
+					maxEndAt.UTC().Format(time.RFC3339)),
+				nil,
+			).WithContext("max_end_at", maxEndAt)
+		}
 	}
 
 	return nil
 }
 
-// ValidateEmergencyRequest validates emergency activation request.
-func ValidateEmergencyRequest(reason string, duration int64) error {
-	reason = strings.TrimSpace(reason)
-
-	if reason == "" {
-		return errors.BadRequest("reason is required", nil)
-	}
-
-	if len(reason) > MaxReasonLength {
+// ValidatePageLimit validates the page size of a list request.
+func ValidatePageLimit(limit int, max int) error {
+	if limit < 1 || limit > max {
 		return errors.BadRequest(
-			fmt.Sprintf("reason must not exceed %d characters", MaxReasonLength),
+			fmt.Sprintf("limit must be between 1 and %d", max),
 			nil,
 		)
 	}
 
-	if duration <= 0 {
-		return errors.BadRequest("duration must be positive", nil)
-	}
+	return nil
+}
 
-	if duration > 86400 {
-		return errors.BadRequest("duration must not exceed 24 hours (86400 seconds)", nil)
+// ValidateCursor validates an opaque pagination cursor.
+func ValidateCursor(cursor string) error {
+	if len(cursor) > 200 {
+		return errors.BadRequest("cursor must not exceed 200 characters", nil)
 	}
 
 	return nil
@@ -1,5 +1,5 @@
 import client from './client'
-import type { AppConfig, ClusterConfig, Metrics, EmergencyStatus, TokenResponse } from '../types'
+import type { AppConfig, ClusterApp, ClusterConfig, ClusterStatus, ConnectionLimit, ConnectionNode, ConnectionStats, Metrics, EmergencyHistory, EmergencySchedule, EmergencyStatus, TokenResponse } from '../types'
 
 // 认证
 export const authApi = {
@@ -15,19 +15,30 @@ export const appsApi = {
   get: (id: string) => client.get<AppConfig>(`/apps/${id}`),
   create: (config: Partial<AppConfig>) => client.post('/apps', config),
   update: (id: string, config: Partial<AppConfig>) => client.put(`/apps/${id}`, config),
-  delete: (id: string) => client.delete(`/apps/${id}`)
+  delete: (id: string) => client.delete(`/apps/${id}`),
+  import: (apps: Partial<AppConfig>[]) => client.post('/apps/import', { apps }),
+  validate: (config: Partial<AppConfig>, clusterId?: string) =>
+    client.post('/apps/validate', config, { params: clusterId ? { cluster_id: clusterId } : undefined })
 }
 
 // 集群管理
 export const clustersApi = {
   list: () => client.get<{ clusters: ClusterConfig[] }>('/clusters'),
   get: (id: string) => client.get<ClusterConfig>(`/clusters/${id}`),
-  update: (id: string, config: Partial<ClusterConfig>) => client.put(`/clusters/${id}`, config)
+  status: (id: string) => client.get<ClusterStatus>(`/clusters/${id}/status`),
+  apps: (id: string) => client.get<{ cluster_id: string; apps: ClusterApp[]; guaranteed: number }>(`/clusters/${id}/apps`),
+  create: (config: Partial<ClusterConfig>) => client.post('/clusters', config),
+  update: (id: string, config: Partial<ClusterConfig>) => client.put(`/clusters/${id}`, config),
+  delete: (id: string, cascade = false) => client.delete(`/clusters/${id}`, { params: { cascade } }),
+  validate: (id: string, config: Partial<ClusterConfig>) => client.post(`/clusters/${id}/validate`, config)
 }
 
 // 连接管理
 export const connectionsApi = {
-  getStats: () => client.get('/connections'),
+  getStats: (byNode = false) =>
+    client.get<{ connections: ConnectionStats[]; limits: ConnectionLimit[]; nodes?: ConnectionNode[] }>('/connections', {
+      params: byNode ? { by_node: true } : undefined
+    }),
   updateLimit: (targetType: string, targetId: string, limit: number) =>
     client.put('/connections', { target_type: targetType, target_id: targetId, limit })
 }
@@ -37,7 +48,14 @@ export const emergencyApi = {
   getStatus: () => client.get<EmergencyStatus>('/emergency'),
   activate: (reason: string, duration: number) =>
     client.post('/emergency/activate', { reason, duration }),
-  deactivate: () => client.post('/emergency/deactivate')
+  deactivate: () => client.post('/emergency/deactivate'),
+  extend: (duration: number) => client.post<EmergencyStatus>('/emergency/extend', { duration }),
+  listSchedules: () => client.get<{ schedules: EmergencySchedule[] }>('/emergency/schedules'),
+  schedule: (reason: string, startAt: string, duration: number) =>
+    client.post<EmergencySchedule>('/emergency/schedules', { reason, start_at: startAt, duration }),
+  cancelSchedule: (id: string) => client.delete(`/emergency/schedules/${id}`),
+  history: (params?: { from?: string; to?: string; limit?: number }) =>
+    client.get<EmergencyHistory>('/emergency/history', { params })
 }
 
 // 指标
@@ -46,3 +64,9 @@ export const metricsApi = {
   getApp: (id: string) => client.get(`/metrics/apps/${id}`),
   getConnections: () => client.get('/metrics/connections')
 }
+
+// 模型校验规则（JSON Schema）
+export const schemasApi = {
+  list: () => client.get<{ schemas: string[] }>('/schemas'),
+  get: (model: 'app' | 'cluster') => client.get<Record<string, any>>(`/schemas/${model}`)
+}
@@ -96,8 +96,12 @@ export function useError(router?: Router) {
    * 处理表单验证错误
    */
   const handleFormError = (err: any) => {
-    if (err?.response?.data?.errors) {
-      setFieldErrors(err.response.data.errors)
+    const fieldErrors = err?.response?.data?.errors
+    if (Array.isArray(fieldErrors)) {
+      // problem+json: [{ field, rule, message }]
+      setFieldErrors(Object.fromEntries(fieldErrors.map((e: any) => [e.field, e.message])))
+    } else if (fieldErrors) {
+      setFieldErrors(fieldErrors)
     } else {
       setError(extractApiError(err))
     }
@@ -8,9 +8,23 @@ export interface AppConfig {
   priority: number
   max_borrow: number
   max_connections: number
+  clusters?: ClusterAssignment[]
   updated_at?: string
 }
 
+// 应用在集群上的配额分配
+export interface ClusterAssignment {
+  cluster_id: string
+  guaranteed_quota?: number
+}
+
+// 集群成员应用
+export interface ClusterApp {
+  app_id: string
+  guaranteed_quota: number
+  app: AppConfig
+}
+
 // 集群配置
 export interface ClusterConfig {
   cluster_id: string
@@ -21,6 +35,26 @@ export interface ClusterConfig {
   updated_at?: string
 }
 
+// 网关 L1 集群桶状态
+export interface GatewayClusterStatus {
+  capacity: number
+  available: number
+  allocated: number
+  reserved_ratio: number
+  remaining: number
+  usage_ratio: number
+  emergency_threshold: number
+  emergency_mode: boolean
+  last_reconcile?: string
+}
+
+export interface ClusterStatus {
+  cluster_id: string
+  config: ClusterConfig
+  l1: GatewayClusterStatus | null
+  in_sync: boolean
+}
+
 // 连接统计
 export interface ConnectionStats {
   type: 'app' | 'cluster'
@@ -29,6 +63,31 @@ export interface ConnectionStats {
   limit: number
   peak: number
   rejected: number
+  nodes?: NodeConnectionStats[]
+}
+
+export interface NodeConnectionStats {
+  node: string
+  current: number
+  limit: number
+  peak: number
+  rejected: number
+}
+
+// 连接限制配置
+export interface ConnectionLimit {
+  target_type: 'app' | 'cluster'
+  target_id: string
+  limit: number
+}
+
+// 网关节点连接统计汇总
+export interface ConnectionNode {
+  node: string
+  rejected_total: number
+  leaked_total: number
+  last_cleanup: string
+  last_report: string
 }
 
 // 紧急模式状态
@@ -38,6 +97,49 @@ export interface EmergencyStatus {
   activated_at: string
   expires_at: string
   duration: number
+  remaining_seconds: number
+}
+
+// 计划紧急窗口
+export interface EmergencySchedule {
+  id: string
+  reason: string
+  start_at: string
+  end_at: string
+  duration: number
+  created_by: string
+  created_at: string
+}
+
+// 紧急模式历史
+export interface EmergencyEvent {
+  timestamp: string
+  action: 'activated' | 'extended' | 'deactivated' | 'expired'
+  actor: string
+  reason: string
+  comment?: string
+  activated_at: string
+  expires_at: string
+  duration: number
+  utilization: number
+}
+
+export interface EmergencyWeek {
+  week_start: string
+  activations: number
+  seconds: number
+}
+
+export interface EmergencyHistory {
+  events: EmergencyEvent[]
+  totals: {
+    activations: number
+    extensions: number
+    deactivations: number
+    expirations: number
+    seconds: number
+    weeks: EmergencyWeek[]
+  }
 }
 
 // 系统指标
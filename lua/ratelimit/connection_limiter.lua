@@ -18,6 +18,9 @@ local CONFIG = {
     DEFAULT_CLUSTER_LIMIT = 5000, -- 默认 Cluster 连接限制
     MAX_CLEANUP_KEYS = 1000,      -- 每次清理最大扫描键数
     RETRY_MAX = 3,                -- 原子操作最大重试次数
+    CONFIG_UPDATE_CHANNEL = "ratelimit:config_update", -- 管理后台配置更新频道
+    SUBSCRIBE_READ_TIMEOUT = 5000, -- 订阅读超时（毫秒），超时后检查 worker 是否退出
+    SUBSCRIBE_RETRY_DELAY = 5,    -- 订阅断开后重连间隔（秒）
 }
 
 --- 输入验证
@@ -383,13 +386,36 @@ function _M.report_stats_to_redis()
     local node_id = ngx.var.server_addr or "unknown"
     local key = "connlimit:stats:node:" .. node_id
     
-    red:hmset(key,
+    local fields = {
         "rejected_total", stats.rejected_total,
         "leaked_total", stats.leaked_total,
         "last_cleanup", stats.last_cleanup,
         "last_report", ngx.now()
-    )
+    }
+    
+    -- 各 App / Cluster 的连接统计，字段名为 app:<id> 或 cluster:<id>
+    -- 需遍历全部键，否则连接追踪记录较多时会漏报目标；每 10 秒仅执行一次
+    local keys = shared_dict:get_keys(0)
+    for _, k in ipairs(keys) do
+        local target = k:match("^conn:(app:.+)$") or k:match("^conn:(cluster:.+)$")
+        if target then
+            local data = get_or_init_data(k, 0)
+            table.insert(fields, target)
+            table.insert(fields, cjson.encode({
+                current = data.current,
+                limit = data.limit,
+                peak = data.peak,
+                rejected = data.rejected
+            }))
+        end
+    end
+    
+    -- 整体替换，已清理的目标不再上报
+    red:init_pipeline()
+    red:del(key)
+    red:hmset(key, unpack(fields))
     red:expire(key, 300)
+    red:commit_pipeline()
     
     redis_client.release_connection(red)
 end
@@ -436,6 +462,73 @@ function _M.set_cluster_limit(cluster_id, limit)
     shared_dict:set(key, cjson.encode(data))
 end
 
+--- 应用管理后台发布到 ratelimit:config_update 的连接限制事件
+--- @param message string 事件 JSON，type 为 connection_limit
+--- @return boolean applied 是否已应用
+function _M.apply_limit_event(message)
+    local event = cjson.decode(message)
+    if not event or event.type ~= "connection_limit" then
+        return false
+    end
+    
+    local limit = tonumber(event.limit)
+    if not limit or limit < 1 or type(event.target_id) ~= "string" then
+        return false
+    end
+    
+    if event.target_type == "app" then
+        _M.set_app_limit(event.target_id, limit)
+    elseif event.target_type == "cluster" then
+        _M.set_cluster_limit(event.target_id, limit)
+    else
+        return false
+    end
+    return true
+end
+
+--- 启动配置更新订阅，将管理后台发布的连接限制事件应用到本节点
+--- 连接数据位于节点共享内存，只需在一个 worker 中订阅
+function _M.start_config_subscriber()
+    local handler
+    handler = function(premature)
+        if premature then return end
+        
+        local redis_client = require "ratelimit.redis"
+        local red, err = redis_client.get_connection()
+        if not red then
+            ngx.log(ngx.WARN, "Config subscriber connect failed: ", err)
+            ngx.timer.at(CONFIG.SUBSCRIBE_RETRY_DELAY, handler)
+            return
+        end
+        
+        local ok
+        ok, err = red:subscribe(CONFIG.CONFIG_UPDATE_CHANNEL)
+        if ok then
+            red:set_timeout(CONFIG.SUBSCRIBE_READ_TIMEOUT)
+            while not ngx.worker.exiting() do
+                local reply, read_err = red:read_reply()
+                if reply then
+                    -- 同一频道上的其他事件类型由 apply_limit_event 忽略
+                    if reply[1] == "message" and _M.apply_limit_event(reply[3]) then
+                        ngx.log(ngx.INFO, "Applied connection limit event: ", reply[3])
+                    end
+                elseif read_err ~= "timeout" then
+                    err = read_err
+                    break
+                end
+            end
+        end
+        
+        -- 订阅状态的连接不能归还连接池
+        redis_client.close_connection(red)
+        if ngx.worker.exiting() then return end
+        
+        ngx.log(ngx.WARN, "Config subscriber disconnected: ", err)
+        ngx.timer.at(CONFIG.SUBSCRIBE_RETRY_DELAY, handler)
+    end
+    ngx.timer.at(0, handler)
+end
+
 --- 设置响应头
 function _M.set_response_headers(result)
     if result then
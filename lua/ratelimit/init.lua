@@ -38,6 +38,12 @@ function _M.init()
         -- 启动连接清理定时器
         connection_limiter.start_cleanup_timer()
         
+        -- 启动连接统计上报定时器
+        connection_limiter.start_stats_timer()
+        
+        -- 订阅管理后台的连接限制更新
+        connection_limiter.start_config_subscriber()
+        
         -- 启动预留清理定时器
         reservation.start_cleanup_timer()
         
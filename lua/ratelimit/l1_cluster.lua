@@ -42,6 +42,7 @@ function _M.init_cluster(capacity)
     red:setnx(key .. ":capacity", capacity)
     red:setnx(key .. ":available", capacity)
     red:setnx(key .. ":reserved_ratio", CONFIG.RESERVED_RATIO)
+    red:setnx(key .. ":emergency_threshold", CONFIG.EMERGENCY_THRESHOLD)
     red:setnx(key .. ":emergency_mode", "false")
     red:setnx(key .. ":emergency_reason", "")
     red:setnx(key .. ":emergency_start", 0)
@@ -71,6 +72,7 @@ function _M.get_status()
     red:get(key .. ":emergency_reason")
     red:get(key .. ":emergency_start")
     red:get(key .. ":last_reconcile")
+    red:get(key .. ":emergency_threshold")
     
     local results, err = red:commit_pipeline()
     redis_client.release_connection(red)
@@ -82,6 +84,7 @@ function _M.get_status()
     local capacity = tonumber(results[1]) or CONFIG.DEFAULT_CAPACITY
     local available = tonumber(results[2]) or capacity
     
+    -- reserved_ratio 与 emergency_threshold 由管理后台的集群配置写入
     return {
         capacity = capacity,
         available = available,
@@ -90,6 +93,7 @@ function _M.get_status()
         emergency_reason = results[5] or "",
         emergency_start = tonumber(results[6]) or 0,
         last_reconcile = tonumber(results[7]) or 0,
+        emergency_threshold = tonumber(results[8]) or CONFIG.EMERGENCY_THRESHOLD,
         usage_ratio = 1 - (available / capacity),
         reserved_amount = capacity * (tonumber(results[3]) or CONFIG.RESERVED_RATIO),
     }
@@ -108,7 +112,7 @@ function _M.check_emergency_trigger()
         return false, "already_in_emergency"
     end
     
-    if status.usage_ratio >= CONFIG.EMERGENCY_THRESHOLD then
+    if status.usage_ratio >= status.emergency_threshold then
         return true, "usage_exceeded_threshold"
     end
     
@@ -228,7 +232,7 @@ function _M.allocate_quota(app_id, amount)
     -- 获取当前可用量
     local available = tonumber(red:get(key .. ":available")) or 0
     local capacity = tonumber(red:get(key .. ":capacity")) or CONFIG.DEFAULT_CAPACITY
-    local reserved = capacity * CONFIG.RESERVED_RATIO
+    local reserved = capacity * (tonumber(red:get(key .. ":reserved_ratio")) or CONFIG.RESERVED_RATIO)
     
     -- 计算可分配量
     local allocatable = available - reserved
@@ -204,6 +204,8 @@ function _M.check_connection_limiter()
     check_function_exists(conn, "set_app_limit", "connection_limiter")
     check_function_exists(conn, "set_cluster_limit", "connection_limiter")
     check_function_exists(conn, "start_cleanup_timer", "connection_limiter")
+    check_function_exists(conn, "apply_limit_event", "connection_limiter")
+    check_function_exists(conn, "start_config_subscriber", "connection_limiter")
 end
 
 --- 10. 验证 Reservation Manager 接口